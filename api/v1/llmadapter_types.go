@@ -0,0 +1,119 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LLMAdapterSpec defines the desired state of LLMAdapter.
+type LLMAdapterSpec struct {
+	// ModelRef refers to the base LLMModel in the same namespace which serves this adapter.
+	// +kubebuilder:validation:Required
+	ModelRef string `json:"modelRef"`
+
+	// Name is the model name the adapter is advertised as by the engine, it is the LLMAdapter name if not defined.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9][a-zA-Z0-9._:/-]*$`
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Source is where the engine loads the LoRA adapter from.
+	// For vllm, it is a path in the models storage or a Hugging Face repository, like: algoprog/fact-generation-llama-3.1-8b-instruct-lora
+	// For ollama, it is a GGUF file or a directory of safetensors in the models storage, which is copied into the blobs
+	// of ollama by a Job, so the models storage must be a PersistentVolumeClaim shared with the pods of the base model.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[^'"\s;&|$]+$`
+	Source string `json:"source"`
+
+	// Download downloads the adapter into the Source path in the models storage of the base LLMModel with a Job
+	// before it is loaded, the models storage must be a PersistentVolumeClaim shared with the pods of the base model.
+	// +optional
+	Download *AdapterDownloadSpec `json:"download,omitempty"`
+}
+
+// AdapterDownloadSpec defines how the adapter is downloaded into the models storage.
+// +kubebuilder:validation:XValidation:rule="has(self.repository) || has(self.scripts)",message="one of repository and scripts must be set"
+type AdapterDownloadSpec struct {
+	// Repository is the Hugging Face repository of the adapter downloaded by the default scripts,
+	// like: algoprog/fact-generation-llama-3.1-8b-instruct-lora
+	// +kubebuilder:validation:Pattern=`^[^'"\s;&|$]+$`
+	// +optional
+	Repository string `json:"repository,omitempty"`
+
+	// Image is the image the download scripts run in.
+	// +kubebuilder:default="python:3.12-slim"
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Scripts download the adapter with /bin/sh, the {{ .Repository }} and the {{ .Source }} are replaced.
+	// The default scripts download the Repository with the huggingface_hub python package.
+	// +optional
+	Scripts string `json:"scripts,omitempty"`
+
+	// Envs are the environment variables of the download, like the HF_TOKEN from a Secret.
+	// +optional
+	Envs []corev1.EnvVar `json:"envs,omitempty"`
+}
+
+// LLMAdapterStatus defines the observed state of LLMAdapter.
+type LLMAdapterStatus struct {
+	// Conditions represent the latest available observations of the LLMAdapter's state.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ModelName is the model name of the adapter in the requests sent to the engine.
+	// +optional
+	ModelName string `json:"modelName,omitempty"`
+
+	// Backend is the in-cluster URL of the Service serving the adapter, it is the Service of the base LLMModel.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+
+	// LoadedReplicas is the number of the ready pods of the base LLMModel which have loaded the adapter.
+	// +optional
+	LoadedReplicas int32 `json:"loadedReplicas,omitempty"`
+
+	// Blobs are the digests of the files of the adapter copied into the blobs of ollama, keyed by the file names.
+	// +optional
+	Blobs map[string]string `json:"blobs,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+
+// LLMAdapter is the Schema for the llmadapters API.
+type LLMAdapter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LLMAdapterSpec   `json:"spec,omitempty"`
+	Status LLMAdapterStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LLMAdapterList contains a list of LLMAdapter.
+type LLMAdapterList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LLMAdapter `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LLMAdapter{}, &LLMAdapterList{})
+}
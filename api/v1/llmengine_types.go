@@ -20,6 +20,7 @@ import (
 	"fmt"
 
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -58,7 +59,33 @@ const (
 	LLMEngineTypeVLLM   LLMEngineType = "vllm"
 )
 
+// ServingMode decides how the LLMModels of a LLMEngine are served.
+// +kubebuilder:validation:Enum=PerModel;Shared
+type ServingMode string
+
+const (
+	// ServingModePerModel serves each LLMModel with its own Deployment and Service.
+	ServingModePerModel ServingMode = "PerModel"
+	// ServingModeShared serves all LLMModels of the engine with one Deployment and one Service.
+	ServingModeShared ServingMode = "Shared"
+)
+
+// SecurityProfile decides the securityContexts of the model pods of a LLMEngine.
+// +kubebuilder:validation:Enum=Restricted;Unrestricted
+type SecurityProfile string
+
+const (
+	// SecurityProfileRestricted runs the model pods with the profile of the engine type, which is compatible with
+	// the restricted Pod Security Standard.
+	SecurityProfileRestricted SecurityProfile = "Restricted"
+	// SecurityProfileUnrestricted runs the model pods with the securityContexts of the images.
+	SecurityProfileUnrestricted SecurityProfile = "Unrestricted"
+)
+
 // LLMEngineSpec defines the desired state of LLMEngine.
+// +kubebuilder:validation:XValidation:rule="!has(self.servingMode) || self.servingMode != 'Shared' || self.engineType == 'ollama'",message="the Shared servingMode is only supported by the ollama engine"
+// +kubebuilder:validation:XValidation:rule="!has(self.servingMode) || self.servingMode != 'Shared' || (!has(self.networkPolicy) && !has(self.tls) && !has(self.serviceAccount))",message="the networkPolicy, tls and serviceAccount are not supported in the Shared servingMode"
+// +kubebuilder:validation:XValidation:rule="!has(self.auth) || self.engineType in ['ollama', 'vllm']",message="the auth is only supported by the engines which the operator binds to the loopback address"
 type LLMEngineSpec struct {
 	// Type specifies the type of LLM engine (e.g., ollama, vllm).
 	// +kubebuilder:validation:Required
@@ -83,6 +110,282 @@ type LLMEngineSpec struct {
 	// ModelDeploymentTemplate provides default values for LLMModel CRs.
 	// +optional
 	ModelDeploymentTemplate *ModelDeploymentTemplate `json:"modelDeploymentTemplate,omitempty"`
+
+	// Gateway deploys an OpenAI-compatible gateway for this engine, which routes the requests
+	// to the LLMModels of this engine by the model field in the requests.
+	// +optional
+	Gateway *GatewaySpec `json:"gateway,omitempty"`
+
+	// Exposure provides the default values of how the LLMModels of this engine are exposed
+	// outside of the cluster, each LLMModel can override them.
+	// +optional
+	Exposure *ExposureSpec `json:"exposure,omitempty"`
+
+	// ServingMode decides if each LLMModel gets its own Deployment and Service (PerModel), or all LLMModels
+	// are pre-pulled into one Deployment served by one Service (Shared). Shared is only supported by ollama.
+	// +kubebuilder:default=PerModel
+	// +optional
+	ServingMode ServingMode `json:"servingMode,omitempty"`
+
+	// SharedReplicas is the number of replicas of the shared Deployment in the Shared ServingMode, it is 1 if not defined.
+	// The replicas, autoscaling and scale to zero of each LLMModel are ignored in the Shared ServingMode.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SharedReplicas *int32 `json:"sharedReplicas,omitempty"`
+
+	// Monitoring makes Prometheus scrape the metrics of the engine in the pods of the LLMModels.
+	// +optional
+	Monitoring *MonitoringSpec `json:"monitoring,omitempty"`
+
+	// Auth requires an API key in the requests sent to the LLMModels of this engine and to its gateway.
+	// The requests without a valid key are rejected by a proxy sidecar before they reach the engine container,
+	// which only listens on the loopback address, so it is only supported by the ollama and vllm engines.
+	// +optional
+	Auth *AuthSpec `json:"auth,omitempty"`
+
+	// NetworkPolicy restricts the traffic of the pods of the LLMModels of this engine with a NetworkPolicy owned by
+	// each LLMModel, each LLMModel can override it. It is rejected in the Shared ServingMode.
+	// +optional
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// TLS adds an https port to the Services of the LLMModels of this engine and of its gateway, which is terminated
+	// by the proxy sidecar and the gateway. It is rejected in the Shared ServingMode.
+	// +optional
+	TLS *TLSSpec `json:"tls,omitempty"`
+
+	// ServiceAccount is the ServiceAccount of the pods of the LLMModels of this engine, each LLMModel can override
+	// it. It is rejected in the Shared ServingMode.
+	// +optional
+	ServiceAccount *ServiceAccountSpec `json:"serviceAccount,omitempty"`
+
+	// SecurityProfile decides if the model pods run with the restricted profile of the engine type (Restricted),
+	// like the user 1000 and a read-only root filesystem, or with the securityContexts of the images (Unrestricted).
+	// The LLMEngines without a profile get the Restricted one, the ones whose models were pulled as root by an older
+	// operator set Unrestricted to keep their pods as they are. The securityContexts of the modelDeploymentTemplate
+	// replace the ones of the profile.
+	// +optional
+	SecurityProfile SecurityProfile `json:"securityProfile,omitempty"`
+}
+
+// ServiceAccountSpec defines the ServiceAccount the pods of a LLMModel run as. By default the operator creates a
+// ServiceAccount for each LLMModel, named after its Service, and the pods do not mount its token.
+type ServiceAccountSpec struct {
+	// Name of an existing ServiceAccount to run the pods as instead of the one created by the operator.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Annotations of the ServiceAccount created by the operator, like eks.amazonaws.com/role-arn for the IAM roles
+	// of EKS or iam.gke.io/gcp-service-account for the Workload Identity of GKE, so that the download scripts can
+	// read from the cloud buckets without static keys in the Envs.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// AutomountServiceAccountToken decides if the pods mount the token of the ServiceAccount, it is false if not
+	// defined.
+	// +optional
+	AutomountServiceAccountToken *bool `json:"automountServiceAccountToken,omitempty"`
+
+	// RoleRef refers to an existing Role in the namespace of the LLMModel, or a ClusterRole, which is bound to the
+	// ServiceAccount by a RoleBinding owned by the LLMModel. Only the users who can bind the role can refer to it.
+	// +optional
+	RoleRef *RoleReference `json:"roleRef,omitempty"`
+}
+
+// RoleReference refers to a Role or a ClusterRole to bind
+type RoleReference struct {
+	// Kind of the role.
+	// +kubebuilder:validation:Enum=Role;ClusterRole
+	// +kubebuilder:default=Role
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Name of the role.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+}
+
+// TLSSpec defines how the serving certificates of the https ports are issued. The certificates are issued by the
+// cert-manager when the IssuerRef is defined and the cert-manager is installed, otherwise they are signed by a CA
+// generated by the operator, which is kept in the Secret <engine name>-ca. The certificates are renewed before
+// they expire, and the Secret of each certificate has the ca.crt for the clients to verify it.
+type TLSSpec struct {
+	// Enabled decides if the https ports are added.
+	Enabled bool `json:"enabled"`
+
+	// ServicePort is the https port of the Services, it is 443 if not defined.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	ServicePort int32 `json:"servicePort,omitempty"`
+
+	// Plaintext keeps the http ports of the Services and the pods next to the https ones. They are dropped by
+	// default, the requests only come in over https when the TLS is enabled.
+	// +optional
+	Plaintext bool `json:"plaintext,omitempty"`
+
+	// IssuerRef refers to the cert-manager Issuer or ClusterIssuer which issues the certificates.
+	// +optional
+	IssuerRef *IssuerReference `json:"issuerRef,omitempty"`
+
+	// DNSNames are the extra DNS names in the certificates, like the host the models are exposed on.
+	// The DNS names of the Services are always in the certificates.
+	// +optional
+	DNSNames []string `json:"dnsNames,omitempty"`
+}
+
+// IssuerReference refers to a cert-manager issuer.
+type IssuerReference struct {
+	// Name is the name of the issuer.
+	Name string `json:"name"`
+
+	// Kind is Issuer or ClusterIssuer, it is Issuer if not defined.
+	// +kubebuilder:validation:Enum=Issuer;ClusterIssuer
+	// +optional
+	Kind string `json:"kind,omitempty"`
+
+	// Group is the API group of the issuer, it is cert-manager.io if not defined.
+	// +optional
+	Group string `json:"group,omitempty"`
+}
+
+// NetworkPolicySpec defines the NetworkPolicies of the pods of a LLMModel. The ingress is only allowed on the port of
+// the Service of the model, and the egress is only allowed to the DNS. Another NetworkPolicy allows the egress to
+// where the model is downloaded from, it only selects the pods until their init container has downloaded the model,
+// and the Jobs downloading the adapters of the model.
+type NetworkPolicySpec struct {
+	// Enabled decides if the NetworkPolicy is created.
+	Enabled bool `json:"enabled"`
+
+	// Namespaces are the names of the namespaces whose pods can send requests to the model.
+	// The pods in the namespace of the model can send requests when neither Namespaces nor From is defined.
+	// The gateway of the engine and the operator are always allowed.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// From are the other peers which can send requests to the model, like the pods of an ingress controller.
+	// +optional
+	From []networkingv1.NetworkPolicyPeer `json:"from,omitempty"`
+
+	// DownloadEgress are where the init container downloading the model and the Jobs downloading the adapters can
+	// connect to, like a HuggingFace mirror. Any host on the port 80 and 443 is allowed if not defined. The pods only
+	// get it until the download is done, the engine container waits for it to be removed, so the engine itself can
+	// not download the model.
+	// +optional
+	DownloadEgress []networkingv1.NetworkPolicyEgressRule `json:"downloadEgress,omitempty"`
+
+	// MetricsFrom are the peers which scrape the metrics of the engine, like the pods of Prometheus. They can only
+	// reach the metrics on a port apart from the one of the engine, like the admin port of the proxy sidecar.
+	// The metrics port is not opened if not defined.
+	// +optional
+	MetricsFrom []networkingv1.NetworkPolicyPeer `json:"metricsFrom,omitempty"`
+}
+
+// AuthSpec defines the API keys accepted in the "Authorization: Bearer <key>" header of the requests.
+type AuthSpec struct {
+	// APIKeys are the accepted API keys.
+	// +kubebuilder:validation:MinItems=1
+	APIKeys []APIKeySpec `json:"apiKeys"`
+}
+
+// APIKeySpec is an API key kept in a Secret in the namespace of the engine.
+type APIKeySpec struct {
+	// SecretRef selects the key of the Secret which holds the API key.
+	SecretRef corev1.SecretKeySelector `json:"secretRef"`
+
+	// Models are the names of the models in the requests, like llama3.2:latest, the API key is allowed to use.
+	// All models are allowed if empty.
+	// +optional
+	Models []string `json:"models,omitempty"`
+}
+
+// MonitoringSpec defines how the metrics of the engine are scraped. A PodMonitor owned by each LLMModel is created
+// when the Prometheus Operator is installed, otherwise the pods get the prometheus.io scrape annotations.
+type MonitoringSpec struct {
+	// Enabled decides if the metrics of the engine are scraped.
+	Enabled bool `json:"enabled"`
+
+	// Port is the port of the metrics endpoint in the engine container, it is the Port of the engine if not defined.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// Path is the path of the metrics endpoint, it is /metrics for vllm if not defined.
+	// ollama has no metrics endpoint by itself, so it needs the Path of an exporter serving in the pods.
+	// +kubebuilder:validation:Pattern=`^/`
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Interval is how often the metrics are scraped, like: 30s. It is the default of Prometheus if not defined.
+	// +kubebuilder:validation:Pattern=`^([0-9]+(ms|s|m|h))+$`
+	// +optional
+	Interval string `json:"interval,omitempty"`
+
+	// Labels are added to the PodMonitor, like the ones the Prometheus selects the PodMonitors with.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// ExposureType is the kind of the resource used to expose a LLMModel.
+// +kubebuilder:validation:Enum=Ingress;HTTPRoute
+type ExposureType string
+
+const (
+	// ExposureTypeIngress exposes the LLMModel with a networking.k8s.io/v1 Ingress.
+	ExposureTypeIngress ExposureType = "Ingress"
+	// ExposureTypeHTTPRoute exposes the LLMModel with a Gateway API gateway.networking.k8s.io/v1 HTTPRoute.
+	ExposureTypeHTTPRoute ExposureType = "HTTPRoute"
+)
+
+// ExposureSpec defines how a LLMModel is exposed outside of the cluster.
+// The path prefix is removed before the requests are passed to the model.
+type ExposureSpec struct {
+	// Type is the kind of the resource created to expose the model, it is Ingress if not defined.
+	// +optional
+	Type ExposureType `json:"type,omitempty"`
+
+	// Host is the host name the model is exposed on, any host matches if not defined.
+	// +optional
+	Host string `json:"host,omitempty"`
+
+	// PathPrefix is the path the model is exposed on, it is /<LLMModel name> if not defined.
+	// +kubebuilder:validation:Pattern=`^/`
+	// +optional
+	PathPrefix string `json:"pathPrefix,omitempty"`
+
+	// TLSSecretName is the Secret with the TLS certificate of the host, the URL of the model is https when it is set.
+	// The TLS of a HTTPRoute is terminated by the listener of the parent Gateway, so it only marks the URL as https.
+	// +optional
+	TLSSecretName string `json:"tlsSecretName,omitempty"`
+
+	// ClassName is the IngressClass of the Ingress, or the name of the parent Gateway of the HTTPRoute.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+
+	// GatewayNamespace is the namespace of the parent Gateway of the HTTPRoute, it is the namespace of the model if not defined.
+	// +optional
+	GatewayNamespace string `json:"gatewayNamespace,omitempty"`
+
+	// Annotations are added to the Ingress or the HTTPRoute, they override the ones set by the operator.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// GatewaySpec defines the OpenAI-compatible gateway of a LLMEngine.
+type GatewaySpec struct {
+	// Enabled decides if the gateway is deployed.
+	Enabled bool `json:"enabled"`
+
+	// Replicas of the gateway Deployment, it is 1 if not defined.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// ServicePort specifies the port of the gateway Service, it is 8080 if not defined.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	ServicePort int32 `json:"servicePort,omitempty"`
 }
 
 type ModelDeploymentTemplate struct {
@@ -106,6 +409,15 @@ type ModelDeploymentTemplate struct {
 	// DownloadScripts for model preparation
 	// +optional
 	DownloadScripts string `json:"downloadScripts,omitempty"`
+
+	// PodSecurityContext of the model pods, it replaces the one of the security profile of the engine.
+	// +optional
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// SecurityContext of the engine and the download containers, it replaces the one of the security profile of the engine.
+	// An emptyDir is mounted at /tmp when the root filesystem is read only.
+	// +optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
 }
 
 func (m ModelDeploymentTemplate) String() string {
@@ -123,12 +435,25 @@ func EnvsString(envs *[]corev1.EnvVar) string {
 	return result
 }
 
+const (
+	// ConditionTypeReady reports whether the resource has been deployed successfully.
+	ConditionTypeReady = "Ready"
+	// ConditionTypeTerminating reports that the resource is being torn down by its finalizer.
+	ConditionTypeTerminating = "Terminating"
+	// ConditionTypeAvailable reports whether the LLMModel has at least one ready pod serving it.
+	ConditionTypeAvailable = "Available"
+)
+
 // LLMEngineStatus defines the observed state of LLMEngine.
 type LLMEngineStatus struct {
 	// Conditions represent the latest available observations of the LLMEngine's state.
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 	Ready      bool               `json:"ready"`
+
+	// GatewayEndpoint is the in-cluster URL of the gateway when it is enabled.
+	// +optional
+	GatewayEndpoint string `json:"gatewayEndpoint,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -17,8 +17,22 @@ limitations under the License.
 package v1
 
 import (
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// StorageDeletionPolicy defines what happens to the model storage when the LLMModel is deleted.
+// +kubebuilder:validation:Enum=Retain;Delete
+type StorageDeletionPolicy string
+
+const (
+	// StorageDeletionPolicyRetain keeps the PersistentVolumeClaim of the downloaded models.
+	StorageDeletionPolicyRetain StorageDeletionPolicy = "Retain"
+	// StorageDeletionPolicyDelete deletes the PersistentVolumeClaim of the downloaded models.
+	StorageDeletionPolicyDelete StorageDeletionPolicy = "Delete"
 )
 
 // LLMModelSpec defines the desired state of LLMModel.
@@ -36,9 +50,15 @@ type LLMModelSpec struct {
 	EngineRef string `json:"engineRef"`
 
 	// Number of replicas for this model.
+	// It is set by the HorizontalPodAutoscaler through the scale subresource once Autoscaling is defined, and kept
+	// within its minReplicas and maxReplicas.
 	// +kubebuilder:validation:Minimum=1
 	Replicas int32 `json:"replicas"`
 
+	// Autoscaling enables a HorizontalPodAutoscaler managed by the operator for the model Deployment.
+	// +optional
+	Autoscaling *AutoscalingSpec `json:"autoscaling,omitempty"`
+
 	// Resource requirements for this model (overrides engine defaults).
 	// +optional
 	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
@@ -48,18 +68,204 @@ type LLMModelSpec struct {
 	// This is useful when you want to deploy a model with different settings than the engine.
 	// +optional
 	ModelDeployment *ModelDeploymentTemplate `json:"modelDeployment,omitempty"`
+
+	// ScaleToZero scales the model Deployment down to 0 after it has been idle for a while.
+	// The first request coming afterwards is held by the operator until the model is back.
+	// +optional
+	ScaleToZero *ScaleToZeroSpec `json:"scaleToZero,omitempty"`
+
+	// StorageDeletionPolicy decides if the PersistentVolumeClaim used as the models storage
+	// gets deleted together with the LLMModel. Only the claim owned by the LLMModel or labeled with
+	// aitrigram.ihomeland.cn/llmmodel=<name> is deleted, never the one in the ModelDeploymentTemplate of the LLMEngine.
+	// It has no effect on other volume types.
+	// +kubebuilder:default=Retain
+	// +optional
+	StorageDeletionPolicy StorageDeletionPolicy `json:"storageDeletionPolicy,omitempty"`
+
+	// Exposure exposes the model outside of the cluster with an Ingress or a HTTPRoute owned by the operator.
+	// The fields defined here override the ones in the Exposure of the LLMEngine.
+	// +optional
+	Exposure *ExposureSpec `json:"exposure,omitempty"`
+
+	// Revision pins the revision of the model to download. For ollama, it is the digest of the pulled model, like
+	// sha256:a80c4f17acd5, or its ID shown by `ollama list`, and the pods fail to start when the pulled model does
+	// not match it. For vllm, it is passed as the --revision of the model, like a commit of the HuggingFace repository.
+	// It is available as {{ .Revision }} in the DownloadScripts. A change of it rolls out the pods, the old pods keep
+	// serving until the new ones are ready.
+	// +kubebuilder:validation:Pattern=`^[a-zA-Z0-9][a-zA-Z0-9._:/-]*$`
+	// +optional
+	Revision string `json:"revision,omitempty"`
+
+	// Modelfile builds a customized model named as the NameInEngine from a Modelfile, it is only supported by ollama.
+	// The base model is pulled first, and a change of the Modelfile rebuilds the model and rolls out the pods.
+	// +optional
+	Modelfile *ModelfileSpec `json:"modelfile,omitempty"`
+
+	// DisruptionBudget limits how many pods of the model can be evicted at once, like when the nodes are drained,
+	// with a PodDisruptionBudget owned by the operator. It is ignored in the Shared ServingMode of the engine.
+	// +optional
+	DisruptionBudget *DisruptionBudgetSpec `json:"disruptionBudget,omitempty"`
+
+	// Strategy decides how the pods of the model are replaced on changes. It is a RollingUpdate with maxSurge 1
+	// and maxUnavailable 0 if not defined, so that the old pods keep serving until the new ones are ready.
+	// +optional
+	Strategy *ModelStrategySpec `json:"strategy,omitempty"`
+
+	// NetworkPolicy restricts the traffic of the pods of the model with a NetworkPolicy owned by the operator.
+	// It replaces the NetworkPolicy of the LLMEngine when defined. It is rejected in the Shared ServingMode of the engine.
+	// +optional
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// ServiceAccount is the ServiceAccount the pods of the model run as.
+	// It replaces the ServiceAccount of the LLMEngine when defined. It is rejected in the Shared ServingMode of the engine.
+	// +optional
+	ServiceAccount *ServiceAccountSpec `json:"serviceAccount,omitempty"`
+}
+
+// DisruptionBudgetSpec defines the PodDisruptionBudget of the pods of a LLMModel.
+// +kubebuilder:validation:XValidation:rule="has(self.minAvailable) != has(self.maxUnavailable)",message="exactly one of minAvailable and maxUnavailable must be set"
+type DisruptionBudgetSpec struct {
+	// MinAvailable is the number or the percentage of the pods which must stay available after an eviction.
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+
+	// MaxUnavailable is the number or the percentage of the pods which can be unavailable after an eviction.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// ModelStrategySpec defines the strategy of the Deployment of a LLMModel.
+// +kubebuilder:validation:XValidation:rule="self.type != 'Recreate' || (!has(self.maxSurge) && !has(self.maxUnavailable))",message="maxSurge and maxUnavailable are only allowed with the RollingUpdate type"
+type ModelStrategySpec struct {
+	// Type is RollingUpdate or Recreate. Recreate stops the old pods before starting the new ones,
+	// which is needed when a node has only one GPU for one pod.
+	// +kubebuilder:validation:Enum=RollingUpdate;Recreate
+	// +kubebuilder:default=RollingUpdate
+	// +optional
+	Type appsv1.DeploymentStrategyType `json:"type,omitempty"`
+
+	// MaxSurge is the number or the percentage of the pods created above the replicas during a RollingUpdate, it is 1 if not defined.
+	// +optional
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+
+	// MaxUnavailable is the number or the percentage of the pods which can be unavailable during a RollingUpdate, it is 0 if not defined.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
 }
 
+// ModelfileSpec defines the Modelfile of a customized ollama model, like the system prompt, the parameters and the template.
+// +kubebuilder:validation:XValidation:rule="has(self.inline) != has(self.configMapRef)",message="exactly one of inline and configMapRef must be set"
+type ModelfileSpec struct {
+	// From is the base model pulled by ollama, like: llama3.2:latest.
+	// The FROM instruction of the Modelfile is generated from it.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Pattern=`^[^'"\s;&|$]+$`
+	From string `json:"from"`
+
+	// Inline is the content of the Modelfile without the FROM instruction, like:
+	//
+	//	PARAMETER temperature 0.2
+	//	SYSTEM You are a helpful SQL assistant.
+	// +optional
+	Inline string `json:"inline,omitempty"`
+
+	// ConfigMapRef refers to the key of a ConfigMap in the same namespace which has the content of the Modelfile.
+	// +optional
+	ConfigMapRef *corev1.ConfigMapKeySelector `json:"configMapRef,omitempty"`
+}
+
+// AutoscalingSpec defines how the replicas of a LLMModel get scaled by a HorizontalPodAutoscaler.
+type AutoscalingSpec struct {
+	// MinReplicas is the lower limit of the replicas, it is 1 if not defined.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit of the replicas.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Required
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// TargetCPUUtilizationPercentage is the target average CPU utilization over all the pods.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+
+	// TargetMemoryUtilizationPercentage is the target average memory utilization over all the pods.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+
+	// Metrics are additional metrics to scale on, like the queue depth or the tokens per second
+	// exposed through a custom or external metrics API.
+	// +optional
+	Metrics []autoscalingv2.MetricSpec `json:"metrics,omitempty"`
+}
+
+// ScaleToZeroSpec defines when a LLMModel is scaled to zero.
+type ScaleToZeroSpec struct {
+	// IdleTimeout is how long the model can go without requests before it is scaled to zero, like: 30m
+	// +kubebuilder:validation:Required
+	IdleTimeout metav1.Duration `json:"idleTimeout"`
+}
+
+// LLMModelPhase is a simple, high-level summary of where the LLMModel is in its lifecycle.
+// +kubebuilder:validation:Enum=Pending;Running;ScaledToZero;Terminating
+type LLMModelPhase string
+
+const (
+	LLMModelPhasePending      LLMModelPhase = "Pending"
+	LLMModelPhaseRunning      LLMModelPhase = "Running"
+	LLMModelPhaseScaledToZero LLMModelPhase = "ScaledToZero"
+	LLMModelPhaseTerminating  LLMModelPhase = "Terminating"
+)
+
 // LLMModelStatus defines the observed state of LLMModel.
 type LLMModelStatus struct {
 	// Conditions represent the latest available observations of the LLMModel's state.
 	// +optional
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 	Ready      bool               `json:"ready"`
+
+	// Phase is a high-level summary of the LLMModel.
+	// +optional
+	Phase LLMModelPhase `json:"phase,omitempty"`
+
+	// LastRequestTime is the last time a request was seen by the model, it is tracked when ScaleToZero is enabled.
+	// +optional
+	LastRequestTime *metav1.Time `json:"lastRequestTime,omitempty"`
+
+	// Replicas is the number of pods of the model Deployment.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+
+	// Selector is the label selector of the model pods, it is used by the scale subresource.
+	// +optional
+	Selector string `json:"selector,omitempty"`
+
+	// URL is the external URL of the model when it is exposed.
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// Backend is the in-cluster URL of the Service serving the model, it is the shared Service of
+	// the engine in the Shared ServingMode.
+	// +optional
+	Backend string `json:"backend,omitempty"`
+
+	// ResolvedRevision is the revision downloaded by the newest ready pod, which is written by the download
+	// scripts into the termination message of the init container.
+	// +optional
+	ResolvedRevision string `json:"resolvedRevision,omitempty"`
+
+	// ServingRevisions are the distinct revisions downloaded by the ready pods, there are more than one
+	// during a rolling update, or when the pods have downloaded the model at different times.
+	// +optional
+	ServingRevisions []string `json:"servingRevisions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
 
 // LLMModel is the Schema for the llmmodels API.
 type LLMModel struct {
@@ -0,0 +1,72 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LLMModelAliasSpec defines the desired state of LLMModelAlias.
+type LLMModelAliasSpec struct {
+	// ModelRef refers to the LLMModel in the same namespace which serves the alias now,
+	// changing it moves the clients of the alias to another LLMModel.
+	// +kubebuilder:validation:Required
+	ModelRef string `json:"modelRef"`
+}
+
+// LLMModelAliasStatus defines the observed state of LLMModelAlias.
+type LLMModelAliasStatus struct {
+	// Conditions represent the latest available observations of the LLMModelAlias's state.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// URL is the in-cluster URL of the Service of the alias, like: http://chat.default.svc:8080
+	// +optional
+	URL string `json:"url,omitempty"`
+
+	// BackendService is the name of the Service of the LLMModel the alias points to.
+	// +optional
+	BackendService string `json:"backendService,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Model",type=string,JSONPath=`.spec.modelRef`
+// +kubebuilder:printcolumn:name="URL",type=string,JSONPath=`.status.url`
+
+// LLMModelAlias is the Schema for the llmmodelaliases API.
+// It has a Service of the same name, which points to the pods of the LLMModel it refers to.
+type LLMModelAlias struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LLMModelAliasSpec   `json:"spec,omitempty"`
+	Status LLMModelAliasStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LLMModelAliasList contains a list of LLMModelAlias.
+type LLMModelAliasList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LLMModelAlias `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LLMModelAlias{}, &LLMModelAliasList{})
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LLMModelRolloutSpec defines the desired state of LLMModelRollout.
+// +kubebuilder:validation:XValidation:rule="self.stable != self.canary",message="the stable and the canary must be different LLMModels"
+type LLMModelRolloutSpec struct {
+	// Model is the model name in the requests to the gateway of the engine which gets split between the
+	// stable and the canary LLMModels. It is the NameInEngine of the stable LLMModel if not defined,
+	// so that the clients of the stable LLMModel get the canary without any change.
+	// +optional
+	Model string `json:"model,omitempty"`
+
+	// Stable refers to the LLMModel in the same namespace which serves the requests now.
+	// +kubebuilder:validation:Required
+	Stable string `json:"stable"`
+
+	// Canary refers to the new LLMModel in the same namespace, it must be in the same LLMEngine as the Stable.
+	// +kubebuilder:validation:Required
+	Canary string `json:"canary"`
+
+	// Steps are the weights of the canary in order, the rollout is promoted after the last step.
+	// +kubebuilder:validation:MinItems=1
+	Steps []RolloutStep `json:"steps"`
+
+	// Analysis decides when the canary is rolled back.
+	// +optional
+	Analysis *RolloutAnalysis `json:"analysis,omitempty"`
+
+	// Paused holds the rollout at the current step, the canary is still analyzed.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+}
+
+// RolloutStep is a weight of the canary kept for a while
+type RolloutStep struct {
+	// Weight is the percentage of the requests sent to the canary.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	Weight int32 `json:"weight"`
+
+	// Pause is how long the step lasts before the next one, like: 10m
+	// +kubebuilder:validation:Required
+	Pause metav1.Duration `json:"pause"`
+}
+
+// RolloutAnalysis defines the health of the canary
+type RolloutAnalysis struct {
+	// MaxErrorRatePercent is the max percentage of the canary requests failed with 5xx in a step.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +kubebuilder:default=5
+	// +optional
+	MaxErrorRatePercent int32 `json:"maxErrorRatePercent,omitempty"`
+
+	// MinRequests is how many canary requests in a step are needed before the error rate is checked.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=10
+	// +optional
+	MinRequests int64 `json:"minRequests,omitempty"`
+}
+
+// LLMModelRolloutPhase is where the LLMModelRollout is.
+// +kubebuilder:validation:Enum=Progressing;Paused;Promoted;RolledBack;Failed
+type LLMModelRolloutPhase string
+
+const (
+	LLMModelRolloutPhaseProgressing LLMModelRolloutPhase = "Progressing"
+	LLMModelRolloutPhasePaused      LLMModelRolloutPhase = "Paused"
+	LLMModelRolloutPhasePromoted    LLMModelRolloutPhase = "Promoted"
+	LLMModelRolloutPhaseRolledBack  LLMModelRolloutPhase = "RolledBack"
+	LLMModelRolloutPhaseFailed      LLMModelRolloutPhase = "Failed"
+)
+
+// LLMModelRolloutStatus defines the observed state of LLMModelRollout.
+type LLMModelRolloutStatus struct {
+	// Conditions represent the latest available observations of the LLMModelRollout's state.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// ObservedGeneration is the generation of the spec observed by the rollout.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Stable and Canary are the LLMModels the rollout is progressing with, a change of them starts the rollout again.
+	// +optional
+	Stable string `json:"stable,omitempty"`
+	// +optional
+	Canary string `json:"canary,omitempty"`
+
+	// +optional
+	Phase LLMModelRolloutPhase `json:"phase,omitempty"`
+
+	// Message tells why the rollout is in the phase.
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Model is the model name in the requests which is split.
+	// +optional
+	Model string `json:"model,omitempty"`
+
+	// CurrentStep is the index of the current step, it is -1 before the canary is ready.
+	// +optional
+	CurrentStep int32 `json:"currentStep"`
+
+	// CanaryWeight is the percentage of the requests sent to the canary now.
+	// +optional
+	CanaryWeight int32 `json:"canaryWeight"`
+
+	// StepStartTime is when the current step started.
+	// +optional
+	StepStartTime *metav1.Time `json:"stepStartTime,omitempty"`
+
+	// CanaryRequests and CanaryErrors are counted by the gateway in the current step.
+	// +optional
+	CanaryRequests int64 `json:"canaryRequests,omitempty"`
+	// +optional
+	CanaryErrors int64 `json:"canaryErrors,omitempty"`
+
+	// StepBaseline is the count of the requests and the errors of the canary when the current step started,
+	// the gateway counts them since it started.
+	// +optional
+	StepBaseline *RolloutCounts `json:"stepBaseline,omitempty"`
+}
+
+// RolloutCounts are the requests and the errors counted by the gateway
+type RolloutCounts struct {
+	Requests int64 `json:"requests"`
+	Errors   int64 `json:"errors"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Weight",type=integer,JSONPath=`.status.canaryWeight`
+// +kubebuilder:printcolumn:name="Step",type=integer,JSONPath=`.status.currentStep`
+
+// LLMModelRollout is the Schema for the llmmodelrollouts API.
+type LLMModelRollout struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LLMModelRolloutSpec   `json:"spec,omitempty"`
+	Status LLMModelRolloutStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LLMModelRolloutList contains a list of LLMModelRollout.
+type LLMModelRolloutList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LLMModelRollout `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LLMModelRollout{}, &LLMModelRolloutList{})
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LLMQuotaSpec defines the desired state of LLMQuota.
+type LLMQuotaSpec struct {
+	// ModelRef refers to the LLMModel in the same namespace the quota applies to. The quota is not enforced for the
+	// LLMModels with more than one replica or autoscaling, or served in the Shared ServingMode of the engine.
+	// +kubebuilder:validation:Required
+	ModelRef string `json:"modelRef"`
+
+	// Limits are the limits of the clients of the LLMModel, a client is an API key in the auth of the engine.
+	// +kubebuilder:validation:MinItems=1
+	Limits []QuotaLimit `json:"limits"`
+}
+
+// QuotaLimit limits the requests and the tokens of a client.
+// +kubebuilder:validation:XValidation:rule="has(self.requestsPerSecond) || has(self.tokensPerMinute)",message="requestsPerSecond or tokensPerMinute is required"
+type QuotaLimit struct {
+	// APIKey selects one of the API keys in the auth of the engine the limit applies to.
+	// The limit without an APIKey applies to each of the other API keys, or to all requests when the engine has no auth.
+	// +optional
+	APIKey *corev1.SecretKeySelector `json:"apiKey,omitempty"`
+
+	// RequestsPerSecond is the max requests per second of the client.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	RequestsPerSecond int32 `json:"requestsPerSecond,omitempty"`
+
+	// TokensPerMinute is the max tokens per minute of the client, counted from the usage reported in the responses.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TokensPerMinute int64 `json:"tokensPerMinute,omitempty"`
+}
+
+// LLMQuotaStatus defines the observed state of LLMQuota.
+type LLMQuotaStatus struct {
+	// Conditions represent the latest available observations of the LLMQuota's state.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Usage is the usage of each client in the current minute.
+	// +optional
+	Usage []QuotaUsage `json:"usage,omitempty"`
+}
+
+// QuotaUsage is the usage of a client in the current minute.
+type QuotaUsage struct {
+	// APIKey is the API key of the client, like: <Secret name>/<key>. It is empty for the requests without an API key.
+	// +optional
+	APIKey string `json:"apiKey,omitempty"`
+
+	// Requests are the requests allowed.
+	Requests int64 `json:"requests"`
+
+	// Tokens are the tokens used.
+	Tokens int64 `json:"tokens"`
+
+	// Throttled are the requests rejected with 429.
+	Throttled int64 `json:"throttled"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:path=llmquotas
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Model",type=string,JSONPath=`.spec.modelRef`
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+
+// LLMQuota is the Schema for the llmquotas API.
+// It limits the requests per second and the tokens per minute of the clients of a LLMModel,
+// which is enforced by the proxy sidecar in the pod of the LLMModel. It is rejected for the LLMModels with more
+// than one replica or autoscaling, whose pods would each enforce the whole limits.
+type LLMQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LLMQuotaSpec   `json:"spec,omitempty"`
+	Status LLMQuotaStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// LLMQuotaList contains a list of LLMQuota.
+type LLMQuotaList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []LLMQuota `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&LLMQuota{}, &LLMQuotaList{})
+}
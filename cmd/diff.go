@@ -0,0 +1,202 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+type DiffOptions struct {
+	RenderOptions
+	Kubeconfig string
+}
+
+// NewDiffCommand compares the resources rendered from the manifests with the ones in the cluster
+func NewDiffCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Compares the Deployments and the Services rendered from the manifests with the ones in the cluster",
+		Long: "Compares the Deployments and the Services rendered from the manifests with the ones in the cluster. " +
+			"Only the fields set by the operator are compared, the exit code is 1 when there are differences.",
+	}
+
+	opts := DiffOptions{
+		RenderOptions: RenderOptions{
+			ManifestOptions: ManifestOptions{Namespace: "default"},
+			ProxyImage:      defaultProxyImage,
+		},
+	}
+	addManifestFlags(cmd, &opts.ManifestOptions)
+	addProxyImageFlag(cmd, &opts.ProxyImage)
+	cmd.Flags().StringVar(&opts.Kubeconfig, "kubeconfig", opts.Kubeconfig,
+		"The kubeconfig of the cluster, the KUBECONFIG env or the in-cluster config is used if not set.")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		changed, err := diffManifests(cmd.Context(), cmd.OutOrStdout(), &opts)
+		if err != nil {
+			return &exitError{code: 2, err: err}
+		}
+		if changed {
+			// like diff, the differences are the output, and the exit code tells they are found
+			return &exitError{code: 1}
+		}
+		return nil
+	}
+	return cmd
+}
+
+func diffManifests(ctx context.Context, w io.Writer, opts *DiffOptions) (bool, error) {
+	manifests, err := readManifests(opts.Filenames, opts.Namespace)
+	if err != nil {
+		return false, err
+	}
+	objs, err := renderManifests(ctx, manifests, opts.ProxyImage)
+	if err != nil {
+		return false, err
+	}
+	c, err := newClient(opts.Kubeconfig)
+	if err != nil {
+		return false, err
+	}
+	return diffObjects(ctx, w, c, objs)
+}
+
+// A client of the cluster in the kubeconfig, or in the KUBECONFIG env or the in-cluster config if it is not set
+func newClient(kubeconfig string) (client.Client, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig != "" {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	} else {
+		config, err = ctrl.GetConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return client.New(config, client.Options{Scheme: scheme})
+}
+
+// Prints the unified diffs between the objects in the cluster and the rendered ones. The fields of the objects in
+// the cluster which are not rendered, like the defaults of the API server and the status, are left out the same way
+// the reconcilers compare them, the rendered objects not in the cluster are all added.
+func diffObjects(ctx context.Context, w io.Writer, c client.Reader, objs []client.Object) (bool, error) {
+	changed := false
+	for _, obj := range objs {
+		rendered, err := renderedContent(obj)
+		if err != nil {
+			return false, err
+		}
+		live := &unstructured.Unstructured{}
+		live.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+		var liveContent interface{}
+		if err := c.Get(ctx, client.ObjectKeyFromObject(obj), live); err == nil {
+			liveContent = pruneContent(live.Object, rendered)
+		} else if !apierrors.IsNotFound(err) {
+			return false, err
+		}
+
+		name := fmt.Sprintf("%s/%s/%s", obj.GetObjectKind().GroupVersionKind().Kind, obj.GetNamespace(), obj.GetName())
+		liveYAML, err := contentYAML(liveContent)
+		if err != nil {
+			return false, err
+		}
+		renderedYAML, err := contentYAML(rendered)
+		if err != nil {
+			return false, err
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(liveYAML),
+			B:        splitLines(renderedYAML),
+			FromFile: "live/" + name,
+			ToFile:   "rendered/" + name,
+			Context:  3,
+		})
+		if err != nil {
+			return false, err
+		}
+		if diff != "" {
+			changed = true
+			if _, err := fmt.Fprint(w, diff); err != nil {
+				return false, err
+			}
+		}
+	}
+	return changed, nil
+}
+
+// Keeps the fields of the live content which are in the rendered one, the items of the lists are pruned by their
+// positions, so the extra items in the cluster are kept as the reconcilers replace the lists then.
+func pruneContent(live, rendered interface{}) interface{} {
+	switch renderedValue := rendered.(type) {
+	case map[string]interface{}:
+		liveMap, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		pruned := map[string]interface{}{}
+		for key, value := range renderedValue {
+			if liveValue, ok := liveMap[key]; ok {
+				pruned[key] = pruneContent(liveValue, value)
+			}
+		}
+		return pruned
+	case []interface{}:
+		liveList, ok := live.([]interface{})
+		if !ok {
+			return live
+		}
+		pruned := make([]interface{}, 0, len(liveList))
+		for i, liveValue := range liveList {
+			if i < len(renderedValue) {
+				liveValue = pruneContent(liveValue, renderedValue[i])
+			}
+			pruned = append(pruned, liveValue)
+		}
+		return pruned
+	}
+	return live
+}
+
+func contentYAML(content interface{}) (string, error) {
+	if content == nil {
+		return "", nil
+	}
+	out, err := yaml.Marshal(content)
+	return string(out), err
+}
+
+// The lines of the YAML with their line breaks, an empty YAML has no lines
+func splitLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
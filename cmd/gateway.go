@@ -0,0 +1,96 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+type GatewayOptions struct {
+	Listen         string
+	Routes         string
+	ReloadInterval time.Duration
+	AuthConfig     string
+	TLS            TLSOptions
+	AdminListen    string
+	BackendCAFile  string
+}
+
+// NewGatewayCommand runs the OpenAI-compatible gateway of a LLMEngine
+func NewGatewayCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gateway",
+		Short: "Runs the OpenAI-compatible gateway which routes the requests to the LLMModels by the model name",
+	}
+
+	opts := GatewayOptions{
+		Listen:         ":8080",
+		Routes:         "/etc/aitrigram/gateway/" + proxy.RoutesFileName,
+		ReloadInterval: 5 * time.Second,
+		TLS:            TLSOptions{Listen: ":8443"},
+		AdminListen:    ":8081",
+	}
+	cmd.Flags().StringVar(&opts.Listen, "listen", opts.Listen, "The address the gateway listens on, there is only the https listener if empty.")
+	cmd.Flags().StringVar(&opts.Routes, "routes", opts.Routes, "The route table file maintained by the operator.")
+	cmd.Flags().DurationVar(&opts.ReloadInterval, "reload-interval", opts.ReloadInterval, "How often the route table file is checked for changes.")
+	cmd.Flags().StringVar(&opts.AuthConfig, "auth-config", opts.AuthConfig, "The API keys required in the requests, in the JSON format of proxy.AuthConfig.")
+	addTLSFlags(cmd, &opts.TLS)
+	cmd.Flags().StringVar(&opts.BackendCAFile, "backend-ca-file", opts.BackendCAFile, "The CA which signs the certificates of the backends served over https, "+
+		"besides the system ones.")
+	cmd.Flags().StringVar(&opts.AdminListen, "admin-listen", opts.AdminListen, "The address the admin listener listens on, it serves the stats of the backends to the operator. "+
+		"There is no admin listener if empty.")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return runGateway(ctrl.SetupSignalHandler(), &opts)
+	}
+	return cmd
+}
+
+func runGateway(ctx context.Context, opts *GatewayOptions) error {
+	gateway := proxy.NewGateway()
+	if opts.BackendCAFile != "" {
+		transport, err := proxy.NewBackendTransport(opts.BackendCAFile)
+		if err != nil {
+			return err
+		}
+		gateway.SetTransport(transport)
+	}
+	if err := gateway.LoadRoutes(opts.Routes); err != nil {
+		return err
+	}
+	go gateway.WatchRoutes(ctx, opts.Routes, opts.ReloadInterval)
+	handler, err := withAuth(ctx, opts.AuthConfig, "", gateway, opts.ReloadInterval)
+	if err != nil {
+		return err
+	}
+	setupLog.Info("Starting the gateway", "listen", opts.Listen, "routes", opts.Routes, "auth", opts.AuthConfig != "", "tls", opts.TLS.CertFile != "",
+		"admin", opts.AdminListen)
+	serve := func(ctx context.Context) error { return serveHTTPAndTLS(ctx, opts.Listen, &opts.TLS, handler) }
+	if opts.AdminListen == "" {
+		return serve(ctx)
+	}
+	admin := http.NewServeMux()
+	admin.Handle("GET "+proxy.StatsPath, gateway.StatsHandler())
+	return serveAll(ctx, serve, func(ctx context.Context) error { return serveHTTP(ctx, opts.AdminListen, nil, admin) })
+}
@@ -18,7 +18,9 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"time"
 
@@ -36,12 +38,15 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
 	"github.com/gaol/AITrigram/internal/controller"
+	"github.com/gaol/AITrigram/internal/proxy"
 	webhookv1 "github.com/gaol/AITrigram/internal/webhook/v1"
 	// +kubebuilder:scaffold:imports
 )
@@ -52,6 +57,8 @@ var (
 	version  = "0.0.1"
 )
 
+const defaultProxyImage = "ghcr.io/gaol/aitrigram-controller:latest"
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
@@ -70,24 +77,64 @@ func main() {
 			_ = cmd.Help()
 			os.Exit(1)
 		},
+		// the errors returned by the commands are printed once below, without the usage
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
 
 	cmd.Version = version
 
 	cmd.AddCommand(NewRunCommand())
+	cmd.AddCommand(NewProxyCommand())
+	cmd.AddCommand(NewGatewayCommand())
+	cmd.AddCommand(NewRenderCommand())
+	cmd.AddCommand(NewValidateCommand())
+	cmd.AddCommand(NewDiffCommand())
+	cmd.AddCommand(NewRBACCommand())
 
 	if err := cmd.Execute(); err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		code := 1
+		var exit *exitError
+		if errors.As(err, &exit) {
+			code = exit.code
+		}
+		if exit == nil || exit.err != nil {
+			_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "%v\n", err)
+		}
+		os.Exit(code)
+	}
+}
+
+// exitError is returned by the commands which exit with another code than 1, the err is printed if it is not nil
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit status %d", e.code)
 	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
 }
 
 type StartOptions struct {
 	Namespace            string
 	PodName              string
+	PodIP                string
 	MetricsAddr          string
 	EnableWebHook        bool
 	CertDir              string
+	ProxyImage           string
+	ActivatorPort        int32
+	ActivatorTLSPort     int32
+	ActivatorTimeout     time.Duration
+	WatchNamespaces      string
+	BindableRoles        []string
 	probeAddr            string
 	enableLeaderElection bool
 }
@@ -99,11 +146,20 @@ func NewRunCommand() *cobra.Command {
 	}
 
 	opts := StartOptions{
-		Namespace:     "aitrigram-system",
-		PodName:       "operator",
-		MetricsAddr:   "0",
-		EnableWebHook: false,
-		CertDir:       "",
+		Namespace:        "aitrigram-system",
+		PodName:          "operator",
+		PodIP:            os.Getenv("POD_IP"),
+		MetricsAddr:      "0",
+		EnableWebHook:    false,
+		CertDir:          "",
+		ProxyImage:       defaultProxyImage,
+		ActivatorPort:    8090,
+		ActivatorTLSPort: 8091,
+		ActivatorTimeout: 10 * time.Minute,
+	}
+	// the NetworkPolicies of the models only allow the operator pods in its namespace
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		opts.Namespace = namespace
 	}
 
 	cmd.Flags().StringVar(&opts.probeAddr, "health-probe-bind-address", ":8081",
@@ -115,15 +171,26 @@ func NewRunCommand() *cobra.Command {
 		"The address the metric endpoint binds to.")
 	cmd.Flags().StringVar(&opts.CertDir, "cert-dir", opts.CertDir, "Path to the serving key and cert for manager")
 	cmd.Flags().BoolVar(&opts.EnableWebHook, "enable-webhook", false, "If enable the webhook server or not")
+	cmd.Flags().StringVar(&opts.ProxyImage, "proxy-image", opts.ProxyImage,
+		"The image of the proxy sidecar put in the model pods and of the gateways, it is the image of the operator.")
+	cmd.Flags().StringVar(&opts.PodIP, "pod-ip", opts.PodIP,
+		"The IP of the operator pod, the Services of the models scaled to zero point to it. Defaults to the POD_IP env.")
+	cmd.Flags().Int32Var(&opts.ActivatorPort, "activator-port", opts.ActivatorPort,
+		"The port the activator listens on for the requests to the models scaled to zero, 0 to disable it.")
+	cmd.Flags().Int32Var(&opts.ActivatorTLSPort, "activator-tls-port", opts.ActivatorTLSPort,
+		"The port the activator passes the https connections to the models scaled to zero through on, 0 to disable it.")
+	cmd.Flags().DurationVar(&opts.ActivatorTimeout, "activator-timeout", opts.ActivatorTimeout,
+		"How long the activator holds a request while the model is waking up.")
+	cmd.Flags().StringSliceVar(&opts.BindableRoles, "bindable-roles", opts.BindableRoles,
+		"The roles the serviceAccount.roleRef of the LLMEngines and the LLMModels can refer to, like ClusterRole/view or "+
+			"Role/hf-token-reader, the operator needs the bind permission on them. They are only bound with the webhook enabled.")
+	addWatchNamespacesFlag(cmd, &opts.WatchNamespaces)
 
-	cmd.Run = func(cmd *cobra.Command, args []string) {
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
 		ctx, cancel := context.WithCancel(ctrl.SetupSignalHandler())
 		defer cancel()
 
-		if err := run(ctx, &opts, ctrl.Log.WithName("setup")); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
+		return run(ctx, &opts, ctrl.Log.WithName("setup"))
 	}
 
 	return cmd
@@ -156,6 +223,26 @@ func run(ctx context.Context, opts *StartOptions, log logr.Logger) error {
 		RenewDeadline:                 &renewDeadline,
 		RetryPeriod:                   &retryPeriod,
 	}
+	watchNamespaces, err := controller.ParseWatchNamespaces(opts.WatchNamespaces)
+	if err != nil {
+		return fmt.Errorf("invalid --watch-namespaces: %w", err)
+	}
+	var namespaces []string
+	var namespaceClient crclient.WithWatch
+	if watchNamespaces != nil {
+		// the namespaces are listed before the cache of the manager is restricted to them
+		if namespaceClient, err = crclient.NewWithWatch(kubeConfig, crclient.Options{Scheme: scheme}); err != nil {
+			return err
+		}
+		if namespaces, err = watchNamespaces.Resolve(ctx, namespaceClient); err != nil {
+			return err
+		}
+		log.Info("Watching only the namespaces", "watchNamespaces", watchNamespaces.String(), "namespaces", namespaces)
+		ctrlOptions.Cache.DefaultNamespaces = map[string]cache.Config{}
+		for _, namespace := range namespaces {
+			ctrlOptions.Cache.DefaultNamespaces[namespace] = cache.Config{}
+		}
+	}
 	if opts.EnableWebHook {
 		log.Info("Webhook server is enabled")
 		ctrlOptions.WebhookServer = webhook.NewServer(webhook.Options{
@@ -168,29 +255,105 @@ func run(ctx context.Context, opts *StartOptions, log logr.Logger) error {
 		return fmt.Errorf("unable to start manager: %w", err)
 
 	}
+	if watchNamespaces != nil {
+		// the manager exits once the selected namespaces change, to be restarted with the cache of the new ones
+		if err := mgr.Add(nonLeaderRunnable(func(ctx context.Context) error {
+			return watchNamespaces.Watch(ctx, namespaceClient, namespaces)
+		})); err != nil {
+			setupLog.Error(err, "unable to set up the watch of the namespaces")
+			return err
+		}
+	}
 	llmEngineReconciler := &controller.LLMEngineReconciler{
 		Client:            mgr.GetClient(),
 		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("llmengine-controller"),
 		OperatorNamespace: opts.Namespace,
 		OperatorPodName:   opts.PodName,
+		ProxyImage:        opts.ProxyImage,
 	}
 	if err = llmEngineReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "LLMEngine")
 		return err
 	}
-	if err = (&controller.LLMModelReconciler{
+	llmModelReconciler := &controller.LLMModelReconciler{
+		Client:            mgr.GetClient(),
+		Scheme:            mgr.GetScheme(),
+		Recorder:          mgr.GetEventRecorderFor("llmmodel-controller"),
+		ProxyImage:        opts.ProxyImage,
+		OperatorNamespace: opts.Namespace,
+		// the webhook checks that the users can bind the roles they refer to
+		BindableRoles:   opts.BindableRoles,
+		RoleRefsChecked: opts.EnableWebHook,
+	}
+	if opts.ActivatorPort > 0 {
+		llmModelReconciler.ActivatorIP = opts.PodIP
+		llmModelReconciler.ActivatorPort = opts.ActivatorPort
+		activator := proxy.NewActivator(&controller.ModelWaker{Client: mgr.GetClient(), Namespaces: namespaces}, opts.ActivatorTimeout)
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			log.Info("Starting the activator", "port", opts.ActivatorPort)
+			return serveHTTP(ctx, fmt.Sprintf(":%d", opts.ActivatorPort), nil, activator)
+		})); err != nil {
+			setupLog.Error(err, "unable to set up the activator")
+			return err
+		}
+		if opts.ActivatorTLSPort > 0 {
+			llmModelReconciler.ActivatorTLSPort = opts.ActivatorTLSPort
+			if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+				listener, err := net.Listen("tcp", fmt.Sprintf(":%d", opts.ActivatorTLSPort))
+				if err != nil {
+					return err
+				}
+				log.Info("Starting the TLS passthrough of the activator", "port", opts.ActivatorTLSPort)
+				return activator.ServeTLS(ctx, listener)
+			})); err != nil {
+				setupLog.Error(err, "unable to set up the TLS passthrough of the activator")
+				return err
+			}
+		}
+	}
+	if err = llmModelReconciler.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LLMModel")
+		return err
+	}
+	if err = (&controller.LLMAdapterReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "LLMModel")
+		setupLog.Error(err, "unable to create controller", "controller", "LLMAdapter")
+		return err
+	}
+	if err = (&controller.LLMModelRolloutReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LLMModelRollout")
+		return err
+	}
+	if err = (&controller.LLMModelAliasReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LLMModelAlias")
+		return err
+	}
+	if err = (&controller.LLMQuotaReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "LLMQuota")
 		return err
 	}
 	// +kubebuilder:scaffold:builder
 	if opts.EnableWebHook {
-		if err := webhookv1.SetupLLMEngineWebhookWithManager(mgr); err != nil {
+		if err := webhookv1.SetupLLMEngineWebhookWithManager(mgr, opts.BindableRoles); err != nil {
 			setupLog.Error(err, "unable to create webhook", "controller", "WebHook")
 			return err
 		}
+		if err := webhookv1.SetupLLMModelWebhookWithManager(mgr, opts.BindableRoles); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "LLMModel")
+			return err
+		}
 	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -205,3 +368,14 @@ func run(ctx context.Context, opts *StartOptions, log logr.Logger) error {
 	setupLog.Info("starting manager")
 	return mgr.Start(ctx)
 }
+
+// nonLeaderRunnable runs on every replica of the operator, not only on the leader
+type nonLeaderRunnable manager.RunnableFunc
+
+func (r nonLeaderRunnable) Start(ctx context.Context) error {
+	return r(ctx)
+}
+
+func (nonLeaderRunnable) NeedLeaderElection() bool {
+	return false
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spf13/cobra"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+const (
+	// how often the API keys mounted from the Secrets are read again by the proxy
+	authReloadInterval = 30 * time.Second
+	// how often the quota file mounted from the ConfigMap is checked for changes
+	quotaReloadInterval = 5 * time.Second
+	// how often the certificate mounted from the Secret is read again
+	certReloadInterval = time.Minute
+)
+
+type ProxyOptions struct {
+	Listen      string
+	Upstream    string
+	Model       string
+	AuthConfig  string
+	QuotaConfig string
+	TLS         TLSOptions
+	Admin       AdminOptions
+}
+
+// AdminOptions are the options of the admin listener of the operator and the kubelet, apart from the clients
+type AdminOptions struct {
+	Listen    string
+	Paths     []string
+	TokenFile string
+}
+
+// TLSOptions are the options of the https listener, which is started when the certificate is defined
+type TLSOptions struct {
+	Listen   string
+	CertFile string
+	KeyFile  string
+}
+
+func addTLSFlags(cmd *cobra.Command, opts *TLSOptions) {
+	cmd.Flags().StringVar(&opts.Listen, "tls-listen", opts.Listen, "The address the https listener listens on.")
+	cmd.Flags().StringVar(&opts.CertFile, "tls-cert-file", opts.CertFile, "The certificate served by the https listener, there is no https listener if not defined.")
+	cmd.Flags().StringVar(&opts.KeyFile, "tls-key-file", opts.KeyFile, "The private key of the certificate.")
+}
+
+// NewProxyCommand runs the proxy sidecar in front of the engine container in a model pod
+func NewProxyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "Runs the proxy sidecar in front of the engine container",
+	}
+
+	opts := ProxyOptions{
+		Listen:   ":15080",
+		Upstream: "http://127.0.0.1:11434",
+		TLS:      TLSOptions{Listen: ":15443"},
+		Admin:    AdminOptions{Listen: ":15090"},
+	}
+	cmd.Flags().StringVar(&opts.Listen, "listen", opts.Listen, "The address the proxy listens on, there is only the https listener if empty.")
+	cmd.Flags().StringVar(&opts.Upstream, "upstream", opts.Upstream, "The URL of the engine container to forward the requests to.")
+	cmd.Flags().StringVar(&opts.Model, "model", opts.Model, "The model served by the engine container, the API keys are checked against it. "+
+		"The model in the request body is checked if not defined.")
+	cmd.Flags().StringVar(&opts.AuthConfig, "auth-config", opts.AuthConfig, "The API keys required in the requests, in the JSON format of proxy.AuthConfig.")
+	cmd.Flags().StringVar(&opts.QuotaConfig, "quota-config", opts.QuotaConfig, "The quota file maintained by the operator, the requests are not limited if not defined.")
+	addTLSFlags(cmd, &opts.TLS)
+	cmd.Flags().StringVar(&opts.Admin.Listen, "admin-listen", opts.Admin.Listen, "The address the admin listener listens on, it also serves the activity and the quota usage to the operator. "+
+		"There is no admin listener if empty.")
+	cmd.Flags().StringSliceVar(&opts.Admin.Paths, "admin-paths", opts.Admin.Paths, "The paths of the engine the admin listener forwards the GET requests to "+
+		"without the admin token, like the health and the metrics endpoints.")
+	cmd.Flags().StringVar(&opts.Admin.TokenFile, "admin-token-file", opts.Admin.TokenFile, "The token of the operator required by the admin listener for the other requests, "+
+		"they are all rejected if not defined.")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return runProxy(ctrl.SetupSignalHandler(), &opts)
+	}
+	return cmd
+}
+
+func runProxy(ctx context.Context, opts *ProxyOptions) error {
+	sidecar, err := proxy.NewSidecar(opts.Upstream)
+	if err != nil {
+		return err
+	}
+	var handler http.Handler = sidecar
+	var limiter *proxy.Limiter
+	if opts.QuotaConfig != "" {
+		limiter = proxy.NewLimiter()
+		if err := limiter.LoadConfig(opts.QuotaConfig); err != nil {
+			return err
+		}
+		go limiter.WatchConfig(ctx, opts.QuotaConfig, quotaReloadInterval)
+		handler = proxy.EnforceQuota(limiter, handler)
+	}
+	// the API key is checked first, so that the quota knows the client of the request
+	handler, err = withAuth(ctx, opts.AuthConfig, opts.Model, handler, authReloadInterval)
+	if err != nil {
+		return err
+	}
+	setupLog.Info("Starting the proxy", "listen", opts.Listen, "upstream", opts.Upstream, "auth", opts.AuthConfig != "", "quota", opts.QuotaConfig,
+		"tls", opts.TLS.CertFile != "", "admin", opts.Admin.Listen)
+	serve := func(ctx context.Context) error { return serveHTTPAndTLS(ctx, opts.Listen, &opts.TLS, handler) }
+	if opts.Admin.Listen == "" {
+		return serve(ctx)
+	}
+	admin, err := proxy.NewAdmin(opts.Upstream, opts.Admin.Paths, opts.Admin.TokenFile)
+	if err != nil {
+		return err
+	}
+	admin.Handle(proxy.ActivityPath, sidecar.ActivityHandler())
+	if limiter != nil {
+		admin.Handle(proxy.QuotaPath, limiter.UsageHandler())
+	}
+	return serveAll(ctx, serve, func(ctx context.Context) error { return serveHTTP(ctx, opts.Admin.Listen, nil, admin) })
+}
+
+// withAuth requires the API keys in the auth config in front of the handler, the handler is returned as it is
+// when there is no auth config.
+func withAuth(ctx context.Context, authConfig string, model string, handler http.Handler, reloadInterval time.Duration) (http.Handler, error) {
+	if authConfig == "" {
+		return handler, nil
+	}
+	config := proxy.AuthConfig{}
+	if err := json.Unmarshal([]byte(authConfig), &config); err != nil {
+		return nil, fmt.Errorf("invalid auth config: %w", err)
+	}
+	auth, err := proxy.NewAuthenticator(config)
+	if err != nil {
+		return nil, err
+	}
+	go auth.WatchKeys(ctx, reloadInterval)
+	return proxy.RequireAPIKey(auth, model, handler), nil
+}
+
+// serveHTTPAndTLS serves the handler on the http address, and on the https address as well when there is a
+// certificate, until the context is done or one of them fails. There is only the https listener when the http
+// address is empty.
+func serveHTTPAndTLS(ctx context.Context, addr string, tlsOpts *TLSOptions, handler http.Handler) error {
+	if tlsOpts.CertFile == "" {
+		if addr == "" {
+			return errors.New("there is no listener, the http address and the certificate are both empty")
+		}
+		return serveHTTP(ctx, addr, nil, handler)
+	}
+	certs, err := proxy.NewCertificateReloader(tlsOpts.CertFile, tlsOpts.KeyFile)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go certs.WatchCertificate(ctx, certReloadInterval)
+	if addr == "" {
+		return serveHTTP(ctx, tlsOpts.Listen, certs.TLSConfig(), handler)
+	}
+	return serveAll(ctx,
+		func(ctx context.Context) error { return serveHTTP(ctx, addr, nil, handler) },
+		func(ctx context.Context) error { return serveHTTP(ctx, tlsOpts.Listen, certs.TLSConfig(), handler) })
+}
+
+// serveAll runs the serve functions until the context is done or one of them fails
+func serveAll(ctx context.Context, serves ...func(ctx context.Context) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errs := make(chan error, len(serves))
+	for _, serve := range serves {
+		go func() { errs <- serve(ctx) }()
+	}
+	// the others are stopped once any of them returns
+	err := <-errs
+	cancel()
+	for range serves[1:] {
+		err = errors.Join(err, <-errs)
+	}
+	return err
+}
+
+// serveHTTP serves the handler until the context is done, it serves https when the tlsConfig is defined
+func serveHTTP(ctx context.Context, addr string, tlsConfig *tls.Config, handler http.Handler) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	var err error
+	if tlsConfig != nil {
+		// the certificate comes from the GetCertificate of the tlsConfig
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
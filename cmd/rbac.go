@@ -0,0 +1,109 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+type RBACOptions struct {
+	WatchNamespaces string
+	ClusterRole     string
+	NamePrefix      string
+	ServiceAccount  types.NamespacedName
+	Kubeconfig      string
+}
+
+// NewRBACCommand prints the Roles and the RoleBindings to install the operator watching only some namespaces
+func NewRBACCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rbac",
+		Short: "Prints the Roles and the RoleBindings of the operator for the namespaces it watches",
+		Long: "Prints the Roles with the rules of the ClusterRole of the operator and the RoleBindings of them to its " +
+			"ServiceAccount in each of the namespaces of --watch-namespaces, to install the operator with them instead " +
+			"of the ClusterRoleBinding. The namespaces matching a label selector are listed in the cluster.",
+	}
+
+	opts := RBACOptions{
+		ClusterRole:    "config/rbac/role.yaml",
+		NamePrefix:     "aitrigram-",
+		ServiceAccount: types.NamespacedName{Namespace: "aitrigram-system", Name: "aitrigram-controller-manager"},
+	}
+	addWatchNamespacesFlag(cmd, &opts.WatchNamespaces)
+	cmd.Flags().StringVar(&opts.ClusterRole, "cluster-role", opts.ClusterRole,
+		"The file of the ClusterRole generated for the operator, the rules of the Roles are taken from it.")
+	cmd.Flags().StringVar(&opts.NamePrefix, "name-prefix", opts.NamePrefix,
+		"The prefix of the names of the Roles and the RoleBindings, the namePrefix of config/default.")
+	cmd.Flags().StringVar(&opts.ServiceAccount.Name, "service-account", opts.ServiceAccount.Name,
+		"The name of the ServiceAccount the operator runs as.")
+	cmd.Flags().StringVar(&opts.ServiceAccount.Namespace, "service-account-namespace", opts.ServiceAccount.Namespace,
+		"The namespace of the ServiceAccount the operator runs as.")
+	cmd.Flags().StringVar(&opts.Kubeconfig, "kubeconfig", opts.Kubeconfig,
+		"The kubeconfig of the cluster to list the namespaces in, the KUBECONFIG env or the in-cluster config is used if not set.")
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		return printRBAC(cmd.Context(), cmd.OutOrStdout(), &opts)
+	}
+	return cmd
+}
+
+func addWatchNamespacesFlag(cmd *cobra.Command, watchNamespaces *string) {
+	cmd.Flags().StringVar(watchNamespaces, "watch-namespaces", *watchNamespaces,
+		"The namespaces the operator watches, a comma separated list of their names, like: team-a,team-b, "+
+			"or a label selector of them, like: tenant=llm. The whole cluster is watched if not set.")
+}
+
+func printRBAC(ctx context.Context, w io.Writer, opts *RBACOptions) error {
+	watchNamespaces, err := controller.ParseWatchNamespaces(opts.WatchNamespaces)
+	if err != nil {
+		return err
+	}
+	if watchNamespaces == nil {
+		return errors.New("--watch-namespaces is required, the ClusterRoleBinding is used to watch the whole cluster")
+	}
+	content, err := os.ReadFile(opts.ClusterRole)
+	if err != nil {
+		return err
+	}
+	clusterRole := &rbacv1.ClusterRole{}
+	if err := yaml.UnmarshalStrict(content, clusterRole); err != nil {
+		return fmt.Errorf("failed to read the ClusterRole in %s: %w", opts.ClusterRole, err)
+	}
+	clusterRole.Name = opts.NamePrefix + clusterRole.Name
+	namespaces := watchNamespaces.Names
+	if watchNamespaces.Selector != nil {
+		c, err := newClient(opts.Kubeconfig)
+		if err != nil {
+			return err
+		}
+		if namespaces, err = watchNamespaces.Resolve(ctx, c); err != nil {
+			return err
+		}
+	}
+	return printObjects(w, watchNamespaces.RBAC(clusterRole, opts.ServiceAccount, namespaces))
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+// ManifestOptions are where the manifests are read from by the commands working without a cluster
+type ManifestOptions struct {
+	Filenames []string
+	Namespace string
+}
+
+type RenderOptions struct {
+	ManifestOptions
+	ProxyImage string
+}
+
+// manifest is an object read from the manifests with the file it is defined in
+type manifest struct {
+	object client.Object
+	source string
+	// strictErr has the unknown and the duplicated fields of the object, which are dropped
+	strictErr error
+}
+
+// NewRenderCommand prints the Deployments and the Services the operator creates for the LLMEngines and the LLMModels
+// in the manifests, without a cluster
+func NewRenderCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "render",
+		Short: "Prints the Deployments and the Services of the LLMEngines and the LLMModels in the manifests without a cluster",
+	}
+
+	opts := RenderOptions{
+		ManifestOptions: ManifestOptions{Namespace: "default"},
+		ProxyImage:      defaultProxyImage,
+	}
+	addManifestFlags(cmd, &opts.ManifestOptions)
+	addProxyImageFlag(cmd, &opts.ProxyImage)
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		manifests, err := readManifests(opts.Filenames, opts.Namespace)
+		if err != nil {
+			return err
+		}
+		objs, err := renderManifests(cmd.Context(), manifests, opts.ProxyImage)
+		if err != nil {
+			return err
+		}
+		return printObjects(cmd.OutOrStdout(), objs)
+	}
+	return cmd
+}
+
+func addManifestFlags(cmd *cobra.Command, opts *ManifestOptions) {
+	cmd.Flags().StringSliceVarP(&opts.Filenames, "filename", "f", opts.Filenames,
+		"The files or the directories of the manifests, - reads them from the stdin.")
+	cmd.Flags().StringVarP(&opts.Namespace, "namespace", "n", opts.Namespace,
+		"The namespace of the resources in the manifests without one.")
+	_ = cmd.MarkFlagRequired("filename")
+}
+
+func addProxyImageFlag(cmd *cobra.Command, proxyImage *string) {
+	cmd.Flags().StringVar(proxyImage, "proxy-image", *proxyImage,
+		"The image of the proxy sidecar and of the gateways, as the --proxy-image of the operator.")
+}
+
+// Renders the resources of the manifests by the same defaulting and builders as the operator
+func renderManifests(ctx context.Context, manifests []manifest, proxyImage string) ([]client.Object, error) {
+	objs := make([]client.Object, 0, len(manifests))
+	for _, m := range manifests {
+		objs = append(objs, m.object)
+	}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+	return controller.Render(ctx, reader, scheme, controller.RenderOptions{ProxyImage: proxyImage})
+}
+
+// Reads the objects of the kinds known by the operator from the manifests in the files, the directories are read
+// recursively for the .yaml, .yml and .json files. The other kinds, like the Namespaces, are skipped.
+func readManifests(paths []string, namespace string) ([]manifest, error) {
+	decoder := serializer.NewCodecFactory(scheme, serializer.EnableStrict).UniversalDeserializer()
+	var manifests []manifest
+	seen := map[string]string{}
+	read := func(name string, r io.Reader) error {
+		reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+		for {
+			doc, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			if strings.TrimSpace(string(doc)) == "" {
+				continue
+			}
+			obj, _, err := decoder.Decode(doc, nil, nil)
+			if runtime.IsNotRegisteredError(err) {
+				continue
+			}
+			var strictErr error
+			if runtime.IsStrictDecodingError(err) && obj != nil {
+				strictErr, err = err, nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to decode %s: %w", name, err)
+			}
+			clientObj, ok := obj.(client.Object)
+			if !ok {
+				continue
+			}
+			if clientObj.GetNamespace() == "" {
+				clientObj.SetNamespace(namespace)
+			}
+			key := fmt.Sprintf("%s %s", obj.GetObjectKind().GroupVersionKind().Kind, client.ObjectKeyFromObject(clientObj))
+			if previous, ok := seen[key]; ok {
+				return fmt.Errorf("%s in %s is defined in %s as well", key, name, previous)
+			}
+			seen[key] = name
+			manifests = append(manifests, manifest{object: clientObj, source: name, strictErr: strictErr})
+		}
+	}
+	for _, path := range paths {
+		if path == "-" {
+			if err := read("stdin", os.Stdin); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		err := filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			if name != path && !strings.HasSuffix(name, ".yaml") && !strings.HasSuffix(name, ".yml") && !strings.HasSuffix(name, ".json") {
+				return nil
+			}
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer func() { _ = f.Close() }()
+			return read(name, f)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifests, nil
+}
+
+// Prints the objects as YAML documents
+func printObjects(w io.Writer, objs []client.Object) error {
+	for _, obj := range objs {
+		content, err := renderedContent(obj)
+		if err != nil {
+			return err
+		}
+		out, err := yaml.Marshal(content)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The content of a rendered object, without the empty status and the creation timestamps
+func renderedContent(obj client.Object) (map[string]interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	unstructured.RemoveNestedField(content, "status")
+	unstructured.RemoveNestedField(content, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(content, "spec", "template", "metadata", "creationTimestamp")
+	return content, nil
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/controller"
+	webhookv1 "github.com/gaol/AITrigram/internal/webhook/v1"
+)
+
+// NewValidateCommand checks the LLMEngines and the LLMModels in the manifests by the validating webhooks of the
+// operator and the checks of the reconcilers, without a cluster
+func NewValidateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Validates the LLMEngines and the LLMModels in the manifests as the webhooks of the operator without a cluster",
+	}
+
+	opts := ManifestOptions{Namespace: "default"}
+	addManifestFlags(cmd, &opts)
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		problems, err := validateManifests(cmd.Context(), &opts)
+		if err != nil {
+			return err
+		}
+		invalid := 0
+		for _, p := range problems {
+			if _, err := fmt.Fprintln(cmd.ErrOrStderr(), p); err != nil {
+				return err
+			}
+			if !p.warning {
+				invalid++
+			}
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%d errors found in the manifests", invalid)
+		}
+		return nil
+	}
+	return cmd
+}
+
+// problem is an error or a warning of an object in the manifests
+type problem struct {
+	source  string
+	object  string
+	field   string
+	message string
+	warning bool
+}
+
+func (p problem) String() string {
+	level := "error"
+	if p.warning {
+		level = "warning"
+	}
+	if p.field == "" {
+		return fmt.Sprintf("%s: %s: %s: %s", p.source, level, p.object, p.message)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s: %s", p.source, level, p.object, p.field, p.message)
+}
+
+// Validates the manifests by the decoding, the validating webhooks and the references between the objects, and
+// renders them when they are valid to find the errors the reconcilers would run into, like a missing Modelfile.
+func validateManifests(ctx context.Context, opts *ManifestOptions) ([]problem, error) {
+	manifests, err := readManifests(opts.Filenames, opts.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	engines := map[types.NamespacedName]bool{}
+	for _, m := range manifests {
+		if _, ok := m.object.(*aitrigramv1.LLMEngine); ok {
+			engines[client.ObjectKeyFromObject(m.object)] = true
+		}
+	}
+
+	var problems []problem
+	for _, m := range manifests {
+		object := fmt.Sprintf("%s %s", m.object.GetObjectKind().GroupVersionKind().Kind, client.ObjectKeyFromObject(m.object))
+		report := func(field, message string, warning bool) {
+			problems = append(problems, problem{source: m.source, object: object, field: field, message: message, warning: warning})
+		}
+		if strictErr, ok := runtime.AsStrictDecodingError(m.strictErr); ok {
+			for _, err := range strictErr.Errors() {
+				report("", err.Error(), false)
+			}
+		}
+
+		var warnings admission.Warnings
+		var err error
+		// the checks of the reconcilers are not enforced by the webhooks, which would reject the objects accepted before
+		var allErrs field.ErrorList
+		switch obj := m.object.(type) {
+		case *aitrigramv1.LLMEngine:
+			warnings, err = (&webhookv1.LLMEngineCustomValidator{}).ValidateCreate(ctx, obj)
+			allErrs = controller.ValidateLLMEngine(obj)
+		case *aitrigramv1.LLMModel:
+			warnings, err = (&webhookv1.LLMModelCustomValidator{}).ValidateCreate(ctx, obj)
+			allErrs = controller.ValidateLLMModel(obj)
+			if engineKey := (types.NamespacedName{Namespace: obj.Namespace, Name: obj.Spec.EngineRef}); !engines[engineKey] {
+				notFound := field.NotFound(field.NewPath("spec", "engineRef"), obj.Spec.EngineRef)
+				report(notFound.Field, notFound.ErrorBody(), false)
+			}
+		}
+		for _, warning := range warnings {
+			report("", warning, true)
+		}
+		for _, fieldErr := range allErrs {
+			report(fieldErr.Field, fieldErr.ErrorBody(), false)
+		}
+		var statusErr *apierrors.StatusError
+		if errors.As(err, &statusErr) && statusErr.Status().Details != nil {
+			for _, cause := range statusErr.Status().Details.Causes {
+				report(cause.Field, cause.Message, false)
+			}
+		} else if err != nil {
+			report("", err.Error(), false)
+		}
+	}
+
+	for _, p := range problems {
+		if !p.warning {
+			return problems, nil
+		}
+	}
+	if _, err := renderManifests(ctx, manifests, defaultProxyImage); err != nil {
+		problems = append(problems, problem{source: "render", object: "manifests", message: err.Error()})
+	}
+	return problems, nil
+}
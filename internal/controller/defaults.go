@@ -4,6 +4,7 @@ import (
 	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/utils/ptr"
 )
 
 func cacheAndModelsMount(storage *aitrigramv1.LLMEngineStorage) ([]corev1.Volume, []corev1.VolumeMount) {
@@ -96,6 +97,33 @@ func defaultsModelDeploymentSpecOllama() *aitrigramv1.ModelDeploymentTemplate {
 	}
 }
 
+// DefaultsOfLLMEngine returns the defaults of the engine type of the LLMEngine, with the template of its security
+// profile. The LLMEngines without a profile get the Restricted one, the Unrestricted profile keeps the pods as the
+// images run them.
+// The template of the engine is merged after them, so its securityContexts replace the ones of the profile.
+func DefaultsOfLLMEngine(llmEngine *aitrigramv1.LLMEngine) *aitrigramv1.LLMEngineSpec {
+	spec := DefaultLLMEngineSpec(&llmEngine.Spec.EngineType).DeepCopy()
+	profile := llmEngine.Spec.SecurityProfile
+	if profile == "" {
+		profile = aitrigramv1.SecurityProfileRestricted
+	}
+	spec.SecurityProfile = profile
+	restricted := restrictedProfileTemplate(llmEngine.Spec.EngineType)
+	if profile != aitrigramv1.SecurityProfileRestricted || restricted == nil {
+		return spec
+	}
+	template := spec.ModelDeploymentTemplate
+	if template == nil {
+		template = &aitrigramv1.ModelDeploymentTemplate{}
+	}
+	envs := append(ptr.Deref(template.Envs, nil), *restricted.Envs...)
+	template.Envs = &envs
+	template.PodSecurityContext = restricted.PodSecurityContext
+	template.SecurityContext = restricted.SecurityContext
+	spec.ModelDeploymentTemplate = template
+	return spec
+}
+
 var (
 	ollamaEngineType                                   = aitrigramv1.LLMEngineTypeOllama
 	DefaultOllamaEngineSpec *aitrigramv1.LLMEngineSpec = DefaultLLMEngineSpec(&ollamaEngineType)
@@ -111,6 +139,10 @@ func MergeModelDeploymentTemplate(modelSpecs ...*aitrigramv1.ModelDeploymentTemp
 		return modelSpecs[0], nil
 	}
 	result := modelSpecs[0]
+	if result == nil {
+		// like the defaults of the engine types without a profile
+		result = &aitrigramv1.ModelDeploymentTemplate{}
+	}
 	for _, ms := range modelSpecs[1:] {
 		if ms == nil {
 			continue
@@ -134,6 +166,12 @@ func MergeModelDeploymentTemplate(modelSpecs ...*aitrigramv1.ModelDeploymentTemp
 		if ms.Storage != nil {
 			result.Storage = mergeStorages(result.Storage, ms.Storage)
 		}
+		if ms.PodSecurityContext != nil {
+			result.PodSecurityContext = ms.PodSecurityContext
+		}
+		if ms.SecurityContext != nil {
+			result.SecurityContext = ms.SecurityContext
+		}
 	}
 	return result, nil
 }
@@ -170,6 +208,36 @@ func MergeLLMSpecs(llmEngineSpecs ...*aitrigramv1.LLMEngineSpec) (*aitrigramv1.L
 			}
 			result.ModelDeploymentTemplate = modelDeploymentTemplate
 		}
+		if llmSpec.Gateway != nil {
+			result.Gateway = llmSpec.Gateway
+		}
+		if llmSpec.Exposure != nil {
+			result.Exposure = llmSpec.Exposure
+		}
+		if llmSpec.ServingMode != "" {
+			result.ServingMode = llmSpec.ServingMode
+		}
+		if llmSpec.SharedReplicas != nil {
+			result.SharedReplicas = llmSpec.SharedReplicas
+		}
+		if llmSpec.Monitoring != nil {
+			result.Monitoring = llmSpec.Monitoring
+		}
+		if llmSpec.Auth != nil {
+			result.Auth = llmSpec.Auth
+		}
+		if llmSpec.NetworkPolicy != nil {
+			result.NetworkPolicy = llmSpec.NetworkPolicy
+		}
+		if llmSpec.TLS != nil {
+			result.TLS = llmSpec.TLS
+		}
+		if llmSpec.ServiceAccount != nil {
+			result.ServiceAccount = llmSpec.ServiceAccount
+		}
+		if llmSpec.SecurityProfile != "" {
+			result.SecurityProfile = llmSpec.SecurityProfile
+		}
 	}
 	return result, nil
 }
@@ -196,3 +264,40 @@ func mergeStorages(storages ...*aitrigramv1.LLMEngineStorage) *aitrigramv1.LLMEn
 	}
 	return result
 }
+
+// Merge the ExposureSpec, the later overrides the previous ones.
+// The first one is not modified because it is the one in the LLMEngine.
+func MergeExposures(exposures ...*aitrigramv1.ExposureSpec) *aitrigramv1.ExposureSpec {
+	var result *aitrigramv1.ExposureSpec
+	for _, exposure := range exposures {
+		if exposure == nil {
+			continue
+		}
+		if result == nil {
+			result = exposure.DeepCopy()
+			continue
+		}
+		if exposure.Type != "" {
+			result.Type = exposure.Type
+		}
+		if exposure.Host != "" {
+			result.Host = exposure.Host
+		}
+		if exposure.PathPrefix != "" {
+			result.PathPrefix = exposure.PathPrefix
+		}
+		if exposure.TLSSecretName != "" {
+			result.TLSSecretName = exposure.TLSSecretName
+		}
+		if exposure.ClassName != "" {
+			result.ClassName = exposure.ClassName
+		}
+		if exposure.GatewayNamespace != "" {
+			result.GatewayNamespace = exposure.GatewayNamespace
+		}
+		if exposure.Annotations != nil {
+			result.Annotations = MergeMaps(result.Annotations, exposure.Annotations)
+		}
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package controller
+
+import (
+	"fmt"
+	"sync"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+)
+
+// The reasons of the Events recorded on the LLMEngines and the LLMModels
+const (
+	eventReasonDeploymentCreated     = "DeploymentCreated"
+	eventReasonDeploymentUpdated     = "DeploymentUpdated"
+	eventReasonServiceCreated        = "ServiceCreated"
+	eventReasonDownloadStarted       = "DownloadStarted"
+	eventReasonDownloadFailed        = "DownloadFailed"
+	eventReasonEngineNotFound        = "EngineNotFound"
+	eventReasonSpecDefaulted         = "SpecDefaulted"
+	eventReasonRolloutComplete       = "RolloutComplete"
+	eventReasonRoleRefRejected       = "RoleRefRejected"
+	eventReasonQuotaRejected         = "QuotaRejected"
+	eventReasonSharedServingRejected = "SharedServingRejected"
+)
+
+// the annotation of the Deployment with the revision of its current ReplicaSet
+const deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Records an Event on the object, it does nothing when the reconciler has no EventRecorder
+func recordEvent(recorder record.EventRecorder, obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if recorder == nil {
+		return
+	}
+	recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
+
+// Records the Events of the init containers downloading the model in the pods, each is recorded once
+func recordDownloadEvents(recorder record.EventRecorder, obj runtime.Object, events []downloadEvent) {
+	for _, event := range events {
+		if event.failed {
+			recordEvent(recorder, obj, corev1.EventTypeWarning, eventReasonDownloadFailed,
+				"Downloading the model failed in the pod %s: %s", event.pod, event.message)
+			continue
+		}
+		recordEvent(recorder, obj, corev1.EventTypeNormal, eventReasonDownloadStarted,
+			"Downloading the model in the pod %s", event.pod)
+	}
+}
+
+// A download started or failed in a pod
+type downloadEvent struct {
+	pod     string
+	failed  bool
+	message string
+}
+
+// DownloadFailureMessage returns the message of the last failed run of the init container downloading a model
+func DownloadFailureMessage(status corev1.ContainerStatus) string {
+	terminated := status.LastTerminationState.Terminated
+	if terminated == nil {
+		terminated = status.State.Terminated
+	}
+	if terminated == nil {
+		return fmt.Sprintf("restarted %d times", status.RestartCount)
+	}
+	if terminated.Message != "" {
+		return fmt.Sprintf("exit code %d, %s", terminated.ExitCode, terminated.Message)
+	}
+	return fmt.Sprintf("exit code %d, %s", terminated.ExitCode, terminated.Reason)
+}
+
+// rolloutTracker remembers the last completed revision of each Deployment, so that the completion of a
+// rollout is recorded once no matter how many times it is reconciled.
+type rolloutTracker struct {
+	revisions sync.Map
+}
+
+// completed returns true the first time the current revision of the Deployment is rolled out to all its pods
+func (t *rolloutTracker) completed(deployment *appsv1.Deployment) bool {
+	status := deployment.Status
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	if replicas == 0 || status.ObservedGeneration < deployment.Generation ||
+		status.UpdatedReplicas != replicas || status.Replicas != replicas || status.AvailableReplicas != replicas {
+		return false
+	}
+	key := types.NamespacedName{Namespace: deployment.Namespace, Name: deployment.Name}
+	revision := deployment.Annotations[deploymentRevisionAnnotation]
+	previous, loaded := t.revisions.Swap(key, revision)
+	return !loaded || previous != revision
+}
@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// Returns the Events recorded so far
+func recordedEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func Test_LLMModelEvents(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "events"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: "ollama", Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &appsv1.Deployment{}).
+		Build()
+	recorder := record.NewFakeRecorder(100)
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, Recorder: recorder}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+
+	reconcileTimes(t, r, req, 1)
+	require.Equal(t, []string{"Warning EngineNotFound The LLMEngine ollama is not found"}, recordedEvents(recorder))
+
+	llmEngine := newTestLLMEngine()
+	llmEngine.Namespace = "events"
+	require.NoError(t, k8sClient.Create(ctx, llmEngine))
+	reconcileTimes(t, r, req, 3)
+	require.Equal(t, []string{
+		"Normal SpecDefaulted The modelDeployment is defaulted from the LLMEngine ollama",
+		"Normal DeploymentCreated Created the Deployment ollama-llama3",
+		"Normal ServiceCreated Created the Service ollama-llama3",
+	}, recordedEvents(recorder))
+
+	// the download is started, then fails once
+	pod := newReadyPod("ollama-llama3-0", llmModelLabels("ollama-llama3"))
+	pod.Namespace = "events"
+	pod.UID = "ollama-llama3-0-uid"
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "init-ollama-llama3",
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}}
+	require.NoError(t, k8sClient.Create(ctx, pod))
+	reconcileTimes(t, r, req, 2)
+	require.Equal(t, []string{"Normal DownloadStarted Downloading the model in the pod ollama-llama3-0"}, recordedEvents(recorder))
+	pod.Status.InitContainerStatuses[0].RestartCount = 1
+	pod.Status.InitContainerStatuses[0].LastTerminationState = corev1.ContainerState{
+		Terminated: &corev1.ContainerStateTerminated{ExitCode: 1, Message: "pull model manifest: file does not exist"},
+	}
+	require.NoError(t, k8sClient.Status().Update(ctx, pod))
+	reconcileTimes(t, r, req, 2)
+	require.Equal(t, []string{
+		"Warning DownloadFailed Downloading the model failed in the pod ollama-llama3-0: exit code 1, pull model manifest: file does not exist",
+	}, recordedEvents(recorder))
+
+	// the completion of the rollout is recorded once
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Namespace: "events", Name: "ollama-llama3"}, deployment))
+	deployment.Status = appsv1.DeploymentStatus{
+		ObservedGeneration: deployment.Generation,
+		Replicas:           1,
+		UpdatedReplicas:    1,
+		ReadyReplicas:      1,
+		AvailableReplicas:  1,
+	}
+	require.NoError(t, k8sClient.Status().Update(ctx, deployment))
+	reconcileTimes(t, r, req, 2)
+	require.Equal(t, []string{"Normal RolloutComplete The Deployment ollama-llama3 has rolled out 1 pods"}, recordedEvents(recorder))
+
+	// a change of the model updates the Deployment
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.Replicas = 2
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.Equal(t, []string{"Normal DeploymentUpdated Updated the Deployment ollama-llama3"}, recordedEvents(recorder))
+}
+
+func Test_LLMEngineEvents(t *testing.T) {
+	t.Parallel()
+	scheme := newTestScheme(t)
+	llmEngine := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama", Namespace: "default"},
+		Spec: aitrigramv1.LLMEngineSpec{
+			EngineType: aitrigramv1.LLMEngineTypeOllama,
+			Gateway:    &aitrigramv1.GatewaySpec{Enabled: true, Replicas: ptr.To[int32](1)},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine).
+		WithStatusSubresource(&aitrigramv1.LLMEngine{}).
+		Build()
+	recorder := record.NewFakeRecorder(100)
+	r := &LLMEngineReconciler{Client: k8sClient, Scheme: scheme, Recorder: recorder}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmEngine)}
+	for i := 0; i < 3; i++ {
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{
+		"Normal SpecDefaulted The spec is defaulted for the engine type ollama",
+		"Normal DeploymentCreated Created the Deployment ollama-gateway",
+		"Normal ServiceCreated Created the Service ollama-gateway",
+	}, recordedEvents(recorder))
+}
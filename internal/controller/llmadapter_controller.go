@@ -0,0 +1,293 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// LLMAdapterFinalizer is added to each LLMAdapter so that it is unloaded from the engine before it goes away.
+	LLMAdapterFinalizer = "aitrigram.ihomeland.cn/llmadapter-finalizer"
+
+	// how often the adapters are checked to be loaded in the pods, which lose them once restarted
+	adapterResyncPeriod = 30 * time.Second
+)
+
+// LLMAdapterReconciler reconciles a LLMAdapter object
+type LLMAdapterReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// loader loads the adapters into the pods, it is replaced in tests
+	loader adapterLoader
+}
+
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmadapters,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmadapters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmadapters/finalizers,verbs=update
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodels,verbs=get;list;watch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmengines,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+
+// Reconcile makes the LLMAdapter served by the engine of its base LLMModel at runtime, so the adapters come and go
+// without a rollout of the base LLMModel. The adapter is downloaded into the models storage by a Job first when the
+// Download is defined. The vllm engine loads it with its runtime LoRA updating API, and the ollama engine creates it
+// as a model over the base model with its create API, from the blobs the Job copies the adapter into.
+func (r *LLMAdapterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	llmAdapter := &aitrigramv1.LLMAdapter{}
+	if err := r.Get(ctx, req.NamespacedName, llmAdapter); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	if !llmAdapter.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, r.finalizeLLMAdapter(ctx, llmAdapter)
+	}
+	if controllerutil.AddFinalizer(llmAdapter, LLMAdapterFinalizer) {
+		if err := r.Update(ctx, llmAdapter); err != nil {
+			logger.Error(err, "Failed to add the finalizer to the llmadapter")
+			return ctrl.Result{}, err
+		}
+	}
+
+	llmModel, llmEngine, err := r.baseModelOf(ctx, llmAdapter)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("Waiting for the base LLMModel and its LLMEngine", "modelRef", llmAdapter.Spec.ModelRef)
+			condition := metav1.Condition{
+				Type:    aitrigramv1.ConditionTypeReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ModelNotFound",
+				Message: err.Error(),
+			}
+			return ctrl.Result{RequeueAfter: time.Second * 10}, r.updateLLMAdapterStatus(ctx, req, &condition, nil)
+		}
+		return ctrl.Result{}, err
+	}
+
+	// Set ownerReference to the base model, so that the llmAdapter gets garbage collected with the model
+	if !metav1.IsControlledBy(llmAdapter, llmModel) {
+		if err := ctrl.SetControllerReference(llmModel, llmAdapter, r.Scheme); err != nil {
+			logger.Error(err, "Failed to set owner reference")
+			return ctrl.Result{}, err
+		}
+		if err := r.Update(ctx, llmAdapter); err != nil {
+			logger.Error(err, "Failed to update the owner reference of the llmadapter")
+			return ctrl.Result{}, err
+		}
+	}
+
+	status := &aitrigramv1.LLMAdapterStatus{
+		ModelName: adapterModelName(llmAdapter),
+		Backend:   llmModelBackend(llmEngine, llmModel),
+	}
+	notPrepared, blobs, err := r.reconcileAdapterJob(ctx, llmAdapter, llmModel, llmEngine)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if notPrepared != nil {
+		status.Blobs = llmAdapter.Status.Blobs
+		return ctrl.Result{RequeueAfter: time.Second * 10}, r.updateLLMAdapterStatus(ctx, req, notPrepared, status)
+	}
+	// the adapter is created again from the new blobs once they change
+	reload := len(llmAdapter.Status.Blobs) > 0 && !maps.Equal(llmAdapter.Status.Blobs, blobs)
+	llmAdapter.Status.Blobs = blobs
+	status.Blobs = blobs
+
+	pods, err := readyModelPods(ctx, r.Client, req.Namespace, backendPodLabels(llmEngine, llmModel))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	status.LoadedReplicas = r.loadIntoPods(ctx, llmAdapter, llmModel, llmEngine, pods, reload)
+	condition := metav1.Condition{
+		Type:    aitrigramv1.ConditionTypeReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Loaded",
+		Message: fmt.Sprintf("The adapter is loaded in %d of %d ready pods", status.LoadedReplicas, len(pods)),
+	}
+	if status.LoadedReplicas == 0 {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "WaitingForModel"
+		condition.Message = "There is no ready pod of the base LLMModel serving the adapter"
+	}
+	if err := r.updateLLMAdapterStatus(ctx, req, &condition, status); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: adapterResyncPeriod}, nil
+}
+
+// Loads the adapter into the pods which have not loaded it yet, or into all of them to reload it.
+// It returns the number of the pods serving it.
+func (r *LLMAdapterReconciler) loadIntoPods(ctx context.Context, llmAdapter *aitrigramv1.LLMAdapter, llmModel *aitrigramv1.LLMModel,
+	llmEngine *aitrigramv1.LLMEngine, pods []corev1.Pod, reload bool) int32 {
+	logger := log.FromContext(ctx)
+	name := adapterModelName(llmAdapter)
+	loaded := int32(0)
+	for _, pod := range pods {
+		endpoint, err := engineEndpointOf(ctx, r.Client, r.Scheme, llmEngine, &pod)
+		if err != nil {
+			logger.Error(err, "Failed to get the endpoint of the engine in the pod", "Pod.Name", pod.Name)
+			continue
+		}
+		loader := r.adapterLoader(llmEngine)
+		models, err := loader.LoadedModels(ctx, endpoint)
+		if err != nil {
+			logger.Error(err, "Failed to get the models served by the pod", "Pod.Name", pod.Name)
+			continue
+		}
+		if reload || !slices.Contains(models, name) {
+			logger.Info("Loading the adapter into the pod", "Pod.Name", pod.Name, "adapter", name)
+			if err := loader.Load(ctx, endpoint, llmAdapter, llmModelNameInEngine(llmModel)); err != nil {
+				logger.Error(err, "Failed to load the adapter into the pod", "Pod.Name", pod.Name)
+				continue
+			}
+		}
+		loaded++
+	}
+	return loaded
+}
+
+// Unloads the adapter from the pods, the failures are ignored because the pods may be gone already
+func (r *LLMAdapterReconciler) finalizeLLMAdapter(ctx context.Context, llmAdapter *aitrigramv1.LLMAdapter) error {
+	logger := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(llmAdapter, LLMAdapterFinalizer) {
+		return nil
+	}
+	llmModel, llmEngine, err := r.baseModelOf(ctx, llmAdapter)
+	if client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	if err == nil {
+		pods, err := readyModelPods(ctx, r.Client, llmAdapter.Namespace, backendPodLabels(llmEngine, llmModel))
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			endpoint, err := engineEndpointOf(ctx, r.Client, r.Scheme, llmEngine, &pod)
+			if err != nil {
+				return err
+			}
+			if err := r.adapterLoader(llmEngine).Unload(ctx, endpoint, adapterModelName(llmAdapter)); err != nil {
+				logger.Error(err, "Failed to unload the adapter from the pod", "Pod.Name", pod.Name)
+			}
+		}
+	}
+	controllerutil.RemoveFinalizer(llmAdapter, LLMAdapterFinalizer)
+	if err := r.Update(ctx, llmAdapter); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	logger.Info("LLMAdapter has been finalized")
+	return nil
+}
+
+// Returns the base LLMModel of the adapter and its LLMEngine
+func (r *LLMAdapterReconciler) baseModelOf(ctx context.Context, llmAdapter *aitrigramv1.LLMAdapter) (*aitrigramv1.LLMModel, *aitrigramv1.LLMEngine, error) {
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: llmAdapter.Namespace, Name: llmAdapter.Spec.ModelRef}, llmModel); err != nil {
+		return nil, nil, err
+	}
+	llmEngine := &aitrigramv1.LLMEngine{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: llmAdapter.Namespace, Name: llmModel.Spec.EngineRef}, llmEngine); err != nil {
+		return nil, nil, err
+	}
+	return llmModel, llmEngine, nil
+}
+
+func (r *LLMAdapterReconciler) adapterLoader(llmEngine *aitrigramv1.LLMEngine) adapterLoader {
+	if r.loader != nil {
+		return r.loader
+	}
+	return adapterLoaderOf(llmEngine.Spec.EngineType)
+}
+
+func (r *LLMAdapterReconciler) updateLLMAdapterStatus(ctx context.Context, req ctrl.Request, condition *metav1.Condition, status *aitrigramv1.LLMAdapterStatus) error {
+	llmAdapter := &aitrigramv1.LLMAdapter{}
+	if err := r.Get(ctx, req.NamespacedName, llmAdapter); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if status != nil {
+		llmAdapter.Status.ModelName = status.ModelName
+		llmAdapter.Status.Backend = status.Backend
+		llmAdapter.Status.LoadedReplicas = status.LoadedReplicas
+		llmAdapter.Status.Blobs = status.Blobs
+	}
+	meta.SetStatusCondition(&llmAdapter.Status.Conditions, *condition)
+	return r.Status().Update(ctx, llmAdapter)
+}
+
+// The model name of the adapter in the requests sent to the engine
+func adapterModelName(llmAdapter *aitrigramv1.LLMAdapter) string {
+	if llmAdapter.Spec.Name != "" {
+		return llmAdapter.Spec.Name
+	}
+	return llmAdapter.Name
+}
+
+// The labels of the pods serving the model, they are the pods of the shared Deployment in the Shared ServingMode
+func backendPodLabels(llmEngine *aitrigramv1.LLMEngine, llmModel *aitrigramv1.LLMModel) map[string]string {
+	if isSharedServing(llmEngine) {
+		return llmSharedLabels(llmSharedName(llmEngine))
+	}
+	return llmModelLabels(llmModelResourceName(llmEngine.Spec.EngineType, llmModel.Spec.Name))
+}
+
+// Returns the LLMAdapters which refer to the base LLMModel, sorted by name
+func llmAdaptersOfModel(ctx context.Context, c client.Reader, llmModel *aitrigramv1.LLMModel) ([]aitrigramv1.LLMAdapter, error) {
+	llmAdapterList := &aitrigramv1.LLMAdapterList{}
+	if err := c.List(ctx, llmAdapterList, client.InNamespace(llmModel.Namespace)); err != nil {
+		return nil, err
+	}
+	var llmAdapters []aitrigramv1.LLMAdapter
+	for _, llmAdapter := range llmAdapterList.Items {
+		if llmAdapter.Spec.ModelRef == llmModel.Name && llmAdapter.GetDeletionTimestamp().IsZero() {
+			llmAdapters = append(llmAdapters, llmAdapter)
+		}
+	}
+	sort.Slice(llmAdapters, func(i, j int) bool {
+		return llmAdapters[i].Name < llmAdapters[j].Name
+	})
+	return llmAdapters, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LLMAdapterReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&aitrigramv1.LLMAdapter{}).
+		Owns(&batchv1.Job{}).
+		Named("llmadapter").
+		Complete(withReconcileErrorMetrics("llmadapter", r))
+}
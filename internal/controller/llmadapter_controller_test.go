@@ -0,0 +1,365 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// a fake engine which keeps the loaded adapters of each pod
+type fakeAdapterLoader struct {
+	mu     sync.Mutex
+	loaded map[string][]string
+	loads  int
+	// the token, the base model and the blobs of the last load
+	token     string
+	baseModel string
+	blobs     map[string]string
+}
+
+func (l *fakeAdapterLoader) LoadedModels(_ context.Context, endpoint engineEndpoint) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.loaded[endpoint.baseURL]), nil
+}
+
+func (l *fakeAdapterLoader) Load(_ context.Context, endpoint engineEndpoint, llmAdapter *aitrigramv1.LLMAdapter, baseModel string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loads++
+	l.token = endpoint.token
+	l.baseModel = baseModel
+	l.blobs = llmAdapter.Status.Blobs
+	name := adapterModelName(llmAdapter)
+	if !slices.Contains(l.loaded[endpoint.baseURL], name) {
+		l.loaded[endpoint.baseURL] = append(l.loaded[endpoint.baseURL], name)
+	}
+	return nil
+}
+
+func (l *fakeAdapterLoader) Unload(_ context.Context, endpoint engineEndpoint, name string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.loaded[endpoint.baseURL] = slices.DeleteFunc(l.loaded[endpoint.baseURL], func(s string) bool { return s == name })
+	return nil
+}
+
+// Returns a vllm LLMEngine which has the values set, like it is retrieved from the cluster
+func newTestVLLMEngine() *aitrigramv1.LLMEngine {
+	llmEngine := newTestLLMEngine()
+	llmEngine.Name = "vllm"
+	llmEngine.Spec.EngineType = aitrigramv1.LLMEngineTypeVLLM
+	llmEngine.Spec.Image = "vllm/vllm-openai:latest"
+	llmEngine.Spec.Port = 8000
+	llmEngine.Spec.ModelDeploymentTemplate.Args = []string{"vllm", "serve", "meta-llama/Llama-3.1-8B-Instruct"}
+	llmEngine.Spec.ModelDeploymentTemplate.DownloadScripts = ""
+	return llmEngine
+}
+
+func Test_LLMAdapterVLLM(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestVLLMEngine()
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	llmAdapter := &aitrigramv1.LLMAdapter{
+		ObjectMeta: metav1.ObjectMeta{Name: "sql", Namespace: "default"},
+		Spec:       aitrigramv1.LLMAdapterSpec{ModelRef: "llama3", Name: "sql-lora", Source: "/models/adapters/sql"},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "vllm-llama3-0", Namespace: "default", Labels: llmModelLabels("vllm-llama3")},
+		Status: corev1.PodStatus{
+			PodIP:      "10.0.0.12",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel, llmAdapter, pod).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &aitrigramv1.LLMAdapter{}).
+		Build()
+
+	// the model Deployment has LoRA enabled for the runtime loading
+	modelReconciler := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	reconcileTimes(t, modelReconciler, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}, 3)
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "vllm-llama3", Namespace: "default"}, deployment))
+	container := deployment.Spec.Template.Spec.Containers[0]
+	require.Contains(t, container.Command, vllmEnableLoRAFlag)
+	require.Contains(t, container.Env, corev1.EnvVar{Name: vllmRuntimeLoRAUpdatingEnv, Value: "True"})
+
+	loader := &fakeAdapterLoader{loaded: map[string][]string{}}
+	r := &LLMAdapterReconciler{Client: k8sClient, Scheme: scheme, loader: loader}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmAdapter)}
+	for i := 0; i < 3; i++ {
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, loader.loads, "the adapter is loaded only once")
+	require.Equal(t, []string{"sql-lora"}, loader.loaded["http://10.0.0.12:8000"])
+
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmAdapter))
+	require.Equal(t, "sql-lora", llmAdapter.Status.ModelName)
+	require.Equal(t, "http://vllm-llama3.default.svc:8080", llmAdapter.Status.Backend)
+	require.Equal(t, int32(1), llmAdapter.Status.LoadedReplicas)
+	require.True(t, metav1.IsControlledBy(llmAdapter, llmModel))
+
+	// the adapter is unloaded once deleted, and the Deployment keeps LoRA enabled
+	require.NoError(t, k8sClient.Delete(ctx, llmAdapter))
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Empty(t, loader.loaded["http://10.0.0.12:8000"])
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, req.NamespacedName, llmAdapter)))
+	reconcileTimes(t, modelReconciler, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}, 1)
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "vllm-llama3", Namespace: "default"}, deployment))
+	require.Contains(t, deployment.Spec.Template.Spec.Containers[0].Command, vllmEnableLoRAFlag)
+}
+
+func Test_LLMAdapterVLLMAuth(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestVLLMEngine()
+	llmEngine.Spec.Auth = &aitrigramv1.AuthSpec{APIKeys: []aitrigramv1.APIKeySpec{
+		{SecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "team-a"}, Key: "key"}},
+	}}
+	llmEngine.Spec.Monitoring = &aitrigramv1.MonitoringSpec{Enabled: true}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	llmAdapter := &aitrigramv1.LLMAdapter{
+		ObjectMeta: metav1.ObjectMeta{Name: "sql", Namespace: "default"},
+		Spec:       aitrigramv1.LLMAdapterSpec{ModelRef: "llama3", Name: "sql-lora", Source: "/models/adapters/sql"},
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "vllm-llama3-0", Namespace: "default", Labels: llmModelLabels("vllm-llama3")},
+		Status: corev1.PodStatus{
+			PodIP:      "10.0.0.12",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel, llmAdapter, pod).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &aitrigramv1.LLMAdapter{}).
+		Build()
+
+	// vllm only listens on the loopback address, its health and metrics are served on the admin port of the sidecar
+	modelReconciler := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:latest"}
+	reconcileTimes(t, modelReconciler, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}, 3)
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "vllm-llama3", Namespace: "default"}, deployment))
+	containers := deployment.Spec.Template.Spec.Containers
+	require.Contains(t, containers[0].Command, "--host=127.0.0.1")
+	require.Equal(t, intstr.FromInt32(proxySidecarAdminPort), containers[0].ReadinessProbe.HTTPGet.Port)
+	require.Contains(t, containers[1].Args, "--admin-paths=/health,/metrics")
+	require.Equal(t, "15090", deployment.Spec.Template.Annotations[prometheusPortAnnotation])
+
+	// the adapter is loaded through the admin port with the admin token
+	loader := &fakeAdapterLoader{loaded: map[string][]string{}}
+	r := &LLMAdapterReconciler{Client: k8sClient, Scheme: scheme, loader: loader}
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmAdapter)})
+	require.NoError(t, err)
+	require.Equal(t, []string{"sql-lora"}, loader.loaded["http://10.0.0.12:15090"])
+	adminToken := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "vllm-proxy-admin", Namespace: "default"}, adminToken))
+	require.Equal(t, string(adminToken.Data[adminTokenKey]), loader.token)
+}
+
+func Test_LLMAdapterOllama(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.ModelDeploymentTemplate.Storage.ModelsStorage.VolumeSource = corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "models"},
+	}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", NameInEngine: "llama3.2:latest", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	llmAdapter := &aitrigramv1.LLMAdapter{
+		ObjectMeta: metav1.ObjectMeta{Name: "sql-lora", Namespace: "default"},
+		Spec: aitrigramv1.LLMAdapterSpec{
+			ModelRef: "llama3",
+			Source:   "/models/adapters/sql",
+			Download: &aitrigramv1.AdapterDownloadSpec{Repository: "algoprog/sql-lora"},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel, llmAdapter, newReadyPod("ollama-llama3-0", llmModelLabels("ollama-llama3"))).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &aitrigramv1.LLMAdapter{}, &batchv1.Job{}).
+		Build()
+	modelReconciler := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	reconcileTimes(t, modelReconciler, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}, 3)
+
+	// the adapters do not roll out the base model
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}, deployment))
+	require.NotContains(t, deployment.Spec.Template.Spec.InitContainers[0].Args[0], "ADAPTER")
+
+	// the Job downloads the adapter into the models storage and copies it into the blobs of ollama
+	loader := &fakeAdapterLoader{loaded: map[string][]string{}}
+	r := &LLMAdapterReconciler{Client: k8sClient, Scheme: scheme, loader: loader}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmAdapter)}
+	reconcileTimes(t, r, req, 2)
+	require.Zero(t, loader.loads)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmAdapter))
+	require.Equal(t, "Preparing", meta.FindStatusCondition(llmAdapter.Status.Conditions, aitrigramv1.ConditionTypeReady).Reason)
+	job := &batchv1.Job{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "sql-lora-adapter", Namespace: "default"}, job))
+	require.True(t, metav1.IsControlledBy(job, llmAdapter))
+	require.Equal(t, "llama3", job.Labels[LLMModelNameLabel])
+	// the pods are selected by the NetworkPolicy of the download of the base model
+	require.Equal(t, "ollama-llama3", job.Spec.Template.Labels[ModelDownloadLabel])
+	podSpec := job.Spec.Template.Spec
+	require.Equal(t, defaultAdapterDownloadImage, podSpec.Containers[0].Image)
+	require.Contains(t, podSpec.Containers[0].Args[0], "snapshot_download('algoprog/sql-lora', local_dir='/models/adapters/sql')")
+	require.Contains(t, podSpec.Containers[0].Args[0], `src='/models/adapters/sql' && blobs='/models/blobs'`)
+	require.Equal(t, "models", podSpec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	require.Equal(t, "/models", podSpec.Containers[0].VolumeMounts[0].MountPath)
+	require.Equal(t, llmEngine.Spec.ModelDeploymentTemplate.PodSecurityContext, podSpec.SecurityContext)
+
+	// the adapter is created over the base model from the blobs reported by the Job
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	require.NoError(t, k8sClient.Status().Update(ctx, job))
+	jobPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "sql-lora-adapter-x", Namespace: "default", Labels: map[string]string{batchv1.JobNameLabel: job.Name}},
+	}
+	require.NoError(t, k8sClient.Create(ctx, jobPod))
+	jobPod.Status = corev1.PodStatus{
+		Phase: corev1.PodSucceeded,
+		ContainerStatuses: []corev1.ContainerStatus{{
+			Name: adapterJobContainerName,
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				Message: `{"adapter_model.safetensors":"sha256:abc","adapter_config.json":"sha256:def"}`,
+			}},
+		}},
+	}
+	require.NoError(t, k8sClient.Status().Update(ctx, jobPod))
+	reconcileTimes(t, r, req, 2)
+	require.Equal(t, 1, loader.loads)
+	require.Equal(t, "llama3.2:latest", loader.baseModel)
+	blobs := map[string]string{"adapter_model.safetensors": "sha256:abc", "adapter_config.json": "sha256:def"}
+	require.Equal(t, blobs, loader.blobs)
+	require.Equal(t, []string{"sql-lora"}, loader.loaded["http://10.0.0.12:11434"])
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmAdapter))
+	require.Equal(t, blobs, llmAdapter.Status.Blobs)
+	require.Equal(t, int32(1), llmAdapter.Status.LoadedReplicas)
+
+	// it is deleted from ollama once deleted
+	require.NoError(t, k8sClient.Delete(ctx, llmAdapter))
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Empty(t, loader.loaded["http://10.0.0.12:11434"])
+}
+
+func Test_LLMAdapterOllamaStorageNotShared(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", NameInEngine: "llama3.2:latest", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	llmAdapter := &aitrigramv1.LLMAdapter{
+		ObjectMeta: metav1.ObjectMeta{Name: "sql-lora", Namespace: "default"},
+		Spec:       aitrigramv1.LLMAdapterSpec{ModelRef: "llama3", Source: "/models/adapters/sql"},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel, llmAdapter).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &aitrigramv1.LLMAdapter{}).
+		Build()
+	r := &LLMAdapterReconciler{Client: k8sClient, Scheme: scheme, loader: &fakeAdapterLoader{loaded: map[string][]string{}}}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmAdapter)}
+	reconcileTimes(t, r, req, 2)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmAdapter))
+	condition := meta.FindStatusCondition(llmAdapter.Status.Conditions, aitrigramv1.ConditionTypeReady)
+	require.Equal(t, metav1.ConditionFalse, condition.Status)
+	require.Equal(t, "StorageNotShared", condition.Reason)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: "sql-lora-adapter", Namespace: "default"}, &batchv1.Job{})))
+}
+
+func Test_OllamaAdapterLoader(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var requests []string
+	var created map[string]interface{}
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/api/tags":
+			_, _ = io.WriteString(w, `{"models":[{"name":"llama3.2:latest"},{"name":"sql-lora:latest"}]}`)
+		case "/api/create":
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&created))
+			_, _ = io.WriteString(w, `{"status":"success"}`)
+		}
+	}))
+	t.Cleanup(engine.Close)
+	loader := &ollamaAdapterLoader{httpClient: engine.Client()}
+	endpoint := engineEndpoint{baseURL: engine.URL, token: "admin-token"}
+
+	models, err := loader.LoadedModels(ctx, endpoint)
+	require.NoError(t, err)
+	require.Contains(t, models, "sql-lora")
+	llmAdapter := &aitrigramv1.LLMAdapter{
+		ObjectMeta: metav1.ObjectMeta{Name: "sql-lora"},
+		Status:     aitrigramv1.LLMAdapterStatus{Blobs: map[string]string{"adapter.gguf": "sha256:abc"}},
+	}
+	require.NoError(t, loader.Load(ctx, endpoint, llmAdapter, "llama3.2:latest"))
+	require.Equal(t, map[string]interface{}{
+		"model":    "sql-lora",
+		"from":     "llama3.2:latest",
+		"adapters": map[string]interface{}{"adapter.gguf": "sha256:abc"},
+		"stream":   false,
+	}, created)
+	require.NoError(t, loader.Unload(ctx, endpoint, "sql-lora"))
+	require.Equal(t, []string{
+		"GET /api/tags Bearer admin-token",
+		"POST /api/create Bearer admin-token",
+		"DELETE /api/delete Bearer admin-token",
+	}, requests)
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"strings"
+	"text/template"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// LLMAdapterNameLabel is put on the Job preparing the adapter
+	LLMAdapterNameLabel = "aitrigram.ihomeland.cn/llmadapter"
+
+	// the container of the Job preparing the adapter
+	adapterJobContainerName = "adapter"
+	// the image of the download when it is not defined
+	defaultAdapterDownloadImage = "python:3.12-slim"
+	// the download scripts when they are not defined, they download the repository from Hugging Face
+	defaultAdapterDownloadScripts = `pip install --quiet --target /tmp/python huggingface_hub && ` +
+		`PYTHONPATH=/tmp/python python -c "from huggingface_hub import snapshot_download; ` +
+		`snapshot_download('{{ .Repository }}', local_dir='{{ .Source }}')"`
+)
+
+// adapterDownloadTemplate is the data of the download scripts of a LLMAdapter
+type adapterDownloadTemplate struct {
+	Repository string
+	Source     string
+}
+
+// The name of the Job preparing the adapter
+func llmAdapterJobName(llmAdapter *aitrigramv1.LLMAdapter) string {
+	return llmAdapter.Name + "-adapter"
+}
+
+// The ModelDeploymentTemplate of the pods serving the adapter. The base LLMModel has taken the values of the LLMEngine,
+// and the shared Deployment uses the one of the LLMEngine.
+func adapterDeploymentTemplate(llmEngine *aitrigramv1.LLMEngine, llmModel *aitrigramv1.LLMModel) *aitrigramv1.ModelDeploymentTemplate {
+	if isSharedServing(llmEngine) || llmModel.Spec.ModelDeployment == nil {
+		return llmEngine.Spec.ModelDeploymentTemplate
+	}
+	return llmModel.Spec.ModelDeployment
+}
+
+// The Job is needed to download the adapter, and to copy it into the blobs of ollama
+func adapterJobNeeded(llmAdapter *aitrigramv1.LLMAdapter, llmEngine *aitrigramv1.LLMEngine) bool {
+	return llmAdapter.Spec.Download != nil || llmEngine.Spec.EngineType == aitrigramv1.LLMEngineTypeOllama
+}
+
+// reconcileAdapterJob runs the Job which downloads the adapter into the models storage when the Download is defined,
+// and copies the files of the adapter into the blobs of ollama for the create API. The Job is replaced when the
+// adapter changes. It returns the condition of the LLMAdapter while the adapter is not prepared yet, and the digests
+// of the blobs of ollama once it is.
+func (r *LLMAdapterReconciler) reconcileAdapterJob(ctx context.Context, llmAdapter *aitrigramv1.LLMAdapter,
+	llmModel *aitrigramv1.LLMModel, llmEngine *aitrigramv1.LLMEngine) (*metav1.Condition, map[string]string, error) {
+	logger := log.FromContext(ctx)
+	if !adapterJobNeeded(llmAdapter, llmEngine) {
+		return nil, nil, nil
+	}
+	modelTemplate := adapterDeploymentTemplate(llmEngine, llmModel)
+	if modelTemplate == nil || modelTemplate.Storage == nil || modelTemplate.Storage.ModelsStorage == nil ||
+		modelTemplate.Storage.ModelsStorage.PersistentVolumeClaim == nil {
+		return &metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "StorageNotShared",
+			Message: "The adapter is prepared by a Job in the models storage of the base LLMModel, which must be a PersistentVolumeClaim",
+		}, nil, nil
+	}
+	desired, err := r.newAdapterJob(llmAdapter, llmModel, llmEngine, modelTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+	preparing := &metav1.Condition{
+		Type:    aitrigramv1.ConditionTypeReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Preparing",
+		Message: fmt.Sprintf("The Job %s is preparing the adapter", desired.Name),
+	}
+
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), job); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, nil, err
+		}
+		logger.Info("Creating the Job preparing the adapter", "Job.Name", desired.Name)
+		return preparing, nil, r.Create(ctx, desired)
+	}
+	if !job.GetDeletionTimestamp().IsZero() {
+		return preparing, nil, nil
+	}
+	// the pod template of a Job can not be changed
+	current, expected := job.Spec.Template.Spec.Containers[0], desired.Spec.Template.Spec.Containers[0]
+	if current.Image != expected.Image || !equality.Semantic.DeepEqual(current.Args, expected.Args) || !equality.Semantic.DeepEqual(current.Env, expected.Env) {
+		logger.Info("Replacing the Job preparing the adapter", "Job.Name", job.Name)
+		return preparing, nil, client.IgnoreNotFound(r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+	}
+	if jobConditionTrue(job, batchv1.JobFailed) {
+		return &metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "PrepareFailed",
+			Message: fmt.Sprintf("The Job %s failed to prepare the adapter", job.Name),
+		}, nil, nil
+	}
+	if !jobConditionTrue(job, batchv1.JobComplete) {
+		return preparing, nil, nil
+	}
+	if llmEngine.Spec.EngineType != aitrigramv1.LLMEngineTypeOllama {
+		return nil, nil, nil
+	}
+	blobs, err := r.adapterBlobsOf(ctx, job)
+	if err != nil {
+		return nil, nil, err
+	}
+	if blobs == nil {
+		// the pods of the Job may have been removed
+		blobs = llmAdapter.Status.Blobs
+	}
+	if len(blobs) == 0 {
+		return &metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "PrepareFailed",
+			Message: fmt.Sprintf("The Job %s found no file of the adapter at %s", job.Name, llmAdapter.Spec.Source),
+		}, nil, nil
+	}
+	return nil, blobs, nil
+}
+
+// Returns the digests of the blobs reported by the succeeded pod of the Job in its termination message,
+// it returns nil if there is no such pod
+func (r *LLMAdapterReconciler) adapterBlobsOf(ctx context.Context, job *batchv1.Job) (map[string]string, error) {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(job.Namespace), client.MatchingLabels{batchv1.JobNameLabel: job.Name}); err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != adapterJobContainerName || status.State.Terminated == nil {
+				continue
+			}
+			blobs := map[string]string{}
+			if err := json.Unmarshal([]byte(status.State.Terminated.Message), &blobs); err != nil {
+				return nil, fmt.Errorf("invalid termination message of the pod %s: %w", pod.Name, err)
+			}
+			return blobs, nil
+		}
+	}
+	return nil, nil
+}
+
+// Returns the Job which downloads the adapter and copies it into the blobs of ollama, it mounts the models storage
+// at the same path as the pods of the base model and runs with their securityContexts.
+func (r *LLMAdapterReconciler) newAdapterJob(llmAdapter *aitrigramv1.LLMAdapter, llmModel *aitrigramv1.LLMModel,
+	llmEngine *aitrigramv1.LLMEngine, modelTemplate *aitrigramv1.ModelDeploymentTemplate) (*batchv1.Job, error) {
+	modelsStorage := modelTemplate.Storage.ModelsStorage
+	image := modelTemplate.DownloadImage
+	envs := []corev1.EnvVar{{Name: "HOME", Value: tmpMountPath}}
+	// the Job fails once any of the scripts fails
+	scripts := []string{"set -e"}
+	if download := llmAdapter.Spec.Download; download != nil {
+		downloadScripts, err := generateAdapterDownloadScripts(download, llmAdapter.Spec.Source)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, downloadScripts)
+		image = download.Image
+		if image == "" {
+			image = defaultAdapterDownloadImage
+		}
+		envs = append(envs, download.Envs...)
+	}
+	if llmEngine.Spec.EngineType == aitrigramv1.LLMEngineTypeOllama {
+		scripts = append(scripts, ollamaBlobScripts(llmAdapter.Spec.Source, modelsStorage.Path))
+	}
+
+	labels := map[string]string{
+		LLMModelNameLabel:   llmModel.Name,
+		LLMAdapterNameLabel: llmAdapter.Name,
+	}
+	// the pods are selected by the NetworkPolicy of the download of the base model, if it is enabled
+	podLabels := maps.Clone(labels)
+	podLabels[ModelDownloadLabel] = llmModelResourceName(llmEngine.Spec.EngineType, llmModel.Spec.Name)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      llmAdapterJobName(llmAdapter),
+			Namespace: llmAdapter.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: ptr.To(int32(3)),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: podLabels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:         adapterJobContainerName,
+						Image:        image,
+						Command:      []string{"/bin/sh", "-c"},
+						Args:         []string{strings.Join(scripts, "\n")},
+						Env:          envs,
+						VolumeMounts: []corev1.VolumeMount{{Name: "models", MountPath: modelsStorage.Path}},
+					}},
+					Volumes: []corev1.Volume{{Name: "models", VolumeSource: modelsStorage.VolumeSource}},
+				},
+			},
+		},
+	}
+	applySecurityContext(&job.Spec.Template.Spec, modelTemplate, adapterJobContainerName)
+	if err := ctrl.SetControllerReference(llmAdapter, job, r.Scheme); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func generateAdapterDownloadScripts(download *aitrigramv1.AdapterDownloadSpec, source string) (string, error) {
+	scripts := download.Scripts
+	if scripts == "" {
+		scripts = defaultAdapterDownloadScripts
+	}
+	tmpl, err := template.New("adapterDownload").Parse(scripts)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, adapterDownloadTemplate{Repository: download.Repository, Source: source}); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// Returns the scripts which copy the adapter, a file or the files in a directory, into the blobs of ollama in the
+// models storage, and report the digests in the termination message, like: {"adapter.gguf":"sha256:..."}
+func ollamaBlobScripts(source string, modelsPath string) string {
+	return fmt.Sprintf(`src='%s' && blobs='%s/blobs' && mkdir -p "$blobs" && `+
+		`if [ -d "$src" ]; then dir="$src"; files=$(ls "$src"); else dir=$(dirname "$src"); files=$(basename "$src"); fi && `+
+		`sep='' && printf '{' > /dev/termination-log && `+
+		`for f in $files; do [ -f "$dir/$f" ] || continue; `+
+		`d=$(sha256sum "$dir/$f" | cut -d' ' -f1) && cp "$dir/$f" "$blobs/sha256-$d" && `+
+		`printf '%%s"%%s":"sha256:%%s"' "$sep" "$f" "$d" >> /dev/termination-log && sep=',' || exit 1; done && `+
+		`printf '}' >> /dev/termination-log`, source, modelsPath)
+}
+
+func jobConditionTrue(job *batchv1.Job, conditionType batchv1.JobConditionType) bool {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == conditionType {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
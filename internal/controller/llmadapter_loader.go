@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// adapterLoader loads the LoRA adapters into a running engine, which is addressed by the endpoint like: http://10.0.0.12:8000
+type adapterLoader interface {
+	// LoadedModels returns the model names served by the engine, including the loaded adapters
+	LoadedModels(ctx context.Context, endpoint engineEndpoint) ([]string, error)
+	// Load loads the adapter over the base model, which is served as the baseModel by the engine
+	Load(ctx context.Context, endpoint engineEndpoint, llmAdapter *aitrigramv1.LLMAdapter, baseModel string) error
+	// Unload unloads the adapter served as the name
+	Unload(ctx context.Context, endpoint engineEndpoint, name string) error
+}
+
+// Returns the adapterLoader of the engine type
+func adapterLoaderOf(engineType aitrigramv1.LLMEngineType) adapterLoader {
+	if engineType == aitrigramv1.LLMEngineTypeOllama {
+		return &ollamaAdapterLoader{}
+	}
+	return &vllmAdapterLoader{}
+}
+
+// vllmAdapterLoader uses the runtime LoRA updating API of vllm, which requires VLLM_ALLOW_RUNTIME_LORA_UPDATING=True
+type vllmAdapterLoader struct {
+	httpClient *http.Client
+}
+
+var _ adapterLoader = &vllmAdapterLoader{}
+
+func (l *vllmAdapterLoader) LoadedModels(ctx context.Context, endpoint engineEndpoint) ([]string, error) {
+	body, err := sendEngineRequest(ctx, l.httpClient, endpoint, http.MethodGet, "/v1/models", nil)
+	if err != nil {
+		return nil, err
+	}
+	models := struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &models); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(models.Data))
+	for _, model := range models.Data {
+		names = append(names, model.ID)
+	}
+	return names, nil
+}
+
+func (l *vllmAdapterLoader) Load(ctx context.Context, endpoint engineEndpoint, llmAdapter *aitrigramv1.LLMAdapter, _ string) error {
+	_, err := sendEngineRequest(ctx, l.httpClient, endpoint, http.MethodPost, "/v1/load_lora_adapter",
+		map[string]interface{}{"lora_name": adapterModelName(llmAdapter), "lora_path": llmAdapter.Spec.Source})
+	return err
+}
+
+func (l *vllmAdapterLoader) Unload(ctx context.Context, endpoint engineEndpoint, name string) error {
+	_, err := sendEngineRequest(ctx, l.httpClient, endpoint, http.MethodPost, "/v1/unload_lora_adapter", map[string]interface{}{"lora_name": name})
+	return err
+}
+
+// ollamaAdapterLoader creates the adapter as a model over the base model with the create API of ollama, from the
+// files of the adapter which have been copied into the blobs of ollama, and deletes the model to unload it.
+type ollamaAdapterLoader struct {
+	httpClient *http.Client
+}
+
+var _ adapterLoader = &ollamaAdapterLoader{}
+
+// The names without a tag are listed with the latest tag by ollama, they are returned with and without it
+func (l *ollamaAdapterLoader) LoadedModels(ctx context.Context, endpoint engineEndpoint) ([]string, error) {
+	body, err := sendEngineRequest(ctx, l.httpClient, endpoint, http.MethodGet, "/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	models := struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}{}
+	if err := json.Unmarshal(body, &models); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(models.Models))
+	for _, model := range models.Models {
+		names = append(names, model.Name)
+		if name, ok := strings.CutSuffix(model.Name, ":latest"); ok {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (l *ollamaAdapterLoader) Load(ctx context.Context, endpoint engineEndpoint, llmAdapter *aitrigramv1.LLMAdapter, baseModel string) error {
+	if len(llmAdapter.Status.Blobs) == 0 {
+		return fmt.Errorf("the files of the adapter %s have not been copied into the blobs of ollama", llmAdapter.Name)
+	}
+	_, err := sendEngineRequest(ctx, l.httpClient, endpoint, http.MethodPost, "/api/create", map[string]interface{}{
+		"model":    adapterModelName(llmAdapter),
+		"from":     baseModel,
+		"adapters": llmAdapter.Status.Blobs,
+		"stream":   false,
+	})
+	return err
+}
+
+func (l *ollamaAdapterLoader) Unload(ctx context.Context, endpoint engineEndpoint, name string) error {
+	_, err := sendEngineRequest(ctx, l.httpClient, endpoint, http.MethodDelete, "/api/delete", map[string]interface{}{"model": name})
+	return err
+}
+
+// Sends the request with the JSON payload to the engine, with the admin token of the endpoint if there is one,
+// and returns the body of the response
+func sendEngineRequest(ctx context.Context, httpClient *http.Client, endpoint engineEndpoint, method string, path string, payload map[string]interface{}) ([]byte, error) {
+	var reader io.Reader
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if endpoint.token != "" {
+		req.Header.Set("Authorization", "Bearer "+endpoint.token)
+	}
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s: %s", resp.StatusCode, req.URL, string(body))
+	}
+	return body, nil
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// the admin port of the proxy sidecar, for the kubelet, Prometheus and the operator
+	proxySidecarAdminPort int32 = 15090
+	// the name of the admin port of the proxy sidecar
+	proxySidecarAdminPortName = "proxy-admin"
+	// the volume of the admin token in the proxy sidecar
+	adminTokenVolumeName = "proxy-admin-token"
+	// where the admin token is mounted in the proxy sidecar
+	adminTokenMountPath = "/etc/aitrigram/admin"
+	// the key of the admin token in its Secret
+	adminTokenKey = "token"
+
+	// the environment variable of the address ollama listens on
+	ollamaHostEnv = "OLLAMA_HOST"
+	// the flag of the address vllm listens on
+	vllmHostFlag = "--host"
+)
+
+// The engine only listens on the loopback address when the API keys are required, so that the requests can only
+// reach it through the proxy sidecar which checks them.
+func engineBoundToLocalhost(llmEngine *aitrigramv1.LLMEngine) bool {
+	return authEnabled(llmEngine) && bindsToLocalhost(llmEngine.Spec.EngineType)
+}
+
+// Whether the operator knows how to bind the engine of the type to the loopback address, the auth is rejected
+// for the other engines, which would still be reachable on the pod IP without the API keys.
+func bindsToLocalhost(engineType aitrigramv1.LLMEngineType) bool {
+	return engineType == aitrigramv1.LLMEngineTypeOllama || engineType == aitrigramv1.LLMEngineTypeVLLM
+}
+
+// The name of the Secret of the admin token of the proxy sidecars of the engine
+func llmAdminTokenSecretName(llmEngine *aitrigramv1.LLMEngine) string {
+	return llmEngine.Name + "-proxy-admin"
+}
+
+// engineEndpoint is where the operator reaches the engine in a pod, with the admin token of the proxy sidecar
+// when the engine only listens on the loopback address.
+type engineEndpoint struct {
+	baseURL string
+	token   string
+}
+
+// Returns the endpoint of the engine in the pod, the admin token is read from its Secret when it is needed
+func engineEndpointOf(ctx context.Context, c client.Client, scheme *runtime.Scheme, llmEngine *aitrigramv1.LLMEngine, pod *corev1.Pod) (engineEndpoint, error) {
+	if !engineBoundToLocalhost(llmEngine) {
+		return engineEndpoint{baseURL: fmt.Sprintf("http://%s:%d", pod.Status.PodIP, llmEngine.Spec.Port)}, nil
+	}
+	token, err := reconcileAdminToken(ctx, c, scheme, llmEngine)
+	if err != nil {
+		return engineEndpoint{}, err
+	}
+	return engineEndpoint{baseURL: fmt.Sprintf("http://%s:%d", pod.Status.PodIP, proxySidecarAdminPort), token: token}, nil
+}
+
+// reconcileAdminToken makes sure the Secret of the admin token of the engine exists, and returns the token.
+// It is created by whoever needs it first, and is owned by the engine.
+func reconcileAdminToken(ctx context.Context, c client.Client, scheme *runtime.Scheme, llmEngine *aitrigramv1.LLMEngine) (string, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: llmEngine.Namespace, Name: llmAdminTokenSecretName(llmEngine)}, secret)
+	if err == nil {
+		if token := strings.TrimSpace(string(secret.Data[adminTokenKey])); token != "" {
+			return token, nil
+		}
+		return "", fmt.Errorf("no %s in the Secret %s", adminTokenKey, secret.Name)
+	}
+	if !apierrors.IsNotFound(err) {
+		return "", err
+	}
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(data)
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      llmAdminTokenSecretName(llmEngine),
+			Namespace: llmEngine.Namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{adminTokenKey: []byte(token)},
+	}
+	if err := ctrl.SetControllerReference(llmEngine, secret, scheme); err != nil {
+		return "", err
+	}
+	log.FromContext(ctx).Info("Creating the admin token of the proxy sidecars", "Secret.Name", secret.Name)
+	return token, c.Create(ctx, secret)
+}
+
+// Binds the engine container, the first one, to the loopback address. The kubelet probes its readiness and Prometheus
+// scrapes its metrics through the admin port of the proxy sidecar, which forwards the other requests, like the
+// loading of the adapters, only with the admin token.
+func bindEngineToLocalhost(podSpec *corev1.PodSpec, llmEngine *aitrigramv1.LLMEngine) {
+	if !engineBoundToLocalhost(llmEngine) {
+		return
+	}
+	engine := &podSpec.Containers[0]
+	switch llmEngine.Spec.EngineType {
+	case aitrigramv1.LLMEngineTypeOllama:
+		// the env is shared with the init container, which serves ollama on its own address
+		env := slices.DeleteFunc(slices.Clone(engine.Env), func(env corev1.EnvVar) bool { return env.Name == ollamaHostEnv })
+		engine.Env = append(env, corev1.EnvVar{Name: ollamaHostEnv, Value: fmt.Sprintf("127.0.0.1:%d", llmEngine.Spec.Port)})
+	case aitrigramv1.LLMEngineTypeVLLM:
+		if len(engine.Command) > 0 {
+			engine.Command = append(slices.Clone(engine.Command), vllmHostFlag+"=127.0.0.1")
+		} else {
+			engine.Args = append(slices.Clone(engine.Args), vllmHostFlag+"=127.0.0.1")
+		}
+	}
+
+	adminPaths := []string{}
+	if probe := engine.ReadinessProbe; probe != nil && probe.HTTPGet != nil {
+		probe.HTTPGet.Port = intstr.FromInt32(proxySidecarAdminPort)
+		adminPaths = append(adminPaths, probe.HTTPGet.Path)
+	}
+	if port, metricsPath := engineMetricsEndpoint(llmEngine); metricsPath != "" && port == proxySidecarAdminPort {
+		adminPaths = append(adminPaths, metricsPath)
+	}
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if container.Name != proxySidecarName {
+			continue
+		}
+		container.Args = append(container.Args, "--admin-token-file="+path.Join(adminTokenMountPath, adminTokenKey))
+		if len(adminPaths) > 0 {
+			container.Args = append(container.Args, "--admin-paths="+strings.Join(adminPaths, ","))
+		}
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      adminTokenVolumeName,
+			MountPath: adminTokenMountPath,
+			ReadOnly:  true,
+		})
+	}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: adminTokenVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: llmAdminTokenSecretName(llmEngine)},
+		},
+	})
+}
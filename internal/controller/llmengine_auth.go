@@ -0,0 +1,129 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+const (
+	// the volume of the API keys in the pods of the proxy sidecar and the gateway
+	apiKeysVolumeName = "api-keys"
+	// where the API keys are mounted, each key is a file named by its index in the AuthSpec
+	apiKeysMountPath = "/etc/aitrigram/auth"
+)
+
+// The requests to the LLMModels of the engine need an API key
+func authEnabled(llmEngine *aitrigramv1.LLMEngine) bool {
+	return llmEngine.Spec.Auth != nil && len(llmEngine.Spec.Auth.APIKeys) > 0
+}
+
+// The file of the API key mounted in the containers, it is named by the index of the key in the AuthSpec
+func apiKeyFile(index int) string {
+	return path.Join(apiKeysMountPath, strconv.Itoa(index))
+}
+
+// Returns the file of the API key of the engine selected by the selector
+func apiKeyFileOf(llmEngine *aitrigramv1.LLMEngine, selector *corev1.SecretKeySelector) (string, bool) {
+	if !authEnabled(llmEngine) {
+		return "", false
+	}
+	for i, apiKey := range llmEngine.Spec.Auth.APIKeys {
+		if apiKey.SecretRef.Name == selector.Name && apiKey.SecretRef.Key == selector.Key {
+			return apiKeyFile(i), true
+		}
+	}
+	return "", false
+}
+
+// The projected volume which puts the API keys of all referenced Secrets into one directory
+func apiKeysVolume(auth *aitrigramv1.AuthSpec) corev1.Volume {
+	sources := make([]corev1.VolumeProjection, 0, len(auth.APIKeys))
+	for i, apiKey := range auth.APIKeys {
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: apiKey.SecretRef.LocalObjectReference,
+				Items: []corev1.KeyToPath{{
+					Key:  apiKey.SecretRef.Key,
+					Path: strconv.Itoa(i),
+				}},
+			},
+		})
+	}
+	return corev1.Volume{
+		Name: apiKeysVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{Sources: sources},
+		},
+	}
+}
+
+// The auth config passed to the proxy sidecar and the gateway, which points to the mounted API keys
+func authConfigArg(auth *aitrigramv1.AuthSpec) (string, error) {
+	config := proxy.AuthConfig{APIKeys: make([]proxy.APIKeyFile, 0, len(auth.APIKeys))}
+	for i, apiKey := range auth.APIKeys {
+		config.APIKeys = append(config.APIKeys, proxy.APIKeyFile{
+			File:   apiKeyFile(i),
+			Models: apiKey.Models,
+		})
+	}
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	return "--auth-config=" + string(data), nil
+}
+
+// Mounts the API keys of the engine into the named container, and passes the auth config to it.
+// The model is the one served behind the container, it is empty when the container serves many models.
+func applyAuth(podSpec *corev1.PodSpec, containerName string, llmEngine *aitrigramv1.LLMEngine, model string) error {
+	if !authEnabled(llmEngine) {
+		return nil
+	}
+	var container *corev1.Container
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == containerName {
+			container = &podSpec.Containers[i]
+		}
+	}
+	if container == nil {
+		return fmt.Errorf("no container %s to check the API keys", containerName)
+	}
+	arg, err := authConfigArg(llmEngine.Spec.Auth)
+	if err != nil {
+		return err
+	}
+	container.Args = append(container.Args, arg)
+	if model != "" {
+		container.Args = append(container.Args, "--model="+model)
+	}
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      apiKeysVolumeName,
+		MountPath: apiKeysMountPath,
+		ReadOnly:  true,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, apiKeysVolume(llmEngine.Spec.Auth))
+	return nil
+}
@@ -18,11 +18,18 @@ package controller
 
 import (
 	"context"
+	"slices"
 
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
 )
@@ -33,12 +40,24 @@ type LLMEngineReconciler struct {
 	Scheme            *runtime.Scheme
 	OperatorNamespace string
 	OperatorPodName   string
+	// ProxyImage is the image of the gateway, it is the image of the operator
+	ProxyImage string
+	// Recorder records the Events of the LLMEngines, no Event is recorded when it is nil
+	Recorder record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmengines,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmengines/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmengines/finalizers,verbs=update
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodels,verbs=get;list;watch;delete
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmadapters,verbs=get;list;watch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodelrollouts,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -57,21 +76,55 @@ func (r *LLMEngineReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	desired, err := MergeLLMSpecs(DefaultLLMEngineSpec(&llmEngine.Spec.EngineType).DeepCopy(), &llmEngine.Spec)
+	if !llmEngine.GetDeletionTimestamp().IsZero() {
+		return r.finalizeLLMEngine(ctx, req, llmEngine)
+	}
+
+	desired, err := MergeLLMSpecs(DefaultsOfLLMEngine(llmEngine), &llmEngine.Spec)
 	if err != nil {
 		return ctrl.Result{}, nil
 	}
-	if LLMEngineSpecEquals(&llmEngine.Spec, desired) {
-		logger.Info("LLMEngine is already up-to-date")
+	finalizerAdded := controllerutil.AddFinalizer(llmEngine, LLMEngineFinalizer)
+	defaulted := !LLMEngineSpecEquals(&llmEngine.Spec, desired)
+	if finalizerAdded || defaulted {
+		llmEngine.Spec = *desired
+		logger.Info("Update LLMEngine Spec")
+		if err := r.Client.Update(ctx, llmEngine); err != nil {
+			logger.Error(err, "Failed to update the llmengine")
+			return ctrl.Result{}, err
+		}
+		if defaulted {
+			recordEvent(r.Recorder, llmEngine, corev1.EventTypeNormal, eventReasonSpecDefaulted,
+				"The spec is defaulted for the engine type %s", llmEngine.Spec.EngineType)
+		}
+		// the update triggers another reconcile
 		return ctrl.Result{}, nil
 	}
-	llmEngine.Spec = *desired
-	logger.Info("Update LLMEngine Spec")
-	if err := r.Client.Update(ctx, llmEngine); err != nil {
-		logger.Error(err, "Failed to update the llmengine")
+	logger.Info("LLMEngine is already up-to-date")
+	llmModels, err := r.llmModelsOfEngine(ctx, llmEngine)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
-	return ctrl.Result{}, err
+	llmEngineModels.WithLabelValues(req.Namespace, req.Name).Set(float64(len(llmModels)))
+
+	if err := r.reconcileSharedServing(ctx, llmEngine); err != nil {
+		logger.Error(err, "Failed to reconcile the shared serving")
+		return ctrl.Result{}, err
+	}
+	gatewayEndpoint, err := r.reconcileGateway(ctx, llmEngine)
+	if err != nil {
+		logger.Error(err, "Failed to reconcile the gateway")
+		return ctrl.Result{}, err
+	}
+	if err := r.updateLLMEngineGatewayEndpoint(ctx, req, gatewayEndpoint); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !tlsEnabled(llmEngine) {
+		// the CA is generated again when the TLS is enabled again
+		return ctrl.Result{}, r.deleteEngineResources(ctx, llmEngine, llmCASecretName(llmEngine), &corev1.Secret{})
+	}
+	// the certificates are checked again for the renewal
+	return ctrl.Result{RequeueAfter: certCheckInterval}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
@@ -79,6 +132,55 @@ func (r *LLMEngineReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&aitrigramv1.LLMEngine{}).
 		Owns(&aitrigramv1.LLMModel{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&aitrigramv1.LLMAdapter{}, handler.EnqueueRequestsFromMapFunc(r.llmEngineOfAdapter)).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.llmEnginesOfModelfileConfigMap)).
+		Watches(&aitrigramv1.LLMModelRollout{}, handler.EnqueueRequestsFromMapFunc(r.llmEngineOfRollout)).
 		Named("llmengine").
-		Complete(r)
+		Complete(withReconcileErrorMetrics("llmengine", r))
+}
+
+// Enqueues the LLMEngine of the base LLMModel of the adapter, which serves the adapter in the gateway and the shared Deployment
+func (r *LLMEngineReconciler) llmEngineOfAdapter(ctx context.Context, obj client.Object) []reconcile.Request {
+	llmAdapter, ok := obj.(*aitrigramv1.LLMAdapter)
+	if !ok {
+		return nil
+	}
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: llmAdapter.Namespace, Name: llmAdapter.Spec.ModelRef}, llmModel); err != nil {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: llmModel.Namespace, Name: llmModel.Spec.EngineRef}}}
+}
+
+// Enqueues the LLMEngines of the LLMModels which have the Modelfile in the ConfigMap, which rebuild them in the shared Deployment
+func (r *LLMEngineReconciler) llmEnginesOfModelfileConfigMap(ctx context.Context, obj client.Object) []reconcile.Request {
+	llmModels, err := llmModelsOfModelfileConfigMap(ctx, r.Client, obj)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list the LLMModels of the ConfigMap", "ConfigMap.Name", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, llmModel := range llmModels {
+		request := reconcile.Request{NamespacedName: client.ObjectKey{Namespace: llmModel.Namespace, Name: llmModel.Spec.EngineRef}}
+		if !slices.Contains(requests, request) {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+// Enqueues the LLMEngine of the stable LLMModel of the rollout, which splits the route of the model in the gateway
+func (r *LLMEngineReconciler) llmEngineOfRollout(ctx context.Context, obj client.Object) []reconcile.Request {
+	rollout, ok := obj.(*aitrigramv1.LLMModelRollout)
+	if !ok {
+		return nil
+	}
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: rollout.Namespace, Name: rollout.Spec.Stable}, llmModel); err != nil {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: client.ObjectKey{Namespace: llmModel.Namespace, Name: llmModel.Spec.EngineRef}}}
 }
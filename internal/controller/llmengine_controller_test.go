@@ -17,14 +17,27 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+	"encoding/json"
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 
 	"github.com/stretchr/testify/require"
 
 	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
 )
 
 func Test_OllamaEngineDefault(t *testing.T) {
@@ -96,6 +109,8 @@ func Test_OllamaEngineDefault(t *testing.T) {
 							Value: "/cache_dir_new",
 						},
 					},
+					PodSecurityContext: ollamaDefaultEngineSpec.ModelDeploymentTemplate.PodSecurityContext,
+					SecurityContext:    ollamaDefaultEngineSpec.ModelDeploymentTemplate.SecurityContext,
 				},
 			},
 		},
@@ -142,7 +157,9 @@ func Test_OllamaEngineDefault(t *testing.T) {
 							},
 						},
 					},
-					Envs: ollamaDefaultEngineSpec.ModelDeploymentTemplate.Envs,
+					Envs:               ollamaDefaultEngineSpec.ModelDeploymentTemplate.Envs,
+					PodSecurityContext: ollamaDefaultEngineSpec.ModelDeploymentTemplate.PodSecurityContext,
+					SecurityContext:    ollamaDefaultEngineSpec.ModelDeploymentTemplate.SecurityContext,
 				},
 			},
 		},
@@ -161,3 +178,307 @@ func Test_OllamaEngineDefault(t *testing.T) {
 		})
 	}
 }
+
+func Test_LLMEngineSecurityProfile(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+
+	// a new engine gets the Restricted profile of its engine type
+	vllm := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "vllm", Namespace: "default"},
+		Spec:       aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeVLLM, Port: 8000, ServicePort: 8080},
+	}
+	// an engine reconciled before the profiles gets the Restricted profile too, it opts out with Unrestricted
+	ollama := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama", Namespace: "default", Finalizers: []string{LLMEngineFinalizer}},
+		Spec:       *DefaultLLMEngineSpec(&ollamaEngineType).DeepCopy(),
+	}
+	ollama.Spec.SecurityProfile = aitrigramv1.SecurityProfileUnrestricted
+	k8sClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(vllm, ollama).Build()
+	r := &LLMEngineReconciler{Client: k8sClient, Scheme: scheme}
+	for _, llmEngine := range []*aitrigramv1.LLMEngine{vllm, ollama} {
+		_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmEngine)})
+		require.NoError(t, err)
+		require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+	}
+	require.Equal(t, aitrigramv1.SecurityProfileRestricted, vllm.Spec.SecurityProfile)
+	require.Equal(t, restrictedPodSecurityContext(), vllm.Spec.ModelDeploymentTemplate.PodSecurityContext)
+	require.Equal(t, restrictedSecurityContext(), vllm.Spec.ModelDeploymentTemplate.SecurityContext)
+	require.Equal(t, []corev1.EnvVar{{Name: "HOME", Value: tmpMountPath}}, *vllm.Spec.ModelDeploymentTemplate.Envs)
+	require.Equal(t, aitrigramv1.SecurityProfileUnrestricted, ollama.Spec.SecurityProfile)
+	require.Nil(t, ollama.Spec.ModelDeploymentTemplate.PodSecurityContext)
+	require.Nil(t, ollama.Spec.ModelDeploymentTemplate.SecurityContext)
+	require.Equal(t, *DefaultLLMEngineSpec(&ollamaEngineType).ModelDeploymentTemplate.Envs, *ollama.Spec.ModelDeploymentTemplate.Envs)
+
+	// the engine opts in the profile, and its template still replaces the securityContexts of the profile
+	ollama.Spec.SecurityProfile = aitrigramv1.SecurityProfileRestricted
+	ollama.Spec.ModelDeploymentTemplate.PodSecurityContext = &corev1.PodSecurityContext{RunAsUser: ptr.To(int64(2000))}
+	spec, err := MergeLLMSpecs(DefaultsOfLLMEngine(ollama), &ollama.Spec)
+	require.NoError(t, err)
+	require.Equal(t, ollama.Spec.ModelDeploymentTemplate.PodSecurityContext, spec.ModelDeploymentTemplate.PodSecurityContext)
+	require.Equal(t, restrictedSecurityContext(), spec.ModelDeploymentTemplate.SecurityContext)
+	require.Contains(t, *spec.ModelDeploymentTemplate.Envs, corev1.EnvVar{Name: "HOME", Value: "/cache_dir"})
+
+	// the Unrestricted profile keeps the securityContexts of the images on a new engine
+	unrestricted := &aitrigramv1.LLMEngine{
+		Spec: aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeOllama, SecurityProfile: aitrigramv1.SecurityProfileUnrestricted},
+	}
+	spec = DefaultsOfLLMEngine(unrestricted)
+	require.Equal(t, aitrigramv1.SecurityProfileUnrestricted, spec.SecurityProfile)
+	require.Nil(t, spec.ModelDeploymentTemplate.SecurityContext)
+}
+
+func Test_LLMEngineFinalizer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	require.NoError(t, aitrigramv1.AddToScheme(scheme))
+
+	now := metav1.Now()
+	llmEngine := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "ollama",
+			Namespace:         "default",
+			Finalizers:        []string{LLMEngineFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Spec: aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeOllama},
+	}
+	// the LLMModel has never been reconciled, so there is no ownerReference to the engine
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: "ollama", Replicas: 1},
+	}
+	otherModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "gemma3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "gemma3", EngineRef: "vllm", Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel, otherModel).
+		WithStatusSubresource(&aitrigramv1.LLMEngine{}).
+		Build()
+	r := &LLMEngineReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "ollama", Namespace: "default"}}
+
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NotZero(t, result.RequeueAfter, "waits for the LLMModels to be deleted")
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(llmModel), &aitrigramv1.LLMModel{})))
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(otherModel), &aitrigramv1.LLMModel{}))
+
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, req.NamespacedName, &aitrigramv1.LLMEngine{})))
+}
+
+func Test_LLMEngineGateway(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Finalizers = []string{LLMEngineFinalizer}
+	llmEngine.Spec.Gateway = &aitrigramv1.GatewaySpec{Enabled: true}
+	llama := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", NameInEngine: "llama3.2:latest", EngineRef: "ollama", Replicas: 1},
+	}
+	qwen := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "qwen2", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "qwen2", EngineRef: "ollama", Replicas: 1},
+	}
+	otherModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "gemma3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "gemma3", EngineRef: "vllm", Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llama, qwen, otherModel).
+		WithStatusSubresource(&aitrigramv1.LLMEngine{}).
+		Build()
+	r := &LLMEngineReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:test"}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "ollama", Namespace: "default"}}
+
+	_, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+
+	gatewayKey := types.NamespacedName{Name: "ollama-gateway", Namespace: "default"}
+	routes := func() []proxy.Route {
+		configMap := &corev1.ConfigMap{}
+		require.NoError(t, k8sClient.Get(ctx, gatewayKey, configMap))
+		table := proxy.RouteTable{}
+		require.NoError(t, json.Unmarshal([]byte(configMap.Data[proxy.RoutesFileName]), &table))
+		return table.Routes
+	}
+	require.Equal(t, []proxy.Route{
+		{Model: "llama3.2:latest", Backend: "http://ollama-llama3.default.svc:8080"},
+		{Model: "qwen2", Backend: "http://ollama-qwen2.default.svc:8080"},
+	}, routes())
+
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, gatewayKey, deployment))
+	require.Equal(t, "aitrigram:test", deployment.Spec.Template.Spec.Containers[0].Image)
+	require.Equal(t, "gateway", deployment.Spec.Template.Spec.Containers[0].Args[0])
+	service := &corev1.Service{}
+	require.NoError(t, k8sClient.Get(ctx, gatewayKey, service))
+	require.Equal(t, int32(8080), service.Spec.Ports[0].Port)
+
+	engine := &aitrigramv1.LLMEngine{}
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, engine))
+	require.Equal(t, "http://ollama-gateway.default.svc:8080", engine.Status.GatewayEndpoint)
+
+	// the route table follows the LLMModels of the engine
+	require.NoError(t, k8sClient.Delete(ctx, qwen))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, []proxy.Route{
+		{Model: "llama3.2:latest", Backend: "http://ollama-llama3.default.svc:8080"},
+	}, routes())
+
+	// everything is removed once the gateway is disabled
+	engine.Spec.Gateway.Enabled = false
+	require.NoError(t, k8sClient.Update(ctx, engine))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, gatewayKey, &appsv1.Deployment{})))
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, gatewayKey, &corev1.Service{})))
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, gatewayKey, &corev1.ConfigMap{})))
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, engine))
+	require.Empty(t, engine.Status.GatewayEndpoint)
+}
+
+func Test_LLMEngineGatewayTLS(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Finalizers = []string{LLMEngineFinalizer}
+	llmEngine.Spec.Gateway = &aitrigramv1.GatewaySpec{Enabled: true}
+	llmEngine.Spec.TLS = &aitrigramv1.TLSSpec{Enabled: true, ServicePort: 8443}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine).
+		WithStatusSubresource(&aitrigramv1.LLMEngine{}).
+		Build()
+	r := &LLMEngineReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:test"}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmEngine)}
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, certCheckInterval, result.RequeueAfter)
+
+	// the gateway terminates the TLS with the certificate signed by the CA of the engine
+	gatewayKey := types.NamespacedName{Name: "ollama-gateway", Namespace: "default"}
+	secret := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-gateway-tls", Namespace: "default"}, secret))
+	require.True(t, metav1.IsControlledBy(secret, llmEngine))
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+	require.Contains(t, cert.DNSNames, "ollama-gateway.default.svc")
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, gatewayKey, deployment))
+	require.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--tls-listen=:8443")
+	// only https is served, the backends are reached over https as well
+	require.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--listen=")
+	require.Contains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--backend-ca-file=/etc/aitrigram/tls/ca.crt")
+	service := &corev1.Service{}
+	require.NoError(t, k8sClient.Get(ctx, gatewayKey, service))
+	require.Equal(t, []corev1.ServicePort{
+		{Name: "https", Port: 8443, TargetPort: intstr.FromInt32(gatewayTLSPort)},
+	}, service.Spec.Ports)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmEngine))
+	require.Equal(t, "https://ollama-gateway.default.svc:8443", llmEngine.Status.GatewayEndpoint)
+
+	// the http port is kept next to the https one with the plaintext
+	llmEngine.Spec.TLS.Plaintext = true
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(ctx, gatewayKey, service))
+	require.Len(t, service.Spec.Ports, 2)
+	require.Equal(t, int32(8443), service.Spec.Ports[1].Port)
+	require.NoError(t, k8sClient.Get(ctx, gatewayKey, deployment))
+	require.NotContains(t, deployment.Spec.Template.Spec.Containers[0].Args, "--backend-ca-file=/etc/aitrigram/tls/ca.crt")
+
+	// disabling the TLS removes the certificate and the CA
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmEngine))
+	llmEngine.Spec.TLS = nil
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	_, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(secret), secret)))
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-ca", Namespace: "default"}, secret)))
+	require.NoError(t, k8sClient.Get(ctx, gatewayKey, service))
+	require.Len(t, service.Spec.Ports, 1)
+}
+
+func Test_LLMEngineSharedServing(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Finalizers = []string{LLMEngineFinalizer}
+	llmEngine.Spec.ServingMode = aitrigramv1.ServingModeShared
+	llmEngine.Spec.Gateway = &aitrigramv1.GatewaySpec{Enabled: true}
+	llama := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", NameInEngine: "llama3.2:latest", EngineRef: "ollama", Replicas: 1},
+	}
+	qwen := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "qwen2", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "qwen2", EngineRef: "ollama", Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llama, qwen).
+		WithStatusSubresource(&aitrigramv1.LLMEngine{}, &aitrigramv1.LLMModel{}).
+		Build()
+	engineReconciler := &LLMEngineReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:test"}
+	modelReconciler := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	_, err := engineReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmEngine)})
+	require.NoError(t, err)
+
+	sharedKey := types.NamespacedName{Name: "ollama-shared", Namespace: "default"}
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, sharedKey, deployment))
+	initContainers := deployment.Spec.Template.Spec.InitContainers
+	require.Len(t, initContainers, 2)
+	require.Equal(t, "init-ollama-llama3", initContainers[0].Name)
+	require.Contains(t, initContainers[0].Args[0], "ollama pull llama3.2:latest")
+	require.Equal(t, "init-ollama-qwen2", initContainers[1].Name)
+	require.Contains(t, initContainers[1].Args[0], "ollama pull qwen2")
+	require.Equal(t, int32(1), *deployment.Spec.Replicas)
+	service := &corev1.Service{}
+	require.NoError(t, k8sClient.Get(ctx, sharedKey, service))
+	require.Equal(t, int32(11434), service.Spec.Ports[0].TargetPort.IntVal)
+
+	// the gateway routes all models to the shared Service
+	configMap := &corev1.ConfigMap{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-gateway", Namespace: "default"}, configMap))
+	require.Contains(t, configMap.Data[proxy.RoutesFileName], `"backend": "http://ollama-shared.default.svc:8080"`)
+	require.NotContains(t, configMap.Data[proxy.RoutesFileName], "ollama-llama3")
+
+	// the model is mapped to the shared backend, without a Deployment of its own
+	modelReq := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llama)}
+	reconcileTimes(t, modelReconciler, modelReq, 3)
+	modelKey := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, modelKey, &appsv1.Deployment{})))
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, modelKey, &corev1.Service{})))
+	require.NoError(t, k8sClient.Get(ctx, modelReq.NamespacedName, llama))
+	require.Equal(t, "http://ollama-shared.default.svc:8080", llama.Status.Backend)
+	require.Equal(t, aitrigramv1.LLMModelPhaseRunning, llama.Status.Phase)
+
+	// back to the PerModel ServingMode, the shared resources go away and the model gets its own
+	engine := &aitrigramv1.LLMEngine{}
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), engine))
+	engine.Spec.ServingMode = aitrigramv1.ServingModePerModel
+	require.NoError(t, k8sClient.Update(ctx, engine))
+	_, err = engineReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmEngine)})
+	require.NoError(t, err)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, sharedKey, &appsv1.Deployment{})))
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, sharedKey, &corev1.Service{})))
+	reconcileTimes(t, modelReconciler, modelReq, 1)
+	require.NoError(t, k8sClient.Get(ctx, modelKey, &appsv1.Deployment{}))
+	require.NoError(t, k8sClient.Get(ctx, modelReq.NamespacedName, llama))
+	require.Equal(t, "http://ollama-llama3.default.svc:8080", llama.Status.Backend)
+}
@@ -0,0 +1,332 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+const (
+	llmGatewayAppLabel = "aitrigram-gateway"
+	// the port the gateway container listens on
+	gatewayPort int32 = 8080
+	// the port of the admin listener of the gateway, which serves the stats of the backends to the operator
+	gatewayAdminPort int32 = 8081
+	// the default port of the gateway Service
+	defaultGatewayServicePort int32 = 8080
+	// where the route table ConfigMap is mounted in the gateway container
+	gatewayRoutesMountPath = "/etc/aitrigram/gateway"
+)
+
+// The name of the Deployment, Service and the route table ConfigMap of the gateway
+func llmGatewayName(llmEngine *aitrigramv1.LLMEngine) string {
+	return llmEngine.Name + "-gateway"
+}
+
+func llmGatewayLabels(instance string) map[string]string {
+	return map[string]string{
+		"app":      llmGatewayAppLabel,
+		"instance": instance,
+	}
+}
+
+func gatewayServicePort(gateway *aitrigramv1.GatewaySpec) int32 {
+	if gateway.ServicePort > 0 {
+		return gateway.ServicePort
+	}
+	return defaultGatewayServicePort
+}
+
+// reconcileGateway deploys the OpenAI-compatible gateway of the engine, and keeps its route table
+// in sync with the LLMModels of the engine. Everything is deleted when the gateway is disabled.
+// It returns the in-cluster URL of the gateway, which is empty when the gateway is disabled.
+func (r *LLMEngineReconciler) reconcileGateway(ctx context.Context, llmEngine *aitrigramv1.LLMEngine) (string, error) {
+	gateway := llmEngine.Spec.Gateway
+	if gateway == nil || !gateway.Enabled {
+		if err := deleteServingCert(ctx, r.Client, llmEngine, llmTLSSecretName(llmGatewayName(llmEngine))); err != nil {
+			return "", err
+		}
+		return "", r.deleteEngineResources(ctx, llmEngine, llmGatewayName(llmEngine), &corev1.Service{}, &appsv1.Deployment{}, &corev1.ConfigMap{})
+	}
+	if err := r.reconcileGatewayServingCert(ctx, llmEngine); err != nil {
+		return "", err
+	}
+
+	routes, err := r.gatewayRouteTable(ctx, llmEngine)
+	if err != nil {
+		return "", err
+	}
+	configMap, err := r.newGatewayConfigMap(llmEngine, routes)
+	if err != nil {
+		return "", err
+	}
+	if err := r.reconcileEngineConfigMap(ctx, configMap); err != nil {
+		return "", err
+	}
+	deployment, err := r.newGatewayDeployment(llmEngine)
+	if err != nil {
+		return "", err
+	}
+	if err := r.reconcileEngineDeployment(ctx, llmEngine, deployment); err != nil {
+		return "", err
+	}
+	service, err := r.newGatewayService(llmEngine)
+	if err != nil {
+		return "", err
+	}
+	if err := r.reconcileEngineService(ctx, llmEngine, service); err != nil {
+		return "", err
+	}
+	return servingURL(llmEngine, service.Namespace, service.Name, gatewayServicePort(gateway)), nil
+}
+
+// Builds the route table from the LLMModels of the engine and their LLMAdapters, each model is routed by its name in the engine
+func (r *LLMEngineReconciler) gatewayRouteTable(ctx context.Context, llmEngine *aitrigramv1.LLMEngine) (*proxy.RouteTable, error) {
+	llmModels, err := r.llmModelsOfEngine(ctx, llmEngine)
+	if err != nil {
+		return nil, err
+	}
+	table := &proxy.RouteTable{Routes: []proxy.Route{}}
+	seen := map[string]bool{}
+	for _, llmModel := range llmModels {
+		if !llmModel.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		model := llmModel.Spec.NameInEngine
+		if model == "" {
+			model = llmModel.Spec.Name
+		}
+		if seen[model] {
+			log.FromContext(ctx).Info("Ignoring the LLMModel with a duplicated name in the engine", "LLMModel.Name", llmModel.Name, "model", model)
+			continue
+		}
+		seen[model] = true
+		backend := llmModelBackend(llmEngine, &llmModel)
+		table.Routes = append(table.Routes, proxy.Route{
+			Model:   model,
+			Backend: backend,
+		})
+		// the adapters are served by the backend of the base model
+		llmAdapters, err := llmAdaptersOfModel(ctx, r.Client, &llmModel)
+		if err != nil {
+			return nil, err
+		}
+		for i := range llmAdapters {
+			adapter := adapterModelName(&llmAdapters[i])
+			if seen[adapter] {
+				log.FromContext(ctx).Info("Ignoring the LLMAdapter with a duplicated name in the engine", "LLMAdapter.Name", llmAdapters[i].Name, "model", adapter)
+				continue
+			}
+			seen[adapter] = true
+			table.Routes = append(table.Routes, proxy.Route{
+				Model:   adapter,
+				Backend: backend,
+			})
+		}
+	}
+	if err := r.applyRollouts(ctx, llmEngine, llmModels, table); err != nil {
+		return nil, err
+	}
+	sort.Slice(table.Routes, func(i, j int) bool {
+		return table.Routes[i].Model < table.Routes[j].Model
+	})
+	return table, nil
+}
+
+// Splits the route of the model of each LLMModelRollout in the engine between its stable and canary LLMModels
+// by the weight in its status, the route replaces the one of the stable LLMModel with the same model name.
+func (r *LLMEngineReconciler) applyRollouts(ctx context.Context, llmEngine *aitrigramv1.LLMEngine, llmModels []aitrigramv1.LLMModel, table *proxy.RouteTable) error {
+	rolloutList := &aitrigramv1.LLMModelRolloutList{}
+	if err := r.List(ctx, rolloutList, client.InNamespace(llmEngine.Namespace)); err != nil {
+		return err
+	}
+	modelsByName := map[string]*aitrigramv1.LLMModel{}
+	for i := range llmModels {
+		if llmModels[i].GetDeletionTimestamp().IsZero() {
+			modelsByName[llmModels[i].Name] = &llmModels[i]
+		}
+	}
+	for _, rollout := range rolloutList.Items {
+		stable, canary := modelsByName[rollout.Spec.Stable], modelsByName[rollout.Spec.Canary]
+		if stable == nil || canary == nil || rollout.Status.Model == "" ||
+			rollout.Status.Phase == aitrigramv1.LLMModelRolloutPhaseFailed {
+			continue
+		}
+		route := proxy.Route{
+			Model: rollout.Status.Model,
+			Backends: []proxy.WeightedBackend{
+				{Backend: llmModelBackend(llmEngine, stable), Model: llmModelNameInEngine(stable), Weight: 100 - rollout.Status.CanaryWeight},
+				{Backend: llmModelBackend(llmEngine, canary), Model: llmModelNameInEngine(canary), Weight: rollout.Status.CanaryWeight},
+			},
+		}
+		index := slices.IndexFunc(table.Routes, func(existing proxy.Route) bool { return existing.Model == route.Model })
+		if index < 0 {
+			table.Routes = append(table.Routes, route)
+			continue
+		}
+		if len(table.Routes[index].Backends) > 0 {
+			log.FromContext(ctx).Info("Ignoring the LLMModelRollout of a model split by another one", "LLMModelRollout.Name", rollout.Name, "model", route.Model)
+			continue
+		}
+		table.Routes[index] = route
+	}
+	return nil
+}
+
+func (r *LLMEngineReconciler) newGatewayConfigMap(llmEngine *aitrigramv1.LLMEngine, routes *proxy.RouteTable) (*corev1.ConfigMap, error) {
+	data, err := json.MarshalIndent(routes, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	name := llmGatewayName(llmEngine)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: llmEngine.Namespace,
+			Labels:    llmGatewayLabels(name),
+		},
+		Data: map[string]string{
+			proxy.RoutesFileName: string(data),
+		},
+	}
+	if err := ctrl.SetControllerReference(llmEngine, configMap, r.Scheme); err != nil {
+		return nil, err
+	}
+	return configMap, nil
+}
+
+func (r *LLMEngineReconciler) newGatewayDeployment(llmEngine *aitrigramv1.LLMEngine) (*appsv1.Deployment, error) {
+	name := llmGatewayName(llmEngine)
+	labels := llmGatewayLabels(name)
+	replicas := llmEngine.Spec.Gateway.Replicas
+	if replicas == nil {
+		replicas = ptr.To[int32](1)
+	}
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: llmEngine.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:            "gateway",
+						Image:           r.ProxyImage,
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Command:         []string{"/manager"},
+						Args: []string{
+							"gateway",
+							fmt.Sprintf("--listen=:%d", gatewayPort),
+							fmt.Sprintf("--admin-listen=:%d", gatewayAdminPort),
+							fmt.Sprintf("--routes=%s/%s", gatewayRoutesMountPath, proxy.RoutesFileName),
+						},
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: gatewayPort,
+							Name:          "http",
+						}, {
+							ContainerPort: gatewayAdminPort,
+							Name:          "admin",
+						}},
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "routes",
+							MountPath: gatewayRoutesMountPath,
+							ReadOnly:  true,
+						}},
+						SecurityContext: restrictedSecurityContext(),
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "routes",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{Name: name},
+							},
+						},
+					}},
+				},
+			},
+		},
+	}
+	if err := applyAuth(&deployment.Spec.Template.Spec, "gateway", llmEngine, ""); err != nil {
+		return nil, err
+	}
+	if tlsEnabled(llmEngine) {
+		applyTLS(&deployment.Spec.Template.Spec, "gateway", llmTLSSecretName(name), gatewayTLSPort, plaintextEnabled(llmEngine))
+		if !plaintextEnabled(llmEngine) {
+			// the backends are only served over https, their certificates are signed by the CA of the engine
+			deployment.Spec.Template.Spec.Containers[0].Args = append(deployment.Spec.Template.Spec.Containers[0].Args,
+				"--backend-ca-file="+path.Join(tlsMountPath, "ca.crt"))
+		}
+	}
+	if err := ctrl.SetControllerReference(llmEngine, deployment, r.Scheme); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+func (r *LLMEngineReconciler) newGatewayService(llmEngine *aitrigramv1.LLMEngine) (*corev1.Service, error) {
+	name := llmGatewayName(llmEngine)
+	labels := llmGatewayLabels(name)
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: llmEngine.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Type:     corev1.ServiceTypeClusterIP,
+		},
+	}
+	if plaintextEnabled(llmEngine) {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       "http",
+			Port:       gatewayServicePort(llmEngine.Spec.Gateway),
+			TargetPort: intstr.FromInt32(gatewayPort),
+		})
+	}
+	if tlsEnabled(llmEngine) {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       "https",
+			Port:       tlsServicePort(llmEngine.Spec.TLS),
+			TargetPort: intstr.FromInt32(gatewayTLSPort),
+		})
+	}
+	if err := ctrl.SetControllerReference(llmEngine, service, r.Scheme); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
+
+// Reconcile the serving certificate of the Service of the gateway, it is deleted when the TLS is disabled
+func (r *LLMEngineReconciler) reconcileGatewayServingCert(ctx context.Context, llmEngine *aitrigramv1.LLMEngine) error {
+	name := llmGatewayName(llmEngine)
+	if !tlsEnabled(llmEngine) {
+		return deleteServingCert(ctx, r.Client, llmEngine, llmTLSSecretName(name))
+	}
+	dnsNames := serviceDNSNames(llmEngine.Namespace, name, llmEngine.Spec.TLS)
+	return reconcileServingCert(ctx, r.Client, r.Scheme, llmEngine, llmEngine, llmTLSSecretName(name), dnsNames)
+}
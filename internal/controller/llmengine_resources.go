@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// The helpers to create or update the resources owned by the LLMEngine, like the gateway and the shared serving.
+
+func (r *LLMEngineReconciler) reconcileEngineConfigMap(ctx context.Context, desired *corev1.ConfigMap) error {
+	logger := log.FromContext(ctx)
+	existing := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		logger.Info("Creating a new ConfigMap", "ConfigMap.Name", desired.Name)
+		return r.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepEqual(desired.Data, existing.Data) {
+		return nil
+	}
+	logger.Info("Updating the ConfigMap", "ConfigMap.Name", desired.Name)
+	patch := client.MergeFrom(existing.DeepCopy())
+	existing.Data = desired.Data
+	return r.Patch(ctx, existing, patch)
+}
+
+func (r *LLMEngineReconciler) reconcileEngineDeployment(ctx context.Context, llmEngine *aitrigramv1.LLMEngine, desired *appsv1.Deployment) error {
+	logger := log.FromContext(ctx)
+	if err := setDeploymentSpecHash(desired); err != nil {
+		return err
+	}
+	existing := &appsv1.Deployment{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		logger.Info("Creating a new Deployment", "Deployment.Name", desired.Name)
+		if err := r.Create(ctx, desired); err != nil {
+			return err
+		}
+		recordEvent(r.Recorder, llmEngine, corev1.EventTypeNormal, eventReasonDeploymentCreated, "Created the Deployment %s", desired.Name)
+		return nil
+	}
+	if deploymentUpToDate(desired, existing) {
+		return nil
+	}
+	logger.Info("Updating the Deployment", "Deployment.Name", desired.Name)
+	if err := patchDeployment(ctx, r.Client, desired, existing); err != nil {
+		return err
+	}
+	recordEvent(r.Recorder, llmEngine, corev1.EventTypeNormal, eventReasonDeploymentUpdated, "Updated the Deployment %s", desired.Name)
+	return nil
+}
+
+func (r *LLMEngineReconciler) reconcileEngineService(ctx context.Context, llmEngine *aitrigramv1.LLMEngine, desired *corev1.Service) error {
+	logger := log.FromContext(ctx)
+	existing := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		logger.Info("Creating a new Service", "Service.Name", desired.Name)
+		if err := r.Create(ctx, desired); err != nil {
+			return err
+		}
+		recordEvent(r.Recorder, llmEngine, corev1.EventTypeNormal, eventReasonServiceCreated, "Created the Service %s", desired.Name)
+		return nil
+	}
+	// only the fields managed by the operator are compared, others like the ClusterIP are assigned by the api server
+	if equality.Semantic.DeepEqual(desired.Spec.Selector, existing.Spec.Selector) &&
+		len(desired.Spec.Ports) == len(existing.Spec.Ports) &&
+		equality.Semantic.DeepDerivative(desired.Spec.Ports, existing.Spec.Ports) {
+		return nil
+	}
+	logger.Info("Updating the Service", "Service.Name", desired.Name)
+	patch := client.MergeFrom(existing.DeepCopy())
+	existing.Spec.Selector = desired.Spec.Selector
+	existing.Spec.Ports = desired.Spec.Ports
+	return r.Patch(ctx, existing, patch)
+}
+
+// Deletes the objects with the name which are controlled by the engine
+func (r *LLMEngineReconciler) deleteEngineResources(ctx context.Context, llmEngine *aitrigramv1.LLMEngine, name string, objs ...client.Object) error {
+	logger := log.FromContext(ctx)
+	for _, obj := range objs {
+		if err := r.Get(ctx, client.ObjectKey{Namespace: llmEngine.Namespace, Name: name}, obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if !metav1.IsControlledBy(obj, llmEngine) {
+			continue
+		}
+		logger.Info("Deleting resource of the LLMEngine", "Kind", fmt.Sprintf("%T", obj), "Name", name)
+		if err := r.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
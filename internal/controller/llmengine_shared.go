@@ -0,0 +1,236 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const llmSharedAppLabel = "aitrigram-shared"
+
+// The name of the Deployment and the Service serving all LLMModels of the engine in the Shared ServingMode
+func llmSharedName(llmEngine *aitrigramv1.LLMEngine) string {
+	return llmEngine.Name + "-shared"
+}
+
+func llmSharedLabels(instance string) map[string]string {
+	return map[string]string{
+		"app":      llmSharedAppLabel,
+		"instance": instance,
+	}
+}
+
+func isSharedServing(llmEngine *aitrigramv1.LLMEngine) bool {
+	return llmEngine.Spec.ServingMode == aitrigramv1.ServingModeShared &&
+		llmEngine.Spec.EngineType == aitrigramv1.LLMEngineTypeOllama
+}
+
+// The in-cluster URL of the Service serving the model, it is the shared Service in the Shared ServingMode
+func llmModelBackend(llmEngine *aitrigramv1.LLMEngine, llmModel *aitrigramv1.LLMModel) string {
+	if isSharedServing(llmEngine) {
+		return serviceURL(llmModel.Namespace, backendServiceName(llmEngine, llmModel), llmEngine.Spec.ServicePort)
+	}
+	return servingURL(llmEngine, llmModel.Namespace, backendServiceName(llmEngine, llmModel), llmEngine.Spec.ServicePort)
+}
+
+// reconcileSharedServing deploys one Deployment and one Service for all LLMModels of the engine in the Shared
+// ServingMode, each LLMModel is pre-pulled by an init container into the models storage of the engine.
+// Both are deleted in the PerModel ServingMode.
+func (r *LLMEngineReconciler) reconcileSharedServing(ctx context.Context, llmEngine *aitrigramv1.LLMEngine) error {
+	logger := log.FromContext(ctx)
+	if llmEngine.Spec.ServingMode == aitrigramv1.ServingModeShared && !isSharedServing(llmEngine) {
+		logger.Info("The Shared ServingMode is only supported by ollama, the LLMModels are served per model", "engineType", llmEngine.Spec.EngineType)
+	}
+	if !isSharedServing(llmEngine) {
+		return r.deleteEngineResources(ctx, llmEngine, llmSharedName(llmEngine), &corev1.Service{}, &appsv1.Deployment{})
+	}
+
+	llmModels, err := r.llmModelsOfEngine(ctx, llmEngine)
+	if err != nil {
+		return err
+	}
+	servedModels, modelfiles, err := sharedServingModels(ctx, r.Client, llmModels)
+	if err != nil {
+		return err
+	}
+	if engineBoundToLocalhost(llmEngine) {
+		if _, err := reconcileAdminToken(ctx, r.Client, r.Scheme, llmEngine); err != nil {
+			return err
+		}
+	}
+	deployment, err := r.newSharedDeployment(llmEngine, servedModels, modelfiles)
+	if err != nil {
+		return err
+	}
+	if err := r.reconcileEngineDeployment(ctx, llmEngine, deployment); err != nil {
+		return err
+	}
+	service, err := r.newSharedService(llmEngine)
+	if err != nil {
+		return err
+	}
+	return r.reconcileEngineService(ctx, llmEngine, service)
+}
+
+// Returns the LLMModels served by the shared Deployment, with the resolved Modelfile of each one keyed by the
+// LLMModel name. The LLMModels whose Modelfile is not resolved are left out, the other ones are still served and
+// the LLMModel gets added once its Modelfile is resolved.
+func sharedServingModels(ctx context.Context, c client.Reader, llmModels []aitrigramv1.LLMModel) (
+	[]aitrigramv1.LLMModel, map[string]string, error) {
+	logger := log.FromContext(ctx)
+	servedModels := []aitrigramv1.LLMModel{}
+	modelfiles := map[string]string{}
+	for i := range llmModels {
+		var err error
+		if modelfiles[llmModels[i].Name], err = resolveModelfile(ctx, c, &llmModels[i]); err != nil {
+			logger.Error(err, "Failed to resolve the Modelfile of the LLMModel", "LLMModel.Name", llmModels[i].Name)
+			continue
+		}
+		servedModels = append(servedModels, llmModels[i])
+	}
+	return servedModels, modelfiles, nil
+}
+
+// The Modelfiles are the resolved Modelfile of each LLMModel keyed by the LLMModel name
+func (r *LLMEngineReconciler) newSharedDeployment(llmEngine *aitrigramv1.LLMEngine, llmModels []aitrigramv1.LLMModel, modelfiles map[string]string) (*appsv1.Deployment, error) {
+	name := llmSharedName(llmEngine)
+	labels := llmSharedLabels(name)
+	template := llmEngine.Spec.ModelDeploymentTemplate
+	if template == nil {
+		template = &aitrigramv1.ModelDeploymentTemplate{}
+	}
+	envs := []corev1.EnvVar{}
+	if template.Envs != nil {
+		envs = *template.Envs
+	}
+	volumes, volumeMounts := cacheAndModelsMount(template.Storage)
+	modelsDir := ""
+	if template.Storage != nil && template.Storage.ModelsStorage != nil {
+		modelsDir = template.Storage.ModelsStorage.Path
+	}
+
+	// the init containers are sorted so that the order of the listed LLMModels does not cause a rollout
+	sort.Slice(llmModels, func(i, j int) bool {
+		return llmModels[i].Name < llmModels[j].Name
+	})
+	initContainers := []corev1.Container{}
+	for _, llmModel := range llmModels {
+		if !llmModel.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		modelDeployment := llmModel.Spec.ModelDeployment
+		if modelDeployment == nil {
+			modelDeployment = template
+		}
+		downloadScripts, err := generateInitScript(modelDeployment.DownloadScripts, DownloadScriptsTemplate{
+			ModelName: llmModelPullName(&llmModel),
+			Revision:  llmModel.Spec.Revision,
+			ModelUrl:  modelDeployment.DownloadImage,
+			ModelDir:  modelsDir,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate the download scripts of LLMModel %s: %w", llmModel.Name, err)
+		}
+		downloadScripts = appendOllamaRevisionScripts(downloadScripts, &llmModel)
+		downloadScripts = appendOllamaModelfileScripts(downloadScripts, &llmModel, modelfiles[llmModel.Name])
+		// the models are downloaded into the storage of the engine, which is shared by all of them
+		initContainers = append(initContainers, corev1.Container{
+			Image:        modelDeployment.DownloadImage,
+			Name:         "init-" + llmModelResourceName(llmEngine.Spec.EngineType, llmModel.Spec.Name),
+			Env:          envs,
+			Command:      []string{"/bin/sh", "-c"},
+			Args:         []string{downloadScripts},
+			VolumeMounts: volumeMounts,
+		})
+	}
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: llmEngine.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptr.To(ptr.Deref(llmEngine.Spec.SharedReplicas, 1)),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Strategy: modelRolloutStrategy(nil),
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					InitContainers: initContainers,
+					Containers: []corev1.Container{{
+						Image:           llmEngine.Spec.Image,
+						Name:            name,
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Ports: []corev1.ContainerPort{{
+							ContainerPort: llmEngine.Spec.Port,
+							Name:          "http",
+						}},
+						Command:        template.Args,
+						Env:            envs,
+						VolumeMounts:   volumeMounts,
+						ReadinessProbe: engineReadinessProbe(llmEngine.Spec.EngineType),
+					}},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+	applySecurityContext(&deployment.Spec.Template.Spec, template, name)
+	if authEnabled(llmEngine) {
+		// the shared pods serve all models, so the API keys are checked against the model in each request
+		deployment.Spec.Template.Spec.Containers = append(deployment.Spec.Template.Spec.Containers, proxySidecar(r.ProxyImage, llmEngine.Spec.Port))
+		if err := applyAuth(&deployment.Spec.Template.Spec, proxySidecarName, llmEngine, ""); err != nil {
+			return nil, err
+		}
+		bindEngineToLocalhost(&deployment.Spec.Template.Spec, llmEngine)
+	}
+	if err := ctrl.SetControllerReference(llmEngine, deployment, r.Scheme); err != nil {
+		return nil, err
+	}
+	return deployment, nil
+}
+
+func (r *LLMEngineReconciler) newSharedService(llmEngine *aitrigramv1.LLMEngine) (*corev1.Service, error) {
+	name := llmSharedName(llmEngine)
+	labels := llmSharedLabels(name)
+	targetPort := llmEngine.Spec.Port
+	if authEnabled(llmEngine) {
+		targetPort = proxySidecarPort
+	}
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: llmEngine.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: labels,
+			Ports: []corev1.ServicePort{{
+				Port:       llmEngine.Spec.ServicePort,
+				TargetPort: intstr.FromInt32(targetPort),
+			}},
+			Type:            corev1.ServiceTypeClusterIP,
+			SessionAffinity: corev1.ServiceAffinityClientIP,
+		},
+	}
+	if err := ctrl.SetControllerReference(llmEngine, service, r.Scheme); err != nil {
+		return nil, err
+	}
+	return service, nil
+}
@@ -2,35 +2,166 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
 )
 
-func (r *LLMEngineReconciler) UpdateLLMEngineStatus(ctx context.Context, req ctrl.Request, condition *metav1.Condition) error {
+func (r *LLMEngineReconciler) UpdateLLMEngineStatus(ctx context.Context, req ctrl.Request, conditions ...*metav1.Condition) error {
 	llmEngine := &aitrigramv1.LLMEngine{}
 	if err := r.Get(ctx, req.NamespacedName, llmEngine); err != nil {
 		return client.IgnoreNotFound(err)
 	}
-	meta.SetStatusCondition(&llmEngine.Status.Conditions, *condition)
+	for _, condition := range conditions {
+		meta.SetStatusCondition(&llmEngine.Status.Conditions, *condition)
+	}
 	if err := r.Status().Update(ctx, llmEngine); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *LLMModelReconciler) updateLLMModelStatus(ctx context.Context, req ctrl.Request, condition *metav1.Condition) error {
+// Records the in-cluster URL of the gateway, it is empty when the gateway is disabled
+func (r *LLMEngineReconciler) updateLLMEngineGatewayEndpoint(ctx context.Context, req ctrl.Request, endpoint string) error {
+	llmEngine := &aitrigramv1.LLMEngine{}
+	if err := r.Get(ctx, req.NamespacedName, llmEngine); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if llmEngine.Status.GatewayEndpoint == endpoint {
+		return nil
+	}
+	llmEngine.Status.GatewayEndpoint = endpoint
+	return r.Status().Update(ctx, llmEngine)
+}
+
+func (r *LLMModelReconciler) updateLLMModelStatus(ctx context.Context, req ctrl.Request, conditions ...*metav1.Condition) error {
 	llmModel := &aitrigramv1.LLMModel{}
 	if err := r.Get(ctx, req.NamespacedName, llmModel); err != nil {
 		return client.IgnoreNotFound(err)
 	}
-	meta.SetStatusCondition(&llmModel.Status.Conditions, *condition)
+	for _, condition := range conditions {
+		meta.SetStatusCondition(&llmModel.Status.Conditions, *condition)
+	}
 	if err := r.Status().Update(ctx, llmModel); err != nil {
 		return err
 	}
 	return nil
 }
+
+// Records the replicas and the pod selector of the model Deployment, which are used by the scale subresource,
+// and if the model has a ready pod in the Available condition
+func (r *LLMModelReconciler) updateLLMModelScaleStatus(ctx context.Context, req ctrl.Request, deployment *appsv1.Deployment, engineType aitrigramv1.LLMEngineType) error {
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, req.NamespacedName, llmModel); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	selector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels).String()
+	available := availableCondition(llmModel, deployment)
+	previousReason := ""
+	if previous := meta.FindStatusCondition(llmModel.Status.Conditions, aitrigramv1.ConditionTypeAvailable); previous != nil {
+		if llmModel.Status.Replicas == deployment.Status.Replicas && llmModel.Status.Selector == selector &&
+			previous.Status == available.Status && previous.Reason == available.Reason {
+			return nil
+		}
+		previousReason = previous.Reason
+	}
+	llmModel.Status.Replicas = deployment.Status.Replicas
+	llmModel.Status.Selector = selector
+	meta.SetStatusCondition(&llmModel.Status.Conditions, available)
+	if err := r.Status().Update(ctx, llmModel); err != nil {
+		return err
+	}
+	// only the first ready pod since the creation counts, not the ones after a scale from zero
+	if available.Status == metav1.ConditionTrue && previousReason == llmModelReasonDeploying {
+		llmModelTimeToReady.WithLabelValues(string(engineType)).Observe(time.Since(llmModel.CreationTimestamp.Time).Seconds())
+	}
+	return nil
+}
+
+// the reason of the Available condition before the model has had any ready pod
+const llmModelReasonDeploying = "Deploying"
+
+func availableCondition(llmModel *aitrigramv1.LLMModel, deployment *appsv1.Deployment) metav1.Condition {
+	if deployment.Status.ReadyReplicas > 0 {
+		return metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeAvailable,
+			Status:  metav1.ConditionTrue,
+			Reason:  "PodsReady",
+			Message: fmt.Sprintf("%d pods are ready", deployment.Status.ReadyReplicas),
+		}
+	}
+	reason := "NoReadyPods"
+	if previous := meta.FindStatusCondition(llmModel.Status.Conditions, aitrigramv1.ConditionTypeAvailable); previous == nil || previous.Reason == llmModelReasonDeploying {
+		reason = llmModelReasonDeploying
+	}
+	return metav1.Condition{
+		Type:    aitrigramv1.ConditionTypeAvailable,
+		Status:  metav1.ConditionFalse,
+		Reason:  reason,
+		Message: "There is no ready pod",
+	}
+}
+
+// Records the external URL of the model, which is empty when the model is not exposed, and the in-cluster URL of its backend
+func (r *LLMModelReconciler) updateLLMModelEndpoints(ctx context.Context, req ctrl.Request, url string, backend string) error {
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, req.NamespacedName, llmModel); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if llmModel.Status.URL == url && llmModel.Status.Backend == backend {
+		return nil
+	}
+	llmModel.Status.URL = url
+	llmModel.Status.Backend = backend
+	return r.Status().Update(ctx, llmModel)
+}
+
+// Records the phase and the last time a request was seen by the model
+func (r *LLMModelReconciler) updateLLMModelActivity(ctx context.Context, req ctrl.Request, phase aitrigramv1.LLMModelPhase, lastRequestTime *metav1.Time) error {
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, req.NamespacedName, llmModel); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	llmModel.Status.Phase = phase
+	llmModel.Status.LastRequestTime = lastRequestTime
+	return r.Status().Update(ctx, llmModel)
+}
+
+// Records the phase of the model
+func (r *LLMModelReconciler) updateLLMModelPhase(ctx context.Context, req ctrl.Request, phase aitrigramv1.LLMModelPhase) error {
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, req.NamespacedName, llmModel); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if llmModel.Status.Phase == phase {
+		return nil
+	}
+	llmModel.Status.Phase = phase
+	return r.Status().Update(ctx, llmModel)
+}
+
+// The condition set on both LLMEngine and LLMModel once the finalizer starts the teardown
+func terminatingConditions(message string) []*metav1.Condition {
+	return []*metav1.Condition{
+		{
+			Type:    aitrigramv1.ConditionTypeTerminating,
+			Status:  metav1.ConditionTrue,
+			Reason:  "Finalizing",
+			Message: message,
+		},
+		{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Terminating",
+			Message: message,
+		},
+	}
+}
@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// LLMEngineFinalizer is added to each LLMEngine so that all LLMModels referring to it
+	// are torn down before the LLMEngine goes away.
+	LLMEngineFinalizer = "aitrigram.ihomeland.cn/llmengine-finalizer"
+)
+
+// finalizeLLMEngine deletes all LLMModels which refer to the LLMEngine, no matter if they have the
+// ownerReference set or not, and waits for them to be finalized before removing the finalizer.
+func (r *LLMEngineReconciler) finalizeLLMEngine(ctx context.Context, req ctrl.Request, llmEngine *aitrigramv1.LLMEngine) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(llmEngine, LLMEngineFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if !meta.IsStatusConditionTrue(llmEngine.Status.Conditions, aitrigramv1.ConditionTypeTerminating) {
+		if err := r.UpdateLLMEngineStatus(ctx, req, terminatingConditions("LLMEngine is being deleted")...); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	llmModels, err := r.llmModelsOfEngine(ctx, llmEngine)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(llmModels) > 0 {
+		for i := range llmModels {
+			if !llmModels[i].GetDeletionTimestamp().IsZero() {
+				continue
+			}
+			logger.Info("Deleting the LLMModel of the LLMEngine", "LLMModel.Name", llmModels[i].Name)
+			if err := r.Delete(ctx, &llmModels[i]); client.IgnoreNotFound(err) != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		// wait for the LLMModels to be finalized
+		if err := r.UpdateLLMEngineStatus(ctx, req, terminatingConditions(fmt.Sprintf("Waiting for %d LLMModels to be deleted", len(llmModels)))...); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+	}
+
+	controllerutil.RemoveFinalizer(llmEngine, LLMEngineFinalizer)
+	if err := r.Update(ctx, llmEngine); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	llmEngineModels.DeleteLabelValues(req.Namespace, req.Name)
+	logger.Info("LLMEngine has been finalized")
+	return ctrl.Result{}, nil
+}
+
+// Returns the LLMModels in the same namespace which refer to the LLMEngine
+func (r *LLMEngineReconciler) llmModelsOfEngine(ctx context.Context, llmEngine *aitrigramv1.LLMEngine) ([]aitrigramv1.LLMModel, error) {
+	llmModelList := &aitrigramv1.LLMModelList{}
+	if err := r.List(ctx, llmModelList, client.InNamespace(llmEngine.Namespace)); err != nil {
+		return nil, err
+	}
+	var llmModels []aitrigramv1.LLMModel
+	for _, llmModel := range llmModelList.Items {
+		if llmModel.Spec.EngineRef == llmEngine.Name {
+			llmModels = append(llmModels, llmModel)
+		}
+	}
+	return llmModels, nil
+}
@@ -0,0 +1,507 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	defaultTLSServicePort int32 = 443
+	// the https port of the proxy sidecar
+	proxySidecarTLSPort int32 = 15443
+	// the https port of the gateway container
+	gatewayTLSPort int32 = 8443
+	// the volume of the serving certificate in the pods of the proxy sidecar and the gateway
+	tlsVolumeName = "tls"
+	// where the Secret of the serving certificate is mounted
+	tlsMountPath = "/etc/aitrigram/tls"
+
+	// the validity of the CA generated by the operator
+	caValidity = 10 * 365 * 24 * time.Hour
+	// the validity of the serving certificates signed by the CA generated by the operator
+	servingCertValidity = 90 * 24 * time.Hour
+	// how often the certificates are checked for the renewal
+	certCheckInterval = time.Hour
+)
+
+var certificateGVK = schema.GroupVersionKind{
+	Group:   "cert-manager.io",
+	Version: "v1",
+	Kind:    "Certificate",
+}
+
+// The Services of the LLMModels and the gateway of the engine get the https port
+func tlsEnabled(llmEngine *aitrigramv1.LLMEngine) bool {
+	return llmEngine.Spec.TLS != nil && llmEngine.Spec.TLS.Enabled
+}
+
+// The http ports are served unless the TLS is enabled without the plaintext
+func plaintextEnabled(llmEngine *aitrigramv1.LLMEngine) bool {
+	return !tlsEnabled(llmEngine) || llmEngine.Spec.TLS.Plaintext
+}
+
+// The in-cluster URL of the Service, it is the https one when the http port is not served
+func servingURL(llmEngine *aitrigramv1.LLMEngine, namespace, name string, port int32) string {
+	if plaintextEnabled(llmEngine) {
+		return serviceURL(namespace, name, port)
+	}
+	return fmt.Sprintf("https://%s.%s.svc:%d", name, namespace, tlsServicePort(llmEngine.Spec.TLS))
+}
+
+func tlsServicePort(tls *aitrigramv1.TLSSpec) int32 {
+	if tls.ServicePort > 0 {
+		return tls.ServicePort
+	}
+	return defaultTLSServicePort
+}
+
+// The name of the http port of the Service, the ports need names when there is the https port as well
+func servicePortName(llmEngine *aitrigramv1.LLMEngine) string {
+	if tlsEnabled(llmEngine) {
+		return "http"
+	}
+	return ""
+}
+
+// The name of the Secret of the serving certificate, and of the cert-manager Certificate which issues it
+func llmTLSSecretName(name string) string {
+	return name + "-tls"
+}
+
+// The name of the Secret of the CA generated by the operator for the engine
+func llmCASecretName(llmEngine *aitrigramv1.LLMEngine) string {
+	return llmEngine.Name + "-ca"
+}
+
+// The cert-manager Certificate is only available when the cert-manager CRDs are installed
+func certManagerAvailable(c client.Client) bool {
+	_, err := c.RESTMapper().RESTMapping(certificateGVK.GroupKind(), certificateGVK.Version)
+	return err == nil
+}
+
+// The DNS names of the Service, followed by the extra DNS names of the TLSSpec
+func serviceDNSNames(namespace, name string, tls *aitrigramv1.TLSSpec) []string {
+	dnsNames := []string{
+		name,
+		fmt.Sprintf("%s.%s", name, namespace),
+		fmt.Sprintf("%s.%s.svc", name, namespace),
+		fmt.Sprintf("%s.%s.svc.cluster.local", name, namespace),
+	}
+	return append(dnsNames, tls.DNSNames...)
+}
+
+// Mounts the Secret of the serving certificate into the named container, and starts its https listener on the port.
+// The http listener of the container is turned off unless the plaintext is kept.
+func applyTLS(podSpec *corev1.PodSpec, containerName string, secretName string, port int32, plaintext bool) {
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if container.Name != containerName {
+			continue
+		}
+		if !plaintext {
+			for j, arg := range container.Args {
+				listen, ok := strings.CutPrefix(arg, "--listen=")
+				if !ok {
+					continue
+				}
+				container.Args[j] = "--listen="
+				container.Ports = slices.DeleteFunc(container.Ports, func(port corev1.ContainerPort) bool {
+					return listen == fmt.Sprintf(":%d", port.ContainerPort)
+				})
+			}
+		}
+		container.Args = append(container.Args,
+			fmt.Sprintf("--tls-listen=:%d", port),
+			"--tls-cert-file="+path.Join(tlsMountPath, corev1.TLSCertKey),
+			"--tls-key-file="+path.Join(tlsMountPath, corev1.TLSPrivateKeyKey),
+		)
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			ContainerPort: port,
+			Name:          "https",
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      tlsVolumeName,
+			MountPath: tlsMountPath,
+			ReadOnly:  true,
+		})
+	}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: tlsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: secretName},
+		},
+	})
+}
+
+// reconcileServingCert makes sure the Secret of the serving certificate of the DNS names exists and is valid.
+// It is issued by the cert-manager when the IssuerRef is defined and the cert-manager is installed, otherwise it is
+// signed by the CA of the engine generated by the operator, and it is signed again before it expires.
+func reconcileServingCert(ctx context.Context, c client.Client, scheme *runtime.Scheme, llmEngine *aitrigramv1.LLMEngine,
+	owner client.Object, secretName string, dnsNames []string) error {
+	logger := log.FromContext(ctx)
+	tls := llmEngine.Spec.TLS
+	certManager := certManagerAvailable(c)
+	if tls.IssuerRef != nil && certManager {
+		return reconcileCertificate(ctx, c, scheme, tls.IssuerRef, owner, secretName, dnsNames)
+	}
+	if tls.IssuerRef != nil {
+		logger.Info("The cert-manager is not installed, the certificate is signed by the CA of the operator", "Secret.Name", secretName)
+	}
+	if certManager {
+		// the Certificate is left from a removed IssuerRef
+		if err := deleteOwned(ctx, c, owner, newCertificate(owner.GetNamespace(), secretName)); err != nil {
+			return err
+		}
+	}
+
+	ca, err := reconcileCA(ctx, c, scheme, llmEngine)
+	if err != nil {
+		return err
+	}
+	secret := &corev1.Secret{}
+	err = c.Get(ctx, client.ObjectKey{Namespace: owner.GetNamespace(), Name: secretName}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if exists && servingCertValid(secret, ca, dnsNames, time.Now()) {
+		return nil
+	}
+	certPEM, keyPEM, err := ca.sign(dnsNames, time.Now())
+	if err != nil {
+		return err
+	}
+	data := map[string][]byte{
+		corev1.TLSCertKey:       certPEM,
+		corev1.TLSPrivateKeyKey: keyPEM,
+		"ca.crt":                ca.certPEM,
+	}
+	if !exists {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretName,
+				Namespace: owner.GetNamespace(),
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}
+		if err := ctrl.SetControllerReference(owner, secret, scheme); err != nil {
+			return err
+		}
+		logger.Info("Creating the Secret of the serving certificate", "Secret.Name", secretName)
+		return c.Create(ctx, secret)
+	}
+	logger.Info("Renewing the serving certificate", "Secret.Name", secretName)
+	patch := client.MergeFrom(secret.DeepCopy())
+	secret.Data = data
+	return c.Patch(ctx, secret, patch)
+}
+
+// Deletes the Secret of the serving certificate and its cert-manager Certificate owned by the owner
+func deleteServingCert(ctx context.Context, c client.Client, owner client.Object, secretName string) error {
+	if certManagerAvailable(c) {
+		if err := deleteOwned(ctx, c, owner, newCertificate(owner.GetNamespace(), secretName)); err != nil {
+			return err
+		}
+	}
+	return deleteOwned(ctx, c, owner, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: owner.GetNamespace(), Name: secretName}})
+}
+
+// Deletes the object with the namespace and name of obj when it is controlled by the owner
+func deleteOwned(ctx context.Context, c client.Client, owner client.Object, obj client.Object) error {
+	if err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, owner) {
+		return nil
+	}
+	log.FromContext(ctx).Info("Deleting the resource of the certificate", "Kind", fmt.Sprintf("%T", obj), "Name", obj.GetName())
+	return client.IgnoreNotFound(c.Delete(ctx, obj))
+}
+
+func newCertificate(namespace, name string) *unstructured.Unstructured {
+	certificate := &unstructured.Unstructured{}
+	certificate.SetGroupVersionKind(certificateGVK)
+	certificate.SetNamespace(namespace)
+	certificate.SetName(name)
+	return certificate
+}
+
+// Reconcile the cert-manager Certificate which issues the serving certificate into the Secret, the cert-manager
+// renews it before it expires
+func reconcileCertificate(ctx context.Context, c client.Client, scheme *runtime.Scheme, issuerRef *aitrigramv1.IssuerReference,
+	owner client.Object, secretName string, dnsNames []string) error {
+	logger := log.FromContext(ctx)
+	issuer := map[string]interface{}{
+		"name":  issuerRef.Name,
+		"kind":  "Issuer",
+		"group": certificateGVK.Group,
+	}
+	if issuerRef.Kind != "" {
+		issuer["kind"] = issuerRef.Kind
+	}
+	if issuerRef.Group != "" {
+		issuer["group"] = issuerRef.Group
+	}
+	names := make([]interface{}, 0, len(dnsNames))
+	for _, name := range dnsNames {
+		names = append(names, name)
+	}
+	desired := newCertificate(owner.GetNamespace(), secretName)
+	desired.Object["spec"] = map[string]interface{}{
+		"secretName": secretName,
+		"dnsNames":   names,
+		"issuerRef":  issuer,
+		"usages":     []interface{}{"server auth", "digital signature", "key encipherment"},
+	}
+	if err := ctrl.SetControllerReference(owner, desired, scheme); err != nil {
+		return err
+	}
+	existing := newCertificate(owner.GetNamespace(), secretName)
+	if err := c.Get(ctx, client.ObjectKeyFromObject(existing), existing); err != nil {
+		if !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+			return err
+		}
+		logger.Info("Creating the Certificate of the serving certificate", "Certificate.Name", secretName)
+		return c.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepDerivative(desired.Object["spec"], existing.Object["spec"]) {
+		return nil
+	}
+	logger.Info("Updating the Certificate of the serving certificate", "Certificate.Name", secretName)
+	patch := client.MergeFrom(existing.DeepCopy())
+	existing.Object["spec"] = desired.Object["spec"]
+	return c.Patch(ctx, existing, patch)
+}
+
+// certAuthority is the CA generated by the operator for an engine
+type certAuthority struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+}
+
+// Reconcile the Secret of the CA of the engine, a new CA is generated when it is in the last third of its validity
+func reconcileCA(ctx context.Context, c client.Client, scheme *runtime.Scheme, llmEngine *aitrigramv1.LLMEngine) (*certAuthority, error) {
+	logger := log.FromContext(ctx)
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, client.ObjectKey{Namespace: llmEngine.Namespace, Name: llmCASecretName(llmEngine)}, secret)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, err
+	}
+	exists := err == nil
+	if exists {
+		ca, err := parseCA(secret)
+		if err == nil && !renewalDue(ca.cert, time.Now()) {
+			return ca, nil
+		}
+		if err != nil {
+			logger.Error(err, "Invalid CA, generating a new one", "Secret.Name", secret.Name)
+		}
+	}
+	ca, keyPEM, err := newCA(llmEngine.Name, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	data := map[string][]byte{
+		corev1.TLSCertKey:       ca.certPEM,
+		corev1.TLSPrivateKeyKey: keyPEM,
+		"ca.crt":                ca.certPEM,
+	}
+	if !exists {
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      llmCASecretName(llmEngine),
+				Namespace: llmEngine.Namespace,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: data,
+		}
+		if err := ctrl.SetControllerReference(llmEngine, secret, scheme); err != nil {
+			return nil, err
+		}
+		logger.Info("Creating the CA of the engine", "Secret.Name", secret.Name)
+		return ca, c.Create(ctx, secret)
+	}
+	logger.Info("Renewing the CA of the engine", "Secret.Name", secret.Name)
+	patch := client.MergeFrom(secret.DeepCopy())
+	secret.Data = data
+	return ca, c.Patch(ctx, secret, patch)
+}
+
+// The certificate is renewed in the last third of its validity
+func renewalDue(cert *x509.Certificate, now time.Time) bool {
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	return now.After(cert.NotAfter.Add(-validity / 3))
+}
+
+// The serving certificate in the Secret is signed by the CA for the DNS names, and it is not due to renew
+func servingCertValid(secret *corev1.Secret, ca *certAuthority, dnsNames []string, now time.Time) bool {
+	if !bytes.Equal(secret.Data["ca.crt"], ca.certPEM) || len(secret.Data[corev1.TLSPrivateKeyKey]) == 0 {
+		return false
+	}
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return false
+	}
+	return slices.Equal(cert.DNSNames, dnsNames) && cert.CheckSignatureFrom(ca.cert) == nil && !renewalDue(cert, now)
+}
+
+func parseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("no PEM encoded certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func parseCA(secret *corev1.Secret) (*certAuthority, error) {
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(secret.Data[corev1.TLSPrivateKeyKey])
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the private key can not sign")
+	}
+	return &certAuthority{cert: cert, key: signer, certPEM: secret.Data[corev1.TLSCertKey]}, nil
+}
+
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+func encodePrivateKey(key crypto.Signer) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Generates a self-signed CA, it returns the CA and its PEM encoded private key
+func newCA(name string, now time.Time) (*certAuthority, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name + "-ca", Organization: []string{"aitrigram"}},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return &certAuthority{cert: cert, key: key, certPEM: certPEM}, keyPEM, nil
+}
+
+// Signs a serving certificate of the DNS names, it returns the PEM encoded certificate and private key
+func (ca *certAuthority) sign(dnsNames []string, now time.Time) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(servingCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := encodePrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), keyPEM, nil
+}
+
+// Reconcile the serving certificate of the Service of the LLM model, it is deleted when the TLS is disabled
+func (r *LLMModelReconciler) reconcileLLMServingCert(ctx context.Context, req ctrl.Request, params ReconcileParams) error {
+	name := llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name)
+	if !tlsEnabled(params.llmEngine) {
+		return deleteServingCert(ctx, r.Client, params.model, llmTLSSecretName(name))
+	}
+	dnsNames := serviceDNSNames(req.Namespace, name, params.llmEngine.Spec.TLS)
+	return reconcileServingCert(ctx, r.Client, r.Scheme, params.llmEngine, params.model, llmTLSSecretName(name), dnsNames)
+}
@@ -0,0 +1,316 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+const (
+	endpointSliceManagedBy = "aitrigram.ihomeland.cn"
+)
+
+// Reconcile the EndpointSlice which points the Service of the model to the activator.
+// It exists only when the Service is in the activator mode.
+func (r *LLMModelReconciler) reconcileActivatorEndpoints(ctx context.Context, req ctrl.Request, params ReconcileParams) error {
+	logger := log.FromContext(ctx)
+	serviceName := llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name)
+	nameSpaceName := types.NamespacedName{Namespace: req.Namespace, Name: serviceName + "-activator"}
+
+	endpointSlice := &discoveryv1.EndpointSlice{}
+	err := r.Get(ctx, nameSpaceName, endpointSlice)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+
+	// the ports of the Service served by the activator
+	var ports []discoveryv1.EndpointPort
+	if plaintextEnabled(params.llmEngine) && r.ActivatorPort > 0 {
+		ports = append(ports, discoveryv1.EndpointPort{
+			Name:     ptr.To(servicePortName(params.llmEngine)),
+			Port:     ptr.To(r.ActivatorPort),
+			Protocol: ptr.To(corev1.ProtocolTCP),
+		})
+	}
+	if tlsEnabled(params.llmEngine) && r.ActivatorTLSPort > 0 {
+		ports = append(ports, discoveryv1.EndpointPort{
+			Name:     ptr.To("https"),
+			Port:     ptr.To(r.ActivatorTLSPort),
+			Protocol: ptr.To(corev1.ProtocolTCP),
+		})
+	}
+	if !params.activatorMode || r.ActivatorIP == "" || len(ports) == 0 {
+		if params.activatorMode {
+			logger.Info("There is no activator to hold the requests while the model is not available")
+		}
+		if exists {
+			logger.Info("Deleting the EndpointSlice of the activator", "EndpointSlice.Name", endpointSlice.Name)
+			return client.IgnoreNotFound(r.Delete(ctx, endpointSlice))
+		}
+		return nil
+	}
+
+	desired := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nameSpaceName.Name,
+			Namespace: nameSpaceName.Namespace,
+			Labels: MergeMaps(llmModelLabels(serviceName), map[string]string{
+				discoveryv1.LabelServiceName: serviceName,
+				discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+			}),
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{{
+			Addresses:  []string{r.ActivatorIP},
+			Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)},
+		}},
+		Ports: ports,
+	}
+	if strings.Contains(r.ActivatorIP, ":") {
+		desired.AddressType = discoveryv1.AddressTypeIPv6
+	}
+	if err := ctrl.SetControllerReference(params.model, desired, r.Scheme); err != nil {
+		return err
+	}
+	if !exists {
+		logger.Info("Pointing the Service to the activator", "Service.Name", serviceName)
+		return r.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepDerivative(desired.Endpoints, endpointSlice.Endpoints) &&
+		equality.Semantic.DeepDerivative(desired.Ports, endpointSlice.Ports) {
+		return nil
+	}
+	patch := client.MergeFrom(endpointSlice.DeepCopy())
+	endpointSlice.Endpoints = desired.Endpoints
+	endpointSlice.Ports = desired.Ports
+	return r.Patch(ctx, endpointSlice, patch)
+}
+
+// ModelWaker wakes up the LLMModel behind the Service addressed by the host of a request,
+// it is used by the activator to hold the requests to the models scaled to zero.
+type ModelWaker struct {
+	client.Client
+	// PollInterval is how often it checks if the model is ready, it is 1 second if not set.
+	PollInterval time.Duration
+	// Namespaces are the namespaces the operator watches, the requests to the Services in the other ones are refused.
+	// All the namespaces are watched if not set.
+	Namespaces []string
+}
+
+var _ proxy.Waker = &ModelWaker{}
+
+// Wake implements proxy.Waker, the host is the DNS name of the model Service, like: ollama-llama3.default.svc:8080,
+// or of the Service of a LLMModelAlias, like: chat.default.svc:8080. The Service is also found by its short name,
+// its ClusterIP or the host of its Ingress, see serviceKeyOf. The backend is the target port of the Service port of
+// the scheme, the https one is named https.
+func (w *ModelWaker) Wake(ctx context.Context, host string, scheme string) (*url.URL, error) {
+	logger := log.FromContext(ctx)
+	serviceKey, err := w.serviceKeyOf(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	service := &corev1.Service{}
+	if err := w.Get(ctx, serviceKey, service); err != nil {
+		return nil, err
+	}
+	owner := metav1.GetControllerOf(service)
+	if owner != nil && owner.Kind == "LLMModelAlias" {
+		// the alias mirrors the endpoints of the Service of the LLMModel, which points to the activator now
+		alias := &aitrigramv1.LLMModelAlias{}
+		if err := w.Get(ctx, types.NamespacedName{Namespace: serviceKey.Namespace, Name: owner.Name}, alias); err != nil {
+			return nil, err
+		}
+		serviceKey.Name = alias.Status.BackendService
+		if err := w.Get(ctx, serviceKey, service); err != nil {
+			return nil, err
+		}
+		owner = metav1.GetControllerOf(service)
+	}
+	if owner == nil || owner.Kind != "LLMModel" {
+		return nil, fmt.Errorf("service %s is not managed for a LLMModel", serviceKey)
+	}
+	portIndex := slices.IndexFunc(service.Spec.Ports, func(port corev1.ServicePort) bool {
+		return (port.Name == "https") == (scheme == "https")
+	})
+	if portIndex < 0 {
+		return nil, fmt.Errorf("service %s does not serve %s", serviceKey, scheme)
+	}
+	modelKey := types.NamespacedName{Namespace: serviceKey.Namespace, Name: owner.Name}
+	targetPort := service.Spec.Ports[portIndex].TargetPort.IntVal
+
+	interval := w.PollInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		llmModel := &aitrigramv1.LLMModel{}
+		if err := w.Get(ctx, modelKey, llmModel); err != nil {
+			return nil, err
+		}
+		if llmModel.Spec.ScaleToZero == nil {
+			// only the models scaled to zero are routed to the activator, it does not proxy to the other ones
+			return nil, fmt.Errorf("the LLMModel %s does not scale to zero", modelKey)
+		}
+		if err := w.requestWake(ctx, llmModel); err != nil {
+			logger.Error(err, "Failed to request to wake up the LLMModel", "LLMModel", modelKey)
+		}
+		podList := &corev1.PodList{}
+		if err := w.List(ctx, podList, client.InNamespace(serviceKey.Namespace), client.MatchingLabels(llmModelLabels(service.Name))); err != nil {
+			return nil, err
+		}
+		for i := range podList.Items {
+			pod := &podList.Items[i]
+			if pod.Status.PodIP != "" && isPodReady(pod) {
+				return &url.URL{Scheme: scheme, Host: fmt.Sprintf("%s:%d", pod.Status.PodIP, targetPort)}, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("timed out waiting for the LLMModel %s to wake up: %w", modelKey, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sets the WakeRequestedAnnotation if the LLMModel is still scaled to zero and has not been asked yet
+func (w *ModelWaker) requestWake(ctx context.Context, llmModel *aitrigramv1.LLMModel) error {
+	if llmModel.Status.Phase != aitrigramv1.LLMModelPhaseScaledToZero {
+		return nil
+	}
+	wakeRequested, _ := wakeRequestedAt(llmModel)
+	if llmModel.Status.LastRequestTime != nil && wakeRequested.After(llmModel.Status.LastRequestTime.Time) {
+		return nil
+	}
+	patch := client.MergeFrom(llmModel.DeepCopy())
+	if llmModel.Annotations == nil {
+		llmModel.Annotations = map[string]string{}
+	}
+	// make sure it is after the last request time which is kept in seconds
+	llmModel.Annotations[WakeRequestedAnnotation] = time.Now().Add(time.Second).UTC().Format(time.RFC3339)
+	return w.Patch(ctx, llmModel, patch)
+}
+
+// Returns the Service addressed by the host of a request. The DNS name of the Service with its namespace, like:
+// ollama-llama3.default.svc:8080, is looked up first. Otherwise the request came through one of the Services routed
+// to the activator by the operator, which is the one with the short name, the ClusterIP or the Ingress host of the
+// host, like: ollama-llama3:8080 from the same namespace, 10.96.0.12:8080 or llama3.example.com.
+func (w *ModelWaker) serviceKeyOf(ctx context.Context, host string) (types.NamespacedName, error) {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	serviceKey, err := serviceKeyFromHost(hostname)
+	if err == nil {
+		if w.watched(serviceKey.Namespace) {
+			err = w.Get(ctx, serviceKey, &corev1.Service{})
+			if !apierrors.IsNotFound(err) {
+				return serviceKey, err
+			}
+		} else {
+			err = fmt.Errorf("the namespace %s of the Service %s is not watched by the operator", serviceKey.Namespace, serviceKey.Name)
+		}
+	}
+	routed, lookupErr := w.routedServiceKeys(ctx, hostname)
+	if lookupErr != nil {
+		return types.NamespacedName{}, lookupErr
+	}
+	switch len(routed) {
+	case 0:
+		return types.NamespacedName{}, err
+	case 1:
+		return routed[0], nil
+	default:
+		return types.NamespacedName{}, fmt.Errorf("the host %q matches the Services %v, use the <service>.<namespace> form", host, routed)
+	}
+}
+
+// Returns the Services routed to the activator, or mirroring one of them for a LLMModelAlias, whose short name,
+// ClusterIP or Ingress host is the hostname. Only the watched namespaces are looked up.
+func (w *ModelWaker) routedServiceKeys(ctx context.Context, hostname string) ([]types.NamespacedName, error) {
+	namespaces := w.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	var endpointSlices []discoveryv1.EndpointSlice
+	var ingresses []networkingv1.Ingress
+	for _, namespace := range namespaces {
+		endpointSliceList := &discoveryv1.EndpointSliceList{}
+		if err := w.List(ctx, endpointSliceList, client.InNamespace(namespace),
+			client.MatchingLabels{discoveryv1.LabelManagedBy: endpointSliceManagedBy}); err != nil {
+			return nil, err
+		}
+		endpointSlices = append(endpointSlices, endpointSliceList.Items...)
+		ingressList := &networkingv1.IngressList{}
+		if err := w.List(ctx, ingressList, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		ingresses = append(ingresses, ingressList.Items...)
+	}
+	ingressBackends := map[types.NamespacedName]bool{}
+	for _, ingress := range ingresses {
+		for _, rule := range ingress.Spec.Rules {
+			if rule.Host != hostname || rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service != nil {
+					ingressBackends[types.NamespacedName{Namespace: ingress.Namespace, Name: path.Backend.Service.Name}] = true
+				}
+			}
+		}
+	}
+
+	var routed []types.NamespacedName
+	for _, endpointSlice := range endpointSlices {
+		serviceKey := types.NamespacedName{Namespace: endpointSlice.Namespace, Name: endpointSlice.Labels[discoveryv1.LabelServiceName]}
+		if serviceKey.Name == "" || !w.watched(serviceKey.Namespace) || slices.Contains(routed, serviceKey) {
+			continue
+		}
+		service := &corev1.Service{}
+		if err := w.Get(ctx, serviceKey, service); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if service.Name == hostname || service.Spec.ClusterIP == hostname || ingressBackends[serviceKey] {
+			routed = append(routed, serviceKey)
+		}
+	}
+	return routed, nil
+}
+
+func (w *ModelWaker) watched(namespace string) bool {
+	return len(w.Namespaces) == 0 || slices.Contains(w.Namespaces, namespace)
+}
+
+// Returns the Service named by the hostname with its namespace, like: ollama-llama3.default.svc
+func serviceKeyFromHost(hostname string) (types.NamespacedName, error) {
+	parts := strings.Split(hostname, ".")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, fmt.Errorf("cannot find the Service from host %q, use the <service>.<namespace> form", hostname)
+	}
+	return types.NamespacedName{Namespace: parts[1], Name: parts[0]}, nil
+}
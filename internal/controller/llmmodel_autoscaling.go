@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"context"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// Reconcile the HorizontalPodAutoscaler which scales the LLM model through its scale subresource, the replicas of
+// the model are applied to its Deployment. The HorizontalPodAutoscaler is deleted when the autoscaling is disabled.
+func (r *LLMModelReconciler) reconcileLLMAutoscaler(ctx context.Context, req ctrl.Request, params ReconcileParams) error {
+	logger := log.FromContext(ctx)
+
+	nameSpaceName := &types.NamespacedName{
+		Namespace: req.Namespace,
+		Name:      llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name),
+	}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	err := r.Get(ctx, *nameSpaceName, hpa)
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "Failed to get the HorizontalPodAutoscaler for LLMModel")
+		return err
+	}
+	exists := err == nil
+
+	if params.model.Spec.Autoscaling == nil {
+		if exists && metav1.IsControlledBy(hpa, params.model) {
+			logger.Info("Autoscaling is disabled, deleting the HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Name", hpa.Name)
+			return client.IgnoreNotFound(r.Delete(ctx, hpa))
+		}
+		return nil
+	}
+
+	desired, err := r.newLLMModelAutoscaler(nameSpaceName, params)
+	if err != nil {
+		logger.Error(err, "Failed to define new HorizontalPodAutoscaler resource for LLMModel")
+		return err
+	}
+	if !exists {
+		logger.Info("Creating a new HorizontalPodAutoscaler", "HorizontalPodAutoscaler.Namespace", desired.Namespace, "HorizontalPodAutoscaler.Name", desired.Name)
+		return r.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepDerivative(desired.Spec, hpa.Spec) {
+		logger.Info("HorizontalPodAutoscaler is already up-to-date")
+		return nil
+	}
+	patch := client.MergeFrom(hpa.DeepCopy())
+	hpa.Spec = desired.Spec
+	if err := r.Patch(ctx, hpa, patch); err != nil {
+		logger.Error(err, "Failed to update the HorizontalPodAutoscaler")
+		return err
+	}
+	return nil
+}
+
+func (r *LLMModelReconciler) newLLMModelAutoscaler(nameSpaceName *types.NamespacedName, params ReconcileParams) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	autoscaling := params.model.Spec.Autoscaling
+	metrics := []autoscalingv2.MetricSpec{}
+	if autoscaling.TargetCPUUtilizationPercentage != nil {
+		metrics = append(metrics, resourceUtilizationMetric(corev1.ResourceCPU, *autoscaling.TargetCPUUtilizationPercentage))
+	}
+	if autoscaling.TargetMemoryUtilizationPercentage != nil {
+		metrics = append(metrics, resourceUtilizationMetric(corev1.ResourceMemory, *autoscaling.TargetMemoryUtilizationPercentage))
+	}
+	metrics = append(metrics, autoscaling.Metrics...)
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nameSpaceName.Name,
+			Namespace: nameSpaceName.Namespace,
+			Labels:    llmModelLabels(nameSpaceName.Name),
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: aitrigramv1.GroupVersion.String(),
+				Kind:       "LLMModel",
+				Name:       params.model.Name,
+			},
+			MinReplicas: autoscaling.MinReplicas,
+			MaxReplicas: autoscaling.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+	// Set the ownerRef for the HorizontalPodAutoscaler
+	if err := ctrl.SetControllerReference(params.model, hpa, r.Scheme); err != nil {
+		return nil, err
+	}
+	return hpa, nil
+}
+
+func resourceUtilizationMetric(name corev1.ResourceName, percentage int32) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: name,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: &percentage,
+			},
+		},
+	}
+}
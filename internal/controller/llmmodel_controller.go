@@ -21,14 +21,23 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
 	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 )
 
@@ -36,14 +45,57 @@ import (
 type LLMModelReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Recorder records the Events of the LLMModels, no Event is recorded when it is nil
+	Recorder record.EventRecorder
+
+	// ProxyImage is the image of the proxy sidecar put in the model pods when needed
+	ProxyImage string
+	// OperatorNamespace is the namespace of the operator pods, the NetworkPolicies of the models only allow the
+	// operator pods in it
+	OperatorNamespace string
+	// ActivatorIP and ActivatorPort are where the activator listens for the requests to the models scaled to zero,
+	// the Service of a model scaled to zero points to it.
+	ActivatorIP   string
+	ActivatorPort int32
+	// ActivatorTLSPort is where the activator passes the https connections through to the models once they are back,
+	// the https port of the Service is not routed to the activator if it is 0.
+	ActivatorTLSPort int32
+	// BindableRoles are the roles the roleRefs of the ServiceAccounts can refer to, like ClusterRole/view or
+	// Role/hf-token-reader, the operator needs the bind permission on them. They are only bound when RoleRefsChecked,
+	// which tells the webhook checks that the users referring to them can bind them.
+	BindableRoles   []string
+	RoleRefsChecked bool
+
+	// activityFetcher replaces how the activity gets fetched from the proxy sidecar, it is used in tests
+	activityFetcher func(ctx context.Context, pod *corev1.Pod) (*proxy.Activity, error)
+	// rollouts remembers the rollouts of the model Deployments whose completion has been recorded
+	rollouts rolloutTracker
 }
 
 // +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodels,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodels/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodels/finalizers,verbs=update
 // +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmengines,verbs=get;list;watch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmadapters,verbs=get;list;watch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmquotas,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete;deletecollection
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=list;delete;deletecollection
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=gateway.networking.k8s.io,resources=httproutes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=monitoring.coreos.com,resources=podmonitors,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cert-manager.io,resources=certificates,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;update;patch;delete
 
 func (r *LLMModelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := logf.FromContext(ctx)
@@ -52,21 +104,45 @@ func (r *LLMModelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if !llmModel.GetDeletionTimestamp().IsZero() {
+		return r.finalizeLLMModel(ctx, req, llmModel)
+	}
+	if controllerutil.AddFinalizer(llmModel, LLMModelFinalizer) {
+		if err := r.Update(ctx, llmModel); err != nil {
+			logger.Error(err, "Failed to add the finalizer to the llmmodel")
+			return ctrl.Result{}, err
+		}
+	}
+
 	engineRef := llmModel.Spec.EngineRef
 	llmEngine := &aitrigramv1.LLMEngine{}
 	if err := r.Get(ctx, client.ObjectKey{Name: engineRef, Namespace: req.Namespace}, llmEngine); err != nil {
 		if apierrors.IsNotFound(err) {
-			if llmModel.GetDeletionTimestamp() != nil || llmEngine.GetDeletionTimestamp() != nil {
-				// llmModel has been deleted, just ignore
-				logger.Info("llmEngine has been deleted, llmModel will be deleted too, ignore it.")
-				return ctrl.Result{}, nil
-			}
 			logger.Error(err, "Failed to get LLMEngine",
 				"engineRef", engineRef, "namespace", req.Namespace)
+			recordEvent(r.Recorder, llmModel, corev1.EventTypeWarning, eventReasonEngineNotFound,
+				"The LLMEngine %s is not found", engineRef)
 			return ctrl.Result{RequeueAfter: time.Second * 5}, nil
 		}
 		return ctrl.Result{}, err
 	}
+	if !llmEngine.GetDeletionTimestamp().IsZero() {
+		// llmEngine is being deleted, the llmModel will be deleted by its finalizer
+		logger.Info("llmEngine is being deleted, llmModel will be deleted too, ignore it.")
+		return ctrl.Result{}, nil
+	}
+
+	// Set ownerReference to the engine, so that the llmModel gets garbage collected with the engine
+	if !metav1.IsControlledBy(llmModel, llmEngine) {
+		if err := ctrl.SetControllerReference(llmEngine, llmModel, r.Scheme); err != nil {
+			logger.Error(err, "Failed to set owner reference")
+			return ctrl.Result{}, err
+		}
+		if err := r.Update(ctx, llmModel); err != nil {
+			logger.Error(err, "Failed to update the owner reference of the llmmodel")
+			return ctrl.Result{}, err
+		}
+	}
 
 	// llmEngine has now the all values set because it is retrieved from the cluster
 	// the same for the llmEngine.Spec
@@ -85,6 +161,8 @@ func (r *LLMModelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			logger.Error(err, "Failed to update the llmengine")
 			return ctrl.Result{}, err
 		}
+		recordEvent(r.Recorder, llmModel, corev1.EventTypeNormal, eventReasonSpecDefaulted,
+			"The modelDeployment is defaulted from the LLMEngine %s", llmEngine.Name)
 		return ctrl.Result{}, nil
 	}
 
@@ -92,17 +170,101 @@ func (r *LLMModelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		llmEngine: llmEngine,
 		model:     llmModel,
 	}
-	if err := r.reconcileLLMDeployment(ctx, req, params); err != nil {
+	if isSharedServing(llmEngine) {
+		return r.reconcileSharedLLMModel(ctx, req, params)
+	}
+	if params.adapters, err = llmAdaptersOfModel(ctx, r.Client, llmModel); err != nil {
+		return ctrl.Result{}, err
+	}
+	if llmModel.Spec.Modelfile != nil && llmEngine.Spec.EngineType != aitrigramv1.LLMEngineTypeOllama {
+		logger.Info("The Modelfile is only supported by ollama, it is ignored", "engineType", llmEngine.Spec.EngineType)
+	}
+	if params.modelfile, err = resolveModelfile(ctx, r.Client, llmModel); err != nil {
+		logger.Error(err, "Failed to resolve the Modelfile of the LLMModel")
+		condition := metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ModelfileNotFound",
+			Message: err.Error(),
+		}
+		if err := r.updateLLMModelStatus(ctx, req, &condition); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: time.Second * 10}, nil
+	}
+	scaledToZero, requeueAfter, err := r.reconcileScaleToZero(ctx, req, params)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	params.scaledToZero = scaledToZero
+	params.podMonitor = podMonitorAvailable(r.Client)
+	if params.quotas, err = llmQuotasOfModel(ctx, r.Client, llmModel); err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(params.quotas) > 0 && !quotaEnforceable(llmModel) {
+		recordEvent(r.Recorder, llmModel, corev1.EventTypeWarning, eventReasonQuotaRejected,
+			"The LLMQuotas are not enforced, the LLMModel has more than one replica or autoscaling")
+		params.quotas = nil
+	}
+	if err := r.reconcileLLMServiceAccount(ctx, req, params); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileLLMServingCert(ctx, req, params); err != nil {
+		return ctrl.Result{}, err
+	}
+	if engineBoundToLocalhost(llmEngine) {
+		if _, err := reconcileAdminToken(ctx, r.Client, r.Scheme, llmEngine); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if tlsEnabled(llmEngine) && (requeueAfter == 0 || requeueAfter > certCheckInterval) {
+		// the certificate is checked again for the renewal
+		requeueAfter = certCheckInterval
+	}
+	deployment, err := r.reconcileLLMDeployment(ctx, req, params)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileLLMQuotaConfigMap(ctx, req, params); err != nil {
+		return ctrl.Result{}, err
+	}
+	downloading, err := r.reconcileLLMNetworkPolicy(ctx, req, params, deployment)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if downloading && (requeueAfter == 0 || requeueAfter > downloadCheckInterval) {
+		// the pods are not watched, they are checked again to remove the download label once the model is downloaded
+		requeueAfter = downloadCheckInterval
+	}
+	if err := r.reconcileLLMAutoscaler(ctx, req, params); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileLLMDisruptionBudget(ctx, req, params); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileLLMPodMonitor(ctx, req, params); err != nil {
 		return ctrl.Result{}, err
 	}
 	// reconcile service for this model
+	params.activatorMode = activatorMode(llmModel, deployment, scaledToZero)
 	if err := r.reconcileLLMService(ctx, req, params); err != nil {
 		return ctrl.Result{}, err
 	}
+	if err := r.reconcileActivatorEndpoints(ctx, req, params); err != nil {
+		return ctrl.Result{}, err
+	}
+	url, err := r.reconcileLLMExposure(ctx, req, params)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if params.activatorMode && !scaledToZero {
+		// check again soon to point the Service back to the pods once they are ready
+		requeueAfter = time.Second * 5
+	}
 
 	// Set Ready condition if successful
 	condition := metav1.Condition{
-		Type:    "Ready",
+		Type:    aitrigramv1.ConditionTypeReady,
 		Status:  metav1.ConditionTrue,
 		Reason:  "Deployed",
 		Message: "LLMModel successfully deployed",
@@ -110,20 +272,49 @@ func (r *LLMModelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	if err := r.updateLLMModelStatus(ctx, req, &condition); err != nil {
 		return ctrl.Result{}, err
 	}
-
-	// Set ownerReference to the engine
-	if err := ctrl.SetControllerReference(llmEngine, llmModel, r.Scheme); err != nil {
-		logger.Error(err, "Failed to set owner reference")
+	if err := r.updateLLMModelScaleStatus(ctx, req, deployment, llmEngine.Spec.EngineType); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.updateLLMModelRevisions(ctx, req, deployment.Spec.Selector.MatchLabels, "init-"+deployment.Name); err != nil {
 		return ctrl.Result{}, err
 	}
+	if err := r.recordLLMModelMetrics(ctx, req, llmModel, deployment, "init-"+deployment.Name); err != nil {
+		return ctrl.Result{}, err
+	}
+	if r.rollouts.completed(deployment) {
+		recordEvent(r.Recorder, llmModel, corev1.EventTypeNormal, eventReasonRolloutComplete,
+			"The Deployment %s has rolled out %d pods", deployment.Name, deployment.Status.UpdatedReplicas)
+	}
+	if err := r.updateLLMModelEndpoints(ctx, req, url, llmModelBackend(llmEngine, llmModel)); err != nil {
+		return ctrl.Result{}, err
+	}
+	if !scaledToZero {
+		if err := r.updateLLMModelPhase(ctx, req, aitrigramv1.LLMModelPhaseRunning); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
 type ReconcileParams struct {
 	llmEngine *aitrigramv1.LLMEngine
 	// the ModelDeployment in the model has taken the values in the llmEngine
 	model *aitrigramv1.LLMModel
+	// the model has been scaled to zero because of idle
+	scaledToZero bool
+	// the Service points to the activator because there is no ready pod
+	activatorMode bool
+	// the LLMAdapters served over the model
+	adapters []aitrigramv1.LLMAdapter
+	// LoRA has been enabled in the vllm Deployment of the model
+	loraEnabled bool
+	// the resolved Modelfile of the model, it is empty when there is no Modelfile
+	modelfile string
+	// the metrics are scraped by a PodMonitor, otherwise by the scrape annotations of the pods
+	podMonitor bool
+	// the LLMQuotas of the model, which are enforced by the proxy sidecar
+	quotas []aitrigramv1.LLMQuota
 }
 
 func (r *LLMModelReconciler) SetupWithManager(mgr ctrl.Manager) error {
@@ -131,6 +322,17 @@ func (r *LLMModelReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		For(&aitrigramv1.LLMModel{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
+		Owns(&autoscalingv2.HorizontalPodAutoscaler{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Owns(&discoveryv1.EndpointSlice{}).
+		Owns(&networkingv1.Ingress{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&corev1.ServiceAccount{}).
+		Owns(&rbacv1.RoleBinding{}).
+		Owns(&aitrigramv1.LLMAdapter{}).
+		Watches(&aitrigramv1.LLMEngine{}, handler.EnqueueRequestsFromMapFunc(r.llmModelsForEngine)).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.llmModelsOfModelfileConfigMap)).
+		Watches(&aitrigramv1.LLMQuota{}, handler.EnqueueRequestsFromMapFunc(llmModelOfQuota)).
 		Named("llmmodel").
-		Complete(r)
+		Complete(withReconcileErrorMetrics("llmmodel", r))
 }
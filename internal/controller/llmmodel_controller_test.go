@@ -17,11 +17,37 @@ limitations under the License.
 package controller
 
 import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"slices"
 	"testing"
+	"time"
 
 	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
 	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
 func Test_LLMModelDefault(t *testing.T) {
@@ -107,3 +133,1353 @@ func Test_LLMModelDefault(t *testing.T) {
 		})
 	}
 }
+
+func Test_LLMModelFinalizer(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		policy     aitrigramv1.StorageDeletionPolicy
+		pvcLabels  map[string]string
+		pvcOwned   bool
+		enginePVC  bool
+		pvcRemains bool
+	}{
+		"retain-storage": {
+			policy:     aitrigramv1.StorageDeletionPolicyRetain,
+			pvcLabels:  map[string]string{LLMModelNameLabel: "llama3"},
+			pvcRemains: true,
+		},
+		"delete-labeled-storage": {
+			policy:     aitrigramv1.StorageDeletionPolicyDelete,
+			pvcLabels:  map[string]string{LLMModelNameLabel: "llama3"},
+			pvcRemains: false,
+		},
+		"delete-owned-storage": {
+			policy:     aitrigramv1.StorageDeletionPolicyDelete,
+			pvcOwned:   true,
+			pvcRemains: false,
+		},
+		"keep-storage-of-others": {
+			policy:     aitrigramv1.StorageDeletionPolicyDelete,
+			pvcLabels:  map[string]string{LLMModelNameLabel: "gemma3"},
+			pvcRemains: true,
+		},
+		"keep-storage-of-engine-template": {
+			policy:     aitrigramv1.StorageDeletionPolicyDelete,
+			pvcLabels:  map[string]string{LLMModelNameLabel: "llama3"},
+			enginePVC:  true,
+			pvcRemains: true,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+
+			now := metav1.Now()
+			llmModel := &aitrigramv1.LLMModel{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "llama3",
+					Namespace:         "default",
+					UID:               "llama3-uid",
+					Finalizers:        []string{LLMModelFinalizer},
+					DeletionTimestamp: &now,
+				},
+				Spec: aitrigramv1.LLMModelSpec{
+					Name:                  "llama3",
+					EngineRef:             "ollama",
+					Replicas:              1,
+					StorageDeletionPolicy: c.policy,
+					ModelDeployment: &aitrigramv1.ModelDeploymentTemplate{
+						Storage: &aitrigramv1.LLMEngineStorage{
+							ModelsStorage: &aitrigramv1.ModelStorage{
+								Path: "/models",
+								VolumeSource: corev1.VolumeSource{
+									PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "models"},
+								},
+							},
+						},
+					},
+				},
+			}
+			ownedMeta := func(name string) metav1.ObjectMeta {
+				om := metav1.ObjectMeta{Name: name, Namespace: "default", Labels: llmModelLabels(name)}
+				require.NoError(t, controllerutil.SetControllerReference(llmModel, &om, scheme))
+				return om
+			}
+			service := &corev1.Service{ObjectMeta: ownedMeta("ollama-llama3")}
+			deployment := &appsv1.Deployment{ObjectMeta: ownedMeta("ollama-llama3")}
+			configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name: "llama3-extra", Namespace: "default", Labels: map[string]string{LLMModelNameLabel: "llama3"},
+			}}
+			pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "models", Namespace: "default", Labels: c.pvcLabels}}
+			if c.pvcOwned {
+				require.NoError(t, controllerutil.SetOwnerReference(llmModel, pvc, scheme))
+			}
+			llmEngine := newTestLLMEngine()
+			if c.enginePVC {
+				llmEngine.Spec.ModelDeploymentTemplate = &aitrigramv1.ModelDeploymentTemplate{
+					Storage: llmModel.Spec.ModelDeployment.Storage.DeepCopy(),
+				}
+			}
+
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithObjects(llmModel, llmEngine, service, deployment, configMap, pvc).
+				WithStatusSubresource(&aitrigramv1.LLMModel{}).
+				Build()
+			r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+			req := ctrl.Request{NamespacedName: types.NamespacedName{Name: "llama3", Namespace: "default"}}
+
+			reconcileTimes(t, r, req, 5)
+
+			require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, req.NamespacedName, &aitrigramv1.LLMModel{})))
+			require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(service), &corev1.Service{})))
+			require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(deployment), &appsv1.Deployment{})))
+			require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(configMap), &corev1.ConfigMap{})))
+			err := k8sClient.Get(ctx, client.ObjectKeyFromObject(pvc), &corev1.PersistentVolumeClaim{})
+			if c.pvcRemains {
+				require.NoError(t, err)
+			} else {
+				require.True(t, apierrors.IsNotFound(err))
+			}
+		})
+	}
+}
+
+func Test_LLMModelAutoscaling(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	minReplicas := int32(2)
+	targetCPU := int32(80)
+	llmEngine := newTestLLMEngine()
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:         "llama3",
+			NameInEngine: "llama3.2:latest",
+			EngineRef:    llmEngine.Name,
+			Replicas:     1,
+			Autoscaling: &aitrigramv1.AutoscalingSpec{
+				MinReplicas:                    &minReplicas,
+				MaxReplicas:                    4,
+				TargetCPUUtilizationPercentage: &targetCPU,
+			},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+	require.NoError(t, k8sClient.Get(ctx, key, hpa))
+	// the HorizontalPodAutoscaler scales the LLMModel through its scale subresource
+	require.Equal(t, autoscalingv2.CrossVersionObjectReference{
+		APIVersion: "aitrigram.ihomeland.cn/v1",
+		Kind:       "LLMModel",
+		Name:       "llama3",
+	}, hpa.Spec.ScaleTargetRef)
+	require.Equal(t, int32(4), hpa.Spec.MaxReplicas)
+	require.Len(t, hpa.Spec.Metrics, 1)
+
+	// the replicas are kept within the limits until the HorizontalPodAutoscaler sets them
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, minReplicas, *deployment.Spec.Replicas)
+
+	// the replicas set by the HorizontalPodAutoscaler are applied to the Deployment
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, "app=aitrigram-llmmodel,instance=ollama-llama3", llmModel.Status.Selector)
+	llmModel.Spec.Replicas = 3
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, int32(3), *deployment.Spec.Replicas)
+
+	// disabling the autoscaling removes the HorizontalPodAutoscaler and keeps the replicas of the model
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.Autoscaling = nil
+	llmModel.Spec.Replicas = 1
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, hpa)))
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, int32(1), *deployment.Spec.Replicas)
+}
+
+func Test_LLMModelDisruptionBudget(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:             "llama3",
+			EngineRef:        llmEngine.Name,
+			Replicas:         3,
+			DisruptionBudget: &aitrigramv1.DisruptionBudgetSpec{MinAvailable: ptr.To(intstr.FromInt32(2))},
+			Strategy:         &aitrigramv1.ModelStrategySpec{Type: appsv1.RollingUpdateDeploymentStrategyType, MaxSurge: ptr.To(intstr.FromString("50%"))},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	pdb := &policyv1.PodDisruptionBudget{}
+	require.NoError(t, k8sClient.Get(ctx, key, pdb))
+	require.Equal(t, llmModelLabels("ollama-llama3"), pdb.Spec.Selector.MatchLabels)
+	require.Equal(t, intstr.FromInt32(2), *pdb.Spec.MinAvailable)
+	require.Nil(t, pdb.Spec.MaxUnavailable)
+
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, appsv1.RollingUpdateDeploymentStrategyType, deployment.Spec.Strategy.Type)
+	require.Equal(t, intstr.FromString("50%"), *deployment.Spec.Strategy.RollingUpdate.MaxSurge)
+	require.Equal(t, intstr.FromInt32(0), *deployment.Spec.Strategy.RollingUpdate.MaxUnavailable)
+
+	// switching to maxUnavailable and Recreate replaces the previous values
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.DisruptionBudget = &aitrigramv1.DisruptionBudgetSpec{MaxUnavailable: ptr.To(intstr.FromString("25%"))}
+	llmModel.Spec.Strategy = &aitrigramv1.ModelStrategySpec{Type: appsv1.RecreateDeploymentStrategyType}
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, pdb))
+	require.Nil(t, pdb.Spec.MinAvailable)
+	require.Equal(t, intstr.FromString("25%"), *pdb.Spec.MaxUnavailable)
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, appsv1.RecreateDeploymentStrategyType, deployment.Spec.Strategy.Type)
+	require.Nil(t, deployment.Spec.Strategy.RollingUpdate)
+
+	// removing the DisruptionBudget removes the PodDisruptionBudget
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.DisruptionBudget = nil
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, pdb)))
+}
+
+func Test_LLMModelMonitoring(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		podMonitorInstalled bool
+	}{
+		"pod-monitor":        {podMonitorInstalled: true},
+		"scrape-annotations": {podMonitorInstalled: false},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			llmEngine := newTestVLLMEngine()
+			llmEngine.Spec.Monitoring = &aitrigramv1.MonitoringSpec{
+				Enabled:  true,
+				Interval: "30s",
+				Labels:   map[string]string{"release": "prometheus"},
+			}
+			llmModel := &aitrigramv1.LLMModel{
+				ObjectMeta: metav1.ObjectMeta{Name: "qwen", Namespace: "default"},
+				Spec:       aitrigramv1.LLMModelSpec{Name: "qwen", NameInEngine: "Qwen/Qwen2.5-1.5B-Instruct", EngineRef: llmEngine.Name, Replicas: 1},
+			}
+			restMapper := meta.NewDefaultRESTMapper(nil)
+			if c.podMonitorInstalled {
+				restMapper.Add(podMonitorGVK, meta.RESTScopeNamespace)
+			}
+			k8sClient := fake.NewClientBuilder().
+				WithScheme(scheme).
+				WithRESTMapper(restMapper).
+				WithObjects(llmEngine, llmModel).
+				WithStatusSubresource(&aitrigramv1.LLMModel{}).
+				Build()
+			r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+			reconcileTimes(t, r, req, 3)
+
+			key := types.NamespacedName{Name: "vllm-qwen", Namespace: "default"}
+			deployment := &appsv1.Deployment{}
+			require.NoError(t, k8sClient.Get(ctx, key, deployment))
+			podMonitor := &unstructured.Unstructured{}
+			podMonitor.SetGroupVersionKind(podMonitorGVK)
+			if c.podMonitorInstalled {
+				require.NoError(t, k8sClient.Get(ctx, key, podMonitor))
+				require.Equal(t, "prometheus", podMonitor.GetLabels()["release"])
+				require.True(t, metav1.IsControlledBy(podMonitor, llmModel))
+				endpoints, _, _ := unstructured.NestedSlice(podMonitor.Object, "spec", "podMetricsEndpoints")
+				require.Equal(t, []interface{}{map[string]interface{}{"port": "http", "path": "/metrics", "interval": "30s"}}, endpoints)
+				matchLabels, _, _ := unstructured.NestedStringMap(podMonitor.Object, "spec", "selector", "matchLabels")
+				require.Equal(t, deployment.Spec.Selector.MatchLabels, matchLabels)
+				require.Empty(t, deployment.Spec.Template.Annotations)
+			} else {
+				require.Equal(t, map[string]string{
+					prometheusScrapeAnnotation: "true",
+					prometheusPortAnnotation:   "8000",
+					prometheusPathAnnotation:   "/metrics",
+				}, deployment.Spec.Template.Annotations)
+			}
+
+			// disabling the monitoring removes the PodMonitor and the scrape annotations
+			require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+			llmEngine.Spec.Monitoring.Enabled = false
+			require.NoError(t, k8sClient.Update(ctx, llmEngine))
+			reconcileTimes(t, r, req, 1)
+			require.NoError(t, k8sClient.Get(ctx, key, deployment))
+			require.Empty(t, deployment.Spec.Template.Annotations)
+			if c.podMonitorInstalled {
+				require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, podMonitor)))
+			}
+		})
+	}
+}
+
+func Test_LLMModelAuth(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.Auth = &aitrigramv1.AuthSpec{APIKeys: []aitrigramv1.APIKeySpec{
+		{SecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "team-a"}, Key: "key"}},
+		{SecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "team-b"}, Key: "key"}, Models: []string{"llama3"}},
+	}}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:latest"}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	containers := deployment.Spec.Template.Spec.Containers
+	require.Len(t, containers, 2)
+	sidecar := containers[1]
+	require.Equal(t, proxySidecarName, sidecar.Name)
+	require.Contains(t, sidecar.Args, `--auth-config={"apiKeys":[{"file":"/etc/aitrigram/auth/0"},{"file":"/etc/aitrigram/auth/1","models":["llama3"]}]}`)
+	require.Contains(t, sidecar.Args, "--model=llama3")
+	require.Equal(t, []corev1.VolumeMount{
+		{Name: apiKeysVolumeName, MountPath: apiKeysMountPath, ReadOnly: true},
+		{Name: adminTokenVolumeName, MountPath: adminTokenMountPath, ReadOnly: true},
+	}, sidecar.VolumeMounts)
+	volumes := deployment.Spec.Template.Spec.Volumes
+	volume := volumes[len(volumes)-2]
+	require.Equal(t, apiKeysVolumeName, volume.Name)
+	require.Len(t, volume.Projected.Sources, 2)
+	require.Equal(t, "team-b", volume.Projected.Sources[1].Secret.Name)
+	require.Equal(t, []corev1.KeyToPath{{Key: "key", Path: "1"}}, volume.Projected.Sources[1].Secret.Items)
+
+	// the engine only listens on the loopback address, the kubelet probes it through the admin port of the sidecar
+	engine := containers[0]
+	require.Contains(t, engine.Env, corev1.EnvVar{Name: ollamaHostEnv, Value: "127.0.0.1:11434"})
+	require.NotContains(t, deployment.Spec.Template.Spec.InitContainers[0].Env, corev1.EnvVar{Name: ollamaHostEnv, Value: "127.0.0.1:11434"})
+	require.Equal(t, intstr.FromInt32(proxySidecarAdminPort), engine.ReadinessProbe.HTTPGet.Port)
+	require.Contains(t, sidecar.Args, "--admin-listen=:15090")
+	require.Contains(t, sidecar.Args, "--admin-paths=/")
+	require.Contains(t, sidecar.Args, "--admin-token-file=/etc/aitrigram/admin/token")
+	require.Equal(t, llmAdminTokenSecretName(llmEngine), volumes[len(volumes)-1].Secret.SecretName)
+	adminToken := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-proxy-admin", Namespace: "default"}, adminToken))
+	require.Len(t, adminToken.Data[adminTokenKey], 64)
+	require.True(t, metav1.IsControlledBy(adminToken, llmEngine))
+
+	// the Service sends the requests to the sidecar instead of the engine container
+	service := &corev1.Service{}
+	require.NoError(t, k8sClient.Get(ctx, key, service))
+	require.Equal(t, intstr.FromInt32(proxySidecarPort), service.Spec.Ports[0].TargetPort)
+
+	// removing the auth removes the sidecar, and the Service points to the engine container again
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+	llmEngine.Spec.Auth = nil
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Len(t, deployment.Spec.Template.Spec.Containers, 1)
+	require.NotContains(t, deployment.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: ollamaHostEnv, Value: "127.0.0.1:11434"})
+	require.Equal(t, intstr.FromString("http"), deployment.Spec.Template.Spec.Containers[0].ReadinessProbe.HTTPGet.Port)
+	require.NoError(t, k8sClient.Get(ctx, key, service))
+	require.Equal(t, intstr.FromInt32(llmEngine.Spec.Port), service.Spec.Ports[0].TargetPort)
+}
+
+func Test_LLMModelNetworkPolicy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.Gateway = &aitrigramv1.GatewaySpec{Enabled: true}
+	llmEngine.Spec.NetworkPolicy = &aitrigramv1.NetworkPolicySpec{Enabled: true, Namespaces: []string{"apps"}}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &appsv1.Deployment{}, &corev1.Pod{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:latest", OperatorNamespace: "aitrigram-system"}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	networkPolicy := &networkingv1.NetworkPolicy{}
+	require.NoError(t, k8sClient.Get(ctx, key, networkPolicy))
+	require.True(t, metav1.IsControlledBy(networkPolicy, llmModel))
+	require.Equal(t, llmModelLabels("ollama-llama3"), networkPolicy.Spec.PodSelector.MatchLabels)
+	ingress := networkPolicy.Spec.Ingress
+	require.Len(t, ingress, 2)
+	require.Equal(t, []networkingv1.NetworkPolicyPort{networkPolicyPort(corev1.ProtocolTCP, 11434)}, ingress[0].Ports)
+	require.Equal(t, []networkingv1.NetworkPolicyPeer{
+		{NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: corev1.LabelMetadataName, Operator: metav1.LabelSelectorOpIn, Values: []string{"apps"}},
+		}}},
+		{PodSelector: &metav1.LabelSelector{MatchLabels: llmGatewayLabels("ollama-gateway")}},
+	}, ingress[0].From)
+	// only the operator pods in the namespace of the operator
+	require.Equal(t, []networkingv1.NetworkPolicyPeer{{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: "aitrigram-system"}},
+		PodSelector:       &metav1.LabelSelector{MatchLabels: operatorPodLabels},
+	}}, ingress[1].From)
+	require.Equal(t, []networkingv1.NetworkPolicyPort{networkPolicyPort(corev1.ProtocolTCP, 11434)}, ingress[1].Ports)
+	// the serving pods only reach the DNS
+	require.Equal(t, []networkingv1.NetworkPolicyEgressRule{{Ports: []networkingv1.NetworkPolicyPort{
+		networkPolicyPort(corev1.ProtocolUDP, 53),
+		networkPolicyPort(corev1.ProtocolTCP, 53),
+	}}}, networkPolicy.Spec.Egress)
+
+	// the pods downloading the model can reach any host on 80 and 443
+	downloadPolicy := &networkingv1.NetworkPolicy{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-llama3-download", Namespace: "default"}, downloadPolicy))
+	require.True(t, metav1.IsControlledBy(downloadPolicy, llmModel))
+	require.Equal(t, map[string]string{ModelDownloadLabel: "ollama-llama3"}, downloadPolicy.Spec.PodSelector.MatchLabels)
+	require.Empty(t, downloadPolicy.Spec.Ingress)
+	require.Len(t, downloadPolicy.Spec.Egress, 2)
+	require.Equal(t, []networkingv1.NetworkPolicyPort{
+		networkPolicyPort(corev1.ProtocolTCP, 80),
+		networkPolicyPort(corev1.ProtocolTCP, 443),
+	}, downloadPolicy.Spec.Egress[1].Ports)
+
+	// the new pods are labeled as downloading, and their engine container waits for the label to be removed
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	template := deployment.Spec.Template
+	require.Equal(t, "ollama-llama3", template.Labels[ModelDownloadLabel])
+	require.NotContains(t, deployment.Spec.Selector.MatchLabels, ModelDownloadLabel)
+	require.Len(t, template.Spec.InitContainers, 2)
+	gate := template.Spec.InitContainers[1]
+	require.Equal(t, downloadGateContainerName, gate.Name)
+	require.Equal(t, []string{"while grep -q '^aitrigram.ihomeland.cn/download=' /etc/podinfo/labels; do sleep 2; done"}, gate.Args)
+	require.Contains(t, gate.VolumeMounts, corev1.VolumeMount{Name: podInfoVolumeName, MountPath: podInfoMountPath, ReadOnly: true})
+
+	// the label is kept while the init container downloads the model, and removed once it is done
+	pod := newReadyPod("ollama-llama3-0", template.Labels)
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:  "init-ollama-llama3",
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	}}
+	status := pod.Status
+	require.NoError(t, k8sClient.Create(ctx, pod))
+	pod.Status = status
+	require.NoError(t, k8sClient.Status().Update(ctx, pod))
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, downloadCheckInterval, result.RequeueAfter)
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), pod))
+	require.Equal(t, "ollama-llama3", pod.Labels[ModelDownloadLabel])
+
+	pod.Status.InitContainerStatuses[0].State = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}
+	require.NoError(t, k8sClient.Status().Update(ctx, pod))
+	result, err = r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Zero(t, result.RequeueAfter)
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), pod))
+	require.NotContains(t, pod.Labels, ModelDownloadLabel)
+	require.Equal(t, llmModelLabels("ollama-llama3"), pod.Labels)
+
+	// it is put back when the pod is restarted and downloads the model again
+	pod.Status.InitContainerStatuses[0].State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	require.NoError(t, k8sClient.Status().Update(ctx, pod))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(pod), pod))
+	require.Equal(t, "ollama-llama3", pod.Labels[ModelDownloadLabel])
+
+	// the operator reads the activity from the admin port of the proxy sidecar
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.ScaleToZero = &aitrigramv1.ScaleToZeroSpec{IdleTimeout: metav1.Duration{Duration: time.Hour}}
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, networkPolicy))
+	require.Equal(t, []networkingv1.NetworkPolicyPort{networkPolicyPort(corev1.ProtocolTCP, proxySidecarPort)}, networkPolicy.Spec.Ingress[0].Ports)
+	require.Equal(t, []networkingv1.NetworkPolicyPort{
+		networkPolicyPort(corev1.ProtocolTCP, 11434),
+		networkPolicyPort(corev1.ProtocolTCP, proxySidecarAdminPort),
+	}, networkPolicy.Spec.Ingress[1].Ports)
+
+	// the metrics on the port of the engine are not opened to anyone else, even to the peers of the metrics
+	prometheus := []networkingv1.NetworkPolicyPeer{{
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: "monitoring"}},
+	}}
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+	llmEngine.Spec.Monitoring = &aitrigramv1.MonitoringSpec{Enabled: true, Path: "/metrics"}
+	llmEngine.Spec.NetworkPolicy.MetricsFrom = prometheus
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, networkPolicy))
+	require.Len(t, networkPolicy.Spec.Ingress, 2)
+	// a dedicated metrics port is only opened to them
+	llmEngine.Spec.Monitoring.Port = 9400
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, networkPolicy))
+	require.Len(t, networkPolicy.Spec.Ingress, 3)
+	require.Equal(t, networkingv1.NetworkPolicyIngressRule{
+		Ports: []networkingv1.NetworkPolicyPort{networkPolicyPort(corev1.ProtocolTCP, 9400)},
+		From:  prometheus,
+	}, networkPolicy.Spec.Ingress[2])
+
+	// the model disables the NetworkPolicy of the engine
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.NetworkPolicy = &aitrigramv1.NetworkPolicySpec{Enabled: false}
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, networkPolicy)))
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(downloadPolicy), downloadPolicy)))
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.NotContains(t, deployment.Spec.Template.Labels, ModelDownloadLabel)
+	require.Len(t, deployment.Spec.Template.Spec.InitContainers, 1)
+}
+
+func Test_LLMModelSecurityContext(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.Auth = &aitrigramv1.AuthSpec{APIKeys: []aitrigramv1.APIKeySpec{
+		{SecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "team-a"}, Key: "key"}},
+	}}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:latest"}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	// the pods follow the restricted profile of the engine, with /tmp writable
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	podSpec := deployment.Spec.Template.Spec
+	require.Equal(t, restrictedPodSecurityContext(), podSpec.SecurityContext)
+	tmpMount := corev1.VolumeMount{Name: tmpVolumeName, MountPath: tmpMountPath}
+	for _, container := range append(podSpec.InitContainers, podSpec.Containers...) {
+		require.Equal(t, restrictedSecurityContext(), container.SecurityContext, container.Name)
+		if container.Name != proxySidecarName {
+			require.Contains(t, container.VolumeMounts, tmpMount, container.Name)
+		}
+	}
+	require.True(t, slices.ContainsFunc(podSpec.Volumes, func(volume corev1.Volume) bool { return volume.Name == tmpVolumeName }))
+
+	// the model replaces the securityContext of the containers, the root filesystem is writable again
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	securityContext := restrictedSecurityContext()
+	securityContext.ReadOnlyRootFilesystem = ptr.To(false)
+	llmModel.Spec.ModelDeployment = &aitrigramv1.ModelDeploymentTemplate{SecurityContext: securityContext}
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 2)
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	podSpec = deployment.Spec.Template.Spec
+	require.Equal(t, restrictedPodSecurityContext(), podSpec.SecurityContext)
+	require.Equal(t, securityContext, podSpec.Containers[0].SecurityContext)
+	require.Equal(t, restrictedSecurityContext(), podSpec.Containers[1].SecurityContext)
+	require.NotContains(t, podSpec.Containers[0].VolumeMounts, tmpMount)
+	for _, volume := range podSpec.Volumes {
+		require.NotEqual(t, tmpVolumeName, volume.Name)
+	}
+}
+
+func Test_LLMModelServiceAccount(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.ServiceAccount = &aitrigramv1.ServiceAccountSpec{
+		Annotations: map[string]string{"eks.amazonaws.com/role-arn": "arn:aws:iam::111122223333:role/models"},
+		RoleRef:     &aitrigramv1.RoleReference{Name: "hf-token-reader"},
+	}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:latest",
+		BindableRoles: []string{"Role/hf-token-reader", "ClusterRole/view"}, RoleRefsChecked: true}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	// the pods run as the ServiceAccount of the model without its token
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	serviceAccount := &corev1.ServiceAccount{}
+	require.NoError(t, k8sClient.Get(ctx, key, serviceAccount))
+	require.True(t, metav1.IsControlledBy(serviceAccount, llmModel))
+	require.Equal(t, "arn:aws:iam::111122223333:role/models", serviceAccount.Annotations["eks.amazonaws.com/role-arn"])
+	require.Equal(t, ptr.To(false), serviceAccount.AutomountServiceAccountToken)
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, "ollama-llama3", deployment.Spec.Template.Spec.ServiceAccountName)
+	require.Equal(t, ptr.To(false), deployment.Spec.Template.Spec.AutomountServiceAccountToken)
+	roleBinding := &rbacv1.RoleBinding{}
+	require.NoError(t, k8sClient.Get(ctx, key, roleBinding))
+	require.True(t, metav1.IsControlledBy(roleBinding, llmModel))
+	require.Equal(t, rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "hf-token-reader"}, roleBinding.RoleRef)
+	require.Equal(t, []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: "ollama-llama3", Namespace: "default"}}, roleBinding.Subjects)
+
+	// the roleRef of the RoleBinding can not be changed, so it is created again for another role
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+	llmEngine.Spec.ServiceAccount.RoleRef = &aitrigramv1.RoleReference{Kind: "ClusterRole", Name: "view"}
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, roleBinding))
+	require.Equal(t, rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"}, roleBinding.RoleRef)
+
+	// a role which is not bindable is never bound
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+	llmEngine.Spec.ServiceAccount.RoleRef = &aitrigramv1.RoleReference{Kind: "ClusterRole", Name: "cluster-admin"}
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, &rbacv1.RoleBinding{})))
+
+	// neither is a bindable one without the webhook checking the users
+	llmEngine.Spec.ServiceAccount.RoleRef = &aitrigramv1.RoleReference{Kind: "ClusterRole", Name: "view"}
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	r.RoleRefsChecked = false
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, &rbacv1.RoleBinding{})))
+	r.RoleRefsChecked = true
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, roleBinding))
+
+	// the model runs as an existing ServiceAccount with its token and no role
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.ServiceAccount = &aitrigramv1.ServiceAccountSpec{Name: "downloader", AutomountServiceAccountToken: ptr.To(true)}
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, &corev1.ServiceAccount{})))
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, &rbacv1.RoleBinding{})))
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, "downloader", deployment.Spec.Template.Spec.ServiceAccountName)
+	require.Equal(t, ptr.To(true), deployment.Spec.Template.Spec.AutomountServiceAccountToken)
+}
+
+func Test_LLMModelTLS(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.TLS = &aitrigramv1.TLSSpec{Enabled: true, DNSNames: []string{"llama3.example.com"}}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:latest"}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, certCheckInterval, result.RequeueAfter)
+
+	// the serving certificate is signed by the CA of the engine for the DNS names of the Service
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	caSecret := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-ca", Namespace: "default"}, caSecret))
+	require.True(t, metav1.IsControlledBy(caSecret, llmEngine))
+	ca, err := parseCA(caSecret)
+	require.NoError(t, err)
+	secret := &corev1.Secret{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-llama3-tls", Namespace: "default"}, secret))
+	require.True(t, metav1.IsControlledBy(secret, llmModel))
+	require.Equal(t, corev1.SecretTypeTLS, secret.Type)
+	require.Equal(t, caSecret.Data["ca.crt"], secret.Data["ca.crt"])
+	cert, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	for _, dnsName := range []string{"ollama-llama3.default.svc", "llama3.example.com"} {
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: dnsName, Roots: roots})
+		require.NoError(t, err)
+	}
+
+	// the proxy sidecar terminates the TLS on the https port of the Service, which is the only one served
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	sidecar := deployment.Spec.Template.Spec.Containers[1]
+	require.Equal(t, proxySidecarName, sidecar.Name)
+	require.Contains(t, sidecar.Args, "--tls-cert-file=/etc/aitrigram/tls/tls.crt")
+	require.Contains(t, sidecar.Args, "--listen=")
+	require.Equal(t, []corev1.ContainerPort{
+		{Name: proxySidecarAdminPortName, ContainerPort: proxySidecarAdminPort},
+		{Name: "https", ContainerPort: proxySidecarTLSPort},
+	}, sidecar.Ports)
+	require.Contains(t, sidecar.VolumeMounts, corev1.VolumeMount{Name: tlsVolumeName, MountPath: tlsMountPath, ReadOnly: true})
+	service := &corev1.Service{}
+	require.NoError(t, k8sClient.Get(ctx, key, service))
+	require.Equal(t, []corev1.ServicePort{
+		{Name: "https", Port: 443, TargetPort: intstr.FromInt32(proxySidecarTLSPort)},
+	}, service.Spec.Ports)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, "https://ollama-llama3.default.svc:443", llmModel.Status.Backend)
+
+	// the http port is kept next to the https one with the plaintext
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+	llmEngine.Spec.TLS.Plaintext = true
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, service))
+	require.Equal(t, []corev1.ServicePort{
+		{Name: "http", Port: 8080, TargetPort: intstr.FromInt32(proxySidecarPort)},
+		{Name: "https", Port: 443, TargetPort: intstr.FromInt32(proxySidecarTLSPort)},
+	}, service.Spec.Ports)
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Contains(t, deployment.Spec.Template.Spec.Containers[1].Args, "--listen=:15080")
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, "http://ollama-llama3.default.svc:8080", llmModel.Status.Backend)
+
+	// the certificate in the last third of its validity is renewed
+	certPEM, keyPEM, err := ca.sign(cert.DNSNames, time.Now().Add(-80*24*time.Hour))
+	require.NoError(t, err)
+	secret.Data[corev1.TLSCertKey] = certPEM
+	secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+	require.NoError(t, k8sClient.Update(ctx, secret))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(secret), secret))
+	renewed, err := parseCertificate(secret.Data[corev1.TLSCertKey])
+	require.NoError(t, err)
+	require.False(t, renewalDue(renewed, time.Now()))
+
+	// disabling the TLS removes the certificate and the https port
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+	llmEngine.Spec.TLS = nil
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(secret), secret)))
+	require.NoError(t, k8sClient.Get(ctx, key, service))
+	require.Equal(t, []corev1.ServicePort{{Port: 8080, TargetPort: intstr.FromInt32(llmEngine.Spec.Port)}}, service.Spec.Ports)
+}
+
+func Test_LLMModelTLSCertManager(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.TLS = &aitrigramv1.TLSSpec{Enabled: true, IssuerRef: &aitrigramv1.IssuerReference{Name: "cluster-ca", Kind: "ClusterIssuer"}}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	restMapper := meta.NewDefaultRESTMapper(nil)
+	restMapper.Add(certificateGVK, meta.RESTScopeNamespace)
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithRESTMapper(restMapper).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:latest"}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	// the cert-manager issues the certificate into the Secret mounted by the proxy sidecar, there is no CA of the operator
+	certificate := newCertificate("default", "ollama-llama3-tls")
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(certificate), certificate))
+	require.True(t, metav1.IsControlledBy(certificate, llmModel))
+	secretName, _, _ := unstructured.NestedString(certificate.Object, "spec", "secretName")
+	require.Equal(t, "ollama-llama3-tls", secretName)
+	issuer, _, _ := unstructured.NestedStringMap(certificate.Object, "spec", "issuerRef")
+	require.Equal(t, map[string]string{"name": "cluster-ca", "kind": "ClusterIssuer", "group": "cert-manager.io"}, issuer)
+	dnsNames, _, _ := unstructured.NestedStringSlice(certificate.Object, "spec", "dnsNames")
+	require.Contains(t, dnsNames, "ollama-llama3.default.svc.cluster.local")
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-ca", Namespace: "default"}, &corev1.Secret{})))
+}
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, aitrigramv1.AddToScheme(scheme))
+	return scheme
+}
+
+// Returns an ollama LLMEngine which has the default values set, like it is retrieved from the cluster once created
+func newTestLLMEngine() *aitrigramv1.LLMEngine {
+	llmEngine := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama", Namespace: "default"},
+		Spec:       aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeOllama},
+	}
+	llmEngine.Spec = *DefaultsOfLLMEngine(llmEngine)
+	return llmEngine
+}
+
+func reconcileTimes(t *testing.T, r reconcile.Reconciler, req ctrl.Request, times int) {
+	for i := 0; i < times; i++ {
+		_, err := r.Reconcile(context.Background(), req)
+		require.NoError(t, err)
+	}
+}
+
+func Test_LLMModelScaleToZero(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	lastRequest := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:         "llama3",
+			NameInEngine: "llama3.2:latest",
+			EngineRef:    llmEngine.Name,
+			Replicas:     1,
+			ScaleToZero:  &aitrigramv1.ScaleToZeroSpec{IdleTimeout: metav1.Duration{Duration: time.Minute}},
+		},
+		Status: aitrigramv1.LLMModelStatus{
+			Phase:           aitrigramv1.LLMModelPhaseRunning,
+			LastRequestTime: &lastRequest,
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &appsv1.Deployment{}).
+		Build()
+	r := &LLMModelReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		ProxyImage:    "ghcr.io/gaol/aitrigram-controller:latest",
+		ActivatorIP:   "10.0.0.5",
+		ActivatorPort: 8090,
+		activityFetcher: func(ctx context.Context, pod *corev1.Pod) (*proxy.Activity, error) {
+			return &proxy.Activity{LastRequestTime: lastRequest.Time}, nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 2)
+
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	activatorKey := types.NamespacedName{Name: "ollama-llama3-activator", Namespace: "default"}
+	deployment := &appsv1.Deployment{}
+	service := &corev1.Service{}
+	endpointSlice := &discoveryv1.EndpointSlice{}
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, aitrigramv1.LLMModelPhaseScaledToZero, llmModel.Status.Phase)
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, int32(0), *deployment.Spec.Replicas)
+	require.Len(t, deployment.Spec.Template.Spec.Containers, 2, "the proxy sidecar is added")
+	require.NoError(t, k8sClient.Get(ctx, key, service))
+	require.Nil(t, service.Spec.Selector)
+	require.Equal(t, proxySidecarPort, service.Spec.Ports[0].TargetPort.IntVal)
+	require.NoError(t, k8sClient.Get(ctx, activatorKey, endpointSlice))
+	require.Equal(t, []string{"10.0.0.5"}, endpointSlice.Endpoints[0].Addresses)
+	require.Equal(t, int32(8090), *endpointSlice.Ports[0].Port)
+
+	// the activator asks to wake it up
+	llmModel.Annotations = map[string]string{WakeRequestedAnnotation: time.Now().Add(time.Second).UTC().Format(time.RFC3339)}
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, aitrigramv1.LLMModelPhaseRunning, llmModel.Status.Phase)
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Equal(t, int32(1), *deployment.Spec.Replicas)
+	// requests still go to the activator until the pods are ready
+	require.NoError(t, k8sClient.Get(ctx, activatorKey, endpointSlice))
+
+	deployment.Status.ReadyReplicas = 1
+	require.NoError(t, k8sClient.Status().Update(ctx, deployment))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, key, service))
+	require.Equal(t, llmModelLabels("ollama-llama3"), service.Spec.Selector)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, activatorKey, endpointSlice)))
+}
+
+func Test_LLMModelActivatorTLS(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.TLS = &aitrigramv1.TLSSpec{Enabled: true}
+	lastRequest := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:        "llama3",
+			EngineRef:   llmEngine.Name,
+			Replicas:    1,
+			ScaleToZero: &aitrigramv1.ScaleToZeroSpec{IdleTimeout: metav1.Duration{Duration: time.Minute}},
+		},
+		Status: aitrigramv1.LLMModelStatus{
+			Phase:           aitrigramv1.LLMModelPhaseRunning,
+			LastRequestTime: &lastRequest,
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &appsv1.Deployment{}).
+		Build()
+	r := &LLMModelReconciler{
+		Client:           k8sClient,
+		Scheme:           scheme,
+		ProxyImage:       "ghcr.io/gaol/aitrigram-controller:latest",
+		ActivatorIP:      "10.0.0.5",
+		ActivatorPort:    8090,
+		ActivatorTLSPort: 8091,
+		activityFetcher: func(ctx context.Context, pod *corev1.Pod) (*proxy.Activity, error) {
+			return &proxy.Activity{LastRequestTime: lastRequest.Time}, nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 2)
+
+	// only the https port of the Service is routed to the activator, which passes the TLS through
+	endpointSlice := &discoveryv1.EndpointSlice{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-llama3-activator", Namespace: "default"}, endpointSlice))
+	require.Equal(t, []discoveryv1.EndpointPort{{
+		Name:     ptr.To("https"),
+		Port:     ptr.To[int32](8091),
+		Protocol: ptr.To(corev1.ProtocolTCP),
+	}}, endpointSlice.Ports)
+
+	// the connection is forwarded to the https port of the sidecar once the model is back
+	require.NoError(t, k8sClient.Create(ctx, newReadyPod("ollama-llama3-0", llmModelLabels("ollama-llama3"))))
+	waker := &ModelWaker{Client: k8sClient, PollInterval: time.Millisecond}
+	target, err := waker.Wake(ctx, "ollama-llama3.default.svc", "https")
+	require.NoError(t, err)
+	require.Equal(t, "https://10.0.0.12:15443", target.String())
+	_, err = waker.Wake(ctx, "ollama-llama3.default.svc:8080", "http")
+	require.ErrorContains(t, err, "does not serve http")
+}
+
+func Test_LLMModelScaleToZeroUnreadActivity(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	lastRequest := metav1.NewTime(time.Now().Add(-time.Hour).Truncate(time.Second))
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:         "llama3",
+			NameInEngine: "llama3.2:latest",
+			EngineRef:    llmEngine.Name,
+			Replicas:     1,
+			ScaleToZero:  &aitrigramv1.ScaleToZeroSpec{IdleTimeout: metav1.Duration{Duration: time.Minute}},
+		},
+		Status: aitrigramv1.LLMModelStatus{
+			Phase:           aitrigramv1.LLMModelPhaseRunning,
+			LastRequestTime: &lastRequest,
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel, newReadyPod("ollama-llama3-0", llmModelLabels("ollama-llama3"))).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &appsv1.Deployment{}).
+		Build()
+	var fetched []string
+	fetchErr := errors.New("connection refused")
+	r := &LLMModelReconciler{
+		Client:        k8sClient,
+		Scheme:        scheme,
+		ProxyImage:    "ghcr.io/gaol/aitrigram-controller:latest",
+		ActivatorIP:   "10.0.0.5",
+		ActivatorPort: 8090,
+		activityFetcher: func(ctx context.Context, pod *corev1.Pod) (*proxy.Activity, error) {
+			fetched = append(fetched, pod.Name)
+			if fetchErr != nil {
+				return nil, fetchErr
+			}
+			return &proxy.Activity{LastRequestTime: lastRequest.Time}, nil
+		},
+	}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+
+	// the ready pod may still be serving while its activity can not be read
+	reconcileTimes(t, r, req, 2)
+	require.NotEmpty(t, fetched)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, aitrigramv1.LLMModelPhaseRunning, llmModel.Status.Phase)
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}, deployment))
+	require.Equal(t, int32(1), *deployment.Spec.Replicas)
+
+	// it is scaled to zero once the activity is read
+	fetchErr = nil
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, aitrigramv1.LLMModelPhaseScaledToZero, llmModel.Status.Phase)
+}
+
+func Test_ModelWakerHostFallback(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	routedService := func(namespace, clusterIP string) []client.Object {
+		llmModel := &aitrigramv1.LLMModel{
+			ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: namespace},
+			Spec: aitrigramv1.LLMModelSpec{Name: "llama3", NameInEngine: "llama3.2:latest", EngineRef: "ollama", Replicas: 1,
+				ScaleToZero: &aitrigramv1.ScaleToZeroSpec{IdleTimeout: metav1.Duration{Duration: time.Minute}}},
+			Status: aitrigramv1.LLMModelStatus{Phase: aitrigramv1.LLMModelPhaseRunning},
+		}
+		service := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "ollama-llama3", Namespace: namespace},
+			Spec: corev1.ServiceSpec{
+				ClusterIP: clusterIP,
+				Ports:     []corev1.ServicePort{{Port: 8080, TargetPort: intstr.FromInt32(proxySidecarPort)}},
+			},
+		}
+		require.NoError(t, ctrl.SetControllerReference(llmModel, service, scheme))
+		endpointSlice := &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "ollama-llama3-activator",
+				Namespace: namespace,
+				Labels: map[string]string{
+					discoveryv1.LabelServiceName: "ollama-llama3",
+					discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+				},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+		}
+		pod := newReadyPod("ollama-llama3-0", llmModelLabels("ollama-llama3"))
+		pod.Namespace = namespace
+		return []client.Object{llmModel, service, endpointSlice, pod}
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama-llama3", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{
+			Host: "llama3.example.com",
+			IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Path: "/",
+					Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{
+						Name: "ollama-llama3",
+						Port: networkingv1.ServiceBackendPort{Number: 8080},
+					}},
+				}},
+			}},
+		}}},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(routedService("default", "10.96.0.12")...).
+		WithObjects(ingress).
+		Build()
+	waker := &ModelWaker{Client: k8sClient, PollInterval: time.Millisecond}
+
+	// the Service is found by its short name, its ClusterIP or the host of its Ingress
+	for _, host := range []string{"ollama-llama3.default.svc:8080", "ollama-llama3:8080", "10.96.0.12:8080", "llama3.example.com"} {
+		target, err := waker.Wake(ctx, host, "http")
+		require.NoError(t, err, host)
+		require.Equal(t, "10.0.0.12:15080", target.Host, host)
+	}
+	_, err := waker.Wake(ctx, "unknown:8080", "http")
+	require.ErrorContains(t, err, "cannot find the Service from host")
+
+	// the short name is ambiguous with the same Service in another namespace
+	for _, object := range routedService("team-a", "10.96.0.13") {
+		require.NoError(t, k8sClient.Create(ctx, object))
+	}
+	_, err = waker.Wake(ctx, "ollama-llama3:8080", "http")
+	require.ErrorContains(t, err, "use the <service>.<namespace> form")
+	target, err := waker.Wake(ctx, "10.96.0.13:8080", "http")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.12:15080", target.Host)
+	// but not when only one of the namespaces is watched
+	waker.Namespaces = []string{"team-a"}
+	target, err = waker.Wake(ctx, "ollama-llama3:8080", "http")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.12:15080", target.Host)
+	// the Ingress in the namespace which is not watched is not looked up
+	_, err = waker.Wake(ctx, "llama3.example.com", "http")
+	require.ErrorContains(t, err, "is not watched by the operator")
+
+	// the models which do not scale to zero are never woken up by the activator
+	llmModel := &aitrigramv1.LLMModel{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "llama3", Namespace: "team-a"}, llmModel))
+	llmModel.Spec.ScaleToZero = nil
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	_, err = waker.Wake(ctx, "ollama-llama3.team-a.svc:8080", "http")
+	require.ErrorContains(t, err, "does not scale to zero")
+}
+
+func Test_LLMModelExposure(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.Exposure = &aitrigramv1.ExposureSpec{
+		Host:          "k8s-worker",
+		ClassName:     "haproxy-ingress",
+		TLSSecretName: "k8s-worker-tls",
+		Annotations:   map[string]string{"haproxy.org/timeout-server": "10m"},
+	}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:         "llama3",
+			NameInEngine: "llama3.2:latest",
+			EngineRef:    llmEngine.Name,
+			Replicas:     1,
+			Exposure:     &aitrigramv1.ExposureSpec{PathPrefix: "/ollama/llama3"},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	ingress := &networkingv1.Ingress{}
+	require.NoError(t, k8sClient.Get(ctx, key, ingress))
+	require.Equal(t, "haproxy-ingress", *ingress.Spec.IngressClassName)
+	require.Equal(t, `/ollama/llama3/(.*) /\1`, ingress.Annotations[haproxyPathRewriteAnnotation])
+	require.Equal(t, "10m", ingress.Annotations["haproxy.org/timeout-server"])
+	require.Equal(t, "k8s-worker", ingress.Spec.Rules[0].Host)
+	path := ingress.Spec.Rules[0].HTTP.Paths[0]
+	require.Equal(t, "/ollama/llama3", path.Path)
+	require.Equal(t, "ollama-llama3", path.Backend.Service.Name)
+	require.Equal(t, int32(8080), path.Backend.Service.Port.Number)
+	require.Equal(t, []string{"k8s-worker"}, ingress.Spec.TLS[0].Hosts)
+
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, "https://k8s-worker/ollama/llama3", llmModel.Status.URL)
+
+	// switching to the HTTPRoute replaces the Ingress
+	llmModel.Spec.Exposure = &aitrigramv1.ExposureSpec{Type: aitrigramv1.ExposureTypeHTTPRoute, ClassName: "public"}
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, key, &networkingv1.Ingress{})))
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	require.NoError(t, k8sClient.Get(ctx, key, route))
+	spec := route.Object["spec"].(map[string]interface{})
+	parentRef := spec["parentRefs"].([]interface{})[0].(map[string]interface{})
+	require.Equal(t, "public", parentRef["name"])
+	require.Equal(t, []interface{}{"k8s-worker"}, spec["hostnames"])
+	rules := spec["rules"].([]interface{})
+	require.Len(t, rules, 1)
+	rule := rules[0].(map[string]interface{})
+	prefix, _, _ := unstructured.NestedString(rule["matches"].([]interface{})[0].(map[string]interface{}), "path", "value")
+	require.Equal(t, "/llama3", prefix)
+	rewrite, _, _ := unstructured.NestedString(rule["filters"].([]interface{})[0].(map[string]interface{}), "urlRewrite", "path", "replacePrefixMatch")
+	require.Equal(t, "/", rewrite)
+	require.True(t, metav1.IsControlledBy(route, llmModel))
+
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, "https://k8s-worker/llama3", llmModel.Status.URL)
+}
+
+func Test_LLMModelModelfile(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "sql-assistant", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:         "sql-assistant",
+			NameInEngine: "sql-assistant:latest",
+			EngineRef:    llmEngine.Name,
+			Replicas:     1,
+			Modelfile: &aitrigramv1.ModelfileSpec{
+				From: "llama3.2:latest",
+				ConfigMapRef: &corev1.ConfigMapKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "sql-assistant"},
+					Key:                  "Modelfile",
+				},
+			},
+		},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+
+	// the model waits for the ConfigMap of the Modelfile
+	reconcileTimes(t, r, req, 3)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	ready := meta.FindStatusCondition(llmModel.Status.Conditions, aitrigramv1.ConditionTypeReady)
+	require.NotNil(t, ready)
+	require.Equal(t, "ModelfileNotFound", ready.Reason)
+	deployment := &appsv1.Deployment{}
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-sql-assistant", Namespace: "default"}, deployment)))
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "sql-assistant", Namespace: "default"},
+		Data:       map[string]string{"Modelfile": "PARAMETER temperature 0.2\nSYSTEM You're a SQL assistant."},
+	}
+	require.NoError(t, k8sClient.Create(ctx, configMap))
+	require.Len(t, r.llmModelsOfModelfileConfigMap(ctx, configMap), 1)
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-sql-assistant", Namespace: "default"}, deployment))
+	require.Equal(t, `ollama serve & sleep 10 && ollama pull llama3.2:latest`+
+		` && revision=$(ollama list | awk -v m='llama3.2:latest' '$1 == m {print $2}') && printf '%s' "$revision" > /dev/termination-log`+
+		` && printf '%s\n' 'FROM llama3.2:latest`+"\n"+`PARAMETER temperature 0.2`+"\n"+`SYSTEM You'\''re a SQL assistant.'`+
+		` > /tmp/aitrigram.Modelfile && ollama create 'sql-assistant:latest' -f /tmp/aitrigram.Modelfile`,
+		deployment.Spec.Template.Spec.InitContainers[0].Args[0])
+
+	// a change of the Modelfile rebuilds the model in a new rollout
+	configMap.Data["Modelfile"] = "PARAMETER temperature 0.7"
+	require.NoError(t, k8sClient.Update(ctx, configMap))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-sql-assistant", Namespace: "default"}, deployment))
+	require.Contains(t, deployment.Spec.Template.Spec.InitContainers[0].Args[0], "PARAMETER temperature 0.7")
+	require.NotContains(t, deployment.Spec.Template.Spec.InitContainers[0].Args[0], "PARAMETER temperature 0.2")
+}
+
+func Test_LLMModelRevision(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:         "llama3",
+			NameInEngine: "llama3.2",
+			EngineRef:    llmEngine.Name,
+			Replicas:     2,
+			Revision:     "sha256:a80c4f17acd55265feec403c7aef86be0c25983ab279d83f3bcd3abbcb5b8b72",
+		},
+	}
+	newPod := func(name string, created time.Time, revision string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				Namespace:         "default",
+				Labels:            llmModelLabels("ollama-llama3"),
+				CreationTimestamp: metav1.NewTime(created),
+			},
+			Status: corev1.PodStatus{
+				PodIP:      "10.0.0.12",
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+				InitContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "init-ollama-llama3",
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: revision}},
+				}},
+			},
+		}
+	}
+	now := time.Now().Truncate(time.Second)
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel,
+			newPod("ollama-llama3-0", now.Add(-time.Hour), "365c0bd3c000"),
+			newPod("ollama-llama3-1", now, "a80c4f17acd5")).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	// the pulled model is checked against the pinned revision
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}, deployment))
+	require.Equal(t, `ollama serve & sleep 10 && ollama pull llama3.2`+
+		` && revision=$(ollama list | awk -v m='llama3.2:latest' '$1 == m {print $2}') && printf '%s' "$revision" > /dev/termination-log`+
+		` && case "$revision" in 'a80c4f17acd5'*) ;; *) echo 'The pulled llama3.2:latest does not match the pinned revision `+
+		llmModel.Spec.Revision+`' >&2 && exit 1 ;; esac`,
+		deployment.Spec.Template.Spec.InitContainers[0].Args[0])
+	// the old pods keep serving until the new ones are ready
+	require.Equal(t, intstr.FromInt32(0), *deployment.Spec.Strategy.RollingUpdate.MaxUnavailable)
+	require.Equal(t, "/", deployment.Spec.Template.Spec.Containers[0].ReadinessProbe.HTTPGet.Path)
+
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.Equal(t, "a80c4f17acd5", llmModel.Status.ResolvedRevision)
+	require.Equal(t, []string{"365c0bd3c000", "a80c4f17acd5"}, llmModel.Status.ServingRevisions)
+
+	// a new revision rolls out the pods
+	llmModel.Spec.Revision = "365c0bd3c000"
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}, deployment))
+	require.Contains(t, deployment.Spec.Template.Spec.InitContainers[0].Args[0], `case "$revision" in '365c0bd3c000'*)`)
+}
+
+func Test_LLMModelDeploymentUpdate(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1,
+			ModelDeployment: &aitrigramv1.ModelDeploymentTemplate{Envs: &[]corev1.EnvVar{{Name: "OLLAMA_DEBUG", Value: "1"}}}},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	hash := deployment.Annotations[deploymentSpecHashAnnotation]
+	require.NotEmpty(t, hash)
+	require.Contains(t, deployment.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "OLLAMA_DEBUG", Value: "1"})
+
+	// the Deployment is not updated while the desired spec is the same
+	reconcileTimes(t, r, req, 1)
+	unchanged := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, unchanged))
+	require.Equal(t, deployment.ResourceVersion, unchanged.ResourceVersion)
+
+	// a removed env is removed from the Deployment as well
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.ModelDeployment = nil
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 2)
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.NotEqual(t, hash, deployment.Annotations[deploymentSpecHashAnnotation])
+	require.NotContains(t, deployment.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{Name: "OLLAMA_DEBUG", Value: "1"})
+}
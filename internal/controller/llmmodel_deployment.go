@@ -3,34 +3,45 @@ package controller
 import (
 	"bytes"
 	"context"
-	"reflect"
-	"strings"
+	"slices"
 	"text/template"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// the flag of vllm to serve the LoRA adapters
+	vllmEnableLoRAFlag = "--enable-lora"
+	// the env of vllm to allow loading the LoRA adapters at runtime
+	vllmRuntimeLoRAUpdatingEnv = "VLLM_ALLOW_RUNTIME_LORA_UPDATING"
 )
 
 type DownloadScriptsTemplate struct {
 	ModelName string
 	ModelUrl  string
 	ModelDir  string
+	// the pinned revision of the model, it may be empty
+	Revision string
 }
 
 // Reconcile the deployment for a LLM model
 // when this method is called, all values have been recalculated with consideration of default values and user desired changes.
-func (r *LLMModelReconciler) reconcileLLMDeployment(ctx context.Context, req ctrl.Request, deploymentParams ReconcileParams) error {
+// It returns the Deployment in the cluster.
+func (r *LLMModelReconciler) reconcileLLMDeployment(ctx context.Context, req ctrl.Request, deploymentParams ReconcileParams) (*appsv1.Deployment, error) {
 
 	logger := log.FromContext(ctx)
 
-	deploymentName := strings.ToLower(string(deploymentParams.llmEngine.Spec.EngineType) + "-" + strings.ReplaceAll(deploymentParams.model.Spec.Name, ".", "-"))
+	deploymentName := llmModelResourceName(deploymentParams.llmEngine.Spec.EngineType, deploymentParams.model.Spec.Name)
 	deployment := &appsv1.Deployment{}
 	nameSpaceName := &types.NamespacedName{
 		Namespace: req.Namespace,
@@ -44,36 +55,48 @@ func (r *LLMModelReconciler) reconcileLLMDeployment(ctx context.Context, req ctr
 			newDeployment, err := r.newLLMModelDeployment(nameSpaceName, deploymentParams)
 			if err != nil {
 				logger.Error(err, "Failed to define new Deployment resource for LLMEngine")
-				return err
+				return nil, err
+			}
+			if err := setDeploymentSpecHash(newDeployment); err != nil {
+				return nil, err
 			}
 			logger.Info("Creating a new Deployment", "Deployment.Namespace", newDeployment.Namespace, "Deployment.Name", newDeployment.Name)
 			if err := r.Create(ctx, newDeployment); err != nil {
 				logger.Error(err, "Failed to create the new Deployment", "Deployment.Namespace", newDeployment.Namespace, "Deployment.Name", newDeployment.Name)
-				return err
+				return nil, err
 			}
+			recordEvent(r.Recorder, deploymentParams.model, corev1.EventTypeNormal, eventReasonDeploymentCreated,
+				"Created the Deployment %s", newDeployment.Name)
 			// deployment created successfully, requeue it in 10 seconds
-			return nil
+			return newDeployment, nil
 		}
 		logger.Error(err, "Failed to get the Deployment for LLMEngine")
-		return err
+		return nil, err
+	}
+	// LoRA stays enabled once it is enabled, so that removing the last adapter does not roll out the model
+	if len(deployment.Spec.Template.Spec.Containers) > 0 && slices.Contains(deployment.Spec.Template.Spec.Containers[0].Command, vllmEnableLoRAFlag) {
+		deploymentParams.loraEnabled = true
 	}
 	// Now the deployment has been created, but maybe need to update, let's calculate it
-	existing := deployment.Spec
 	desired, err := r.newLLMModelDeployment(nameSpaceName, deploymentParams)
 	if err != nil {
 		logger.Error(err, "Failed to define new Deployment resource for LLMEngine")
-		return err
+		return nil, err
 	}
-	// make a deep copy and ignore some fields for comparison
-	if reflect.DeepEqual(existing, desired.Spec) {
+	if err := setDeploymentSpecHash(desired); err != nil {
+		return nil, err
+	}
+	if deploymentUpToDate(desired, deployment) {
 		logger.Info("Deployment is already up-to-date")
-		return nil
+		return deployment, nil
 	}
-	if err := r.Client.Patch(ctx, deployment, client.MergeFrom(desired)); err != nil {
+	if err := patchDeployment(ctx, r.Client, desired, deployment); err != nil {
 		logger.Error(err, "Failed to update the deployment")
-		return err
+		return nil, err
 	}
-	return nil
+	recordEvent(r.Recorder, deploymentParams.model, corev1.EventTypeNormal, eventReasonDeploymentUpdated,
+		"Updated the Deployment %s", deployment.Name)
+	return deployment, nil
 }
 
 func generateInitScript(scripts string, data DownloadScriptsTemplate) (string, error) {
@@ -93,7 +116,14 @@ func generateInitScript(scripts string, data DownloadScriptsTemplate) (string, e
 
 // The new deployment has the ownerReferences to the llmEngine CR, so it will be handled automatically by the core
 func (r *LLMModelReconciler) newLLMModelDeployment(nameSpaceName *types.NamespacedName, deploymentParams ReconcileParams) (*appsv1.Deployment, error) {
+	// the HorizontalPodAutoscaler sets the replicas of the model, they are kept within its limits until it does
 	replicas := deploymentParams.model.Spec.Replicas
+	if autoscaling := deploymentParams.model.Spec.Autoscaling; autoscaling != nil {
+		replicas = min(max(replicas, ptr.Deref(autoscaling.MinReplicas, 1)), autoscaling.MaxReplicas)
+	}
+	if deploymentParams.scaledToZero {
+		replicas = 0
+	}
 	image := deploymentParams.llmEngine.Spec.Image
 	port := deploymentParams.llmEngine.Spec.Port
 	args := []string{}
@@ -102,17 +132,28 @@ func (r *LLMModelReconciler) newLLMModelDeployment(nameSpaceName *types.Namespac
 		args = deploymentParams.model.Spec.ModelDeployment.Args
 		envs = deploymentParams.model.Spec.ModelDeployment.Envs
 	}
+	if deploymentParams.llmEngine.Spec.EngineType == aitrigramv1.LLMEngineTypeVLLM {
+		args = vllmRevisionArgs(args, deploymentParams.model.Spec.Revision)
+		if len(deploymentParams.adapters) > 0 || deploymentParams.loraEnabled {
+			args, envs = vllmLoRAArgsAndEnvs(args, envs)
+		}
+	}
 	volumes, volumeMounts := cacheAndModelsMount(deploymentParams.model.Spec.ModelDeployment.Storage)
-	appLabels := map[string]string{"app": "aitrigram-llmmodel", "instance": nameSpaceName.Name}
+	appLabels := llmModelLabels(nameSpaceName.Name)
 	downloadScriptsTemplate := DownloadScriptsTemplate{
-		ModelName: deploymentParams.model.Spec.NameInEngine,
+		ModelName: llmModelPullName(deploymentParams.model),
 		ModelUrl:  deploymentParams.model.Spec.ModelDeployment.DownloadImage,
 		ModelDir:  deploymentParams.model.Spec.ModelDeployment.Storage.ModelsStorage.Path,
+		Revision:  deploymentParams.model.Spec.Revision,
 	}
 	downloadScripts, err := generateInitScript(deploymentParams.model.Spec.ModelDeployment.DownloadScripts, downloadScriptsTemplate)
 	if err != nil {
 		return nil, err
 	}
+	if deploymentParams.llmEngine.Spec.EngineType == aitrigramv1.LLMEngineTypeOllama {
+		downloadScripts = appendOllamaRevisionScripts(downloadScripts, deploymentParams.model)
+		downloadScripts = appendOllamaModelfileScripts(downloadScripts, deploymentParams.model, deploymentParams.modelfile)
+	}
 	dep := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      nameSpaceName.Name,
@@ -124,6 +165,7 @@ func (r *LLMModelReconciler) newLLMModelDeployment(nameSpaceName *types.Namespac
 			Selector: &metav1.LabelSelector{
 				MatchLabels: appLabels,
 			},
+			Strategy: modelRolloutStrategy(deploymentParams.model.Spec.Strategy),
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
 					Labels: appLabels,
@@ -145,13 +187,18 @@ func (r *LLMModelReconciler) newLLMModelDeployment(nameSpaceName *types.Namespac
 							ContainerPort: port,
 							Name:          "http",
 						}},
-						Command: args,
-						Env:     *envs,
+						Command:        args,
+						Env:            *envs,
+						ReadinessProbe: engineReadinessProbe(deploymentParams.llmEngine.Spec.EngineType),
 					}},
 				},
 			},
 		},
 	}
+	if proxySidecarEnabled(deploymentParams) {
+		dep.Spec.Template.Spec.Containers = append(dep.Spec.Template.Spec.Containers, proxySidecar(r.ProxyImage, port))
+	}
+	applyMonitoring(&dep.Spec.Template, deploymentParams.llmEngine, deploymentParams.podMonitor)
 	if volumes != nil {
 		dep.Spec.Template.Spec.Volumes = volumes
 	}
@@ -159,6 +206,22 @@ func (r *LLMModelReconciler) newLLMModelDeployment(nameSpaceName *types.Namespac
 		dep.Spec.Template.Spec.InitContainers[0].VolumeMounts = volumeMounts
 		dep.Spec.Template.Spec.Containers[0].VolumeMounts = volumeMounts
 	}
+	if networkPolicyEnabled(deploymentParams) {
+		applyDownloadGate(&dep.Spec.Template, nameSpaceName.Name, deploymentParams.model.Spec.ModelDeployment.DownloadImage)
+	}
+	applySecurityContext(&dep.Spec.Template.Spec, deploymentParams.model.Spec.ModelDeployment, nameSpaceName.Name)
+	applyServiceAccount(&dep.Spec.Template.Spec, deploymentParams)
+	if err := applyAuth(&dep.Spec.Template.Spec, proxySidecarName, deploymentParams.llmEngine, llmModelNameInEngine(deploymentParams.model)); err != nil {
+		return nil, err
+	}
+	bindEngineToLocalhost(&dep.Spec.Template.Spec, deploymentParams.llmEngine)
+	if len(deploymentParams.quotas) > 0 {
+		applyQuota(&dep.Spec.Template.Spec, nameSpaceName.Name)
+	}
+	if tlsEnabled(deploymentParams.llmEngine) {
+		applyTLS(&dep.Spec.Template.Spec, proxySidecarName, llmTLSSecretName(nameSpaceName.Name), proxySidecarTLSPort,
+			plaintextEnabled(deploymentParams.llmEngine))
+	}
 
 	// Set the ownerRef for the Deployment
 	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/owners-dependents/
@@ -167,3 +230,18 @@ func (r *LLMModelReconciler) newLLMModelDeployment(nameSpaceName *types.Namespac
 	}
 	return dep, nil
 }
+
+// Returns the args and envs of vllm with LoRA enabled, the adapters are loaded at runtime by the LLMAdapterReconciler
+func vllmLoRAArgsAndEnvs(args []string, envs *[]corev1.EnvVar) ([]string, *[]corev1.EnvVar) {
+	if !slices.Contains(args, vllmEnableLoRAFlag) {
+		args = append(slices.Clone(args), vllmEnableLoRAFlag)
+	}
+	loraEnvs := []corev1.EnvVar{}
+	if envs != nil {
+		loraEnvs = slices.Clone(*envs)
+	}
+	if !envInSlice(corev1.EnvVar{Name: vllmRuntimeLoRAUpdatingEnv, Value: "True"}, loraEnvs) {
+		loraEnvs = append(loraEnvs, corev1.EnvVar{Name: vllmRuntimeLoRAUpdatingEnv, Value: "True"})
+	}
+	return args, &loraEnvs
+}
@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// The strategy of the model Deployment. By default, the old pods keep serving until the new ones have downloaded
+// the model and are ready.
+func modelRolloutStrategy(strategy *aitrigramv1.ModelStrategySpec) appsv1.DeploymentStrategy {
+	if strategy != nil && strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return appsv1.DeploymentStrategy{Type: appsv1.RecreateDeploymentStrategyType}
+	}
+	rollingUpdate := &appsv1.RollingUpdateDeployment{
+		MaxUnavailable: ptr.To(intstr.FromInt32(0)),
+		MaxSurge:       ptr.To(intstr.FromInt32(1)),
+	}
+	if strategy != nil {
+		if strategy.MaxUnavailable != nil {
+			rollingUpdate.MaxUnavailable = strategy.MaxUnavailable
+		}
+		if strategy.MaxSurge != nil {
+			rollingUpdate.MaxSurge = strategy.MaxSurge
+		}
+	}
+	return appsv1.DeploymentStrategy{
+		Type:          appsv1.RollingUpdateDeploymentStrategyType,
+		RollingUpdate: rollingUpdate,
+	}
+}
+
+// Reconcile the PodDisruptionBudget of the pods of the LLM model.
+// The PodDisruptionBudget is deleted when the DisruptionBudget is removed.
+func (r *LLMModelReconciler) reconcileLLMDisruptionBudget(ctx context.Context, req ctrl.Request, params ReconcileParams) error {
+	logger := log.FromContext(ctx)
+
+	nameSpaceName := &types.NamespacedName{
+		Namespace: req.Namespace,
+		Name:      llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name),
+	}
+	pdb := &policyv1.PodDisruptionBudget{}
+	err := r.Get(ctx, *nameSpaceName, pdb)
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "Failed to get the PodDisruptionBudget for LLMModel")
+		return err
+	}
+	exists := err == nil
+
+	if params.model.Spec.DisruptionBudget == nil {
+		if exists && metav1.IsControlledBy(pdb, params.model) {
+			logger.Info("DisruptionBudget is removed, deleting the PodDisruptionBudget", "PodDisruptionBudget.Name", pdb.Name)
+			return client.IgnoreNotFound(r.Delete(ctx, pdb))
+		}
+		return nil
+	}
+
+	desired, err := r.newLLMModelDisruptionBudget(nameSpaceName, params)
+	if err != nil {
+		logger.Error(err, "Failed to define new PodDisruptionBudget resource for LLMModel")
+		return err
+	}
+	if !exists {
+		logger.Info("Creating a new PodDisruptionBudget", "PodDisruptionBudget.Namespace", desired.Namespace, "PodDisruptionBudget.Name", desired.Name)
+		return r.Create(ctx, desired)
+	}
+	// minAvailable and maxUnavailable replace each other, which DeepDerivative ignores when one is nil
+	if equality.Semantic.DeepEqual(desired.Spec.MinAvailable, pdb.Spec.MinAvailable) &&
+		equality.Semantic.DeepEqual(desired.Spec.MaxUnavailable, pdb.Spec.MaxUnavailable) &&
+		equality.Semantic.DeepDerivative(desired.Spec.Selector, pdb.Spec.Selector) {
+		logger.Info("PodDisruptionBudget is already up-to-date")
+		return nil
+	}
+	patch := client.MergeFrom(pdb.DeepCopy())
+	pdb.Spec = desired.Spec
+	if err := r.Patch(ctx, pdb, patch); err != nil {
+		logger.Error(err, "Failed to update the PodDisruptionBudget")
+		return err
+	}
+	return nil
+}
+
+func (r *LLMModelReconciler) newLLMModelDisruptionBudget(nameSpaceName *types.NamespacedName, params ReconcileParams) (*policyv1.PodDisruptionBudget, error) {
+	disruptionBudget := params.model.Spec.DisruptionBudget
+	pdb := &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nameSpaceName.Name,
+			Namespace: nameSpaceName.Namespace,
+			Labels:    llmModelLabels(nameSpaceName.Name),
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: llmModelLabels(nameSpaceName.Name),
+			},
+			MinAvailable:   disruptionBudget.MinAvailable,
+			MaxUnavailable: disruptionBudget.MaxUnavailable,
+		},
+	}
+	// Set the ownerRef for the PodDisruptionBudget
+	if err := ctrl.SetControllerReference(params.model, pdb, r.Scheme); err != nil {
+		return nil, err
+	}
+	return pdb, nil
+}
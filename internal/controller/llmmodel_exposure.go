@@ -0,0 +1,314 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// the annotation of the haproxy ingress controller which rewrites the path passed to the backend
+	haproxyPathRewriteAnnotation = "haproxy.org/path-rewrite"
+	// the annotation of the haproxy ingress controller which connects to the backend over https
+	haproxyServerSSLAnnotation = "haproxy.org/server-ssl"
+)
+
+var httpRouteGVK = schema.GroupVersionKind{
+	Group:   "gateway.networking.k8s.io",
+	Version: "v1",
+	Kind:    "HTTPRoute",
+}
+
+// reconcileLLMExposure exposes the model outside of the cluster with an Ingress or a HTTPRoute according to
+// the Exposure of the model merged with the one of the engine, the other kind is deleted if it exists.
+// It returns the external URL of the model, which is empty when the model is not exposed or the host is not known yet.
+func (r *LLMModelReconciler) reconcileLLMExposure(ctx context.Context, req ctrl.Request, params ReconcileParams) (string, error) {
+	nameSpaceName := types.NamespacedName{
+		Namespace: req.Namespace,
+		Name:      llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name),
+	}
+	exposure := MergeExposures(params.llmEngine.Spec.Exposure, params.model.Spec.Exposure)
+	if exposure == nil {
+		if err := r.deleteLLMIngress(ctx, nameSpaceName, params.model); err != nil {
+			return "", err
+		}
+		return "", r.deleteLLMHTTPRoute(ctx, nameSpaceName, params.model)
+	}
+	if exposure.PathPrefix == "" {
+		exposure.PathPrefix = "/" + params.model.Name
+	}
+
+	if exposure.Type == aitrigramv1.ExposureTypeHTTPRoute {
+		if err := r.deleteLLMIngress(ctx, nameSpaceName, params.model); err != nil {
+			return "", err
+		}
+		if err := r.reconcileLLMHTTPRoute(ctx, nameSpaceName, exposure, params); err != nil {
+			return "", err
+		}
+		return exposureURL(exposure, exposure.Host), nil
+	}
+
+	if err := r.deleteLLMHTTPRoute(ctx, nameSpaceName, params.model); err != nil {
+		return "", err
+	}
+	ingress, err := r.reconcileLLMIngress(ctx, nameSpaceName, exposure, params)
+	if err != nil {
+		return "", err
+	}
+	host := exposure.Host
+	if host == "" {
+		// any host matches the Ingress, use the address assigned by the ingress controller
+		for _, lb := range ingress.Status.LoadBalancer.Ingress {
+			if lb.Hostname != "" {
+				host = lb.Hostname
+				break
+			}
+			if lb.IP != "" {
+				host = lb.IP
+				break
+			}
+		}
+	}
+	return exposureURL(exposure, host), nil
+}
+
+func exposureURL(exposure *aitrigramv1.ExposureSpec, host string) string {
+	if host == "" {
+		return ""
+	}
+	scheme := "http"
+	if exposure.TLSSecretName != "" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, exposure.PathPrefix)
+}
+
+func (r *LLMModelReconciler) reconcileLLMIngress(ctx context.Context, nameSpaceName types.NamespacedName, exposure *aitrigramv1.ExposureSpec, params ReconcileParams) (*networkingv1.Ingress, error) {
+	logger := log.FromContext(ctx)
+	desired, err := r.newLLMIngress(nameSpaceName, exposure, params)
+	if err != nil {
+		logger.Error(err, "Failed to define new Ingress resource for LLMModel")
+		return nil, err
+	}
+	ingress := &networkingv1.Ingress{}
+	if err := r.Get(ctx, nameSpaceName, ingress); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		logger.Info("Creating a new Ingress", "Ingress.Namespace", desired.Namespace, "Ingress.Name", desired.Name)
+		return desired, r.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepDerivative(desired.Spec, ingress.Spec) &&
+		equality.Semantic.DeepDerivative(desired.Annotations, ingress.Annotations) {
+		logger.Info("Ingress is already up-to-date")
+		return ingress, nil
+	}
+	patch := client.MergeFrom(ingress.DeepCopy())
+	ingress.Spec = desired.Spec
+	ingress.Annotations = MergeMaps(ingress.Annotations, desired.Annotations)
+	if err := r.Patch(ctx, ingress, patch); err != nil {
+		logger.Error(err, "Failed to update the Ingress")
+		return nil, err
+	}
+	return ingress, nil
+}
+
+func (r *LLMModelReconciler) newLLMIngress(nameSpaceName types.NamespacedName, exposure *aitrigramv1.ExposureSpec, params ReconcileParams) (*networkingv1.Ingress, error) {
+	prefix := strings.TrimSuffix(exposure.PathPrefix, "/")
+	operatorAnnotations := map[string]string{
+		// removes the prefix in the path passed to the model, like: /llama3/api/tags -> /api/tags
+		haproxyPathRewriteAnnotation: fmt.Sprintf(`%s/(.*) /\1`, prefix),
+	}
+	if !plaintextEnabled(params.llmEngine) {
+		operatorAnnotations[haproxyServerSSLAnnotation] = "true"
+	}
+	annotations := MergeMaps(operatorAnnotations, exposure.Annotations)
+	rule := networkingv1.IngressRule{
+		Host: exposure.Host,
+		IngressRuleValue: networkingv1.IngressRuleValue{
+			HTTP: &networkingv1.HTTPIngressRuleValue{
+				Paths: []networkingv1.HTTPIngressPath{{
+					Path:     exposure.PathPrefix,
+					PathType: ptr.To(networkingv1.PathTypePrefix),
+					Backend: networkingv1.IngressBackend{
+						Service: &networkingv1.IngressServiceBackend{
+							Name: backendServiceName(params.llmEngine, params.model),
+							Port: networkingv1.ServiceBackendPort{Number: exposedServicePort(params)},
+						},
+					},
+				}},
+			},
+		},
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        nameSpaceName.Name,
+			Namespace:   nameSpaceName.Namespace,
+			Labels:      llmModelLabels(nameSpaceName.Name),
+			Annotations: annotations,
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{rule},
+		},
+	}
+	if exposure.ClassName != "" {
+		ingress.Spec.IngressClassName = ptr.To(exposure.ClassName)
+	}
+	if exposure.TLSSecretName != "" {
+		tls := networkingv1.IngressTLS{SecretName: exposure.TLSSecretName}
+		if exposure.Host != "" {
+			tls.Hosts = []string{exposure.Host}
+		}
+		ingress.Spec.TLS = []networkingv1.IngressTLS{tls}
+	}
+	// Set the ownerRef for the Ingress
+	if err := ctrl.SetControllerReference(params.model, ingress, r.Scheme); err != nil {
+		return nil, err
+	}
+	return ingress, nil
+}
+
+// The HTTPRoute is handled as unstructured so that the Gateway API CRDs are only needed when it is used
+func (r *LLMModelReconciler) reconcileLLMHTTPRoute(ctx context.Context, nameSpaceName types.NamespacedName, exposure *aitrigramv1.ExposureSpec, params ReconcileParams) error {
+	logger := log.FromContext(ctx)
+	desired, err := r.newLLMHTTPRoute(nameSpaceName, exposure, params)
+	if err != nil {
+		logger.Error(err, "Failed to define new HTTPRoute resource for LLMModel")
+		return err
+	}
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	if err := r.Get(ctx, nameSpaceName, route); err != nil {
+		if meta.IsNoMatchError(err) {
+			return fmt.Errorf("the Gateway API HTTPRoute is not available in the cluster: %w", err)
+		}
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		logger.Info("Creating a new HTTPRoute", "HTTPRoute.Namespace", desired.GetNamespace(), "HTTPRoute.Name", desired.GetName())
+		return r.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepDerivative(desired.Object["spec"], route.Object["spec"]) &&
+		equality.Semantic.DeepDerivative(desired.GetAnnotations(), route.GetAnnotations()) {
+		logger.Info("HTTPRoute is already up-to-date")
+		return nil
+	}
+	patch := client.MergeFrom(route.DeepCopy())
+	route.Object["spec"] = desired.Object["spec"]
+	route.SetAnnotations(MergeMaps(route.GetAnnotations(), desired.GetAnnotations()))
+	if err := r.Patch(ctx, route, patch); err != nil {
+		logger.Error(err, "Failed to update the HTTPRoute")
+		return err
+	}
+	return nil
+}
+
+func (r *LLMModelReconciler) newLLMHTTPRoute(nameSpaceName types.NamespacedName, exposure *aitrigramv1.ExposureSpec, params ReconcileParams) (*unstructured.Unstructured, error) {
+	parentRef := map[string]interface{}{
+		"name": exposure.ClassName,
+	}
+	if exposure.GatewayNamespace != "" {
+		parentRef["namespace"] = exposure.GatewayNamespace
+	}
+	rule := map[string]interface{}{
+		"matches": []interface{}{
+			map[string]interface{}{
+				"path": map[string]interface{}{
+					"type":  "PathPrefix",
+					"value": exposure.PathPrefix,
+				},
+			},
+		},
+		// removes the prefix in the path passed to the model, like: /llama3/api/tags -> /api/tags
+		"filters": []interface{}{
+			map[string]interface{}{
+				"type": "URLRewrite",
+				"urlRewrite": map[string]interface{}{
+					"path": map[string]interface{}{
+						"type":               "ReplacePrefixMatch",
+						"replacePrefixMatch": "/",
+					},
+				},
+			},
+		},
+		"backendRefs": []interface{}{
+			map[string]interface{}{
+				"name": backendServiceName(params.llmEngine, params.model),
+				"port": int64(exposedServicePort(params)),
+			},
+		},
+	}
+	spec := map[string]interface{}{
+		"parentRefs": []interface{}{parentRef},
+		"rules":      []interface{}{rule},
+	}
+	if exposure.Host != "" {
+		spec["hostnames"] = []interface{}{exposure.Host}
+	}
+	route := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	route.SetGroupVersionKind(httpRouteGVK)
+	route.SetName(nameSpaceName.Name)
+	route.SetNamespace(nameSpaceName.Namespace)
+	route.SetLabels(llmModelLabels(nameSpaceName.Name))
+	if len(exposure.Annotations) > 0 {
+		route.SetAnnotations(exposure.Annotations)
+	}
+	// Set the ownerRef for the HTTPRoute
+	if err := ctrl.SetControllerReference(params.model, route, r.Scheme); err != nil {
+		return nil, err
+	}
+	return route, nil
+}
+
+func (r *LLMModelReconciler) deleteLLMIngress(ctx context.Context, nameSpaceName types.NamespacedName, llmModel *aitrigramv1.LLMModel) error {
+	ingress := &networkingv1.Ingress{}
+	if err := r.Get(ctx, nameSpaceName, ingress); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(ingress, llmModel) {
+		return nil
+	}
+	log.FromContext(ctx).Info("Exposure is changed, deleting the Ingress", "Ingress.Name", ingress.Name)
+	return client.IgnoreNotFound(r.Delete(ctx, ingress))
+}
+
+func (r *LLMModelReconciler) deleteLLMHTTPRoute(ctx context.Context, nameSpaceName types.NamespacedName, llmModel *aitrigramv1.LLMModel) error {
+	route := &unstructured.Unstructured{}
+	route.SetGroupVersionKind(httpRouteGVK)
+	if err := r.Get(ctx, nameSpaceName, route); err != nil {
+		if meta.IsNoMatchError(err) {
+			// no Gateway API in the cluster, so there is nothing to delete
+			return nil
+		}
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(route, llmModel) {
+		return nil
+	}
+	log.FromContext(ctx).Info("Exposure is changed, deleting the HTTPRoute", "HTTPRoute.Name", route.GetName())
+	return client.IgnoreNotFound(r.Delete(ctx, route))
+}
+
+// The port of the Service the model is exposed on, it is the https one when the http port is not served.
+// The Gateway needs a BackendTLSPolicy to connect to the https port of a HTTPRoute backend.
+func exposedServicePort(params ReconcileParams) int32 {
+	if plaintextEnabled(params.llmEngine) {
+		return params.llmEngine.Spec.ServicePort
+	}
+	return tlsServicePort(params.llmEngine.Spec.TLS)
+}
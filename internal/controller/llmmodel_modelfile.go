@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// where the Modelfile is written in the init container before `ollama create`
+const modelfilePath = "/tmp/aitrigram.Modelfile"
+
+// The model name served by the engine, it is the Name if the NameInEngine is not defined
+func llmModelNameInEngine(llmModel *aitrigramv1.LLMModel) string {
+	if llmModel.Spec.NameInEngine != "" {
+		return llmModel.Spec.NameInEngine
+	}
+	return llmModel.Spec.Name
+}
+
+// The model pulled by the download scripts, it is the base model of the Modelfile if there is one
+func llmModelPullName(llmModel *aitrigramv1.LLMModel) string {
+	if llmModel.Spec.Modelfile != nil {
+		return llmModel.Spec.Modelfile.From
+	}
+	return llmModelNameInEngine(llmModel)
+}
+
+// resolveModelfile returns the full Modelfile of the LLMModel with the FROM instruction, it is empty when there is no Modelfile.
+// The content comes from the ConfigMap when the ConfigMapRef is defined.
+func resolveModelfile(ctx context.Context, c client.Reader, llmModel *aitrigramv1.LLMModel) (string, error) {
+	modelfile := llmModel.Spec.Modelfile
+	if modelfile == nil {
+		return "", nil
+	}
+	content := modelfile.Inline
+	if ref := modelfile.ConfigMapRef; ref != nil {
+		configMap := &corev1.ConfigMap{}
+		if err := c.Get(ctx, client.ObjectKey{Namespace: llmModel.Namespace, Name: ref.Name}, configMap); err != nil {
+			return "", err
+		}
+		var ok bool
+		if content, ok = configMap.Data[ref.Key]; !ok {
+			return "", fmt.Errorf("key %s is not found in the ConfigMap %s", ref.Key, ref.Name)
+		}
+	}
+	return fmt.Sprintf("FROM %s\n%s", modelfile.From, content), nil
+}
+
+// Appends the commands which write the Modelfile and create the model named as the NameInEngine from it.
+// The Modelfile is part of the scripts, so that a change of it rolls out the pods.
+func appendOllamaModelfileScripts(downloadScripts string, llmModel *aitrigramv1.LLMModel, modelfile string) string {
+	if downloadScripts == "" || modelfile == "" {
+		return downloadScripts
+	}
+	return fmt.Sprintf("%s && printf '%%s\\n' %s > %s && ollama create %s -f %s", downloadScripts,
+		shellQuote(modelfile), modelfilePath, shellQuote(llmModelNameInEngine(llmModel)), modelfilePath)
+}
+
+// Quotes the value in single quotes for the shell, nothing is expanded inside
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// Enqueues the LLMModels which have the Modelfile in the ConfigMap, so that they get rebuilt on changes
+func (r *LLMModelReconciler) llmModelsOfModelfileConfigMap(ctx context.Context, obj client.Object) []reconcile.Request {
+	llmModels, err := llmModelsOfModelfileConfigMap(ctx, r.Client, obj)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list the LLMModels of the ConfigMap", "ConfigMap.Name", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, llmModel := range llmModels {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&llmModel)})
+	}
+	return requests
+}
+
+// Returns the LLMModels in the namespace of the ConfigMap which refer to it for the Modelfile
+func llmModelsOfModelfileConfigMap(ctx context.Context, c client.Reader, obj client.Object) ([]aitrigramv1.LLMModel, error) {
+	llmModelList := &aitrigramv1.LLMModelList{}
+	if err := c.List(ctx, llmModelList, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil, err
+	}
+	var llmModels []aitrigramv1.LLMModel
+	for _, llmModel := range llmModelList.Items {
+		if modelfile := llmModel.Spec.Modelfile; modelfile != nil && modelfile.ConfigMapRef != nil && modelfile.ConfigMapRef.Name == obj.GetName() {
+			llmModels = append(llmModels, llmModel)
+		}
+	}
+	return llmModels, nil
+}
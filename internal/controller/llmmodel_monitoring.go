@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// the name of the container port of the metrics endpoint when it is not the port of the engine
+	metricsPortName = "metrics"
+	// the path of the metrics endpoint of vllm
+	vllmMetricsPath = "/metrics"
+
+	// the annotations of the pods recognized by the common Prometheus scrape configurations
+	prometheusScrapeAnnotation = "prometheus.io/scrape"
+	prometheusPortAnnotation   = "prometheus.io/port"
+	prometheusPathAnnotation   = "prometheus.io/path"
+)
+
+var podMonitorGVK = schema.GroupVersionKind{
+	Group:   "monitoring.coreos.com",
+	Version: "v1",
+	Kind:    "PodMonitor",
+}
+
+// Returns the port and the path of the metrics endpoint of the engine, the path is empty when the engine has no
+// known metrics endpoint or the monitoring is disabled.
+func engineMetricsEndpoint(llmEngine *aitrigramv1.LLMEngine) (int32, string) {
+	monitoring := llmEngine.Spec.Monitoring
+	if monitoring == nil || !monitoring.Enabled {
+		return 0, ""
+	}
+	port := monitoring.Port
+	if port == 0 {
+		port = llmEngine.Spec.Port
+	}
+	if port == llmEngine.Spec.Port && engineBoundToLocalhost(llmEngine) {
+		// the engine port is not reachable out of the pod
+		port = proxySidecarAdminPort
+	}
+	path := monitoring.Path
+	if path == "" && llmEngine.Spec.EngineType == aitrigramv1.LLMEngineTypeVLLM {
+		path = vllmMetricsPath
+	}
+	return port, path
+}
+
+// Returns the name of the container port of the metrics endpoint
+func metricsPortNameOf(llmEngine *aitrigramv1.LLMEngine, port int32) string {
+	if port == llmEngine.Spec.Port {
+		return "http"
+	}
+	if port == proxySidecarAdminPort && engineBoundToLocalhost(llmEngine) {
+		return proxySidecarAdminPortName
+	}
+	return metricsPortName
+}
+
+// The PodMonitor is only available when the Prometheus Operator CRDs are installed
+func podMonitorAvailable(c client.Client) bool {
+	_, err := c.RESTMapper().RESTMapping(podMonitorGVK.GroupKind(), podMonitorGVK.Version)
+	return err == nil
+}
+
+// Adds the metrics port to the engine container, and the scrape annotations to the pods when there is no PodMonitor
+func applyMonitoring(template *corev1.PodTemplateSpec, llmEngine *aitrigramv1.LLMEngine, podMonitor bool) {
+	port, path := engineMetricsEndpoint(llmEngine)
+	if path == "" {
+		return
+	}
+	portName := metricsPortNameOf(llmEngine, port)
+	if portName == metricsPortName {
+		engine := &template.Spec.Containers[0]
+		engine.Ports = append(engine.Ports, corev1.ContainerPort{ContainerPort: port, Name: metricsPortName})
+	}
+	if podMonitor {
+		return
+	}
+	template.Annotations = MergeMaps(template.Annotations, map[string]string{
+		prometheusScrapeAnnotation: "true",
+		prometheusPortAnnotation:   strconv.Itoa(int(port)),
+		prometheusPathAnnotation:   path,
+	})
+}
+
+// Reconcile the PodMonitor which scrapes the metrics of the engine in the pods of the LLM model.
+// The PodMonitor is handled as unstructured so that the Prometheus Operator CRDs are only needed when they are installed,
+// and it is deleted once the monitoring is disabled.
+func (r *LLMModelReconciler) reconcileLLMPodMonitor(ctx context.Context, req ctrl.Request, params ReconcileParams) error {
+	logger := log.FromContext(ctx)
+	if !podMonitorAvailable(r.Client) {
+		return nil
+	}
+	nameSpaceName := types.NamespacedName{
+		Namespace: req.Namespace,
+		Name:      llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name),
+	}
+	podMonitor := &unstructured.Unstructured{}
+	podMonitor.SetGroupVersionKind(podMonitorGVK)
+	err := r.Get(ctx, nameSpaceName, podMonitor)
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "Failed to get the PodMonitor for LLMModel")
+		return err
+	}
+	exists := err == nil
+
+	if _, path := engineMetricsEndpoint(params.llmEngine); path == "" {
+		if exists && metav1.IsControlledBy(podMonitor, params.model) {
+			logger.Info("Monitoring is disabled, deleting the PodMonitor", "PodMonitor.Name", podMonitor.GetName())
+			return client.IgnoreNotFound(r.Delete(ctx, podMonitor))
+		}
+		return nil
+	}
+
+	desired, err := r.newLLMPodMonitor(nameSpaceName, params)
+	if err != nil {
+		logger.Error(err, "Failed to define new PodMonitor resource for LLMModel")
+		return err
+	}
+	if !exists {
+		logger.Info("Creating a new PodMonitor", "PodMonitor.Namespace", desired.GetNamespace(), "PodMonitor.Name", desired.GetName())
+		return r.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepDerivative(desired.Object["spec"], podMonitor.Object["spec"]) &&
+		equality.Semantic.DeepDerivative(desired.GetLabels(), podMonitor.GetLabels()) {
+		logger.Info("PodMonitor is already up-to-date")
+		return nil
+	}
+	patch := client.MergeFrom(podMonitor.DeepCopy())
+	podMonitor.Object["spec"] = desired.Object["spec"]
+	podMonitor.SetLabels(MergeMaps(podMonitor.GetLabels(), desired.GetLabels()))
+	if err := r.Patch(ctx, podMonitor, patch); err != nil {
+		logger.Error(err, "Failed to update the PodMonitor")
+		return err
+	}
+	return nil
+}
+
+func (r *LLMModelReconciler) newLLMPodMonitor(nameSpaceName types.NamespacedName, params ReconcileParams) (*unstructured.Unstructured, error) {
+	monitoring := params.llmEngine.Spec.Monitoring
+	port, path := engineMetricsEndpoint(params.llmEngine)
+	endpoint := map[string]interface{}{
+		"port": metricsPortNameOf(params.llmEngine, port),
+		"path": path,
+	}
+	if monitoring.Interval != "" {
+		endpoint["interval"] = monitoring.Interval
+	}
+	matchLabels := map[string]interface{}{}
+	for key, value := range llmModelLabels(nameSpaceName.Name) {
+		matchLabels[key] = value
+	}
+	spec := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": matchLabels,
+		},
+		"podMetricsEndpoints": []interface{}{endpoint},
+	}
+	podMonitor := &unstructured.Unstructured{Object: map[string]interface{}{"spec": spec}}
+	podMonitor.SetGroupVersionKind(podMonitorGVK)
+	podMonitor.SetName(nameSpaceName.Name)
+	podMonitor.SetNamespace(nameSpaceName.Namespace)
+	podMonitor.SetLabels(MergeMaps(llmModelLabels(nameSpaceName.Name), monitoring.Labels))
+	// Set the ownerRef for the PodMonitor
+	if err := ctrl.SetControllerReference(params.model, podMonitor, r.Scheme); err != nil {
+		return nil, err
+	}
+	return podMonitor, nil
+}
@@ -0,0 +1,372 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// The labels of the operator pods, which connect to the model pods to load the adapters and to fetch the activity
+// and the quota usage from the proxy sidecar
+var operatorPodLabels = map[string]string{
+	"control-plane":          "controller-manager",
+	"app.kubernetes.io/name": "aitrigram",
+}
+
+const (
+	// ModelDownloadLabel selects the pods which are downloading the model or the adapters of the LLMModel into the
+	// NetworkPolicy of the download, its value is the name of the resources of the model.
+	ModelDownloadLabel = "aitrigram.ihomeland.cn/download"
+	// the init container which holds the engine container until the download label is removed from the pod
+	downloadGateContainerName = "wait-download-egress"
+	podInfoVolumeName         = "podinfo"
+	podInfoMountPath          = "/etc/podinfo"
+	// how often the pods are checked for the end of the download
+	downloadCheckInterval = 5 * time.Second
+)
+
+// Returns the NetworkPolicySpec of the model, the one of the model replaces the one of the engine
+func networkPolicyOf(params ReconcileParams) *aitrigramv1.NetworkPolicySpec {
+	if params.model.Spec.NetworkPolicy != nil {
+		return params.model.Spec.NetworkPolicy
+	}
+	return params.llmEngine.Spec.NetworkPolicy
+}
+
+func networkPolicyEnabled(params ReconcileParams) bool {
+	spec := networkPolicyOf(params)
+	return spec != nil && spec.Enabled
+}
+
+// The name of the NetworkPolicy of the download of the model
+func llmDownloadNetworkPolicyName(name string) string {
+	return name + "-download"
+}
+
+func networkPolicyPort(protocol corev1.Protocol, port int32) networkingv1.NetworkPolicyPort {
+	return networkingv1.NetworkPolicyPort{
+		Protocol: ptr.To(protocol),
+		Port:     ptr.To(intstr.FromInt32(port)),
+	}
+}
+
+// Labels the new pods as downloading the model, and holds their engine container with an init container until the
+// operator removes the label once the model is downloaded, so that the engine container never runs while the pod
+// is selected by the NetworkPolicy of the download. The labels of the pod are read from the downward API, which
+// reflects the removed label in a while.
+func applyDownloadGate(template *corev1.PodTemplateSpec, name string, image string) {
+	template.Labels = maps.Clone(template.Labels)
+	template.Labels[ModelDownloadLabel] = name
+	template.Spec.InitContainers = append(template.Spec.InitContainers, corev1.Container{
+		Name:    downloadGateContainerName,
+		Image:   image,
+		Command: []string{"/bin/sh", "-c"},
+		Args: []string{fmt.Sprintf("while grep -q '^%s=' %s/labels; do sleep 2; done",
+			ModelDownloadLabel, podInfoMountPath)},
+		VolumeMounts: []corev1.VolumeMount{{Name: podInfoVolumeName, MountPath: podInfoMountPath, ReadOnly: true}},
+	})
+	template.Spec.Volumes = append(template.Spec.Volumes, corev1.Volume{
+		Name: podInfoVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{{
+					Path:     "labels",
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels"},
+				}},
+			},
+		},
+	})
+}
+
+// The init container of the pod has downloaded the model, it runs again with the download label put back when
+// the pod is restarted
+func modelDownloaded(pod *corev1.Pod, initContainerName string) bool {
+	for _, status := range pod.Status.InitContainerStatuses {
+		if status.Name == initContainerName {
+			return status.State.Terminated != nil && status.State.Terminated.ExitCode == 0
+		}
+	}
+	return false
+}
+
+// Removes the download label from the pods which have downloaded the model, and puts it back on the ones which
+// download it again. It returns whether any pod is still downloading the model.
+func (r *LLMModelReconciler) reconcileDownloadLabels(ctx context.Context, deployment *appsv1.Deployment, initContainerName string) (bool, error) {
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(deployment.Namespace), client.MatchingLabels(deployment.Spec.Selector.MatchLabels)); err != nil {
+		return false, err
+	}
+	downloading := false
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+		downloaded := modelDownloaded(pod, initContainerName)
+		downloading = downloading || !downloaded
+		if _, labeled := pod.Labels[ModelDownloadLabel]; labeled != downloaded {
+			continue
+		}
+		patch := client.MergeFrom(pod.DeepCopy())
+		if downloaded {
+			delete(pod.Labels, ModelDownloadLabel)
+		} else {
+			if pod.Labels == nil {
+				pod.Labels = map[string]string{}
+			}
+			pod.Labels[ModelDownloadLabel] = deployment.Name
+		}
+		log.FromContext(ctx).Info("Updating the download label of the pod", "Pod.Name", pod.Name, "downloading", !downloaded)
+		if err := r.Patch(ctx, pod, patch); err != nil {
+			return false, client.IgnoreNotFound(err)
+		}
+	}
+	return downloading, nil
+}
+
+// Reconcile the NetworkPolicies of the pods of the LLM model, the one of the serving and the one of the download.
+// They are deleted when the NetworkPolicy is disabled. It returns whether any pod is still downloading the model.
+func (r *LLMModelReconciler) reconcileLLMNetworkPolicy(ctx context.Context, req ctrl.Request, params ReconcileParams, deployment *appsv1.Deployment) (bool, error) {
+	name := llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name)
+	if !networkPolicyEnabled(params) {
+		for _, policyName := range []string{name, llmDownloadNetworkPolicyName(name)} {
+			if err := r.deleteLLMNetworkPolicy(ctx, params.model, types.NamespacedName{Namespace: req.Namespace, Name: policyName}); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	nameSpaceName := &types.NamespacedName{Namespace: req.Namespace, Name: name}
+	desired, err := r.newLLMModelNetworkPolicy(nameSpaceName, params)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to define new NetworkPolicy resource for LLMModel")
+		return false, err
+	}
+	if err := r.applyLLMNetworkPolicy(ctx, desired); err != nil {
+		return false, err
+	}
+	desired, err = r.newLLMDownloadNetworkPolicy(nameSpaceName, params)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "Failed to define new NetworkPolicy resource for the download of LLMModel")
+		return false, err
+	}
+	if err := r.applyLLMNetworkPolicy(ctx, desired); err != nil {
+		return false, err
+	}
+	if deployment == nil {
+		return false, nil
+	}
+	return r.reconcileDownloadLabels(ctx, deployment, "init-"+deployment.Name)
+}
+
+func (r *LLMModelReconciler) deleteLLMNetworkPolicy(ctx context.Context, llmModel *aitrigramv1.LLMModel, key types.NamespacedName) error {
+	networkPolicy := &networkingv1.NetworkPolicy{}
+	if err := r.Get(ctx, key, networkPolicy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(networkPolicy, llmModel) {
+		return nil
+	}
+	log.FromContext(ctx).Info("NetworkPolicy is disabled, deleting the NetworkPolicy", "NetworkPolicy.Name", networkPolicy.Name)
+	return client.IgnoreNotFound(r.Delete(ctx, networkPolicy))
+}
+
+// Creates the NetworkPolicy, or updates it when it differs from the desired one
+func (r *LLMModelReconciler) applyLLMNetworkPolicy(ctx context.Context, desired *networkingv1.NetworkPolicy) error {
+	logger := log.FromContext(ctx)
+	networkPolicy := &networkingv1.NetworkPolicy{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), networkPolicy)
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "Failed to get the NetworkPolicy for LLMModel")
+		return err
+	}
+	if err != nil {
+		logger.Info("Creating a new NetworkPolicy", "NetworkPolicy.Namespace", desired.Namespace, "NetworkPolicy.Name", desired.Name)
+		return r.Create(ctx, desired)
+	}
+	// the rules removed from the desired ones are ignored by DeepDerivative
+	if len(desired.Spec.Ingress) == len(networkPolicy.Spec.Ingress) &&
+		len(desired.Spec.Egress) == len(networkPolicy.Spec.Egress) &&
+		equality.Semantic.DeepDerivative(desired.Spec, networkPolicy.Spec) {
+		logger.Info("NetworkPolicy is already up-to-date", "NetworkPolicy.Name", desired.Name)
+		return nil
+	}
+	patch := client.MergeFrom(networkPolicy.DeepCopy())
+	networkPolicy.Spec = desired.Spec
+	if err := r.Patch(ctx, networkPolicy, patch); err != nil {
+		logger.Error(err, "Failed to update the NetworkPolicy", "NetworkPolicy.Name", desired.Name)
+		return err
+	}
+	return nil
+}
+
+// The egress to the DNS, which is allowed to all pods of the model
+func dnsEgressRule() networkingv1.NetworkPolicyEgressRule {
+	return networkingv1.NetworkPolicyEgressRule{
+		Ports: []networkingv1.NetworkPolicyPort{
+			networkPolicyPort(corev1.ProtocolUDP, 53),
+			networkPolicyPort(corev1.ProtocolTCP, 53),
+		},
+	}
+}
+
+func (r *LLMModelReconciler) newLLMModelNetworkPolicy(nameSpaceName *types.NamespacedName, params ReconcileParams) (*networkingv1.NetworkPolicy, error) {
+	spec := networkPolicyOf(params)
+	llmEngine := params.llmEngine
+
+	// the requests come in on the target ports of the Service
+	servedPort := llmEngine.Spec.Port
+	if proxySidecarEnabled(params) {
+		servedPort = proxySidecarPort
+	}
+	var from []networkingv1.NetworkPolicyPeer
+	if len(spec.Namespaces) > 0 {
+		from = append(from, networkingv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{
+					Key:      corev1.LabelMetadataName,
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   spec.Namespaces,
+				}},
+			},
+		})
+	}
+	from = append(from, spec.From...)
+	if len(from) == 0 {
+		from = append(from, networkingv1.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{}})
+	}
+	if gateway := llmEngine.Spec.Gateway; gateway != nil && gateway.Enabled {
+		from = append(from, networkingv1.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{MatchLabels: llmGatewayLabels(llmGatewayName(llmEngine))},
+		})
+	}
+	var servedPorts []networkingv1.NetworkPolicyPort
+	if plaintextEnabled(llmEngine) {
+		servedPorts = append(servedPorts, networkPolicyPort(corev1.ProtocolTCP, servedPort))
+	}
+	if tlsEnabled(llmEngine) {
+		servedPorts = append(servedPorts, networkPolicyPort(corev1.ProtocolTCP, proxySidecarTLSPort))
+	}
+	ingress := []networkingv1.NetworkPolicyIngressRule{{
+		Ports: servedPorts,
+		From:  from,
+	}}
+
+	// the operator loads the adapters on the engine port, or on the admin port of the sidecar when the engine only
+	// listens on the loopback address, and reads the activity and the quota usage from the admin port of the sidecar.
+	// Only the operator pods in the namespace of the operator are allowed, the labels of the pods can be copied by
+	// anyone who can create pods.
+	var operatorPorts []networkingv1.NetworkPolicyPort
+	if !engineBoundToLocalhost(llmEngine) {
+		operatorPorts = append(operatorPorts, networkPolicyPort(corev1.ProtocolTCP, llmEngine.Spec.Port))
+	}
+	if proxySidecarEnabled(params) {
+		operatorPorts = append(operatorPorts, networkPolicyPort(corev1.ProtocolTCP, proxySidecarAdminPort))
+	}
+	ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+		Ports: operatorPorts,
+		From: []networkingv1.NetworkPolicyPeer{{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{corev1.LabelMetadataName: r.OperatorNamespace}},
+			PodSelector:       &metav1.LabelSelector{MatchLabels: operatorPodLabels},
+		}},
+	})
+
+	// the metrics are scraped by the peers of the metrics, only on a port apart from the one of the engine, which
+	// takes the requests to the models without the API keys
+	if metricsPort, path := engineMetricsEndpoint(llmEngine); path != "" && len(spec.MetricsFrom) > 0 &&
+		metricsPort != llmEngine.Spec.Port && metricsPort != servedPort {
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			Ports: []networkingv1.NetworkPolicyPort{networkPolicyPort(corev1.ProtocolTCP, metricsPort)},
+			From:  spec.MetricsFrom,
+		})
+	}
+
+	networkPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nameSpaceName.Name,
+			Namespace: nameSpaceName.Namespace,
+			Labels:    llmModelLabels(nameSpaceName.Name),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: llmModelLabels(nameSpaceName.Name),
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress:     ingress,
+			Egress:      []networkingv1.NetworkPolicyEgressRule{dnsEgressRule()},
+		},
+	}
+	// Set the ownerRef for the NetworkPolicy
+	if err := ctrl.SetControllerReference(params.model, networkPolicy, r.Scheme); err != nil {
+		return nil, err
+	}
+	return networkPolicy, nil
+}
+
+// Returns the NetworkPolicy of the download, which selects the model pods with the download label and the Jobs
+// downloading the adapters of the model. It allows no ingress, the model pods get theirs from the other one.
+func (r *LLMModelReconciler) newLLMDownloadNetworkPolicy(nameSpaceName *types.NamespacedName, params ReconcileParams) (*networkingv1.NetworkPolicy, error) {
+	spec := networkPolicyOf(params)
+	egress := []networkingv1.NetworkPolicyEgressRule{dnsEgressRule()}
+	if len(spec.DownloadEgress) > 0 {
+		egress = append(egress, spec.DownloadEgress...)
+	} else {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{
+			Ports: []networkingv1.NetworkPolicyPort{
+				networkPolicyPort(corev1.ProtocolTCP, 80),
+				networkPolicyPort(corev1.ProtocolTCP, 443),
+			},
+		})
+	}
+	networkPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      llmDownloadNetworkPolicyName(nameSpaceName.Name),
+			Namespace: nameSpaceName.Namespace,
+			Labels:    llmModelLabels(nameSpaceName.Name),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{ModelDownloadLabel: nameSpaceName.Name},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Egress:      egress,
+		},
+	}
+	if err := ctrl.SetControllerReference(params.model, networkPolicy, r.Scheme); err != nil {
+		return nil, err
+	}
+	return networkPolicy, nil
+}
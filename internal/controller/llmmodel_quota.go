@@ -0,0 +1,182 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+const (
+	// the volume of the quota ConfigMap in the model pods
+	quotaVolumeName = "quota"
+	// where the quota ConfigMap is mounted in the proxy sidecar
+	quotaMountPath = "/etc/aitrigram/quota"
+)
+
+// The name of the ConfigMap which holds the quota config of the proxy sidecars of a LLMModel
+func llmQuotaConfigMapName(resourceName string) string {
+	return resourceName + "-quota"
+}
+
+// Returns the LLMQuotas in the namespace of the LLMModel which refer to it, sorted by their names
+func llmQuotasOfModel(ctx context.Context, c client.Reader, llmModel *aitrigramv1.LLMModel) ([]aitrigramv1.LLMQuota, error) {
+	quotaList := &aitrigramv1.LLMQuotaList{}
+	if err := c.List(ctx, quotaList, client.InNamespace(llmModel.Namespace)); err != nil {
+		return nil, err
+	}
+	var quotas []aitrigramv1.LLMQuota
+	for _, quota := range quotaList.Items {
+		if quota.Spec.ModelRef == llmModel.Name && quota.GetDeletionTimestamp().IsZero() {
+			quotas = append(quotas, quota)
+		}
+	}
+	sort.Slice(quotas, func(i, j int) bool {
+		return quotas[i].Name < quotas[j].Name
+	})
+	return quotas, nil
+}
+
+// The LLMQuotas are only enforced on the LLMModels served by a single pod, the proxy sidecar of each pod counts the
+// requests on its own, so more replicas would let the clients use up to the replicas times of the limits.
+func quotaEnforceable(llmModel *aitrigramv1.LLMModel) bool {
+	return llmModel.Spec.Autoscaling == nil && llmModel.Spec.Replicas <= 1
+}
+
+// Enqueues the LLMModel the LLMQuota refers to
+func llmModelOfQuota(_ context.Context, obj client.Object) []reconcile.Request {
+	quota, ok := obj.(*aitrigramv1.LLMQuota)
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: quota.Namespace, Name: quota.Spec.ModelRef}}}
+}
+
+// Builds the quota config of the proxy sidecar from the LLMQuotas. The limit of an API key in more than one LLMQuota
+// is taken from the first one by name, and the limits of the API keys not in the engine are skipped.
+func quotaConfigOf(llmEngine *aitrigramv1.LLMEngine, quotas []aitrigramv1.LLMQuota) proxy.QuotaConfig {
+	config := proxy.QuotaConfig{Limits: []proxy.QuotaLimit{}}
+	seen := map[string]bool{}
+	for _, quota := range quotas {
+		for _, limit := range quota.Spec.Limits {
+			file := ""
+			if limit.APIKey != nil {
+				var ok bool
+				if file, ok = apiKeyFileOf(llmEngine, limit.APIKey); !ok {
+					continue
+				}
+			}
+			if seen[file] {
+				continue
+			}
+			seen[file] = true
+			config.Limits = append(config.Limits, proxy.QuotaLimit{
+				APIKeyFile:        file,
+				RequestsPerSecond: float64(limit.RequestsPerSecond),
+				TokensPerMinute:   limit.TokensPerMinute,
+			})
+		}
+	}
+	return config
+}
+
+// Mounts the quota ConfigMap into the proxy sidecar and passes the quota file to it
+func applyQuota(podSpec *corev1.PodSpec, resourceName string) {
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if container.Name != proxySidecarName {
+			continue
+		}
+		container.Args = append(container.Args, "--quota-config="+path.Join(quotaMountPath, proxy.QuotaFileName))
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      quotaVolumeName,
+			MountPath: quotaMountPath,
+			ReadOnly:  true,
+		})
+	}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: quotaVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: llmQuotaConfigMapName(resourceName)},
+			},
+		},
+	})
+}
+
+// Reconcile the ConfigMap of the quota config read by the proxy sidecars, it is updated in place when the LLMQuotas
+// change, and deleted when there is no LLMQuota of the model.
+func (r *LLMModelReconciler) reconcileLLMQuotaConfigMap(ctx context.Context, req ctrl.Request, params ReconcileParams) error {
+	logger := log.FromContext(ctx)
+	resourceName := llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name)
+	configMap := &corev1.ConfigMap{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: llmQuotaConfigMapName(resourceName)}, configMap)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if len(params.quotas) == 0 {
+		if exists && metav1.IsControlledBy(configMap, params.model) {
+			logger.Info("There is no LLMQuota, deleting the quota ConfigMap", "ConfigMap.Name", configMap.Name)
+			return client.IgnoreNotFound(r.Delete(ctx, configMap))
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(quotaConfigOf(params.llmEngine, params.quotas), "", "  ")
+	if err != nil {
+		return err
+	}
+	desired := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      llmQuotaConfigMapName(resourceName),
+			Namespace: req.Namespace,
+			Labels:    llmModelLabels(resourceName),
+		},
+		Data: map[string]string{
+			proxy.QuotaFileName: string(data),
+		},
+	}
+	if err := ctrl.SetControllerReference(params.model, desired, r.Scheme); err != nil {
+		return err
+	}
+	if !exists {
+		logger.Info("Creating the quota ConfigMap", "ConfigMap.Name", desired.Name)
+		return r.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepEqual(desired.Data, configMap.Data) {
+		return nil
+	}
+	logger.Info("Updating the quota ConfigMap", "ConfigMap.Name", desired.Name)
+	patch := client.MergeFrom(configMap.DeepCopy())
+	configMap.Data = desired.Data
+	return r.Patch(ctx, configMap, patch)
+}
@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// the flag of vllm to pin the revision of the model
+	vllmRevisionFlag = "--revision"
+	// the length of the model ID shown by `ollama list`, which is the prefix of the digest
+	ollamaModelIDLength = 12
+)
+
+// Appends the commands which write the digest of the pulled model into the termination message of the init container,
+// and fail the init container when it does not match the pinned Revision, so the old pods keep serving.
+func appendOllamaRevisionScripts(downloadScripts string, llmModel *aitrigramv1.LLMModel) string {
+	if downloadScripts == "" {
+		return downloadScripts
+	}
+	model := llmModelPullName(llmModel)
+	// ollama list shows the models with their tags
+	if !strings.Contains(model[strings.LastIndex(model, "/")+1:], ":") {
+		model += ":latest"
+	}
+	var out strings.Builder
+	out.WriteString(downloadScripts)
+	fmt.Fprintf(&out, ` && revision=$(ollama list | awk -v m=%s '$1 == m {print $2}') && printf '%%s' "$revision" > %s`,
+		shellQuote(model), corev1.TerminationMessagePathDefault)
+	if pinned := ollamaModelID(llmModel.Spec.Revision); pinned != "" {
+		fmt.Fprintf(&out, ` && case "$revision" in %s*) ;; *) echo %s >&2 && exit 1 ;; esac`, shellQuote(pinned),
+			shellQuote(fmt.Sprintf("The pulled %s does not match the pinned revision %s", model, llmModel.Spec.Revision)))
+	}
+	return out.String()
+}
+
+// Returns the model ID of ollama from the digest, like: a80c4f17acd5 from sha256:a80c4f17acd55265feec...
+func ollamaModelID(digest string) string {
+	id := strings.TrimPrefix(digest, "sha256:")
+	if len(id) > ollamaModelIDLength {
+		id = id[:ollamaModelIDLength]
+	}
+	return id
+}
+
+// Returns the args of vllm with the pinned Revision
+func vllmRevisionArgs(args []string, revision string) []string {
+	if revision == "" || slices.Contains(args, vllmRevisionFlag) {
+		return args
+	}
+	return append(slices.Clone(args), vllmRevisionFlag, revision)
+}
+
+// The readiness of the engine container, the pods are not ready before the model is served
+func engineReadinessProbe(engineType aitrigramv1.LLMEngineType) *corev1.Probe {
+	handler := corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("http")}}
+	switch engineType {
+	case aitrigramv1.LLMEngineTypeOllama:
+		handler = corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/", Port: intstr.FromString("http")}}
+	case aitrigramv1.LLMEngineTypeVLLM:
+		handler = corev1.ProbeHandler{HTTPGet: &corev1.HTTPGetAction{Path: "/health", Port: intstr.FromString("http")}}
+	}
+	return &corev1.Probe{
+		ProbeHandler:        handler,
+		InitialDelaySeconds: 5,
+		PeriodSeconds:       10,
+	}
+}
+
+// Returns the revision downloaded by the newest ready pod, and the distinct revisions of all ready pods.
+// The revision is the termination message of the init container downloading the model.
+func servingRevisions(ctx context.Context, c client.Reader, namespace string, labels map[string]string, initContainerName string) (string, []string, error) {
+	pods, err := readyModelPods(ctx, c, namespace, labels)
+	if err != nil {
+		return "", nil, err
+	}
+	slices.SortFunc(pods, func(a, b corev1.Pod) int {
+		return b.CreationTimestamp.Compare(a.CreationTimestamp.Time)
+	})
+	resolved := ""
+	revisions := []string{}
+	for _, pod := range pods {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name != initContainerName || status.State.Terminated == nil {
+				continue
+			}
+			revision := strings.TrimSpace(status.State.Terminated.Message)
+			if revision == "" {
+				continue
+			}
+			if resolved == "" {
+				resolved = revision
+			}
+			if !slices.Contains(revisions, revision) {
+				revisions = append(revisions, revision)
+			}
+		}
+	}
+	slices.Sort(revisions)
+	return resolved, revisions, nil
+}
+
+// Records the revisions downloaded by the ready pods of the model, they are kept while the model has no ready pod
+func (r *LLMModelReconciler) updateLLMModelRevisions(ctx context.Context, req ctrl.Request, labels map[string]string, initContainerName string) error {
+	resolved, revisions, err := servingRevisions(ctx, r.Client, req.Namespace, labels, initContainerName)
+	if err != nil || resolved == "" {
+		return err
+	}
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, req.NamespacedName, llmModel); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if llmModel.Status.ResolvedRevision == resolved && slices.Equal(llmModel.Status.ServingRevisions, revisions) {
+		return nil
+	}
+	llmModel.Status.ResolvedRevision = resolved
+	llmModel.Status.ServingRevisions = revisions
+	return r.Status().Update(ctx, llmModel)
+}
@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+const (
+	// WakeRequestedAnnotation is set on the LLMModel by the activator when a request comes
+	// to a model scaled to zero, the value is the time of the request in RFC3339 format.
+	WakeRequestedAnnotation = "aitrigram.ihomeland.cn/wake-requested-at"
+
+	// the port the proxy sidecar listens on inside of the model pods
+	proxySidecarPort int32 = 15080
+	// the name of the proxy sidecar container
+	proxySidecarName = "proxy"
+	// how soon the activity is read again when it could not be read from some pods
+	activityRetryPeriod = 10 * time.Second
+)
+
+// reconcileScaleToZero decides if the model should be scaled to zero according to the activity
+// reported by the proxy sidecars, and records the decision in the status.
+// It returns if the model is scaled to zero and when the activity should be checked again.
+func (r *LLMModelReconciler) reconcileScaleToZero(ctx context.Context, req ctrl.Request, params ReconcileParams) (bool, time.Duration, error) {
+	logger := log.FromContext(ctx)
+	llmModel := params.model
+	scaleToZero := llmModel.Spec.ScaleToZero
+	if scaleToZero == nil {
+		if llmModel.Status.Phase == aitrigramv1.LLMModelPhaseScaledToZero {
+			return false, 0, r.updateLLMModelActivity(ctx, req, aitrigramv1.LLMModelPhaseRunning, llmModel.Status.LastRequestTime)
+		}
+		return false, 0, nil
+	}
+
+	lastRequest := llmModel.CreationTimestamp.Time
+	if llmModel.Status.LastRequestTime != nil {
+		lastRequest = llmModel.Status.LastRequestTime.Time
+	}
+	wakeRequested, err := wakeRequestedAt(llmModel)
+	if err != nil {
+		logger.Error(err, "Ignoring the invalid annotation", "annotation", WakeRequestedAnnotation)
+	}
+
+	if llmModel.Status.Phase == aitrigramv1.LLMModelPhaseScaledToZero {
+		if wakeRequested.After(lastRequest) {
+			logger.Info("Waking up the LLMModel scaled to zero", "wakeRequestedAt", wakeRequested)
+			lastRequestTime := metav1.NewTime(wakeRequested)
+			return false, scaleToZero.IdleTimeout.Duration, r.updateLLMModelActivity(ctx, req, aitrigramv1.LLMModelPhaseRunning, &lastRequestTime)
+		}
+		// it stays at zero until the activator asks to wake it up
+		return true, 0, nil
+	}
+
+	if wakeRequested.After(lastRequest) {
+		lastRequest = wakeRequested
+	}
+	pods, err := readyModelPods(ctx, r.Client, req.Namespace, llmModelLabels(llmModelResourceName(params.llmEngine.Spec.EngineType, llmModel.Spec.Name)))
+	if err != nil {
+		return false, 0, err
+	}
+	// the model is only scaled to zero once the activity is read from every ready pod, a pod which can not
+	// be read may still be serving
+	unread := 0
+	for i := range pods {
+		activity, err := r.fetchActivity(ctx, &pods[i])
+		if err != nil {
+			logger.Error(err, "Failed to get the activity from the proxy sidecar", "Pod.Name", pods[i].Name)
+			unread++
+			continue
+		}
+		if activity.InFlight > 0 {
+			lastRequest = time.Now()
+			break
+		}
+		if activity.LastRequestTime.After(lastRequest) {
+			lastRequest = activity.LastRequestTime
+		}
+	}
+
+	// the status only keeps the time in seconds
+	lastRequest = lastRequest.Truncate(time.Second)
+	idle := time.Since(lastRequest)
+	lastRequestTime := metav1.NewTime(lastRequest)
+	if idle >= scaleToZero.IdleTimeout.Duration && unread > 0 {
+		logger.Info("Not scaling the LLMModel to zero without the activity of every ready pod", "idle", idle.String(), "unread", unread)
+		return false, activityRetryPeriod, nil
+	}
+	if idle >= scaleToZero.IdleTimeout.Duration {
+		logger.Info("Scaling the LLMModel to zero", "idle", idle.String())
+		return true, 0, r.updateLLMModelActivity(ctx, req, aitrigramv1.LLMModelPhaseScaledToZero, &lastRequestTime)
+	}
+	if llmModel.Status.LastRequestTime == nil || !llmModel.Status.LastRequestTime.Time.Equal(lastRequest) {
+		if err := r.updateLLMModelActivity(ctx, req, aitrigramv1.LLMModelPhaseRunning, &lastRequestTime); err != nil {
+			return false, 0, err
+		}
+	}
+	return false, scaleToZero.IdleTimeout.Duration - idle, nil
+}
+
+func wakeRequestedAt(llmModel *aitrigramv1.LLMModel) (time.Time, error) {
+	value, ok := llmModel.Annotations[WakeRequestedAnnotation]
+	if !ok {
+		return time.Time{}, nil
+	}
+	wakeRequested, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return wakeRequested, nil
+}
+
+// Returns the ready pods matching the labels, like the pods of the model Deployment
+func readyModelPods(ctx context.Context, c client.Reader, namespace string, labels map[string]string) ([]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	if err := c.List(ctx, podList, client.InNamespace(namespace), client.MatchingLabels(labels)); err != nil {
+		return nil, err
+	}
+	var pods []corev1.Pod
+	for _, pod := range podList.Items {
+		if pod.Status.PodIP != "" && isPodReady(&pod) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func (r *LLMModelReconciler) fetchActivity(ctx context.Context, pod *corev1.Pod) (*proxy.Activity, error) {
+	if r.activityFetcher != nil {
+		return r.activityFetcher(ctx, pod)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return proxy.FetchActivity(ctx, http.DefaultClient, fmt.Sprintf("http://%s:%d", pod.Status.PodIP, proxySidecarAdminPort))
+}
+
+// The requests go through the proxy sidecar when the model scales to zero, the API keys are checked, there are quotas,
+// or the TLS is terminated
+func proxySidecarEnabled(params ReconcileParams) bool {
+	return params.model.Spec.ScaleToZero != nil || authEnabled(params.llmEngine) || len(params.quotas) > 0 || tlsEnabled(params.llmEngine)
+}
+
+// The proxy sidecar which tracks the requests sent to the engine container, and checks their API keys when needed
+func proxySidecar(image string, enginePort int32) corev1.Container {
+	return corev1.Container{
+		Name:            proxySidecarName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/manager"},
+		Args: []string{
+			"proxy",
+			fmt.Sprintf("--listen=:%d", proxySidecarPort),
+			fmt.Sprintf("--upstream=http://127.0.0.1:%d", enginePort),
+			fmt.Sprintf("--admin-listen=:%d", proxySidecarAdminPort),
+		},
+		Ports: []corev1.ContainerPort{{
+			ContainerPort: proxySidecarPort,
+			Name:          proxySidecarName,
+		}, {
+			ContainerPort: proxySidecarAdminPort,
+			Name:          proxySidecarAdminPortName,
+		}},
+		SecurityContext: restrictedSecurityContext(),
+	}
+}
+
+// The service points to the activator when there is no ready pod to serve the requests
+func activatorMode(llmModel *aitrigramv1.LLMModel, deployment *appsv1.Deployment, scaledToZero bool) bool {
+	if llmModel.Spec.ScaleToZero == nil {
+		return false
+	}
+	return scaledToZero || deployment == nil || deployment.Status.ReadyReplicas == 0
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	tmpVolumeName = "tmp"
+	tmpMountPath  = "/tmp"
+)
+
+// Returns the template of the Restricted security profile of the engine type, which is compatible with the restricted
+// Pod Security Standard, or nil for the engine types without a profile
+func restrictedProfileTemplate(engineType aitrigramv1.LLMEngineType) *aitrigramv1.ModelDeploymentTemplate {
+	switch engineType {
+	case aitrigramv1.LLMEngineTypeOllama:
+		return &aitrigramv1.ModelDeploymentTemplate{
+			// ollama keeps its keys in the home directory as the root filesystem is read only
+			Envs:               &[]corev1.EnvVar{{Name: "HOME", Value: "/cache_dir"}},
+			PodSecurityContext: restrictedPodSecurityContext(),
+			SecurityContext:    restrictedSecurityContext(),
+		}
+	case aitrigramv1.LLMEngineTypeVLLM:
+		return &aitrigramv1.ModelDeploymentTemplate{
+			// vllm keeps the Hugging Face, the compile and the triton caches in the home directory, which is not
+			// writable for the user 1000 in the vllm images
+			Envs:               &[]corev1.EnvVar{{Name: "HOME", Value: tmpMountPath}},
+			PodSecurityContext: restrictedPodSecurityContext(),
+			SecurityContext:    restrictedSecurityContext(),
+		}
+	}
+	return nil
+}
+
+// The pod securityContext of the engine profiles, which is compatible with the restricted Pod Security Standard
+func restrictedPodSecurityContext() *corev1.PodSecurityContext {
+	return &corev1.PodSecurityContext{
+		RunAsNonRoot: ptr.To(true),
+		RunAsUser:    ptr.To(int64(1000)),
+		RunAsGroup:   ptr.To(int64(1000)),
+		FSGroup:      ptr.To(int64(1000)),
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// The container securityContext of the engine profiles and of the containers run by the operator image
+func restrictedSecurityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             ptr.To(true),
+		AllowPrivilegeEscalation: ptr.To(false),
+		ReadOnlyRootFilesystem:   ptr.To(true),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &corev1.SeccompProfile{
+			Type: corev1.SeccompProfileTypeRuntimeDefault,
+		},
+	}
+}
+
+// Applies the securityContexts of the template to the pod, the init containers and the engine container.
+// The engine and the download scripts write to /tmp, so an emptyDir is mounted there when the root filesystem
+// is read only, the other writable places are the cache and the models storages.
+func applySecurityContext(podSpec *corev1.PodSpec, template *aitrigramv1.ModelDeploymentTemplate, containerName string) {
+	if template == nil {
+		return
+	}
+	podSpec.SecurityContext = template.PodSecurityContext
+	if template.SecurityContext == nil {
+		return
+	}
+	readOnly := ptr.Deref(template.SecurityContext.ReadOnlyRootFilesystem, false)
+	tmpMount := corev1.VolumeMount{Name: tmpVolumeName, MountPath: tmpMountPath}
+	for i := range podSpec.InitContainers {
+		podSpec.InitContainers[i].SecurityContext = template.SecurityContext
+		if readOnly {
+			podSpec.InitContainers[i].VolumeMounts = append(slices.Clip(podSpec.InitContainers[i].VolumeMounts), tmpMount)
+		}
+	}
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name != containerName {
+			continue
+		}
+		podSpec.Containers[i].SecurityContext = template.SecurityContext
+		if readOnly {
+			podSpec.Containers[i].VolumeMounts = append(slices.Clip(podSpec.Containers[i].VolumeMounts), tmpMount)
+		}
+	}
+	if readOnly {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         tmpVolumeName,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		})
+	}
+}
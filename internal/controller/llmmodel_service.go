@@ -3,9 +3,9 @@ package controller
 import (
 	"context"
 	"reflect"
-	"strings"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -20,7 +20,7 @@ func (r *LLMModelReconciler) reconcileLLMService(ctx context.Context, req ctrl.R
 	// create service for each deployment
 	logger := log.FromContext(ctx)
 
-	serviceName := strings.ToLower(string(serviceParams.llmEngine.Spec.EngineType) + "-" + strings.ReplaceAll(serviceParams.model.Spec.Name, ".", "-"))
+	serviceName := llmModelResourceName(serviceParams.llmEngine.Spec.EngineType, serviceParams.model.Spec.Name)
 	nameSpaceName := &types.NamespacedName{
 		Namespace: req.Namespace,
 		Name:      serviceName,
@@ -40,25 +40,34 @@ func (r *LLMModelReconciler) reconcileLLMService(ctx context.Context, req ctrl.R
 				logger.Error(err, "Failed to create a new Service for LLMEngine")
 				return err
 			}
+			recordEvent(r.Recorder, serviceParams.model, corev1.EventTypeNormal, eventReasonServiceCreated,
+				"Created the Service %s", newService.Name)
 			return nil
 		}
 		logger.Error(err, "Failed to get the service for LLMEngine")
 		return err
 	}
 	// check the update
-	existingCopy := llmService.DeepCopy()
 	desired, err := r.newLLMEngineService(nameSpaceName, serviceParams)
 	if err != nil {
 		logger.Error(err, "Failed to define new Service resource for LLMEngine")
 		return err
 	}
-	// make a deep copy and ignore some fields for comparison
-	desiredCopy := desired.DeepCopy()
-	if reflect.DeepEqual(existingCopy.Spec, desiredCopy.Spec) {
+	// only the fields managed by the operator are compared, others like the ClusterIP are assigned by the api server
+	if reflect.DeepEqual(llmService.Spec.Selector, desired.Spec.Selector) &&
+		len(desired.Spec.Ports) == len(llmService.Spec.Ports) &&
+		equality.Semantic.DeepDerivative(desired.Spec.Ports, llmService.Spec.Ports) &&
+		llmService.Spec.Type == desired.Spec.Type &&
+		llmService.Spec.SessionAffinity == desired.Spec.SessionAffinity {
 		logger.Info("Service is already up-to-date")
 		return nil
 	}
-	if err := r.Client.Patch(ctx, llmService, client.MergeFrom(desired)); err != nil {
+	patch := client.MergeFrom(llmService.DeepCopy())
+	llmService.Spec.Selector = desired.Spec.Selector
+	llmService.Spec.Ports = desired.Spec.Ports
+	llmService.Spec.Type = desired.Spec.Type
+	llmService.Spec.SessionAffinity = desired.Spec.SessionAffinity
+	if err := r.Client.Patch(ctx, llmService, patch); err != nil {
 		logger.Error(err, "Failed to update the service")
 		return err
 	}
@@ -67,7 +76,16 @@ func (r *LLMModelReconciler) reconcileLLMService(ctx context.Context, req ctrl.R
 }
 
 func (r *LLMModelReconciler) newLLMEngineService(nameSpaceName *types.NamespacedName, serviceParams ReconcileParams) (*corev1.Service, error) {
-	appLabels := map[string]string{"app": "aitrigram-llmmodel", "instance": nameSpaceName.Name}
+	appLabels := llmModelLabels(nameSpaceName.Name)
+	targetPort := serviceParams.llmEngine.Spec.Port
+	if proxySidecarEnabled(serviceParams) {
+		targetPort = proxySidecarPort
+	}
+	selector := appLabels
+	if serviceParams.activatorMode {
+		// the endpoints are managed by the operator to point to the activator
+		selector = nil
+	}
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      nameSpaceName.Name,
@@ -75,17 +93,25 @@ func (r *LLMModelReconciler) newLLMEngineService(nameSpaceName *types.Namespaced
 			Labels:    appLabels,
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: appLabels,
-			Ports: []corev1.ServicePort{
-				{
-					Port:       serviceParams.llmEngine.Spec.ServicePort,
-					TargetPort: intstr.FromInt32(serviceParams.llmEngine.Spec.Port),
-				},
-			},
+			Selector:        selector,
 			Type:            corev1.ServiceTypeClusterIP,
 			SessionAffinity: corev1.ServiceAffinityClientIP,
 		},
 	}
+	if plaintextEnabled(serviceParams.llmEngine) {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       servicePortName(serviceParams.llmEngine),
+			Port:       serviceParams.llmEngine.Spec.ServicePort,
+			TargetPort: intstr.FromInt32(targetPort),
+		})
+	}
+	if tlsEnabled(serviceParams.llmEngine) {
+		service.Spec.Ports = append(service.Spec.Ports, corev1.ServicePort{
+			Name:       "https",
+			Port:       tlsServicePort(serviceParams.llmEngine.Spec.TLS),
+			TargetPort: intstr.FromInt32(proxySidecarTLSPort),
+		})
+	}
 	// Set the ownerRef for the Service
 	// More info: https://kubernetes.io/docs/concepts/overview/working-with-objects/owners-dependents/
 	if err := ctrl.SetControllerReference(serviceParams.model, service, r.Scheme); err != nil {
@@ -0,0 +1,234 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// Returns the ServiceAccountSpec of the model, the one of the model replaces the one of the engine
+func serviceAccountOf(params ReconcileParams) *aitrigramv1.ServiceAccountSpec {
+	if params.model.Spec.ServiceAccount != nil {
+		return params.model.Spec.ServiceAccount
+	}
+	if params.llmEngine.Spec.ServiceAccount != nil {
+		return params.llmEngine.Spec.ServiceAccount
+	}
+	return &aitrigramv1.ServiceAccountSpec{}
+}
+
+// The name of the ServiceAccount the pods of the model run as, it is the one created by the operator unless an
+// existing one is referenced
+func llmServiceAccountName(params ReconcileParams) string {
+	if spec := serviceAccountOf(params); spec.Name != "" {
+		return spec.Name
+	}
+	return llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name)
+}
+
+// Applies the ServiceAccount to the pods, which do not mount its token unless it is asked for
+func applyServiceAccount(podSpec *corev1.PodSpec, params ReconcileParams) {
+	podSpec.ServiceAccountName = llmServiceAccountName(params)
+	podSpec.AutomountServiceAccountToken = ptr.To(ptr.Deref(serviceAccountOf(params).AutomountServiceAccountToken, false))
+}
+
+// Reconcile the ServiceAccount of the pods of the LLM model, and the RoleBinding of the referenced role to it.
+// The ServiceAccount is deleted when an existing one is referenced, the RoleBinding is deleted when there is no
+// referenced role.
+func (r *LLMModelReconciler) reconcileLLMServiceAccount(ctx context.Context, req ctrl.Request, params ReconcileParams) error {
+	logger := log.FromContext(ctx)
+
+	nameSpaceName := &types.NamespacedName{
+		Namespace: req.Namespace,
+		Name:      llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name),
+	}
+	spec := serviceAccountOf(params)
+	serviceAccount := &corev1.ServiceAccount{}
+	err := r.Get(ctx, *nameSpaceName, serviceAccount)
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "Failed to get the ServiceAccount for LLMModel")
+		return err
+	}
+	exists := err == nil
+
+	if spec.Name != "" {
+		if exists && metav1.IsControlledBy(serviceAccount, params.model) {
+			logger.Info("An existing ServiceAccount is referenced, deleting the ServiceAccount", "ServiceAccount.Name", serviceAccount.Name)
+			if err := r.Delete(ctx, serviceAccount); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+	} else {
+		desired, err := r.newLLMModelServiceAccount(nameSpaceName, params)
+		if err != nil {
+			logger.Error(err, "Failed to define new ServiceAccount resource for LLMModel")
+			return err
+		}
+		if !exists {
+			logger.Info("Creating a new ServiceAccount", "ServiceAccount.Namespace", desired.Namespace, "ServiceAccount.Name", desired.Name)
+			if err := r.Create(ctx, desired); err != nil {
+				return err
+			}
+		} else if !equality.Semantic.DeepDerivative(desired.Annotations, serviceAccount.Annotations) ||
+			!equality.Semantic.DeepEqual(desired.AutomountServiceAccountToken, serviceAccount.AutomountServiceAccountToken) {
+			// the annotations added by others are kept
+			patch := client.MergeFrom(serviceAccount.DeepCopy())
+			serviceAccount.Annotations = MergeMaps(serviceAccount.Annotations, desired.Annotations)
+			serviceAccount.AutomountServiceAccountToken = desired.AutomountServiceAccountToken
+			if err := r.Patch(ctx, serviceAccount, patch); err != nil {
+				logger.Error(err, "Failed to update the ServiceAccount")
+				return err
+			}
+		}
+	}
+	return r.reconcileLLMRoleBinding(ctx, nameSpaceName, params)
+}
+
+// RoleRefKey returns the key of the role referred by the roleRef in the bindable roles, like ClusterRole/view or
+// Role/hf-token-reader
+func RoleRefKey(roleRef *aitrigramv1.RoleReference) string {
+	kind := roleRef.Kind
+	if kind == "" {
+		kind = "Role"
+	}
+	return kind + "/" + roleRef.Name
+}
+
+// Reports if the role can be bound. The operator binds the roles with its own permissions, so it fails closed: the
+// role must be allowed by the administrator, and the webhook must be enabled to check that the user who refers to
+// it can bind it as well.
+func (r *LLMModelReconciler) roleBindable(roleRef *aitrigramv1.RoleReference) bool {
+	return r.RoleRefsChecked && slices.Contains(r.BindableRoles, RoleRefKey(roleRef))
+}
+
+func (r *LLMModelReconciler) reconcileLLMRoleBinding(ctx context.Context, nameSpaceName *types.NamespacedName, params ReconcileParams) error {
+	logger := log.FromContext(ctx)
+
+	roleBinding := &rbacv1.RoleBinding{}
+	err := r.Get(ctx, *nameSpaceName, roleBinding)
+	if err != nil && !apierrors.IsNotFound(err) {
+		logger.Error(err, "Failed to get the RoleBinding for LLMModel")
+		return err
+	}
+	roleBindingExists := err == nil
+	owned := roleBindingExists && metav1.IsControlledBy(roleBinding, params.model)
+
+	roleRef := serviceAccountOf(params).RoleRef
+	if roleRef != nil && !r.roleBindable(roleRef) {
+		logger.Info("The role is not bindable, it is not bound", "role", RoleRefKey(roleRef))
+		recordEvent(r.Recorder, params.model, corev1.EventTypeWarning, eventReasonRoleRefRejected,
+			"The %s is not bound, it must be in the --bindable-roles of the operator with the webhook enabled", RoleRefKey(roleRef))
+		roleRef = nil
+	}
+	if roleRef == nil {
+		if owned {
+			logger.Info("No role is bound, deleting the RoleBinding", "RoleBinding.Name", roleBinding.Name)
+			return client.IgnoreNotFound(r.Delete(ctx, roleBinding))
+		}
+		return nil
+	}
+
+	desired, err := r.newLLMModelRoleBinding(nameSpaceName, params)
+	if err != nil {
+		logger.Error(err, "Failed to define new RoleBinding resource for LLMModel")
+		return err
+	}
+	if roleBindingExists && owned && desired.RoleRef != roleBinding.RoleRef {
+		// the roleRef of a RoleBinding can not be changed
+		logger.Info("The role is changed, deleting the RoleBinding", "RoleBinding.Name", roleBinding.Name)
+		if err := r.Delete(ctx, roleBinding); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+		roleBindingExists = false
+	}
+	if !roleBindingExists {
+		logger.Info("Creating a new RoleBinding", "RoleBinding.Namespace", desired.Namespace, "RoleBinding.Name", desired.Name)
+		return r.Create(ctx, desired)
+	}
+	if equality.Semantic.DeepEqual(desired.Subjects, roleBinding.Subjects) {
+		return nil
+	}
+	patch := client.MergeFrom(roleBinding.DeepCopy())
+	roleBinding.Subjects = desired.Subjects
+	if err := r.Patch(ctx, roleBinding, patch); err != nil {
+		logger.Error(err, "Failed to update the RoleBinding")
+		return err
+	}
+	return nil
+}
+
+func (r *LLMModelReconciler) newLLMModelServiceAccount(nameSpaceName *types.NamespacedName, params ReconcileParams) (*corev1.ServiceAccount, error) {
+	spec := serviceAccountOf(params)
+	serviceAccount := &corev1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        nameSpaceName.Name,
+			Namespace:   nameSpaceName.Namespace,
+			Labels:      llmModelLabels(nameSpaceName.Name),
+			Annotations: spec.Annotations,
+		},
+		AutomountServiceAccountToken: ptr.To(ptr.Deref(spec.AutomountServiceAccountToken, false)),
+	}
+	// Set the ownerRef for the ServiceAccount
+	if err := ctrl.SetControllerReference(params.model, serviceAccount, r.Scheme); err != nil {
+		return nil, err
+	}
+	return serviceAccount, nil
+}
+
+func (r *LLMModelReconciler) newLLMModelRoleBinding(nameSpaceName *types.NamespacedName, params ReconcileParams) (*rbacv1.RoleBinding, error) {
+	roleRef := serviceAccountOf(params).RoleRef
+	kind := roleRef.Kind
+	if kind == "" {
+		kind = "Role"
+	}
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      nameSpaceName.Name,
+			Namespace: nameSpaceName.Namespace,
+			Labels:    llmModelLabels(nameSpaceName.Name),
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     kind,
+			Name:     roleRef.Name,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      llmServiceAccountName(params),
+			Namespace: nameSpaceName.Namespace,
+		}},
+	}
+	// Set the ownerRef for the RoleBinding
+	if err := ctrl.SetControllerReference(params.model, roleBinding, r.Scheme); err != nil {
+		return nil, err
+	}
+	return roleBinding, nil
+}
@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// The Service serving the model, it is the shared Service of the engine in the Shared ServingMode
+func backendServiceName(llmEngine *aitrigramv1.LLMEngine, llmModel *aitrigramv1.LLMModel) string {
+	if isSharedServing(llmEngine) {
+		return llmSharedName(llmEngine)
+	}
+	return llmModelResourceName(llmEngine.Spec.EngineType, llmModel.Spec.Name)
+}
+
+// LLMModelEndpoint is where a LLMModel is served in the cluster, for the tools outside of the operator like the
+// kubectl plugin
+type LLMModelEndpoint struct {
+	// Service serving the model, it is the shared Service of the engine in the Shared ServingMode
+	Service string
+	// Port is the http port of the Service
+	Port int32
+	// InitContainer downloads the model in the pods
+	InitContainer string
+	// Model is the name of the model in the requests sent to the engine
+	Model string
+}
+
+// LLMModelEndpointOf returns where the LLMModel of the engine is served
+func LLMModelEndpointOf(llmEngine *aitrigramv1.LLMEngine, llmModel *aitrigramv1.LLMModel) LLMModelEndpoint {
+	return LLMModelEndpoint{
+		Service:       backendServiceName(llmEngine, llmModel),
+		Port:          llmEngine.Spec.ServicePort,
+		InitContainer: "init-" + llmModelResourceName(llmEngine.Spec.EngineType, llmModel.Spec.Name),
+		Model:         llmModelNameInEngine(llmModel),
+	}
+}
+
+// reconcileSharedLLMModel handles the LLMModel served by the shared Deployment of the engine, which is managed by
+// the LLMEngineReconciler. The per model resources left from the PerModel ServingMode are deleted.
+func (r *LLMModelReconciler) reconcileSharedLLMModel(ctx context.Context, req ctrl.Request, params ReconcileParams) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	if errs := ValidateLLMModelOfEngine(params.llmEngine, params.model); len(errs) > 0 {
+		// the webhook rejects them, unless the model was created before the engine or the webhook is disabled
+		recordEvent(r.Recorder, params.model, corev1.EventTypeWarning, eventReasonSharedServingRejected,
+			"The shared Deployment of the LLMEngine %s does not apply: %s", params.llmEngine.Name, errs.ToAggregate().Error())
+	}
+	name := llmModelResourceName(params.llmEngine.Spec.EngineType, params.model.Spec.Name)
+	// the Service goes first so that no traffic goes to the terminating pods
+	perModelResources := []struct {
+		name string
+		obj  client.Object
+	}{
+		{name: name, obj: &corev1.Service{}},
+		{name: name + "-activator", obj: &discoveryv1.EndpointSlice{}},
+		{name: name, obj: &autoscalingv2.HorizontalPodAutoscaler{}},
+		{name: name, obj: &policyv1.PodDisruptionBudget{}},
+		{name: name, obj: &appsv1.Deployment{}},
+		{name: llmQuotaConfigMapName(name), obj: &corev1.ConfigMap{}},
+		{name: name, obj: &networkingv1.NetworkPolicy{}},
+		{name: name, obj: &rbacv1.RoleBinding{}},
+		{name: name, obj: &rbacv1.Role{}},
+		{name: name, obj: &corev1.ServiceAccount{}},
+	}
+	if err := deleteServingCert(ctx, r.Client, params.model, llmTLSSecretName(name)); err != nil {
+		return ctrl.Result{}, err
+	}
+	for _, resource := range perModelResources {
+		if err := r.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: resource.name}, resource.obj); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return ctrl.Result{}, err
+		}
+		if !metav1.IsControlledBy(resource.obj, params.model) {
+			continue
+		}
+		logger.Info("LLMModel is served by the shared Deployment, deleting its own resource", "Kind", fmt.Sprintf("%T", resource.obj), "Name", resource.name)
+		if err := r.Delete(ctx, resource.obj); client.IgnoreNotFound(err) != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	url, err := r.reconcileLLMExposure(ctx, req, params)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.updateLLMModelEndpoints(ctx, req, url, llmModelBackend(params.llmEngine, params.model)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	sharedName := llmSharedName(params.llmEngine)
+	deployment := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: req.Namespace, Name: sharedName}, deployment); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		condition := metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "WaitingForSharedDeployment",
+			Message: fmt.Sprintf("Waiting for the shared Deployment %s of the LLMEngine", sharedName),
+		}
+		if err := r.updateLLMModelStatus(ctx, req, &condition); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: time.Second * 5}, nil
+	}
+
+	condition := metav1.Condition{
+		Type:    aitrigramv1.ConditionTypeReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Deployed",
+		Message: fmt.Sprintf("LLMModel is served by the shared Deployment %s", sharedName),
+	}
+	if err := r.updateLLMModelStatus(ctx, req, &condition); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.updateLLMModelScaleStatus(ctx, req, deployment, params.llmEngine.Spec.EngineType); err != nil {
+		return ctrl.Result{}, err
+	}
+	// the LLMModel is downloaded by its own init container in the shared Deployment
+	if err := r.updateLLMModelRevisions(ctx, req, deployment.Spec.Selector.MatchLabels, "init-"+name); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.recordLLMModelMetrics(ctx, req, params.model, deployment, "init-"+name); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.updateLLMModelPhase(ctx, req, aitrigramv1.LLMModelPhaseRunning); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// Enqueues the LLMModels which refer to the LLMEngine, so that they follow the changes of the engine, like the ServingMode
+func (r *LLMModelReconciler) llmModelsForEngine(ctx context.Context, obj client.Object) []reconcile.Request {
+	llmModelList := &aitrigramv1.LLMModelList{}
+	if err := r.List(ctx, llmModelList, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list the LLMModels of the LLMEngine", "LLMEngine.Name", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, llmModel := range llmModelList.Items {
+		if llmModel.Spec.EngineRef == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&llmModel)})
+		}
+	}
+	return requests
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const (
+	// LLMModelFinalizer is added to each LLMModel so that the operator tears down what it created
+	// even when the LLMModel has never been reconciled successfully.
+	LLMModelFinalizer = "aitrigram.ihomeland.cn/llmmodel-finalizer"
+	// LLMModelNameLabel is put on the out-of-band artifacts like ConfigMaps and Jobs created for a LLMModel,
+	// so that they can be cleaned up when the LLMModel is deleted.
+	LLMModelNameLabel = "aitrigram.ihomeland.cn/llmmodel"
+)
+
+// finalizeLLMModel tears down the resources created for the LLMModel in order:
+//  1. the Ingress, HTTPRoute and Services are deleted first so that no new traffic comes to the model
+//  2. the Deployments are deleted once the Services are gone, and waits for the pods to go away
+//  3. the out-of-band ConfigMaps and Jobs labeled with LLMModelNameLabel are deleted
+//  4. the PersistentVolumeClaim of the models storage is deleted if the StorageDeletionPolicy says so and it belongs to the LLMModel
+//
+// The finalizer is removed at last so that the LLMModel can be deleted.
+func (r *LLMModelReconciler) finalizeLLMModel(ctx context.Context, req ctrl.Request, llmModel *aitrigramv1.LLMModel) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	if !controllerutil.ContainsFinalizer(llmModel, LLMModelFinalizer) {
+		return ctrl.Result{}, nil
+	}
+	if !meta.IsStatusConditionTrue(llmModel.Status.Conditions, aitrigramv1.ConditionTypeTerminating) {
+		if err := r.updateLLMModelStatus(ctx, req, terminatingConditions("LLMModel is being deleted")...); err != nil {
+			return ctrl.Result{}, err
+		}
+		if err := r.updateLLMModelPhase(ctx, req, aitrigramv1.LLMModelPhaseTerminating); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// drain the traffic by removing the exposure and the Services first
+	ingresses := &networkingv1.IngressList{}
+	if err := r.List(ctx, ingresses, client.InNamespace(req.Namespace), client.MatchingLabels{"app": llmModelAppLabel}); err != nil {
+		return ctrl.Result{}, err
+	}
+	if remaining, err := r.deleteControlledBy(ctx, llmModel, ingressObjects(ingresses)); err != nil || remaining {
+		return ctrl.Result{RequeueAfter: time.Second * 2}, err
+	}
+	routes := &unstructured.UnstructuredList{}
+	routes.SetGroupVersionKind(httpRouteGVK.GroupVersion().WithKind(httpRouteGVK.Kind + "List"))
+	if err := r.List(ctx, routes, client.InNamespace(req.Namespace), client.MatchingLabels{"app": llmModelAppLabel}); err != nil && !meta.IsNoMatchError(err) {
+		return ctrl.Result{}, err
+	}
+	if remaining, err := r.deleteControlledBy(ctx, llmModel, unstructuredObjects(routes)); err != nil || remaining {
+		return ctrl.Result{RequeueAfter: time.Second * 2}, err
+	}
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, client.InNamespace(req.Namespace), client.MatchingLabels{"app": llmModelAppLabel}); err != nil {
+		return ctrl.Result{}, err
+	}
+	if remaining, err := r.deleteControlledBy(ctx, llmModel, serviceObjects(services)); err != nil || remaining {
+		return ctrl.Result{RequeueAfter: time.Second * 2}, err
+	}
+
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments, client.InNamespace(req.Namespace), client.MatchingLabels{"app": llmModelAppLabel}); err != nil {
+		return ctrl.Result{}, err
+	}
+	// wait for the pods to go away
+	if remaining, err := r.deleteControlledBy(ctx, llmModel, deploymentObjects(deployments), client.PropagationPolicy(metav1.DeletePropagationForeground)); err != nil || remaining {
+		return ctrl.Result{RequeueAfter: time.Second * 5}, err
+	}
+
+	// clean up the out-of-band artifacts
+	selector := client.MatchingLabels{LLMModelNameLabel: llmModel.Name}
+	if err := r.DeleteAllOf(ctx, &corev1.ConfigMap{}, client.InNamespace(req.Namespace), selector); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.DeleteAllOf(ctx, &batchv1.Job{}, client.InNamespace(req.Namespace), selector, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if llmModel.Spec.StorageDeletionPolicy == aitrigramv1.StorageDeletionPolicyDelete {
+		if err := r.deleteModelsStorage(ctx, llmModel); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	controllerutil.RemoveFinalizer(llmModel, LLMModelFinalizer)
+	if err := r.Update(ctx, llmModel); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	forgetLLMModelMetrics(req.NamespacedName)
+	logger.Info("LLMModel has been finalized")
+	return ctrl.Result{}, nil
+}
+
+// Deletes the PersistentVolumeClaim of the models storage only when it belongs to the LLMModel, which is owned by it
+// or labeled with LLMModelNameLabel. The claim in the ModelDeploymentTemplate of the LLMEngine is shared by the other
+// models of the engine, so it is never deleted.
+func (r *LLMModelReconciler) deleteModelsStorage(ctx context.Context, llmModel *aitrigramv1.LLMModel) error {
+	logger := log.FromContext(ctx)
+	claimName := modelsStorageClaimName(llmModel)
+	if claimName == "" {
+		return nil
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: llmModel.Namespace, Name: claimName}, pvc); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	owned := pvc.Labels[LLMModelNameLabel] == llmModel.Name
+	for _, ref := range pvc.OwnerReferences {
+		owned = owned || ref.UID == llmModel.UID
+	}
+	if !owned {
+		logger.Info("Keeping the PersistentVolumeClaim of the models storage not created for the LLMModel", "PersistentVolumeClaim.Name", claimName)
+		return nil
+	}
+	llmEngine := &aitrigramv1.LLMEngine{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: llmModel.Namespace, Name: llmModel.Spec.EngineRef}, llmEngine)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && engineTemplateClaimName(llmEngine) == claimName {
+		logger.Info("Keeping the PersistentVolumeClaim of the models storage of the LLMEngine", "PersistentVolumeClaim.Name", claimName)
+		return nil
+	}
+	logger.Info("Deleting the PersistentVolumeClaim of the models storage", "PersistentVolumeClaim.Name", claimName)
+	return client.IgnoreNotFound(r.Delete(ctx, pvc))
+}
+
+// Deletes the objects controlled by the LLMModel, it returns true if any of them still exists.
+func (r *LLMModelReconciler) deleteControlledBy(ctx context.Context, llmModel *aitrigramv1.LLMModel, objs []client.Object, opts ...client.DeleteOption) (bool, error) {
+	logger := log.FromContext(ctx)
+	remaining := false
+	for _, obj := range objs {
+		if !metav1.IsControlledBy(obj, llmModel) {
+			continue
+		}
+		remaining = true
+		if !obj.GetDeletionTimestamp().IsZero() {
+			continue
+		}
+		logger.Info("Deleting resource of the LLMModel", "Kind", fmt.Sprintf("%T", obj), "Name", obj.GetName())
+		if err := r.Delete(ctx, obj, opts...); client.IgnoreNotFound(err) != nil {
+			return remaining, err
+		}
+	}
+	return remaining, nil
+}
+
+func serviceObjects(list *corev1.ServiceList) []client.Object {
+	objs := make([]client.Object, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	return objs
+}
+
+func ingressObjects(list *networkingv1.IngressList) []client.Object {
+	objs := make([]client.Object, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	return objs
+}
+
+func unstructuredObjects(list *unstructured.UnstructuredList) []client.Object {
+	objs := make([]client.Object, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	return objs
+}
+
+func deploymentObjects(list *appsv1.DeploymentList) []client.Object {
+	objs := make([]client.Object, 0, len(list.Items))
+	for i := range list.Items {
+		objs = append(objs, &list.Items[i])
+	}
+	return objs
+}
+
+// Returns the claim name if the models storage is a PersistentVolumeClaim
+func modelsStorageClaimName(llmModel *aitrigramv1.LLMModel) string {
+	md := llmModel.Spec.ModelDeployment
+	if md == nil || md.Storage == nil || md.Storage.ModelsStorage == nil || md.Storage.ModelsStorage.PersistentVolumeClaim == nil {
+		return ""
+	}
+	return md.Storage.ModelsStorage.PersistentVolumeClaim.ClaimName
+}
+
+// Returns the claim name if the models storage in the ModelDeploymentTemplate of the LLMEngine is a PersistentVolumeClaim
+func engineTemplateClaimName(llmEngine *aitrigramv1.LLMEngine) string {
+	template := llmEngine.Spec.ModelDeploymentTemplate
+	if template == nil || template.Storage == nil || template.Storage.ModelsStorage == nil || template.Storage.ModelsStorage.PersistentVolumeClaim == nil {
+		return ""
+	}
+	return template.Storage.ModelsStorage.PersistentVolumeClaim.ClaimName
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+const llmAliasAppLabel = "aitrigram-alias"
+
+func llmAliasLabels(instance string) map[string]string {
+	return map[string]string{
+		"app":      llmAliasAppLabel,
+		"instance": instance,
+	}
+}
+
+// LLMModelAliasReconciler reconciles a LLMModelAlias object
+type LLMModelAliasReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodelaliases,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodelaliases/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodels,verbs=get;list;watch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmengines,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile keeps a Service named as the LLMModelAlias, which has no selector. Its EndpointSlices mirror the ones
+// of the Service of the LLMModel it refers to, so it follows the pods of the LLMModel, the shared Deployment, and
+// the activator when the LLMModel is scaled to zero.
+func (r *LLMModelAliasReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	alias := &aitrigramv1.LLMModelAlias{}
+	if err := r.Get(ctx, req.NamespacedName, alias); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !alias.GetDeletionTimestamp().IsZero() {
+		// the Service and the EndpointSlices are garbage collected with the alias
+		return ctrl.Result{}, nil
+	}
+
+	backendService, err := r.backendServiceOf(ctx, alias)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("Waiting for the LLMModel and its Service", "modelRef", alias.Spec.ModelRef)
+			condition := metav1.Condition{
+				Type:    aitrigramv1.ConditionTypeReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ModelNotFound",
+				Message: err.Error(),
+			}
+			return ctrl.Result{RequeueAfter: time.Second * 10}, r.updateLLMModelAliasStatus(ctx, req, &condition, "", "")
+		}
+		return ctrl.Result{}, err
+	}
+	service, err := r.reconcileAliasService(ctx, alias, backendService)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err := r.reconcileAliasEndpoints(ctx, alias, backendService); err != nil {
+		return ctrl.Result{}, err
+	}
+	condition := metav1.Condition{
+		Type:    aitrigramv1.ConditionTypeReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Pointed",
+		Message: fmt.Sprintf("The alias points to the LLMModel %s", alias.Spec.ModelRef),
+	}
+	url := serviceURL(service.Namespace, service.Name, service.Spec.Ports[0].Port)
+	if service.Spec.Ports[0].Name == "https" {
+		// the backend only serves https
+		url = fmt.Sprintf("https://%s.%s.svc:%d", service.Name, service.Namespace, service.Spec.Ports[0].Port)
+	}
+	return ctrl.Result{}, r.updateLLMModelAliasStatus(ctx, req, &condition, url, backendService.Name)
+}
+
+// Returns the Service serving the LLMModel the alias refers to, it is the shared Service in the Shared ServingMode
+func (r *LLMModelAliasReconciler) backendServiceOf(ctx context.Context, alias *aitrigramv1.LLMModelAlias) (*corev1.Service, error) {
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: alias.Namespace, Name: alias.Spec.ModelRef}, llmModel); err != nil {
+		return nil, err
+	}
+	llmEngine := &aitrigramv1.LLMEngine{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: alias.Namespace, Name: llmModel.Spec.EngineRef}, llmEngine); err != nil {
+		return nil, err
+	}
+	service := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: alias.Namespace, Name: backendServiceName(llmEngine, llmModel)}, service); err != nil {
+		return nil, err
+	}
+	if len(service.Spec.Ports) == 0 {
+		return nil, apierrors.NewNotFound(corev1.Resource("services"), service.Name)
+	}
+	return service, nil
+}
+
+// The Service of the alias has the same ports as the Service of the LLMModel, but no selector
+func (r *LLMModelAliasReconciler) reconcileAliasService(ctx context.Context, alias *aitrigramv1.LLMModelAlias, backendService *corev1.Service) (*corev1.Service, error) {
+	ports := make([]corev1.ServicePort, 0, len(backendService.Spec.Ports))
+	for _, port := range backendService.Spec.Ports {
+		ports = append(ports, corev1.ServicePort{Name: port.Name, Port: port.Port, Protocol: port.Protocol})
+	}
+	desired := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      alias.Name,
+			Namespace: alias.Namespace,
+			Labels:    llmAliasLabels(alias.Name),
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: ports,
+			Type:  corev1.ServiceTypeClusterIP,
+		},
+	}
+	if err := ctrl.SetControllerReference(alias, desired, r.Scheme); err != nil {
+		return nil, err
+	}
+	service := &corev1.Service{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(desired), service); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return nil, err
+		}
+		log.FromContext(ctx).Info("Creating the Service of the alias", "Service.Name", desired.Name)
+		return desired, r.Create(ctx, desired)
+	}
+	if !metav1.IsControlledBy(service, alias) {
+		return nil, fmt.Errorf("the Service %s exists and is not managed by the LLMModelAlias", service.Name)
+	}
+	if equality.Semantic.DeepDerivative(desired.Spec.Ports, service.Spec.Ports) && service.Spec.Selector == nil {
+		return service, nil
+	}
+	patch := client.MergeFrom(service.DeepCopy())
+	service.Spec.Ports = desired.Spec.Ports
+	service.Spec.Selector = nil
+	return service, r.Patch(ctx, service, patch)
+}
+
+// Mirrors the EndpointSlices of the Service of the LLMModel into one EndpointSlice per address type of the alias
+func (r *LLMModelAliasReconciler) reconcileAliasEndpoints(ctx context.Context, alias *aitrigramv1.LLMModelAlias, backendService *corev1.Service) error {
+	backendSlices := &discoveryv1.EndpointSliceList{}
+	if err := r.List(ctx, backendSlices, client.InNamespace(alias.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: backendService.Name}); err != nil {
+		return err
+	}
+	desiredSlices := map[string]*discoveryv1.EndpointSlice{}
+	for _, backendSlice := range backendSlices.Items {
+		name := alias.Name + "-" + strings.ToLower(string(backendSlice.AddressType))
+		desired, ok := desiredSlices[name]
+		if !ok {
+			desired = &discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: alias.Namespace,
+					Labels: MergeMaps(llmAliasLabels(alias.Name), map[string]string{
+						discoveryv1.LabelServiceName: alias.Name,
+						discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+					}),
+				},
+				AddressType: backendSlice.AddressType,
+				Ports:       backendSlice.Ports,
+				Endpoints:   []discoveryv1.Endpoint{},
+			}
+			if err := ctrl.SetControllerReference(alias, desired, r.Scheme); err != nil {
+				return err
+			}
+			desiredSlices[name] = desired
+		}
+		desired.Endpoints = append(desired.Endpoints, backendSlice.Endpoints...)
+	}
+
+	aliasSlices := &discoveryv1.EndpointSliceList{}
+	if err := r.List(ctx, aliasSlices, client.InNamespace(alias.Namespace),
+		client.MatchingLabels{discoveryv1.LabelServiceName: alias.Name}); err != nil {
+		return err
+	}
+	for i := range aliasSlices.Items {
+		endpointSlice := &aliasSlices.Items[i]
+		if !metav1.IsControlledBy(endpointSlice, alias) {
+			continue
+		}
+		desired, ok := desiredSlices[endpointSlice.Name]
+		if !ok {
+			if err := r.Delete(ctx, endpointSlice); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			continue
+		}
+		delete(desiredSlices, endpointSlice.Name)
+		if equality.Semantic.DeepEqual(desired.Endpoints, endpointSlice.Endpoints) &&
+			equality.Semantic.DeepEqual(desired.Ports, endpointSlice.Ports) {
+			continue
+		}
+		patch := client.MergeFrom(endpointSlice.DeepCopy())
+		endpointSlice.Endpoints = desired.Endpoints
+		endpointSlice.Ports = desired.Ports
+		if err := r.Patch(ctx, endpointSlice, patch); err != nil {
+			return err
+		}
+	}
+	for _, desired := range desiredSlices {
+		if err := r.Create(ctx, desired); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *LLMModelAliasReconciler) updateLLMModelAliasStatus(ctx context.Context, req ctrl.Request, condition *metav1.Condition, url string, backendService string) error {
+	alias := &aitrigramv1.LLMModelAlias{}
+	if err := r.Get(ctx, req.NamespacedName, alias); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	meta.SetStatusCondition(&alias.Status.Conditions, *condition)
+	alias.Status.URL = url
+	alias.Status.BackendService = backendService
+	return r.Status().Update(ctx, alias)
+}
+
+// Enqueues the aliases which point to the Service of the EndpointSlice, so that they follow its endpoints
+func (r *LLMModelAliasReconciler) aliasesOfEndpointSlice(ctx context.Context, obj client.Object) []reconcile.Request {
+	serviceName := obj.GetLabels()[discoveryv1.LabelServiceName]
+	if serviceName == "" {
+		return nil
+	}
+	return r.aliasesMatching(ctx, obj.GetNamespace(), func(alias *aitrigramv1.LLMModelAlias) bool {
+		return alias.Status.BackendService == serviceName
+	})
+}
+
+// Enqueues the aliases which refer to the LLMModel, which may be served by another Service now
+func (r *LLMModelAliasReconciler) aliasesOfModel(ctx context.Context, obj client.Object) []reconcile.Request {
+	return r.aliasesMatching(ctx, obj.GetNamespace(), func(alias *aitrigramv1.LLMModelAlias) bool {
+		return alias.Spec.ModelRef == obj.GetName()
+	})
+}
+
+func (r *LLMModelAliasReconciler) aliasesMatching(ctx context.Context, namespace string, matches func(alias *aitrigramv1.LLMModelAlias) bool) []reconcile.Request {
+	aliasList := &aitrigramv1.LLMModelAliasList{}
+	if err := r.List(ctx, aliasList, client.InNamespace(namespace)); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list the LLMModelAliases")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range aliasList.Items {
+		if matches(&aliasList.Items[i]) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&aliasList.Items[i])})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LLMModelAliasReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&aitrigramv1.LLMModelAlias{}).
+		Owns(&corev1.Service{}).
+		Owns(&discoveryv1.EndpointSlice{}).
+		Watches(&discoveryv1.EndpointSlice{}, handler.EnqueueRequestsFromMapFunc(r.aliasesOfEndpointSlice)).
+		Watches(&aitrigramv1.LLMModel{}, handler.EnqueueRequestsFromMapFunc(r.aliasesOfModel)).
+		Named("llmmodelalias").
+		Complete(withReconcileErrorMetrics("llmmodelalias", r))
+}
@@ -0,0 +1,150 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// Returns the LLMModel with its Service and the EndpointSlice of the Service with one endpoint at the address
+func newAliasTestModel(t *testing.T, scheme *runtime.Scheme, name string, address string) []client.Object {
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)},
+		Spec: aitrigramv1.LLMModelSpec{Name: name, EngineRef: "ollama", Replicas: 1,
+			ScaleToZero: &aitrigramv1.ScaleToZeroSpec{IdleTimeout: metav1.Duration{Duration: time.Minute}}},
+	}
+	serviceName := "ollama-" + name
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: serviceName, Namespace: "default"},
+		Spec: corev1.ServiceSpec{
+			Selector: llmModelLabels(serviceName),
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 8080, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromInt32(11434)},
+			},
+		},
+	}
+	require.NoError(t, controllerutil.SetControllerReference(llmModel, service, scheme))
+	endpointSlice := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      serviceName + "-abcde",
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: serviceName},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{Addresses: []string{address}, Conditions: discoveryv1.EndpointConditions{Ready: ptr.To(true)}},
+		},
+		Ports: []discoveryv1.EndpointPort{{Name: ptr.To("http"), Port: ptr.To[int32](11434)}},
+	}
+	return []client.Object{llmModel, service, endpointSlice}
+}
+
+func Test_LLMModelAlias(t *testing.T) {
+	t.Parallel()
+	scheme := newTestScheme(t)
+	ctx := context.Background()
+	alias := &aitrigramv1.LLMModelAlias{
+		ObjectMeta: metav1.ObjectMeta{Name: "chat", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelAliasSpec{ModelRef: "llama3"},
+	}
+	objects := []client.Object{newTestLLMEngine(), alias}
+	objects = append(objects, newAliasTestModel(t, scheme, "llama3", "10.0.0.3")...)
+	objects = append(objects, newAliasTestModel(t, scheme, "gemma3", "10.0.0.4")...)
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&aitrigramv1.LLMModelAlias{}).
+		Build()
+	r := &LLMModelAliasReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(alias)}
+
+	assertEndpoints := func(backendService string, address string) {
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, alias))
+		require.True(t, meta.IsStatusConditionTrue(alias.Status.Conditions, aitrigramv1.ConditionTypeReady))
+		require.Equal(t, backendService, alias.Status.BackendService)
+		require.Equal(t, "http://chat.default.svc:8080", alias.Status.URL)
+
+		service := &corev1.Service{}
+		require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, service))
+		require.Nil(t, service.Spec.Selector)
+		require.Len(t, service.Spec.Ports, 1)
+		require.Equal(t, int32(8080), service.Spec.Ports[0].Port)
+
+		endpointSlices := &discoveryv1.EndpointSliceList{}
+		require.NoError(t, k8sClient.List(ctx, endpointSlices, client.MatchingLabels{discoveryv1.LabelServiceName: "chat"}))
+		require.Len(t, endpointSlices.Items, 1)
+		endpointSlice := endpointSlices.Items[0]
+		require.Equal(t, "chat-ipv4", endpointSlice.Name)
+		require.Equal(t, endpointSliceManagedBy, endpointSlice.Labels[discoveryv1.LabelManagedBy])
+		require.True(t, metav1.IsControlledBy(&endpointSlice, alias))
+		require.Len(t, endpointSlice.Endpoints, 1)
+		require.Equal(t, []string{address}, endpointSlice.Endpoints[0].Addresses)
+		require.Equal(t, int32(11434), *endpointSlice.Ports[0].Port)
+	}
+	assertEndpoints("ollama-llama3", "10.0.0.3")
+
+	// points to the other model, the Service of the alias stays the same
+	alias.Spec.ModelRef = "gemma3"
+	require.NoError(t, k8sClient.Update(ctx, alias))
+	assertEndpoints("ollama-gemma3", "10.0.0.4")
+	require.ElementsMatch(t, []ctrl.Request{req}, r.aliasesOfEndpointSlice(ctx, &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Labels: map[string]string{discoveryv1.LabelServiceName: "ollama-gemma3"}},
+	}))
+	require.Empty(t, r.aliasesOfEndpointSlice(ctx, &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Labels: map[string]string{discoveryv1.LabelServiceName: "ollama-llama3"}},
+	}))
+
+	// the activator wakes up the model behind the alias
+	require.NoError(t, k8sClient.Create(ctx, newReadyPod("ollama-gemma3-0", llmModelLabels("ollama-gemma3"))))
+	waker := &ModelWaker{Client: k8sClient, PollInterval: time.Millisecond}
+	wakeCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	target, err := waker.Wake(wakeCtx, "chat.default.svc:8080", "http")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.12:11434", target.Host)
+
+	// a missing model is reported and retried
+	alias.Spec.ModelRef = "missing"
+	require.NoError(t, k8sClient.Update(ctx, alias))
+	result, err := r.Reconcile(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, result.RequeueAfter)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, alias))
+	condition := meta.FindStatusCondition(alias.Status.Conditions, aitrigramv1.ConditionTypeReady)
+	require.NotNil(t, condition)
+	require.Equal(t, "ModelNotFound", condition.Reason)
+}
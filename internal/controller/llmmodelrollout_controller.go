@@ -0,0 +1,295 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+const (
+	// how often the canary is analyzed
+	rolloutAnalysisPeriod = 15 * time.Second
+
+	defaultRolloutMaxErrorRatePercent int32 = 5
+	defaultRolloutMinRequests         int64 = 10
+)
+
+// LLMModelRolloutReconciler reconciles a LLMModelRollout object
+type LLMModelRolloutReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// statsFetcher replaces how the stats get fetched from the gateway pods, it is used in tests
+	statsFetcher func(ctx context.Context, pod *corev1.Pod) (proxy.Stats, error)
+}
+
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodelrollouts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodelrollouts/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodels,verbs=get;list;watch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmengines,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile moves the LLMModelRollout through its steps. The weight of the canary is recorded in the status,
+// and the LLMEngineReconciler splits the route of the model in the gateway by it. The canary is rolled back
+// when it has no ready pod, or when too many of its requests fail in a step.
+func (r *LLMModelRolloutReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	rollout := &aitrigramv1.LLMModelRollout{}
+	if err := r.Get(ctx, req.NamespacedName, rollout); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	status := rollout.Status.DeepCopy()
+	if status.Stable != rollout.Spec.Stable || status.Canary != rollout.Spec.Canary {
+		logger.Info("Starting the rollout", "stable", rollout.Spec.Stable, "canary", rollout.Spec.Canary)
+		status = &aitrigramv1.LLMModelRolloutStatus{
+			Conditions:  status.Conditions,
+			Stable:      rollout.Spec.Stable,
+			Canary:      rollout.Spec.Canary,
+			Phase:       aitrigramv1.LLMModelRolloutPhaseProgressing,
+			CurrentStep: -1,
+		}
+	}
+	status.ObservedGeneration = rollout.Generation
+	// the steps may have been changed in the middle of the rollout
+	status.CurrentStep = min(status.CurrentStep, int32(len(rollout.Spec.Steps)-1))
+
+	stable, canary, llmEngine, err := r.modelsOf(ctx, rollout)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			status.Phase = aitrigramv1.LLMModelRolloutPhaseFailed
+			status.Message = err.Error()
+			return ctrl.Result{RequeueAfter: time.Second * 10}, r.updateLLMModelRolloutStatus(ctx, req, status)
+		}
+		return ctrl.Result{}, err
+	}
+	if canary.Spec.EngineRef != stable.Spec.EngineRef {
+		status.Phase = aitrigramv1.LLMModelRolloutPhaseFailed
+		status.Message = fmt.Sprintf("the canary is in the LLMEngine %s, but the stable is in %s", canary.Spec.EngineRef, stable.Spec.EngineRef)
+		return ctrl.Result{}, r.updateLLMModelRolloutStatus(ctx, req, status)
+	}
+	if gateway := llmEngine.Spec.Gateway; gateway == nil || !gateway.Enabled {
+		status.Phase = aitrigramv1.LLMModelRolloutPhaseFailed
+		status.Message = fmt.Sprintf("the gateway of the LLMEngine %s is not enabled", llmEngine.Name)
+		return ctrl.Result{RequeueAfter: rolloutAnalysisPeriod}, r.updateLLMModelRolloutStatus(ctx, req, status)
+	}
+	status.Model = rolloutModelName(rollout, stable)
+	if status.Phase == aitrigramv1.LLMModelRolloutPhaseFailed {
+		// the missing LLMModels or the gateway are back, which starts the rollout again
+		status.Phase = aitrigramv1.LLMModelRolloutPhaseProgressing
+		status.CurrentStep = -1
+		status.CanaryWeight = 0
+	}
+	if status.Phase == aitrigramv1.LLMModelRolloutPhasePromoted || status.Phase == aitrigramv1.LLMModelRolloutPhaseRolledBack {
+		return ctrl.Result{}, r.updateLLMModelRolloutStatus(ctx, req, status)
+	}
+
+	canaryPods, err := readyModelPods(ctx, r.Client, req.Namespace, backendPodLabels(llmEngine, canary))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	counts, err := r.canaryCounts(ctx, llmEngine, canary)
+	if err != nil {
+		// the analysis is skipped this time
+		logger.Error(err, "Failed to fetch the stats of the canary from the gateway")
+	}
+	now := metav1.Now()
+	if status.CurrentStep < 0 {
+		if len(canaryPods) == 0 {
+			status.Message = "Waiting for the canary to be ready"
+			return ctrl.Result{RequeueAfter: rolloutAnalysisPeriod}, r.updateLLMModelRolloutStatus(ctx, req, status)
+		}
+		startRolloutStep(rollout, status, 0, now, counts)
+		return r.nextAnalysis(ctx, req, rollout, status, now)
+	}
+
+	if len(canaryPods) == 0 {
+		rollback(status, "the canary has no ready pod")
+		return ctrl.Result{}, r.updateLLMModelRolloutStatus(ctx, req, status)
+	}
+	if counts != nil {
+		baseline := ptr.Deref(status.StepBaseline, aitrigramv1.RolloutCounts{})
+		if counts.Requests < baseline.Requests || counts.Errors < baseline.Errors {
+			// the gateway has restarted and counts from zero again
+			baseline = aitrigramv1.RolloutCounts{}
+			status.StepBaseline = &baseline
+		}
+		status.CanaryRequests = counts.Requests - baseline.Requests
+		status.CanaryErrors = counts.Errors - baseline.Errors
+		maxErrorRate, minRequests := rolloutAnalysis(rollout)
+		if status.CanaryRequests >= minRequests && status.CanaryErrors*100 > int64(maxErrorRate)*status.CanaryRequests {
+			rollback(status, fmt.Sprintf("%d of %d canary requests failed in step %d, more than %d%%",
+				status.CanaryErrors, status.CanaryRequests, status.CurrentStep, maxErrorRate))
+			return ctrl.Result{}, r.updateLLMModelRolloutStatus(ctx, req, status)
+		}
+	}
+
+	if rollout.Spec.Paused {
+		status.Phase = aitrigramv1.LLMModelRolloutPhasePaused
+		status.Message = "The rollout is paused"
+		return ctrl.Result{RequeueAfter: rolloutAnalysisPeriod}, r.updateLLMModelRolloutStatus(ctx, req, status)
+	}
+	status.Phase = aitrigramv1.LLMModelRolloutPhaseProgressing
+	step := rollout.Spec.Steps[status.CurrentStep]
+	if status.StepStartTime != nil && now.Sub(status.StepStartTime.Time) >= step.Pause.Duration {
+		if int(status.CurrentStep) >= len(rollout.Spec.Steps)-1 {
+			logger.Info("Promoted the canary", "weight", status.CanaryWeight)
+			status.Phase = aitrigramv1.LLMModelRolloutPhasePromoted
+			status.Message = fmt.Sprintf("The canary gets %d%% of the requests", status.CanaryWeight)
+			return ctrl.Result{}, r.updateLLMModelRolloutStatus(ctx, req, status)
+		}
+		startRolloutStep(rollout, status, status.CurrentStep+1, now, counts)
+	}
+	return r.nextAnalysis(ctx, req, rollout, status, now)
+}
+
+// Records the status and requeues at the next analysis, or when the current step ends if it is sooner
+func (r *LLMModelRolloutReconciler) nextAnalysis(ctx context.Context, req ctrl.Request, rollout *aitrigramv1.LLMModelRollout, status *aitrigramv1.LLMModelRolloutStatus, now metav1.Time) (ctrl.Result, error) {
+	status.Message = fmt.Sprintf("Step %d of %d, the canary gets %d%% of the requests", status.CurrentStep+1, len(rollout.Spec.Steps), status.CanaryWeight)
+	requeueAfter := rolloutAnalysisPeriod
+	if status.StepStartTime != nil {
+		pause := rollout.Spec.Steps[status.CurrentStep].Pause.Duration
+		if remaining := status.StepStartTime.Add(pause).Sub(now.Time); remaining > 0 && remaining < requeueAfter {
+			requeueAfter = remaining
+		}
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, r.updateLLMModelRolloutStatus(ctx, req, status)
+}
+
+func startRolloutStep(rollout *aitrigramv1.LLMModelRollout, status *aitrigramv1.LLMModelRolloutStatus, step int32, now metav1.Time, counts *aitrigramv1.RolloutCounts) {
+	status.CurrentStep = step
+	status.CanaryWeight = rollout.Spec.Steps[step].Weight
+	status.StepStartTime = &now
+	status.StepBaseline = counts
+	status.CanaryRequests = 0
+	status.CanaryErrors = 0
+}
+
+func rollback(status *aitrigramv1.LLMModelRolloutStatus, reason string) {
+	status.Phase = aitrigramv1.LLMModelRolloutPhaseRolledBack
+	status.CanaryWeight = 0
+	status.Message = "Rolled back: " + reason
+}
+
+func rolloutAnalysis(rollout *aitrigramv1.LLMModelRollout) (int32, int64) {
+	maxErrorRate, minRequests := defaultRolloutMaxErrorRatePercent, defaultRolloutMinRequests
+	if analysis := rollout.Spec.Analysis; analysis != nil {
+		if analysis.MaxErrorRatePercent > 0 {
+			maxErrorRate = analysis.MaxErrorRatePercent
+		}
+		if analysis.MinRequests > 0 {
+			minRequests = analysis.MinRequests
+		}
+	}
+	return maxErrorRate, minRequests
+}
+
+// The model name in the requests which is split, it is the NameInEngine of the stable LLMModel if not defined
+func rolloutModelName(rollout *aitrigramv1.LLMModelRollout, stable *aitrigramv1.LLMModel) string {
+	if rollout.Spec.Model != "" {
+		return rollout.Spec.Model
+	}
+	return llmModelNameInEngine(stable)
+}
+
+// Returns the stable and the canary LLMModels of the rollout, and the LLMEngine of the stable one
+func (r *LLMModelRolloutReconciler) modelsOf(ctx context.Context, rollout *aitrigramv1.LLMModelRollout) (*aitrigramv1.LLMModel, *aitrigramv1.LLMModel, *aitrigramv1.LLMEngine, error) {
+	stable := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: rollout.Namespace, Name: rollout.Spec.Stable}, stable); err != nil {
+		return nil, nil, nil, err
+	}
+	canary := &aitrigramv1.LLMModel{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: rollout.Namespace, Name: rollout.Spec.Canary}, canary); err != nil {
+		return nil, nil, nil, err
+	}
+	llmEngine := &aitrigramv1.LLMEngine{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: rollout.Namespace, Name: stable.Spec.EngineRef}, llmEngine); err != nil {
+		return nil, nil, nil, err
+	}
+	return stable, canary, llmEngine, nil
+}
+
+// Sums the requests and the errors of the canary counted by the ready gateway pods
+func (r *LLMModelRolloutReconciler) canaryCounts(ctx context.Context, llmEngine *aitrigramv1.LLMEngine, canary *aitrigramv1.LLMModel) (*aitrigramv1.RolloutCounts, error) {
+	pods, err := readyModelPods(ctx, r.Client, llmEngine.Namespace, llmGatewayLabels(llmGatewayName(llmEngine)))
+	if err != nil {
+		return nil, err
+	}
+	backend := llmModelBackend(llmEngine, canary)
+	counts := &aitrigramv1.RolloutCounts{}
+	for i := range pods {
+		stats, err := r.fetchStats(ctx, &pods[i])
+		if err != nil {
+			return nil, err
+		}
+		counts.Requests += stats[backend].Requests
+		counts.Errors += stats[backend].Errors
+	}
+	return counts, nil
+}
+
+func (r *LLMModelRolloutReconciler) fetchStats(ctx context.Context, pod *corev1.Pod) (proxy.Stats, error) {
+	if r.statsFetcher != nil {
+		return r.statsFetcher(ctx, pod)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return proxy.FetchStats(ctx, http.DefaultClient, fmt.Sprintf("http://%s:%d", pod.Status.PodIP, gatewayAdminPort))
+}
+
+func (r *LLMModelRolloutReconciler) updateLLMModelRolloutStatus(ctx context.Context, req ctrl.Request, status *aitrigramv1.LLMModelRolloutStatus) error {
+	rollout := &aitrigramv1.LLMModelRollout{}
+	if err := r.Get(ctx, req.NamespacedName, rollout); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	condition := metav1.Condition{
+		Type:    aitrigramv1.ConditionTypeReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  string(status.Phase),
+		Message: status.Message,
+	}
+	if status.Phase == aitrigramv1.LLMModelRolloutPhaseRolledBack || status.Phase == aitrigramv1.LLMModelRolloutPhaseFailed {
+		condition.Status = metav1.ConditionFalse
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	rollout.Status = *status
+	return r.Status().Update(ctx, rollout)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LLMModelRolloutReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&aitrigramv1.LLMModelRollout{}).
+		Named("llmmodelrollout").
+		Complete(withReconcileErrorMetrics("llmmodelrollout", r))
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+// the gateway stats shared by the fake gateway pods
+type fakeGatewayStats struct {
+	mu    sync.Mutex
+	stats proxy.Stats
+}
+
+func (f *fakeGatewayStats) fetch(_ context.Context, _ *corev1.Pod) (proxy.Stats, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := proxy.Stats{}
+	for backend, counts := range f.stats {
+		stats[backend] = counts
+	}
+	return stats, nil
+}
+
+func (f *fakeGatewayStats) set(backend string, requests, errors int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats[backend] = proxy.BackendStats{Requests: requests, Errors: errors}
+}
+
+func newReadyPod(name string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: labels},
+		Status: corev1.PodStatus{
+			PodIP:      "10.0.0.12",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// Returns a client with the gemma3 1b and 4b LLMModels in the ollama engine, the gateway and the canary have a ready pod
+func newRolloutTestClient(t *testing.T, scheme *runtime.Scheme, rollout *aitrigramv1.LLMModelRollout) client.Client {
+	llmEngine := newTestLLMEngine()
+	llmEngine.Spec.Gateway = &aitrigramv1.GatewaySpec{Enabled: true}
+	stable := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "gemma3-1b", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "gemma3-1b", NameInEngine: "gemma3:1b", EngineRef: "ollama", Replicas: 1},
+	}
+	canary := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "gemma3-4b", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "gemma3-4b", NameInEngine: "gemma3:4b", EngineRef: "ollama", Replicas: 1},
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, stable, canary, rollout,
+			newReadyPod("ollama-gemma3-4b-0", llmModelLabels("ollama-gemma3-4b")),
+			newReadyPod("ollama-gateway-0", llmGatewayLabels("ollama-gateway"))).
+		WithStatusSubresource(&aitrigramv1.LLMModelRollout{}).
+		Build()
+}
+
+// Moves the start of the current step back, as if the pause of it has passed
+func passRolloutStep(t *testing.T, k8sClient client.Client, key client.ObjectKey) {
+	rollout := &aitrigramv1.LLMModelRollout{}
+	require.NoError(t, k8sClient.Get(context.Background(), key, rollout))
+	rollout.Status.StepStartTime = &metav1.Time{Time: rollout.Status.StepStartTime.Add(-time.Hour)}
+	require.NoError(t, k8sClient.Status().Update(context.Background(), rollout))
+}
+
+func Test_LLMModelRolloutPromotion(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	rollout := &aitrigramv1.LLMModelRollout{
+		ObjectMeta: metav1.ObjectMeta{Name: "gemma3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelRolloutSpec{
+			Stable: "gemma3-1b",
+			Canary: "gemma3-4b",
+			Steps: []aitrigramv1.RolloutStep{
+				{Weight: 10, Pause: metav1.Duration{Duration: 10 * time.Minute}},
+				{Weight: 100, Pause: metav1.Duration{Duration: time.Minute}},
+			},
+		},
+	}
+	k8sClient := newRolloutTestClient(t, scheme, rollout)
+	stats := &fakeGatewayStats{stats: proxy.Stats{}}
+	r := &LLMModelRolloutReconciler{Client: k8sClient, Scheme: scheme, statsFetcher: stats.fetch}
+	engineReconciler := &LLMEngineReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(rollout)}
+	canaryBackend := "http://ollama-gemma3-4b.default.svc:8080"
+
+	reconcile := func(expectedPhase aitrigramv1.LLMModelRolloutPhase, expectedWeight int32) {
+		_, err := r.Reconcile(ctx, req)
+		require.NoError(t, err)
+		require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, rollout))
+		require.Equal(t, expectedPhase, rollout.Status.Phase, rollout.Status.Message)
+		require.Equal(t, expectedWeight, rollout.Status.CanaryWeight)
+	}
+
+	// the clients of gemma3:1b get 10% of the requests served by gemma3:4b
+	stats.set(canaryBackend, 3, 0)
+	reconcile(aitrigramv1.LLMModelRolloutPhaseProgressing, 10)
+	require.Equal(t, "gemma3:1b", rollout.Status.Model)
+	require.Equal(t, int32(0), rollout.Status.CurrentStep)
+	llmEngine := &aitrigramv1.LLMEngine{}
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "ollama"}, llmEngine))
+	routes, err := engineReconciler.gatewayRouteTable(ctx, llmEngine)
+	require.NoError(t, err)
+	require.Equal(t, []proxy.Route{
+		{Model: "gemma3:1b", Backends: []proxy.WeightedBackend{
+			{Backend: "http://ollama-gemma3-1b.default.svc:8080", Model: "gemma3:1b", Weight: 90},
+			{Backend: canaryBackend, Model: "gemma3:4b", Weight: 10},
+		}},
+		{Model: "gemma3:4b", Backend: canaryBackend},
+	}, routes.Routes)
+
+	// the step lasts for its pause, the requests are counted from the start of the step
+	stats.set(canaryBackend, 40, 1)
+	reconcile(aitrigramv1.LLMModelRolloutPhaseProgressing, 10)
+	require.Equal(t, int64(37), rollout.Status.CanaryRequests)
+	require.Equal(t, int64(1), rollout.Status.CanaryErrors)
+
+	// the paused rollout holds at the step
+	passRolloutStep(t, k8sClient, req.NamespacedName)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, rollout))
+	rollout.Spec.Paused = true
+	require.NoError(t, k8sClient.Update(ctx, rollout))
+	reconcile(aitrigramv1.LLMModelRolloutPhasePaused, 10)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, rollout))
+	rollout.Spec.Paused = false
+	require.NoError(t, k8sClient.Update(ctx, rollout))
+
+	reconcile(aitrigramv1.LLMModelRolloutPhaseProgressing, 100)
+	require.Equal(t, int32(1), rollout.Status.CurrentStep)
+	passRolloutStep(t, k8sClient, req.NamespacedName)
+	reconcile(aitrigramv1.LLMModelRolloutPhasePromoted, 100)
+	reconcile(aitrigramv1.LLMModelRolloutPhasePromoted, 100)
+}
+
+func Test_LLMModelRolloutRollback(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, k8sClient client.Client, stats *fakeGatewayStats)
+		message string
+	}{
+		{
+			name: "too many errors",
+			prepare: func(t *testing.T, k8sClient client.Client, stats *fakeGatewayStats) {
+				stats.set("http://ollama-gemma3-4b.default.svc:8080", 10, 2)
+			},
+			message: "Rolled back: 2 of 10 canary requests failed in step 0, more than 5%",
+		},
+		{
+			name: "no ready canary pod",
+			prepare: func(t *testing.T, k8sClient client.Client, stats *fakeGatewayStats) {
+				require.NoError(t, k8sClient.Delete(ctx, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "ollama-gemma3-4b-0", Namespace: "default"}}))
+			},
+			message: "Rolled back: the canary has no ready pod",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			scheme := newTestScheme(t)
+			rollout := &aitrigramv1.LLMModelRollout{
+				ObjectMeta: metav1.ObjectMeta{Name: "gemma3", Namespace: "default"},
+				Spec: aitrigramv1.LLMModelRolloutSpec{
+					Model:  "gemma3",
+					Stable: "gemma3-1b",
+					Canary: "gemma3-4b",
+					Steps:  []aitrigramv1.RolloutStep{{Weight: 10, Pause: metav1.Duration{Duration: 10 * time.Minute}}},
+				},
+			}
+			k8sClient := newRolloutTestClient(t, scheme, rollout)
+			stats := &fakeGatewayStats{stats: proxy.Stats{}}
+			r := &LLMModelRolloutReconciler{Client: k8sClient, Scheme: scheme, statsFetcher: stats.fetch}
+			req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(rollout)}
+			_, err := r.Reconcile(ctx, req)
+			require.NoError(t, err)
+
+			tt.prepare(t, k8sClient, stats)
+			_, err = r.Reconcile(ctx, req)
+			require.NoError(t, err)
+			require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, rollout))
+			require.Equal(t, aitrigramv1.LLMModelRolloutPhaseRolledBack, rollout.Status.Phase)
+			require.Equal(t, int32(0), rollout.Status.CanaryWeight)
+			require.Equal(t, tt.message, rollout.Status.Message)
+
+			// all requests of the model go to the stable again
+			llmEngine := &aitrigramv1.LLMEngine{}
+			require.NoError(t, k8sClient.Get(ctx, client.ObjectKey{Namespace: "default", Name: "ollama"}, llmEngine))
+			routes, err := (&LLMEngineReconciler{Client: k8sClient, Scheme: scheme}).gatewayRouteTable(ctx, llmEngine)
+			require.NoError(t, err)
+			require.Equal(t, proxy.Route{Model: "gemma3", Backends: []proxy.WeightedBackend{
+				{Backend: "http://ollama-gemma3-1b.default.svc:8080", Model: "gemma3:1b", Weight: 100},
+				{Backend: "http://ollama-gemma3-4b.default.svc:8080", Model: "gemma3:4b", Weight: 0},
+			}}, routes.Routes[0])
+		})
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+// how often the usage in the status of the LLMQuotas is refreshed
+const quotaUsageRefreshInterval = 30 * time.Second
+
+// LLMQuotaReconciler reconciles a LLMQuota object
+type LLMQuotaReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+
+	// usageFetcher replaces how the usage gets fetched from the proxy sidecar, it is used in tests
+	usageFetcher func(ctx context.Context, pod *corev1.Pod) ([]proxy.QuotaUsage, error)
+}
+
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmquotas,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmquotas/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmmodels,verbs=get;list;watch
+// +kubebuilder:rbac:groups=aitrigram.ihomeland.cn,resources=llmengines,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+
+// Reconcile reports if the LLMQuota is enforced and the usage of its clients. The quota itself is enforced by the
+// proxy sidecars of the LLMModel, which read the quota ConfigMap maintained by the LLMModelReconciler.
+func (r *LLMQuotaReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+	quota := &aitrigramv1.LLMQuota{}
+	if err := r.Get(ctx, req.NamespacedName, quota); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if !quota.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	llmModel := &aitrigramv1.LLMModel{}
+	llmEngine := &aitrigramv1.LLMEngine{}
+	err := r.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: quota.Spec.ModelRef}, llmModel)
+	if err == nil {
+		err = r.Get(ctx, client.ObjectKey{Namespace: req.Namespace, Name: llmModel.Spec.EngineRef}, llmEngine)
+	}
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("Waiting for the LLMModel and its LLMEngine", "modelRef", quota.Spec.ModelRef)
+			condition := metav1.Condition{
+				Type:    aitrigramv1.ConditionTypeReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "ModelNotFound",
+				Message: err.Error(),
+			}
+			return ctrl.Result{RequeueAfter: time.Second * 10}, r.updateLLMQuotaStatus(ctx, req, &condition, nil)
+		}
+		return ctrl.Result{}, err
+	}
+	if isSharedServing(llmEngine) {
+		condition := metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "SharedServingNotSupported",
+			Message: fmt.Sprintf("The LLMModel %s is served by the shared Deployment of the LLMEngine %s", llmModel.Name, llmEngine.Name),
+		}
+		return ctrl.Result{}, r.updateLLMQuotaStatus(ctx, req, &condition, nil)
+	}
+	if !quotaEnforceable(llmModel) {
+		condition := metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ReplicasNotSupported",
+			Message: fmt.Sprintf("The LLMModel %s has more than one replica or autoscaling, each pod would enforce the whole limits", llmModel.Name),
+		}
+		return ctrl.Result{}, r.updateLLMQuotaStatus(ctx, req, &condition, nil)
+	}
+	if unknown := unknownQuotaAPIKeys(llmEngine, quota); len(unknown) > 0 {
+		condition := metav1.Condition{
+			Type:    aitrigramv1.ConditionTypeReady,
+			Status:  metav1.ConditionFalse,
+			Reason:  "APIKeyNotFound",
+			Message: fmt.Sprintf("The API keys %s are not in the auth of the LLMEngine %s", strings.Join(unknown, ", "), llmEngine.Name),
+		}
+		return ctrl.Result{}, r.updateLLMQuotaStatus(ctx, req, &condition, nil)
+	}
+
+	resourceName := llmModelResourceName(llmEngine.Spec.EngineType, llmModel.Spec.Name)
+	pods, err := readyModelPods(ctx, r.Client, req.Namespace, llmModelLabels(resourceName))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	usage := map[string]*aitrigramv1.QuotaUsage{}
+	for i := range pods {
+		podUsage, err := r.fetchUsage(ctx, &pods[i])
+		if err != nil {
+			// the pod may not have the quota yet, the usage of the others is still reported
+			logger.Error(err, "Failed to get the quota usage from the proxy sidecar", "Pod.Name", pods[i].Name)
+			continue
+		}
+		for _, clientUsage := range podUsage {
+			apiKey := quotaAPIKeyName(llmEngine, clientUsage.APIKeyFile)
+			total, ok := usage[apiKey]
+			if !ok {
+				total = &aitrigramv1.QuotaUsage{APIKey: apiKey}
+				usage[apiKey] = total
+			}
+			total.Requests += clientUsage.Requests
+			total.Tokens += clientUsage.Tokens
+			total.Throttled += clientUsage.Throttled
+		}
+	}
+	condition := metav1.Condition{
+		Type:    aitrigramv1.ConditionTypeReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "Enforced",
+		Message: fmt.Sprintf("The quota is enforced by the proxy sidecar of the LLMModel %s", llmModel.Name),
+	}
+	statusUsage := make([]aitrigramv1.QuotaUsage, 0, len(usage))
+	for _, clientUsage := range usage {
+		statusUsage = append(statusUsage, *clientUsage)
+	}
+	sort.Slice(statusUsage, func(i, j int) bool {
+		return statusUsage[i].APIKey < statusUsage[j].APIKey
+	})
+	return ctrl.Result{RequeueAfter: quotaUsageRefreshInterval}, r.updateLLMQuotaStatus(ctx, req, &condition, statusUsage)
+}
+
+// Returns the API keys in the limits of the LLMQuota which are not in the auth of the engine
+func unknownQuotaAPIKeys(llmEngine *aitrigramv1.LLMEngine, quota *aitrigramv1.LLMQuota) []string {
+	var unknown []string
+	for _, limit := range quota.Spec.Limits {
+		if limit.APIKey == nil {
+			continue
+		}
+		if _, ok := apiKeyFileOf(llmEngine, limit.APIKey); !ok {
+			unknown = append(unknown, limit.APIKey.Name+"/"+limit.APIKey.Key)
+		}
+	}
+	return unknown
+}
+
+// Returns the name of the API key mounted as the file in the status, like: <Secret name>/<key>
+func quotaAPIKeyName(llmEngine *aitrigramv1.LLMEngine, file string) string {
+	if file == "" || !authEnabled(llmEngine) {
+		return ""
+	}
+	for i, apiKey := range llmEngine.Spec.Auth.APIKeys {
+		if apiKeyFile(i) == file {
+			return apiKey.SecretRef.Name + "/" + apiKey.SecretRef.Key
+		}
+	}
+	return file
+}
+
+func (r *LLMQuotaReconciler) fetchUsage(ctx context.Context, pod *corev1.Pod) ([]proxy.QuotaUsage, error) {
+	if r.usageFetcher != nil {
+		return r.usageFetcher(ctx, pod)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return proxy.FetchQuotaUsage(ctx, http.DefaultClient, fmt.Sprintf("http://%s:%d", pod.Status.PodIP, proxySidecarAdminPort))
+}
+
+func (r *LLMQuotaReconciler) updateLLMQuotaStatus(ctx context.Context, req ctrl.Request, condition *metav1.Condition, usage []aitrigramv1.QuotaUsage) error {
+	quota := &aitrigramv1.LLMQuota{}
+	if err := r.Get(ctx, req.NamespacedName, quota); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	meta.SetStatusCondition(&quota.Status.Conditions, *condition)
+	quota.Status.Usage = usage
+	return r.Status().Update(ctx, quota)
+}
+
+// Enqueues the LLMQuotas which refer to the LLMModel
+func (r *LLMQuotaReconciler) quotasOfModel(ctx context.Context, obj client.Object) []reconcile.Request {
+	quotaList := &aitrigramv1.LLMQuotaList{}
+	if err := r.List(ctx, quotaList, client.InNamespace(obj.GetNamespace())); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to list the LLMQuotas")
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range quotaList.Items {
+		if quotaList.Items[i].Spec.ModelRef == obj.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&quotaList.Items[i])})
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LLMQuotaReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&aitrigramv1.LLMQuota{}).
+		Watches(&aitrigramv1.LLMModel{}, handler.EnqueueRequestsFromMapFunc(r.quotasOfModel)).
+		Named("llmquota").
+		Complete(withReconcileErrorMetrics("llmquota", r))
+}
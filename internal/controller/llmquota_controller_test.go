@@ -0,0 +1,152 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/proxy"
+)
+
+func Test_LLMQuota(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	batchKey := corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "batch"}, Key: "api-key"}
+	llmEngine.Spec.Auth = &aitrigramv1.AuthSpec{APIKeys: []aitrigramv1.APIKeySpec{
+		{SecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "team-a"}, Key: "api-key"}},
+		{SecretRef: batchKey},
+	}}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 1},
+	}
+	quota := &aitrigramv1.LLMQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "default"},
+		Spec: aitrigramv1.LLMQuotaSpec{
+			ModelRef: "llama3",
+			Limits: []aitrigramv1.QuotaLimit{
+				{APIKey: &batchKey, RequestsPerSecond: 3, TokensPerMinute: 10000},
+				{RequestsPerSecond: 10},
+			},
+		},
+	}
+	labels := llmModelLabels("ollama-llama3")
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel, quota, newReadyPod("ollama-llama3-a", labels), newReadyPod("ollama-llama3-b", labels)).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &aitrigramv1.LLMQuota{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme, ProxyImage: "aitrigram:latest"}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	// the proxy sidecar of the single replica enforces the limits
+	key := types.NamespacedName{Name: "ollama-llama3", Namespace: "default"}
+	configMap := &corev1.ConfigMap{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Name: "ollama-llama3-quota", Namespace: "default"}, configMap))
+	config := proxy.QuotaConfig{}
+	require.NoError(t, json.Unmarshal([]byte(configMap.Data[proxy.QuotaFileName]), &config))
+	require.Equal(t, []proxy.QuotaLimit{
+		{APIKeyFile: "/etc/aitrigram/auth/1", RequestsPerSecond: 3, TokensPerMinute: 10000},
+		{RequestsPerSecond: 10},
+	}, config.Limits)
+	require.True(t, metav1.IsControlledBy(configMap, llmModel))
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	sidecar := deployment.Spec.Template.Spec.Containers[1]
+	require.Contains(t, sidecar.Args, "--quota-config=/etc/aitrigram/quota/quota.json")
+	require.Contains(t, sidecar.VolumeMounts, corev1.VolumeMount{Name: quotaVolumeName, MountPath: quotaMountPath, ReadOnly: true})
+
+	// the usage of the pods, like the old and the new one in a rollout, is summed in the status
+	quotaReconciler := &LLMQuotaReconciler{
+		Client: k8sClient,
+		Scheme: scheme,
+		usageFetcher: func(_ context.Context, pod *corev1.Pod) ([]proxy.QuotaUsage, error) {
+			return []proxy.QuotaUsage{
+				{APIKeyFile: "/etc/aitrigram/auth/1", Requests: 4, Tokens: 1200, Throttled: 1},
+				{APIKeyFile: "/etc/aitrigram/auth/0", Requests: 1, Tokens: 100},
+			}, nil
+		},
+	}
+	quotaReq := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(quota)}
+	result, err := quotaReconciler.Reconcile(ctx, quotaReq)
+	require.NoError(t, err)
+	require.Equal(t, quotaUsageRefreshInterval, result.RequeueAfter)
+	require.NoError(t, k8sClient.Get(ctx, quotaReq.NamespacedName, quota))
+	require.True(t, meta.IsStatusConditionTrue(quota.Status.Conditions, aitrigramv1.ConditionTypeReady))
+	require.Equal(t, []aitrigramv1.QuotaUsage{
+		{APIKey: "batch/api-key", Requests: 8, Tokens: 2400, Throttled: 2},
+		{APIKey: "team-a/api-key", Requests: 2, Tokens: 200},
+	}, quota.Status.Usage)
+
+	// the quota is rejected once the model has more than one replica, each pod would enforce the whole limits
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.Replicas = 2
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(configMap), configMap)))
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.NotContains(t, deployment.Spec.Template.Spec.Containers[1].Args, "--quota-config=/etc/aitrigram/quota/quota.json")
+	_, err = quotaReconciler.Reconcile(ctx, quotaReq)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(ctx, quotaReq.NamespacedName, quota))
+	condition := meta.FindStatusCondition(quota.Status.Conditions, aitrigramv1.ConditionTypeReady)
+	require.Equal(t, metav1.ConditionFalse, condition.Status)
+	require.Equal(t, "ReplicasNotSupported", condition.Reason)
+	require.Empty(t, quota.Status.Usage)
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	llmModel.Spec.Replicas = 1
+	require.NoError(t, k8sClient.Update(ctx, llmModel))
+	reconcileTimes(t, r, req, 1)
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(configMap), configMap))
+
+	// the API keys not in the auth of the engine are reported
+	quota.Spec.Limits[0].APIKey = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "unknown"}, Key: "api-key"}
+	require.NoError(t, k8sClient.Update(ctx, quota))
+	_, err = quotaReconciler.Reconcile(ctx, quotaReq)
+	require.NoError(t, err)
+	require.NoError(t, k8sClient.Get(ctx, quotaReq.NamespacedName, quota))
+	condition = meta.FindStatusCondition(quota.Status.Conditions, aitrigramv1.ConditionTypeReady)
+	require.Equal(t, metav1.ConditionFalse, condition.Status)
+	require.Equal(t, "APIKeyNotFound", condition.Reason)
+
+	// deleting the last LLMQuota deletes the quota ConfigMap and the sidecar
+	require.NoError(t, k8sClient.Get(ctx, client.ObjectKeyFromObject(llmEngine), llmEngine))
+	llmEngine.Spec.Auth = nil
+	require.NoError(t, k8sClient.Update(ctx, llmEngine))
+	require.NoError(t, k8sClient.Delete(ctx, quota))
+	reconcileTimes(t, r, req, 1)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(configMap), configMap)))
+	require.NoError(t, k8sClient.Get(ctx, key, deployment))
+	require.Len(t, deployment.Spec.Template.Spec.Containers, 1)
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+var (
+	llmEngineModels = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aitrigram_llmengine_models",
+		Help: "Number of LLMModels referring to the LLMEngine.",
+	}, []string{"namespace", "llmengine"})
+
+	llmModelDesiredReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aitrigram_llmmodel_desired_replicas",
+		Help: "Number of the desired pods of the Deployment serving the LLMModel.",
+	}, []string{"namespace", "llmmodel"})
+
+	llmModelReadyReplicas = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aitrigram_llmmodel_ready_replicas",
+		Help: "Number of the ready pods of the Deployment serving the LLMModel.",
+	}, []string{"namespace", "llmmodel"})
+
+	llmModelDownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aitrigram_llmmodel_download_duration_seconds",
+		Help:    "Duration of the init containers downloading the LLMModel which have completed successfully.",
+		Buckets: prometheus.ExponentialBuckets(10, 2, 10),
+	}, []string{"namespace", "llmmodel"})
+
+	llmModelDownloadFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aitrigram_llmmodel_download_failures_total",
+		Help: "Number of the failed runs of the init containers downloading the LLMModel.",
+	}, []string{"namespace", "llmmodel"})
+
+	llmModelTimeToReady = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aitrigram_llmmodel_time_to_ready_seconds",
+		Help:    "Duration from the creation of the LLMModel to its first ready pod.",
+		Buckets: prometheus.ExponentialBuckets(10, 2, 10),
+	}, []string{"engine_type"})
+
+	reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "aitrigram_reconcile_errors_total",
+		Help: "Number of the errors returned by the reconcilers, by the reason of the error.",
+	}, []string{"controller", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		llmEngineModels,
+		llmModelDesiredReplicas,
+		llmModelReadyReplicas,
+		llmModelDownloadDuration,
+		llmModelDownloadFailures,
+		llmModelTimeToReady,
+		reconcileErrors,
+	)
+}
+
+// withReconcileErrorMetrics counts the errors returned by the reconciler in the aitrigram_reconcile_errors_total
+func withReconcileErrorMetrics(controllerName string, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		result, err := r.Reconcile(ctx, req)
+		if err != nil {
+			reconcileErrors.WithLabelValues(controllerName, reconcileErrorReason(err)).Inc()
+		}
+		return result, err
+	})
+}
+
+// Returns the reason of the api server error, like Conflict or NotFound, it is Unknown for other errors
+func reconcileErrorReason(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return "Timeout"
+	}
+	if reason := apierrors.ReasonForError(err); reason != "" {
+		return string(reason)
+	}
+	return "Unknown"
+}
+
+// downloadTracker remembers the init containers already counted, so that each download is counted once
+// no matter how many times the LLMModel gets reconciled.
+type downloadTracker struct {
+	mu sync.Mutex
+	// the downloads of the pods of each LLMModel, by the pod UID
+	models map[types.NamespacedName]map[types.UID]downloadState
+}
+
+type downloadState struct {
+	// the restarts of the init container which have been counted as failures
+	failures int32
+	// the duration of the successful download has been observed
+	observed bool
+	// the start of the download has been recorded
+	started bool
+}
+
+var downloads = &downloadTracker{models: map[types.NamespacedName]map[types.UID]downloadState{}}
+
+// record counts the failed runs and observes the duration of the successful runs of the init container
+// downloading the LLMModel in the pods. The pods which are gone are forgotten.
+// It returns the downloads started or failed since the last time.
+func (d *downloadTracker) record(model types.NamespacedName, pods []corev1.Pod, initContainerName string) []downloadEvent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var events []downloadEvent
+	previous := d.models[model]
+	current := make(map[types.UID]downloadState, len(pods))
+	for _, pod := range pods {
+		for _, status := range pod.Status.InitContainerStatuses {
+			if status.Name != initContainerName {
+				continue
+			}
+			state := previous[pod.UID]
+			if !state.started && status.State.Running != nil {
+				events = append(events, downloadEvent{pod: pod.Name})
+				state.started = true
+			}
+			// each restart of the init container is a failed run
+			if status.RestartCount > state.failures {
+				llmModelDownloadFailures.WithLabelValues(model.Namespace, model.Name).Add(float64(status.RestartCount - state.failures))
+				state.failures = status.RestartCount
+				events = append(events, downloadEvent{pod: pod.Name, failed: true, message: DownloadFailureMessage(status)})
+			}
+			if terminated := status.State.Terminated; !state.observed && terminated != nil && terminated.ExitCode == 0 {
+				llmModelDownloadDuration.WithLabelValues(model.Namespace, model.Name).
+					Observe(terminated.FinishedAt.Sub(terminated.StartedAt.Time).Seconds())
+				state.observed = true
+			}
+			current[pod.UID] = state
+		}
+	}
+	d.models[model] = current
+	return events
+}
+
+func (d *downloadTracker) forget(model types.NamespacedName) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.models, model)
+}
+
+// Records the replicas of the Deployment serving the LLMModel and the downloads of its pods
+func (r *LLMModelReconciler) recordLLMModelMetrics(ctx context.Context, req ctrl.Request, llmModel *aitrigramv1.LLMModel, deployment *appsv1.Deployment, initContainerName string) error {
+	desired := int32(0)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	llmModelDesiredReplicas.WithLabelValues(req.Namespace, req.Name).Set(float64(desired))
+	llmModelReadyReplicas.WithLabelValues(req.Namespace, req.Name).Set(float64(deployment.Status.ReadyReplicas))
+	podList := &corev1.PodList{}
+	if err := r.List(ctx, podList, client.InNamespace(req.Namespace), client.MatchingLabels(deployment.Spec.Selector.MatchLabels)); err != nil {
+		return err
+	}
+	recordDownloadEvents(r.Recorder, llmModel, downloads.record(req.NamespacedName, podList.Items, initContainerName))
+	return nil
+}
+
+// Removes the metrics of the LLMModel once it is deleted
+func forgetLLMModelMetrics(model types.NamespacedName) {
+	llmModelDesiredReplicas.DeleteLabelValues(model.Namespace, model.Name)
+	llmModelReadyReplicas.DeleteLabelValues(model.Namespace, model.Name)
+	llmModelDownloadDuration.DeleteLabelValues(model.Namespace, model.Name)
+	llmModelDownloadFailures.DeleteLabelValues(model.Namespace, model.Name)
+	downloads.forget(model)
+}
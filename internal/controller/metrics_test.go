@@ -0,0 +1,169 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// Scrapes the metric with the labels from the registry of the operator, it is nil when there is no such metric
+func scrapeMetric(t *testing.T, name string, labels map[string]string) *dto.Metric {
+	families, err := metrics.Registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			matched := 0
+			for _, label := range metric.GetLabel() {
+				if value, ok := labels[label.GetName()]; ok && value == label.GetValue() {
+					matched++
+				}
+			}
+			if matched == len(labels) {
+				return metric
+			}
+		}
+	}
+	return nil
+}
+
+// It is not parallel, the time to ready is observed for all LLMModels of the same engine type
+func Test_LLMModelMetrics(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	llmEngine := newTestLLMEngine()
+	llmEngine.Namespace = "metrics"
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "metrics", CreationTimestamp: metav1.NewTime(time.Now().Add(-time.Minute))},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: llmEngine.Name, Replicas: 2},
+	}
+	k8sClient := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(llmEngine, llmModel).
+		WithStatusSubresource(&aitrigramv1.LLMModel{}, &aitrigramv1.LLMEngine{}, &appsv1.Deployment{}).
+		Build()
+	r := &LLMModelReconciler{Client: k8sClient, Scheme: scheme}
+	req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmModel)}
+	reconcileTimes(t, r, req, 3)
+
+	modelLabels := map[string]string{"namespace": "metrics", "llmmodel": "llama3"}
+	require.Equal(t, float64(2), scrapeMetric(t, "aitrigram_llmmodel_desired_replicas", modelLabels).GetGauge().GetValue())
+	require.Equal(t, float64(0), scrapeMetric(t, "aitrigram_llmmodel_ready_replicas", modelLabels).GetGauge().GetValue())
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	available := meta.FindStatusCondition(llmModel.Status.Conditions, aitrigramv1.ConditionTypeAvailable)
+	require.NotNil(t, available)
+	require.Equal(t, llmModelReasonDeploying, available.Reason)
+
+	engineReconciler := &LLMEngineReconciler{Client: k8sClient, Scheme: scheme}
+	for i := 0; i < 2; i++ {
+		_, err := engineReconciler.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(llmEngine)})
+		require.NoError(t, err)
+	}
+	require.Equal(t, float64(1), scrapeMetric(t, "aitrigram_llmengine_models",
+		map[string]string{"namespace": "metrics", "llmengine": "ollama"}).GetGauge().GetValue())
+
+	// one pod has downloaded the model in 90 seconds after 2 failures
+	start := metav1.NewTime(time.Now().Add(-2 * time.Minute))
+	pod := newReadyPod("ollama-llama3-0", llmModelLabels("ollama-llama3"))
+	pod.Namespace = "metrics"
+	pod.UID = "ollama-llama3-0-uid"
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{
+		Name:         "init-ollama-llama3",
+		RestartCount: 2,
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			StartedAt:  start,
+			FinishedAt: metav1.NewTime(start.Add(90 * time.Second)),
+		}},
+	}}
+	require.NoError(t, k8sClient.Create(ctx, pod))
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, k8sClient.Get(ctx, types.NamespacedName{Namespace: "metrics", Name: "ollama-llama3"}, deployment))
+	deployment.Status.Replicas = 2
+	deployment.Status.ReadyReplicas = 1
+	require.NoError(t, k8sClient.Status().Update(ctx, deployment))
+
+	timeToReady := scrapeMetric(t, "aitrigram_llmmodel_time_to_ready_seconds", map[string]string{"engine_type": "ollama"})
+	readyBefore := timeToReady.GetHistogram().GetSampleCount()
+	// each download is counted once no matter how many times it is reconciled
+	reconcileTimes(t, r, req, 3)
+	require.Equal(t, float64(1), scrapeMetric(t, "aitrigram_llmmodel_ready_replicas", modelLabels).GetGauge().GetValue())
+	require.Equal(t, float64(2), scrapeMetric(t, "aitrigram_llmmodel_download_failures_total", modelLabels).GetCounter().GetValue())
+	downloadDuration := scrapeMetric(t, "aitrigram_llmmodel_download_duration_seconds", modelLabels).GetHistogram()
+	require.Equal(t, uint64(1), downloadDuration.GetSampleCount())
+	require.Equal(t, float64(90), downloadDuration.GetSampleSum())
+	timeToReady = scrapeMetric(t, "aitrigram_llmmodel_time_to_ready_seconds", map[string]string{"engine_type": "ollama"})
+	require.Equal(t, readyBefore+1, timeToReady.GetHistogram().GetSampleCount())
+	require.NoError(t, k8sClient.Get(ctx, req.NamespacedName, llmModel))
+	require.True(t, meta.IsStatusConditionTrue(llmModel.Status.Conditions, aitrigramv1.ConditionTypeAvailable))
+
+	// no ready pod after that is not counted again once the pods are back
+	deployment.Status.ReadyReplicas = 0
+	require.NoError(t, k8sClient.Status().Update(ctx, deployment))
+	reconcileTimes(t, r, req, 1)
+	deployment.Status.ReadyReplicas = 2
+	require.NoError(t, k8sClient.Status().Update(ctx, deployment))
+	reconcileTimes(t, r, req, 1)
+	timeToReady = scrapeMetric(t, "aitrigram_llmmodel_time_to_ready_seconds", map[string]string{"engine_type": "ollama"})
+	require.Equal(t, readyBefore+1, timeToReady.GetHistogram().GetSampleCount())
+
+	// the metrics of the LLMModel are removed once it is deleted
+	require.NoError(t, k8sClient.Delete(ctx, llmModel))
+	reconcileTimes(t, r, req, 5)
+	require.True(t, apierrors.IsNotFound(k8sClient.Get(ctx, req.NamespacedName, llmModel)))
+	require.Nil(t, scrapeMetric(t, "aitrigram_llmmodel_desired_replicas", modelLabels))
+	require.Nil(t, scrapeMetric(t, "aitrigram_llmmodel_download_failures_total", modelLabels))
+}
+
+func Test_ReconcileErrorMetrics(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		err    error
+		reason string
+	}{
+		"conflict": {
+			err:    apierrors.NewConflict(schema.GroupResource{Resource: "llmmodels"}, "llama3", errors.New("changed")),
+			reason: "Conflict",
+		},
+		"timeout": {
+			err:    context.DeadlineExceeded,
+			reason: "Timeout",
+		},
+		"other": {
+			err:    errors.New("failed"),
+			reason: "Unknown",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			controllerName := "test-" + name
+			r := withReconcileErrorMetrics(controllerName, reconcile.Func(func(context.Context, ctrl.Request) (ctrl.Result, error) {
+				return ctrl.Result{}, c.err
+			}))
+			_, err := r.Reconcile(context.Background(), ctrl.Request{})
+			require.ErrorIs(t, err, c.err)
+			metric := scrapeMetric(t, "aitrigram_reconcile_errors_total", map[string]string{"controller": controllerName, "reason": c.reason})
+			require.Equal(t, float64(1), metric.GetCounter().GetValue())
+		})
+	}
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// WatchNamespaces are the namespaces the operator watches instead of the whole cluster, either by their names or
+// by a label selector of them
+type WatchNamespaces struct {
+	// Names are the names of the namespaces
+	Names []string
+	// Selector selects the namespaces by their labels when there are no Names
+	Selector labels.Selector
+}
+
+// ParseWatchNamespaces parses a comma separated list of the names of the namespaces, like: team-a,team-b, or a label
+// selector of the namespaces when it is not a list of names, like: tenant=llm. It returns nil for an empty value,
+// which is the whole cluster.
+func ParseWatchNamespaces(value string) (*WatchNamespaces, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if len(validation.IsDNS1123Label(name)) > 0 {
+			names = nil
+			break
+		}
+		names = append(names, name)
+	}
+	if len(names) > 0 {
+		slices.Sort(names)
+		return &WatchNamespaces{Names: slices.Compact(names)}, nil
+	}
+	selector, err := labels.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("%q is neither a list of namespaces nor a label selector: %w", value, err)
+	}
+	if selector.Empty() {
+		return nil, fmt.Errorf("%q selects no namespace", value)
+	}
+	return &WatchNamespaces{Selector: selector}, nil
+}
+
+func (w *WatchNamespaces) String() string {
+	if w.Selector != nil {
+		return w.Selector.String()
+	}
+	return strings.Join(w.Names, ",")
+}
+
+// Resolve returns the names of the namespaces, the ones matching the selector are listed by the reader, which must
+// not be restricted to the namespaces. The namespaces labeled after it are not watched until the operator restarts,
+// see Watch.
+func (w *WatchNamespaces) Resolve(ctx context.Context, c client.Reader) ([]string, error) {
+	if w.Selector == nil {
+		return w.Names, nil
+	}
+	namespaceList := &corev1.NamespaceList{}
+	if err := c.List(ctx, namespaceList, client.MatchingLabelsSelector{Selector: w.Selector}); err != nil {
+		return nil, fmt.Errorf("failed to list the namespaces matching %s: %w", w.Selector, err)
+	}
+	var names []string
+	for _, namespace := range namespaceList.Items {
+		names = append(names, namespace.Name)
+	}
+	if len(names) == 0 {
+		// an empty list would be the whole cluster for the cache of the manager
+		return nil, fmt.Errorf("no namespace matches %s", w.Selector)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Watch watches the namespaces matching the selector, and returns an error once they are not the resolved namespaces
+// anymore, so that the operator exits and restarts with its cache restricted to the new ones. The client must not be
+// restricted to the namespaces. It returns when the context is done for the names, which never change.
+func (w *WatchNamespaces) Watch(ctx context.Context, c client.WithWatch, namespaces []string) error {
+	if w.Selector == nil {
+		<-ctx.Done()
+		return nil
+	}
+	for {
+		namespaceList := &corev1.NamespaceList{}
+		if err := c.List(ctx, namespaceList, client.MatchingLabelsSelector{Selector: w.Selector}); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to list the namespaces matching %s: %w", w.Selector, err)
+		}
+		matching := map[string]bool{}
+		for _, namespace := range namespaceList.Items {
+			matching[namespace.Name] = true
+		}
+		if err := w.checkNamespaces(matching, namespaces); err != nil {
+			return err
+		}
+		watcher, err := c.Watch(ctx, &corev1.NamespaceList{}, client.MatchingLabelsSelector{Selector: w.Selector},
+			&client.ListOptions{Raw: &metav1.ListOptions{ResourceVersion: namespaceList.ResourceVersion}})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("failed to watch the namespaces matching %s: %w", w.Selector, err)
+		}
+		err = w.watchNamespaces(ctx, watcher, matching, namespaces)
+		watcher.Stop()
+		if err != nil || ctx.Err() != nil {
+			return err
+		}
+		// the watch has expired, the namespaces are listed again
+	}
+}
+
+// Updates the matching namespaces with the events until the watch ends or they are not the resolved ones
+func (w *WatchNamespaces) watchNamespaces(ctx context.Context, watcher watch.Interface, matching map[string]bool, namespaces []string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return nil
+			}
+			namespace, isNamespace := event.Object.(*corev1.Namespace)
+			if !isNamespace {
+				// like the expired resource version
+				return nil
+			}
+			// the labels are checked again, a namespace not matching anymore may be sent as modified
+			matching[namespace.Name] = event.Type != watch.Deleted && w.Selector.Matches(labels.Set(namespace.Labels))
+			if err := w.checkNamespaces(matching, namespaces); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (w *WatchNamespaces) checkNamespaces(matching map[string]bool, namespaces []string) error {
+	var names []string
+	for name, matches := range matching {
+		if matches {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, namespaces) {
+		return fmt.Errorf("the namespaces matching %s have changed from %v to %v", w.Selector, namespaces, names)
+	}
+	return nil
+}
+
+// RBAC returns the Roles with the rules of the ClusterRole of the operator and the RoleBindings of them to its
+// ServiceAccount in each of the namespaces, to install the operator without the ClusterRole bound to it. The
+// ClusterRole to list and watch the namespaces is added when they are selected by their labels.
+func (w *WatchNamespaces) RBAC(clusterRole *rbacv1.ClusterRole, serviceAccount types.NamespacedName, namespaces []string) []client.Object {
+	subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: serviceAccount.Name, Namespace: serviceAccount.Namespace}}
+	var objects []client.Object
+	if w.Selector != nil {
+		name := strings.TrimSuffix(clusterRole.Name, "manager-role") + "namespace-reader-role"
+		objects = append(objects,
+			&rbacv1.ClusterRole{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+				ObjectMeta: metav1.ObjectMeta{Name: name, Labels: clusterRole.Labels},
+				Rules: []rbacv1.PolicyRule{{
+					APIGroups: []string{""},
+					Resources: []string{"namespaces"},
+					Verbs:     []string{"get", "list", "watch"},
+				}},
+			},
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: name + "binding", Labels: clusterRole.Labels},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: name},
+				Subjects:   subjects,
+			})
+	}
+	for _, namespace := range namespaces {
+		objects = append(objects,
+			&rbacv1.Role{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "Role"},
+				ObjectMeta: metav1.ObjectMeta{Name: clusterRole.Name, Namespace: namespace, Labels: clusterRole.Labels},
+				Rules:      clusterRole.Rules,
+			},
+			&rbacv1.RoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "RoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: clusterRole.Name + "binding", Namespace: namespace, Labels: clusterRole.Labels},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: clusterRole.Name},
+				Subjects:   subjects,
+			})
+	}
+	return objects
+}
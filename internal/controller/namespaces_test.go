@@ -0,0 +1,203 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_ParseWatchNamespaces(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		value    string
+		names    []string
+		selector string
+		err      string
+	}{
+		{name: "empty is the whole cluster", value: " "},
+		{name: "one namespace", value: "team-a", names: []string{"team-a"}},
+		{name: "sorted without duplicates", value: "team-b, team-a,team-b", names: []string{"team-a", "team-b"}},
+		{name: "equality selector", value: "tenant=llm", selector: "tenant=llm"},
+		{name: "set selector", value: "tenant in (a,b),!legacy", selector: "!legacy,tenant in (a,b)"},
+		{name: "empty name", value: "a,,b", err: "is neither a list of namespaces nor a label selector"},
+		{name: "invalid selector", value: "tenant in (a", err: "is neither a list of namespaces nor a label selector"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			watchNamespaces, err := ParseWatchNamespaces(tt.value)
+			if tt.err != "" {
+				require.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			switch {
+			case tt.names != nil:
+				require.Equal(t, tt.names, watchNamespaces.Names)
+				require.Nil(t, watchNamespaces.Selector)
+			case tt.selector != "":
+				require.Empty(t, watchNamespaces.Names)
+				require.Equal(t, tt.selector, watchNamespaces.String())
+			default:
+				require.Nil(t, watchNamespaces)
+			}
+		})
+	}
+}
+
+func Test_WatchNamespacesResolve(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	newNamespace := func(name string, labels map[string]string) *corev1.Namespace {
+		return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+		newNamespace("team-b", map[string]string{"tenant": "llm"}),
+		newNamespace("team-a", map[string]string{"tenant": "llm"}),
+		newNamespace("other", map[string]string{"tenant": "web"}),
+	).Build()
+
+	watchNamespaces, err := ParseWatchNamespaces("tenant=llm")
+	require.NoError(t, err)
+	namespaces, err := watchNamespaces.Resolve(ctx, c)
+	require.NoError(t, err)
+	require.Equal(t, []string{"team-a", "team-b"}, namespaces)
+
+	// the names are taken as they are, even the missing ones
+	watchNamespaces, err = ParseWatchNamespaces("team-a,missing")
+	require.NoError(t, err)
+	namespaces, err = watchNamespaces.Resolve(ctx, c)
+	require.NoError(t, err)
+	require.Equal(t, []string{"missing", "team-a"}, namespaces)
+
+	// no namespace would be the whole cluster
+	watchNamespaces, err = ParseWatchNamespaces("tenant=none")
+	require.NoError(t, err)
+	_, err = watchNamespaces.Resolve(ctx, c)
+	require.ErrorContains(t, err, "no namespace matches tenant=none")
+}
+
+func Test_WatchNamespacesWatch(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"tenant": "llm"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other", Labels: map[string]string{"tenant": "web"}}},
+	).Build()
+	watchNamespaces, err := ParseWatchNamespaces("tenant=llm")
+	require.NoError(t, err)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- watchNamespaces.Watch(ctx, c, []string{"team-a"})
+	}()
+
+	// the namespaces not matching the selector do not matter
+	other := &corev1.Namespace{}
+	require.NoError(t, c.Get(ctx, client.ObjectKey{Name: "other"}, other))
+	other.Labels["team"] = "web"
+	require.NoError(t, c.Update(ctx, other))
+	select {
+	case err := <-watchErr:
+		require.Fail(t, "the watch has returned", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// it returns once a namespace starts matching the selector
+	other.Labels["tenant"] = "llm"
+	require.NoError(t, c.Update(ctx, other))
+	select {
+	case err := <-watchErr:
+		require.ErrorContains(t, err, "the namespaces matching tenant=llm have changed from [team-a] to [other team-a]")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "the watch has not returned")
+	}
+
+	// the names never change
+	watchNamespaces, err = ParseWatchNamespaces("team-a")
+	require.NoError(t, err)
+	cancel()
+	require.NoError(t, watchNamespaces.Watch(ctx, c, []string{"team-a"}))
+}
+
+func Test_WatchNamespacesRBAC(t *testing.T) {
+	t.Parallel()
+	clusterRole := &rbacv1.ClusterRole{
+		ObjectMeta: metav1.ObjectMeta{Name: "aitrigram-manager-role"},
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"get", "list", "watch"},
+		}},
+	}
+	serviceAccount := types.NamespacedName{Namespace: "aitrigram-system", Name: "aitrigram-controller-manager"}
+	subjects := []rbacv1.Subject{{Kind: "ServiceAccount", Name: "aitrigram-controller-manager", Namespace: "aitrigram-system"}}
+
+	watchNamespaces, err := ParseWatchNamespaces("team-a,team-b")
+	require.NoError(t, err)
+	objects := watchNamespaces.RBAC(clusterRole, serviceAccount, watchNamespaces.Names)
+	require.Len(t, objects, 4)
+	for i, namespace := range []string{"team-a", "team-b"} {
+		role, ok := objects[2*i].(*rbacv1.Role)
+		require.True(t, ok)
+		require.Equal(t, "aitrigram-manager-role", role.Name)
+		require.Equal(t, namespace, role.Namespace)
+		require.Equal(t, "Role", role.Kind)
+		require.Equal(t, clusterRole.Rules, role.Rules)
+		roleBinding, ok := objects[2*i+1].(*rbacv1.RoleBinding)
+		require.True(t, ok)
+		require.Equal(t, "aitrigram-manager-rolebinding", roleBinding.Name)
+		require.Equal(t, namespace, roleBinding.Namespace)
+		require.Equal(t, rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: "aitrigram-manager-role"}, roleBinding.RoleRef)
+		require.Equal(t, subjects, roleBinding.Subjects)
+	}
+
+	// the namespaces selected by their labels are listed cluster wide
+	watchNamespaces, err = ParseWatchNamespaces("tenant=llm")
+	require.NoError(t, err)
+	objects = watchNamespaces.RBAC(clusterRole, serviceAccount, []string{"team-a"})
+	require.Len(t, objects, 4)
+	reader, ok := objects[0].(*rbacv1.ClusterRole)
+	require.True(t, ok)
+	require.Equal(t, "aitrigram-namespace-reader-role", reader.Name)
+	require.Equal(t, []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"get", "list", "watch"}}}, reader.Rules)
+	readerBinding, ok := objects[1].(*rbacv1.ClusterRoleBinding)
+	require.True(t, ok)
+	require.Equal(t, "aitrigram-namespace-reader-rolebinding", readerBinding.Name)
+	require.Equal(t, "aitrigram-namespace-reader-role", readerBinding.RoleRef.Name)
+	require.Equal(t, subjects, readerBinding.Subjects)
+	require.Equal(t, "team-a", objects[2].GetNamespace())
+}
+
+func Test_ModelWakerNamespaces(t *testing.T) {
+	t.Parallel()
+	waker := &ModelWaker{Client: fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build(), Namespaces: []string{"team-a"}}
+	_, err := waker.Wake(context.Background(), "ollama-llama3.team-b.svc:8080", "http")
+	require.ErrorContains(t, err, "the namespace team-b of the Service ollama-llama3 is not watched by the operator")
+	// the Services in the watched namespaces are looked up
+	_, err = waker.Wake(context.Background(), "ollama-llama3.team-a.svc:8080", "http")
+	require.ErrorContains(t, err, "not found")
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// RenderOptions are the settings of the operator which the rendered resources depend on
+type RenderOptions struct {
+	// ProxyImage is the image of the proxy sidecar and of the gateways
+	ProxyImage string
+}
+
+// Render returns the Deployments and the Services the operator creates for the LLMEngines and the LLMModels in
+// the reader, like one over the manifests in files, without a cluster. The specs are defaulted and the resources
+// are built the same way as the reconcilers do, and the LLMAdapters, the LLMQuotas and the ConfigMaps of the
+// Modelfiles in the reader are taken into account. The state in the cluster, like the models scaled to zero,
+// is not, and the owner references are left out.
+func Render(ctx context.Context, c client.Reader, scheme *runtime.Scheme, opts RenderOptions) ([]client.Object, error) {
+	llmEngineList := &aitrigramv1.LLMEngineList{}
+	if err := c.List(ctx, llmEngineList); err != nil {
+		return nil, err
+	}
+	llmModelList := &aitrigramv1.LLMModelList{}
+	if err := c.List(ctx, llmModelList); err != nil {
+		return nil, err
+	}
+	llmEngines := llmEngineList.Items
+	sort.Slice(llmEngines, func(i, j int) bool {
+		return client.ObjectKeyFromObject(&llmEngines[i]).String() < client.ObjectKeyFromObject(&llmEngines[j]).String()
+	})
+	llmModels := llmModelList.Items
+	sort.Slice(llmModels, func(i, j int) bool {
+		return client.ObjectKeyFromObject(&llmModels[i]).String() < client.ObjectKeyFromObject(&llmModels[j]).String()
+	})
+
+	engineReconciler := &LLMEngineReconciler{Scheme: scheme, ProxyImage: opts.ProxyImage}
+	modelReconciler := &LLMModelReconciler{Scheme: scheme, ProxyImage: opts.ProxyImage}
+	var objs []client.Object
+	engines := map[types.NamespacedName]*aitrigramv1.LLMEngine{}
+	for i := range llmEngines {
+		llmEngine := &llmEngines[i]
+		spec, err := MergeLLMSpecs(DefaultsOfLLMEngine(llmEngine), &llmEngine.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to default the LLMEngine %s: %w", llmEngine.Name, err)
+		}
+		llmEngine.Spec = *spec
+		engines[client.ObjectKeyFromObject(llmEngine)] = llmEngine
+	}
+
+	modelsOfEngines := map[types.NamespacedName][]aitrigramv1.LLMModel{}
+	for i := range llmModels {
+		llmModel := &llmModels[i]
+		engineKey := types.NamespacedName{Namespace: llmModel.Namespace, Name: llmModel.Spec.EngineRef}
+		llmEngine, ok := engines[engineKey]
+		if !ok {
+			return nil, fmt.Errorf("the LLMEngine %s of the LLMModel %s is not found", engineKey, client.ObjectKeyFromObject(llmModel))
+		}
+		modelDeployment, err := MergeModelDeploymentTemplate(llmEngine.Spec.ModelDeploymentTemplate.DeepCopy(), llmModel.Spec.ModelDeployment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to default the LLMModel %s: %w", llmModel.Name, err)
+		}
+		llmModel.Spec.ModelDeployment = modelDeployment
+		modelsOfEngines[engineKey] = append(modelsOfEngines[engineKey], *llmModel)
+	}
+
+	for i := range llmEngines {
+		llmEngine := &llmEngines[i]
+		if isSharedServing(llmEngine) {
+			servedModels, modelfiles, err := sharedServingModels(ctx, c, modelsOfEngines[client.ObjectKeyFromObject(llmEngine)])
+			if err != nil {
+				return nil, err
+			}
+			deployment, err := engineReconciler.newSharedDeployment(llmEngine, servedModels, modelfiles)
+			if err != nil {
+				return nil, err
+			}
+			service, err := engineReconciler.newSharedService(llmEngine)
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, deployment, service)
+		}
+		if gateway := llmEngine.Spec.Gateway; gateway != nil && gateway.Enabled {
+			deployment, err := engineReconciler.newGatewayDeployment(llmEngine)
+			if err != nil {
+				return nil, err
+			}
+			service, err := engineReconciler.newGatewayService(llmEngine)
+			if err != nil {
+				return nil, err
+			}
+			objs = append(objs, deployment, service)
+		}
+	}
+
+	for i := range llmModels {
+		llmModel := &llmModels[i]
+		llmEngine := engines[types.NamespacedName{Namespace: llmModel.Namespace, Name: llmModel.Spec.EngineRef}]
+		if isSharedServing(llmEngine) {
+			continue
+		}
+		params := ReconcileParams{llmEngine: llmEngine, model: llmModel}
+		var err error
+		if params.adapters, err = llmAdaptersOfModel(ctx, c, llmModel); err != nil {
+			return nil, err
+		}
+		if params.modelfile, err = resolveModelfile(ctx, c, llmModel); err != nil {
+			return nil, fmt.Errorf("failed to resolve the Modelfile of the LLMModel %s: %w", llmModel.Name, err)
+		}
+		if params.quotas, err = llmQuotasOfModel(ctx, c, llmModel); err != nil {
+			return nil, err
+		}
+		nameSpaceName := &types.NamespacedName{
+			Namespace: llmModel.Namespace,
+			Name:      llmModelResourceName(llmEngine.Spec.EngineType, llmModel.Spec.Name),
+		}
+		deployment, err := modelReconciler.newLLMModelDeployment(nameSpaceName, params)
+		if err != nil {
+			return nil, err
+		}
+		service, err := modelReconciler.newLLMEngineService(nameSpaceName, params)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, deployment, service)
+	}
+
+	for _, obj := range objs {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+		obj.SetOwnerReferences(nil)
+	}
+	return objs, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+func Test_Render(t *testing.T) {
+	t.Parallel()
+	scheme := newTestScheme(t)
+	// the engines and the models as written in the manifests, without the defaults
+	perModel := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama", Namespace: "default"},
+		Spec:       aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeOllama},
+	}
+	shared := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "default"},
+		Spec:       aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeOllama, ServingMode: aitrigramv1.ServingModeShared},
+	}
+	llama3 := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name: "llama3", EngineRef: "ollama", Replicas: 2,
+			Modelfile: &aitrigramv1.ModelfileSpec{
+				From:         "llama3.2",
+				ConfigMapRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "modelfiles"}, Key: "llama3"},
+			},
+		},
+	}
+	qwen := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "qwen", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "qwen", EngineRef: "shared", Replicas: 1},
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "modelfiles", Namespace: "default"},
+		Data:       map[string]string{"llama3": "PARAMETER temperature 0.2"},
+	}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(perModel, shared, llama3, qwen, configMap).Build()
+
+	objs, err := Render(context.Background(), reader, scheme, RenderOptions{ProxyImage: "aitrigram:latest"})
+	require.NoError(t, err)
+	names := []string{}
+	for _, obj := range objs {
+		require.Empty(t, obj.GetOwnerReferences())
+		names = append(names, obj.GetObjectKind().GroupVersionKind().Kind+"/"+obj.GetName())
+	}
+	require.Equal(t, []string{"Deployment/shared-shared", "Service/shared-shared", "Deployment/ollama-llama3", "Service/ollama-llama3"}, names)
+
+	// the model is defaulted from the engine profile and its Modelfile is resolved from the ConfigMap
+	deployment := objs[2].(*appsv1.Deployment)
+	require.Equal(t, int32(2), *deployment.Spec.Replicas)
+	require.Equal(t, defaultOllamaImage, deployment.Spec.Template.Spec.Containers[0].Image)
+	require.Equal(t, restrictedPodSecurityContext(), deployment.Spec.Template.Spec.SecurityContext)
+	require.True(t, strings.Contains(deployment.Spec.Template.Spec.InitContainers[0].Args[0], "PARAMETER temperature 0.2"))
+	require.Len(t, objs[1].(*corev1.Service).Spec.Ports, 1)
+	require.Equal(t, "init-ollama-qwen", objs[0].(*appsv1.Deployment).Spec.Template.Spec.InitContainers[0].Name)
+
+	// a model of an engine not in the manifests can not be rendered
+	orphan := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "orphan", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "orphan", EngineRef: "vllm", Replicas: 1},
+	}
+	require.NoError(t, reader.(client.Client).Create(context.Background(), orphan))
+	_, err = Render(context.Background(), reader, scheme, RenderOptions{})
+	require.ErrorContains(t, err, "the LLMEngine default/vllm of the LLMModel default/orphan is not found")
+}
@@ -1,15 +1,72 @@
 package controller
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"slices"
+	"strings"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
 )
 
+// The name of the resources like Deployment and Service created for a LLMModel
+func llmModelResourceName(engineType aitrigramv1.LLMEngineType, modelName string) string {
+	return strings.ToLower(string(engineType) + "-" + strings.ReplaceAll(modelName, ".", "-"))
+}
+
+// the annotation of the Deployments with the hash of the pod template and the strategy desired by the operator
+const deploymentSpecHashAnnotation = "aitrigram.ihomeland.cn/spec-hash"
+
+// Sets the hash of the pod template and the strategy of the desired Deployment in its annotation. They are compared
+// by the hash instead of the fields, as the api server defaults many of them, and a removed container, volume or
+// annotation is not noticed by DeepDerivative.
+func setDeploymentSpecHash(desired *appsv1.Deployment) error {
+	data, err := json.Marshal([]interface{}{desired.Spec.Template, desired.Spec.Strategy})
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	metav1.SetMetaDataAnnotation(&desired.ObjectMeta, deploymentSpecHashAnnotation, hex.EncodeToString(sum[:])[:16])
+	return nil
+}
+
+// Reports if the Deployment in the cluster has the replicas and the spec hash of the desired one
+func deploymentUpToDate(desired, existing *appsv1.Deployment) bool {
+	return ptr.Deref(desired.Spec.Replicas, 1) == ptr.Deref(existing.Spec.Replicas, 1) &&
+		existing.Annotations[deploymentSpecHashAnnotation] == desired.Annotations[deploymentSpecHashAnnotation]
+}
+
+// Updates the Deployment in the cluster to the desired spec and spec hash, the fields removed from the spec are
+// removed by the merge patch as well
+func patchDeployment(ctx context.Context, c client.Client, desired, existing *appsv1.Deployment) error {
+	patch := client.MergeFrom(existing.DeepCopy())
+	existing.Spec = desired.Spec
+	metav1.SetMetaDataAnnotation(&existing.ObjectMeta, deploymentSpecHashAnnotation, desired.Annotations[deploymentSpecHashAnnotation])
+	return c.Patch(ctx, existing, patch)
+}
+
+// The labels put on the resources created for a LLMModel, they are used as selectors as well
+func llmModelLabels(instance string) map[string]string {
+	return map[string]string{"app": llmModelAppLabel, "instance": instance}
+}
+
+const llmModelAppLabel = "aitrigram-llmmodel"
+
+// The in-cluster URL of a Service
+func serviceURL(namespace, name string, port int32) string {
+	return fmt.Sprintf("http://%s.%s.svc:%d", name, namespace, port)
+}
+
 func MergeMaps[K comparable, V any](maps ...map[K]V) map[K]V {
 	result := make(map[K]V)
 	for _, m := range maps {
@@ -82,6 +139,33 @@ func LLMEngineSpecEquals(spec1, spec2 *aitrigramv1.LLMEngineSpec) bool {
 	if spec1.ServicePort != spec2.ServicePort {
 		return false
 	}
+	if !reflect.DeepEqual(spec1.Gateway, spec2.Gateway) {
+		return false
+	}
+	if !reflect.DeepEqual(spec1.Exposure, spec2.Exposure) {
+		return false
+	}
+	if spec1.ServingMode != spec2.ServingMode || !reflect.DeepEqual(spec1.SharedReplicas, spec2.SharedReplicas) {
+		return false
+	}
+	if spec1.SecurityProfile != spec2.SecurityProfile {
+		return false
+	}
+	if !reflect.DeepEqual(spec1.Monitoring, spec2.Monitoring) {
+		return false
+	}
+	if !reflect.DeepEqual(spec1.Auth, spec2.Auth) {
+		return false
+	}
+	if !reflect.DeepEqual(spec1.NetworkPolicy, spec2.NetworkPolicy) {
+		return false
+	}
+	if !reflect.DeepEqual(spec1.TLS, spec2.TLS) {
+		return false
+	}
+	if !reflect.DeepEqual(spec1.ServiceAccount, spec2.ServiceAccount) {
+		return false
+	}
 	if spec1.ModelDeploymentTemplate != nil && spec2.ModelDeploymentTemplate != nil {
 		return ModelDeploymentEquals(spec1.ModelDeploymentTemplate, spec2.ModelDeploymentTemplate)
 	} else if (spec1.ModelDeploymentTemplate) != nil != (spec2.ModelDeploymentTemplate != nil) {
@@ -103,12 +187,18 @@ func ModelDeploymentEquals(dep1, dep2 *aitrigramv1.ModelDeploymentTemplate) bool
 	if !slices.Equal(dep1.Args, dep2.Args) {
 		return false
 	}
-	if !envsEquals(*dep1.Envs, *dep2.Envs) {
+	if !envsEquals(ptr.Deref(dep1.Envs, nil), ptr.Deref(dep2.Envs, nil)) {
 		return false
 	}
 	if !reflect.DeepEqual(dep1.Storage, dep2.Storage) {
 		return false
 	}
+	if !reflect.DeepEqual(dep1.PodSecurityContext, dep2.PodSecurityContext) {
+		return false
+	}
+	if !reflect.DeepEqual(dep1.SecurityContext, dep2.SecurityContext) {
+		return false
+	}
 
 	return true
 }
@@ -0,0 +1,131 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// ValidateLLMEngine checks the LLMEngine, with its defaults, for what the schema of the CRD can not express and the
+// reconcilers rely on, like the ports of the Services which must not conflict.
+func ValidateLLMEngine(llmEngine *aitrigramv1.LLMEngine) field.ErrorList {
+	specPath := field.NewPath("spec")
+	spec, err := MergeLLMSpecs(DefaultsOfLLMEngine(llmEngine), &llmEngine.Spec)
+	if err != nil {
+		return field.ErrorList{field.Invalid(specPath, llmEngine.Spec.EngineType, err.Error())}
+	}
+
+	var allErrs field.ErrorList
+	if spec.Port == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("port"),
+			fmt.Sprintf("the engine type %s has no default port", spec.EngineType)))
+	} else if spec.Port == proxySidecarPort || spec.Port == proxySidecarTLSPort {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("port"), spec.Port, "the port is used by the proxy sidecar"))
+	}
+	if spec.ServicePort == 0 {
+		allErrs = append(allErrs, field.Required(specPath.Child("servicePort"),
+			fmt.Sprintf("the engine type %s has no default servicePort", spec.EngineType)))
+	}
+	if spec.TLS != nil && spec.TLS.Enabled {
+		tlsPath := specPath.Child("tls", "servicePort")
+		if tlsServicePort(spec.TLS) == spec.ServicePort {
+			allErrs = append(allErrs, field.Invalid(tlsPath, tlsServicePort(spec.TLS), "must differ from spec.servicePort"))
+		}
+		if spec.Gateway != nil && spec.Gateway.Enabled && tlsServicePort(spec.TLS) == gatewayServicePort(spec.Gateway) {
+			allErrs = append(allErrs, field.Invalid(tlsPath, tlsServicePort(spec.TLS), "must differ from spec.gateway.servicePort"))
+		}
+	}
+	if spec.Auth != nil {
+		if !bindsToLocalhost(spec.EngineType) {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("auth"),
+				fmt.Sprintf("the engine type %s can not be bound to the loopback address behind the proxy sidecar", spec.EngineType)))
+		}
+		for i, apiKey := range spec.Auth.APIKeys {
+			if apiKey.SecretRef.Name == "" {
+				allErrs = append(allErrs, field.Required(specPath.Child("auth", "apiKeys").Index(i).Child("secretRef", "name"), ""))
+			}
+		}
+	}
+	allErrs = append(allErrs, validateServiceAccount(specPath.Child("serviceAccount"), spec.ServiceAccount)...)
+	if isSharedServing(&aitrigramv1.LLMEngine{Spec: *spec}) {
+		allErrs = append(allErrs, sharedServingErrors(specPath, spec.NetworkPolicy, spec.ServiceAccount)...)
+		if spec.TLS != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("tls"), "not supported in the Shared servingMode"))
+		}
+	}
+	return allErrs
+}
+
+// ValidateLLMModelOfEngine checks the LLMModel against its LLMEngine, the shared Deployment of the engine in the
+// Shared ServingMode has none of the per model resources, like the NetworkPolicy and the ServiceAccount.
+func ValidateLLMModelOfEngine(llmEngine *aitrigramv1.LLMEngine, llmModel *aitrigramv1.LLMModel) field.ErrorList {
+	if !isSharedServing(llmEngine) {
+		return nil
+	}
+	return sharedServingErrors(field.NewPath("spec"), llmModel.Spec.NetworkPolicy, llmModel.Spec.ServiceAccount)
+}
+
+func sharedServingErrors(specPath *field.Path, networkPolicy *aitrigramv1.NetworkPolicySpec,
+	serviceAccount *aitrigramv1.ServiceAccountSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	if networkPolicy != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("networkPolicy"), "not supported in the Shared servingMode"))
+	}
+	if serviceAccount != nil {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("serviceAccount"), "not supported in the Shared servingMode"))
+	}
+	return allErrs
+}
+
+// ValidateLLMModel checks the LLMModel for what the schema of the CRD can not express and the reconcilers rely on,
+// like the name of its resources derived from the name of the model. The LLMEngine of the model is not looked up.
+func ValidateLLMModel(llmModel *aitrigramv1.LLMModel) field.ErrorList {
+	specPath := field.NewPath("spec")
+	var allErrs field.ErrorList
+	// ollama is the longest prefix of the names of the resources
+	resourceName := llmModelResourceName(aitrigramv1.LLMEngineTypeOllama, llmModel.Spec.Name)
+	for _, msg := range validation.IsDNS1035Label(resourceName) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("name"), llmModel.Spec.Name,
+			fmt.Sprintf("the name of the resources %s is invalid: %s", resourceName, msg)))
+	}
+	if autoscaling := llmModel.Spec.Autoscaling; autoscaling != nil && autoscaling.MinReplicas != nil &&
+		*autoscaling.MinReplicas > autoscaling.MaxReplicas {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("autoscaling", "minReplicas"), *autoscaling.MinReplicas,
+			"must not be greater than maxReplicas"))
+	}
+	if modelfile := llmModel.Spec.Modelfile; modelfile != nil && modelfile.ConfigMapRef != nil && modelfile.ConfigMapRef.Name == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("modelfile", "configMapRef", "name"), ""))
+	}
+	allErrs = append(allErrs, validateServiceAccount(specPath.Child("serviceAccount"), llmModel.Spec.ServiceAccount)...)
+	return allErrs
+}
+
+func validateServiceAccount(path *field.Path, serviceAccount *aitrigramv1.ServiceAccountSpec) field.ErrorList {
+	if serviceAccount == nil || serviceAccount.Name == "" {
+		return nil
+	}
+	var allErrs field.ErrorList
+	for _, msg := range validation.IsDNS1123Subdomain(serviceAccount.Name) {
+		allErrs = append(allErrs, field.Invalid(path.Child("name"), serviceAccount.Name, msg))
+	}
+	return allErrs
+}
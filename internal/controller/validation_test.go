@@ -0,0 +1,174 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+func Test_ValidateLLMEngine(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		spec     aitrigramv1.LLMEngineSpec
+		expected []string
+	}{
+		"ollama-defaults": {
+			spec: aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeOllama},
+		},
+		"vllm-without-ports": {
+			spec: aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeVLLM},
+			expected: []string{
+				"spec.port: Required value: the engine type vllm has no default port",
+				"spec.servicePort: Required value: the engine type vllm has no default servicePort",
+			},
+		},
+		"vllm-with-template": {
+			spec: aitrigramv1.LLMEngineSpec{
+				EngineType: aitrigramv1.LLMEngineTypeVLLM, Port: 8000, ServicePort: 8000,
+				ModelDeploymentTemplate: &aitrigramv1.ModelDeploymentTemplate{Args: []string{"--model", "/models"}},
+			},
+		},
+		"port-of-the-proxy": {
+			spec: aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeVLLM, Port: proxySidecarPort, ServicePort: 8000},
+			expected: []string{
+				"spec.port: Invalid value: 15080: the port is used by the proxy sidecar",
+			},
+		},
+		"tls-port-conflicts": {
+			spec: aitrigramv1.LLMEngineSpec{
+				EngineType:  aitrigramv1.LLMEngineTypeOllama,
+				ServicePort: 443,
+				TLS:         &aitrigramv1.TLSSpec{Enabled: true},
+				Gateway:     &aitrigramv1.GatewaySpec{Enabled: true, ServicePort: 443},
+			},
+			expected: []string{
+				"spec.tls.servicePort: Invalid value: 443: must differ from spec.servicePort",
+				"spec.tls.servicePort: Invalid value: 443: must differ from spec.gateway.servicePort",
+			},
+		},
+		"tls-disabled": {
+			spec: aitrigramv1.LLMEngineSpec{
+				EngineType:  aitrigramv1.LLMEngineTypeOllama,
+				ServicePort: 443,
+				TLS:         &aitrigramv1.TLSSpec{Enabled: false},
+			},
+		},
+		"auth-of-unknown-engine": {
+			spec: aitrigramv1.LLMEngineSpec{
+				EngineType:  "sglang",
+				Port:        30000,
+				ServicePort: 30000,
+				Auth:        &aitrigramv1.AuthSpec{APIKeys: []aitrigramv1.APIKeySpec{{SecretRef: corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "keys"}, Key: "key"}}}},
+			},
+			expected: []string{
+				"spec.auth: Forbidden: the engine type sglang can not be bound to the loopback address behind the proxy sidecar",
+			},
+		},
+		"shared-with-per-model-resources": {
+			spec: aitrigramv1.LLMEngineSpec{
+				EngineType:     aitrigramv1.LLMEngineTypeOllama,
+				ServingMode:    aitrigramv1.ServingModeShared,
+				NetworkPolicy:  &aitrigramv1.NetworkPolicySpec{Enabled: true},
+				ServiceAccount: &aitrigramv1.ServiceAccountSpec{Name: "models"},
+				TLS:            &aitrigramv1.TLSSpec{Enabled: true},
+			},
+			expected: []string{
+				"spec.networkPolicy: Forbidden: not supported in the Shared servingMode",
+				"spec.serviceAccount: Forbidden: not supported in the Shared servingMode",
+				"spec.tls: Forbidden: not supported in the Shared servingMode",
+			},
+		},
+		"api-key-and-service-account": {
+			spec: aitrigramv1.LLMEngineSpec{
+				EngineType:     aitrigramv1.LLMEngineTypeOllama,
+				Auth:           &aitrigramv1.AuthSpec{APIKeys: []aitrigramv1.APIKeySpec{{SecretRef: corev1.SecretKeySelector{Key: "key"}}}},
+				ServiceAccount: &aitrigramv1.ServiceAccountSpec{Name: "Models"},
+			},
+			expected: []string{
+				"spec.auth.apiKeys[0].secretRef.name: Required value",
+				"spec.serviceAccount.name: Invalid value: \"Models\": a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var errs []string
+			for _, err := range ValidateLLMEngine(&aitrigramv1.LLMEngine{Spec: c.spec}) {
+				errs = append(errs, err.Error())
+			}
+			require.Equal(t, c.expected, errs)
+		})
+	}
+}
+
+func Test_ValidateLLMModel(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		spec     aitrigramv1.LLMModelSpec
+		expected []string
+	}{
+		"valid": {
+			spec: aitrigramv1.LLMModelSpec{
+				Name:        "llama3.2",
+				Autoscaling: &aitrigramv1.AutoscalingSpec{MinReplicas: ptr.To(int32(1)), MaxReplicas: 3},
+			},
+		},
+		"invalid-name": {
+			spec: aitrigramv1.LLMModelSpec{Name: "llama3.2:latest"},
+			expected: []string{
+				"spec.name: Invalid value: \"llama3.2:latest\": the name of the resources ollama-llama3-2:latest is invalid: a DNS-1035 label must consist of lower case alphanumeric characters or '-', start with an alphabetic character, and end with an alphanumeric character (e.g. 'my-name',  or 'abc-123', regex used for validation is '[a-z]([-a-z0-9]*[a-z0-9])?')",
+			},
+		},
+		"min-over-max-replicas": {
+			spec: aitrigramv1.LLMModelSpec{
+				Name:        "llama3",
+				Autoscaling: &aitrigramv1.AutoscalingSpec{MinReplicas: ptr.To(int32(4)), MaxReplicas: 3},
+			},
+			expected: []string{
+				"spec.autoscaling.minReplicas: Invalid value: 4: must not be greater than maxReplicas",
+			},
+		},
+		"modelfile-without-configmap": {
+			spec: aitrigramv1.LLMModelSpec{
+				Name:      "llama3",
+				Modelfile: &aitrigramv1.ModelfileSpec{From: "llama3.2", ConfigMapRef: &corev1.ConfigMapKeySelector{Key: "llama3"}},
+			},
+			expected: []string{
+				"spec.modelfile.configMapRef.name: Required value",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var errs []string
+			for _, err := range ValidateLLMModel(&aitrigramv1.LLMModel{Spec: c.spec}) {
+				errs = append(errs, err.Error())
+			}
+			require.Equal(t, c.expected, errs)
+		})
+	}
+}
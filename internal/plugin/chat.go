@@ -0,0 +1,164 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+type chatOptions struct {
+	apiKey  string
+	system  string
+	timeout time.Duration
+}
+
+func newChatCommand(opts *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "chat <model>",
+		Short: "Chats with the LLMModel in an interactive prompt, /reset starts a new conversation and /exit quits",
+		Args:  cobra.ExactArgs(1),
+	}
+	// the models scaled to zero are woken up by the first request, which takes a while
+	chatOpts := chatOptions{timeout: 10 * time.Minute}
+	cmd.Flags().StringVar(&chatOpts.apiKey, "api-key", chatOpts.apiKey, "The API key when the engine requires one.")
+	cmd.Flags().StringVar(&chatOpts.system, "system", chatOpts.system, "The system prompt of the conversation.")
+	cmd.Flags().DurationVar(&chatOpts.timeout, "timeout", chatOpts.timeout, "How long to wait for each answer.")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		clients, err := opts.clients()
+		exitOnError(opts, err)
+		exitOnError(opts, runChat(cmd.Context(), opts, clients, args[0], chatOpts))
+	}
+	return cmd
+}
+
+// Chats with the LLMModel through a port forwarded to its Service, which is stopped once the chat ends
+func runChat(ctx context.Context, opts *Options, clients *clients, name string, chatOpts chatOptions) error {
+	llmModel, llmEngine, err := llmModelOf(ctx, clients.client, clients.namespace, name)
+	if err != nil {
+		return err
+	}
+	endpoint := controller.LLMModelEndpointOf(llmEngine, llmModel)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	localPort, err := startPortForward(ctx, portForwardArgs(opts, clients.namespace, endpoint, "", "127.0.0.1"), opts.ErrOut)
+	if err != nil {
+		return err
+	}
+	httpClient := &http.Client{Timeout: chatOpts.timeout}
+	return chat(ctx, httpClient, "http://127.0.0.1:"+localPort, endpoint.Model, chatOpts, opts.In, opts.Out)
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model    string        `json:"model"`
+	Messages []chatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+}
+
+// Reads the prompts line by line and prints the answers of the model by its OpenAI-compatible API, the conversation
+// is kept until /reset. A failed request is printed without ending the conversation.
+func chat(ctx context.Context, httpClient *http.Client, baseURL, model string, opts chatOptions, in io.Reader, out io.Writer) error {
+	var messages []chatMessage
+	if opts.system != "" {
+		messages = append(messages, chatMessage{Role: "system", Content: opts.system})
+	}
+	initial := len(messages)
+	scanner := bufio.NewScanner(in)
+	for {
+		_, _ = fmt.Fprint(out, "> ")
+		if !scanner.Scan() {
+			_, _ = fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		prompt := strings.TrimSpace(scanner.Text())
+		switch prompt {
+		case "":
+			continue
+		case "/exit", "/quit":
+			return nil
+		case "/reset":
+			messages = messages[:initial]
+			continue
+		}
+		messages = append(messages, chatMessage{Role: "user", Content: prompt})
+		answer, err := complete(ctx, httpClient, baseURL, model, opts.apiKey, messages)
+		if err != nil {
+			messages = messages[:len(messages)-1]
+			_, _ = fmt.Fprintf(out, "error: %v\n", err)
+			continue
+		}
+		_, _ = fmt.Fprintln(out, answer)
+		messages = append(messages, chatMessage{Role: "assistant", Content: answer})
+	}
+}
+
+// Sends the conversation to the chat completions endpoint and returns the answer
+func complete(ctx context.Context, httpClient *http.Client, baseURL, model, apiKey string, messages []chatMessage) (string, error) {
+	body, err := json.Marshal(chatRequest{Model: model, Messages: messages})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("the model responded %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	response := &chatResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", errors.New("the model responded no choice")
+	}
+	return response.Choices[0].Message.Content, nil
+}
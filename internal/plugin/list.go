@@ -0,0 +1,105 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+func newListCommand(opts *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "Lists the LLMEngines and the LLMModels with their readiness and endpoints",
+		Args:    cobra.NoArgs,
+	}
+	allNamespaces := false
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", allNamespaces, "Lists them in all namespaces.")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		clients, err := opts.clients()
+		exitOnError(opts, err)
+		namespace := clients.namespace
+		if allNamespaces {
+			namespace = ""
+		}
+		exitOnError(opts, list(cmd.Context(), clients.client, namespace, opts.Out))
+	}
+	return cmd
+}
+
+// Prints the LLMEngines and the LLMModels in the namespace, or in all namespaces when it is empty
+func list(ctx context.Context, c client.Reader, namespace string, w io.Writer) error {
+	llmEngineList := &aitrigramv1.LLMEngineList{}
+	if err := c.List(ctx, llmEngineList, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	llmModelList := &aitrigramv1.LLMModelList{}
+	if err := c.List(ctx, llmModelList, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	modelsOfEngines := map[types.NamespacedName]int{}
+	for _, llmModel := range llmModelList.Items {
+		modelsOfEngines[types.NamespacedName{Namespace: llmModel.Namespace, Name: llmModel.Spec.EngineRef}]++
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NAMESPACE\tENGINE\tTYPE\tSERVING\tREADY\tMODELS\tGATEWAY")
+	for _, llmEngine := range llmEngineList.Items {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", llmEngine.Namespace, llmEngine.Name, llmEngine.Spec.EngineType,
+			orNone(string(llmEngine.Spec.ServingMode)), conditionStatus(llmEngine.Status.Conditions, aitrigramv1.ConditionTypeReady),
+			modelsOfEngines[client.ObjectKeyFromObject(&llmEngine)], orNone(llmEngine.Status.GatewayEndpoint))
+	}
+	_, _ = fmt.Fprintln(tw)
+	_, _ = fmt.Fprintln(tw, "NAMESPACE\tMODEL\tENGINE\tNAME\tAVAILABLE\tPHASE\tREPLICAS\tENDPOINT")
+	for _, llmModel := range llmModelList.Items {
+		endpoint := llmModel.Status.URL
+		if endpoint == "" {
+			endpoint = llmModel.Status.Backend
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", llmModel.Namespace, llmModel.Name, llmModel.Spec.EngineRef,
+			llmModel.Spec.Name, conditionStatus(llmModel.Status.Conditions, aitrigramv1.ConditionTypeAvailable),
+			orNone(string(llmModel.Status.Phase)), llmModel.Status.Replicas, orNone(endpoint))
+	}
+	return tw.Flush()
+}
+
+// The status of the condition, Unknown if it is not set yet
+func conditionStatus(conditions []metav1.Condition, conditionType string) string {
+	if condition := meta.FindStatusCondition(conditions, conditionType); condition != nil {
+		return string(condition.Status)
+	}
+	return string(metav1.ConditionUnknown)
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
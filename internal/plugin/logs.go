@@ -0,0 +1,147 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+type logsOptions struct {
+	follow bool
+	tail   int64
+}
+
+func newLogsCommand(opts *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "logs <model>",
+		Short: "Prints the logs of the init container downloading the LLMModel and of the containers serving it in all its pods",
+		Args:  cobra.ExactArgs(1),
+	}
+	logsOpts := logsOptions{tail: -1}
+	cmd.Flags().BoolVarP(&logsOpts.follow, "follow", "f", logsOpts.follow, "Follows the logs of all the containers.")
+	cmd.Flags().Int64Var(&logsOpts.tail, "tail", logsOpts.tail, "The last lines of the logs of each container, -1 for all.")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		clients, err := opts.clients()
+		exitOnError(opts, err)
+		exitOnError(opts, logs(cmd.Context(), clients.client, clients.clientset, clients.namespace, args[0], logsOpts, opts.Out))
+	}
+	return cmd
+}
+
+// A container of a pod the logs are read from
+type logSource struct {
+	pod       string
+	container string
+}
+
+func logs(ctx context.Context, c client.Reader, clientset kubernetes.Interface, namespace, name string, opts logsOptions, w io.Writer) error {
+	llmModel, llmEngine, err := llmModelOf(ctx, c, namespace, name)
+	if err != nil {
+		return err
+	}
+	pods, err := llmModelPods(ctx, c, llmModel)
+	if err != nil {
+		return err
+	}
+	sources := logSources(pods, controller.LLMModelEndpointOf(llmEngine, llmModel).InitContainer)
+	if len(sources) == 0 {
+		return fmt.Errorf("no container of the LLMModel %s has started", name)
+	}
+	return streamLogs(ctx, clientset, namespace, sources, opts, w)
+}
+
+// The containers of the pods which have started, the init container downloading the model goes before the containers
+// serving it, the init containers of the other models in the shared pods are left out
+func logSources(pods []corev1.Pod, initContainer string) []logSource {
+	var sources []logSource
+	for _, pod := range pods {
+		statuses := map[string]corev1.ContainerStatus{}
+		for _, status := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+			statuses[status.Name] = status
+		}
+		names := []string{initContainer}
+		for _, container := range pod.Spec.Containers {
+			names = append(names, container.Name)
+		}
+		for _, name := range names {
+			if status, ok := statuses[name]; ok && (status.State.Waiting == nil || status.LastTerminationState.Terminated != nil) {
+				sources = append(sources, logSource{pod: pod.Name, container: name})
+			}
+		}
+	}
+	return sources
+}
+
+// Prints the lines of the logs prefixed by their pods and containers, one container after another, or all of them
+// at the same time when they are followed
+func streamLogs(ctx context.Context, clientset kubernetes.Interface, namespace string, sources []logSource, opts logsOptions, w io.Writer) error {
+	var mu sync.Mutex
+	stream := func(source logSource) error {
+		logOptions := &corev1.PodLogOptions{Container: source.container, Follow: opts.follow}
+		if opts.tail >= 0 {
+			logOptions.TailLines = &opts.tail
+		}
+		reader, err := clientset.CoreV1().Pods(namespace).GetLogs(source.pod, logOptions).Stream(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to read the logs of the container %s of the pod %s: %w", source.container, source.pod, err)
+		}
+		defer func() { _ = reader.Close() }()
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			mu.Lock()
+			_, err := fmt.Fprintf(w, "[%s/%s] %s\n", source.pod, source.container, scanner.Text())
+			mu.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+
+	if !opts.follow {
+		for _, source := range sources {
+			if err := stream(source); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	errs := make(chan error, len(sources))
+	for _, source := range sources {
+		go func() { errs <- stream(source) }()
+	}
+	var err error
+	for range sources {
+		err = errors.Join(err, <-errs)
+	}
+	return err
+}
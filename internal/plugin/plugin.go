@@ -0,0 +1,159 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package plugin implements the kubectl-llm plugin for the day-2 operations on the LLMEngines and the LLMModels,
+// which is run as kubectl llm.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(aitrigramv1.AddToScheme(scheme))
+}
+
+// Options are the flags shared by the commands of the plugin and where they read and write
+type Options struct {
+	Kubeconfig string
+	Context    string
+	Namespace  string
+
+	In     io.Reader
+	Out    io.Writer
+	ErrOut io.Writer
+}
+
+// NewLLMCommand is the root command of the kubectl-llm plugin
+func NewLLMCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kubectl-llm",
+		Short: "Operates the LLMEngines and the LLMModels, like listing them, following their logs and chatting with them",
+		Run: func(cmd *cobra.Command, args []string) {
+			_ = cmd.Help()
+			os.Exit(1)
+		},
+	}
+
+	opts := &Options{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
+	cmd.PersistentFlags().StringVar(&opts.Kubeconfig, "kubeconfig", opts.Kubeconfig,
+		"The kubeconfig file, the KUBECONFIG env or ~/.kube/config is used if not set.")
+	cmd.PersistentFlags().StringVar(&opts.Context, "context", opts.Context, "The context of the kubeconfig to use.")
+	cmd.PersistentFlags().StringVarP(&opts.Namespace, "namespace", "n", opts.Namespace,
+		"The namespace, it is the one of the context if not set.")
+
+	cmd.AddCommand(newListCommand(opts))
+	cmd.AddCommand(newLogsCommand(opts))
+	cmd.AddCommand(newPortForwardCommand(opts))
+	cmd.AddCommand(newChatCommand(opts))
+	cmd.AddCommand(newPullStatusCommand(opts))
+	return cmd
+}
+
+// clients are the clients of the cluster in the kubeconfig and the namespace the commands work in
+type clients struct {
+	client    client.Client
+	clientset kubernetes.Interface
+	namespace string
+}
+
+func (o *Options) clientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.Context}
+	overrides.Context.Namespace = o.Namespace
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+}
+
+func (o *Options) clients() (*clients, error) {
+	clientConfig := o.clientConfig()
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	c, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &clients{client: c, clientset: clientset, namespace: namespace}, nil
+}
+
+// Prints the error of a command and exits
+func exitOnError(opts *Options, err error) {
+	if err != nil {
+		_, _ = fmt.Fprintln(opts.ErrOut, err)
+		os.Exit(1)
+	}
+}
+
+// Returns the LLMModel and its LLMEngine
+func llmModelOf(ctx context.Context, c client.Reader, namespace, name string) (*aitrigramv1.LLMModel, *aitrigramv1.LLMEngine, error) {
+	llmModel := &aitrigramv1.LLMModel{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, llmModel); err != nil {
+		return nil, nil, err
+	}
+	llmEngine := &aitrigramv1.LLMEngine{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: llmModel.Spec.EngineRef}, llmEngine); err != nil {
+		return nil, nil, fmt.Errorf("failed to get the LLMEngine of the LLMModel %s: %w", name, err)
+	}
+	return llmModel, llmEngine, nil
+}
+
+// Returns the pods serving the LLMModel by the selector in its status, sorted by their names
+func llmModelPods(ctx context.Context, c client.Reader, llmModel *aitrigramv1.LLMModel) ([]corev1.Pod, error) {
+	if llmModel.Status.Selector == "" {
+		return nil, fmt.Errorf("the LLMModel %s has no pods yet", llmModel.Name)
+	}
+	selector, err := labels.Parse(llmModel.Status.Selector)
+	if err != nil {
+		return nil, err
+	}
+	podList := &corev1.PodList{}
+	if err := c.List(ctx, podList, client.InNamespace(llmModel.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	pods := podList.Items
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods, nil
+}
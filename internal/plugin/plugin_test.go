@@ -0,0 +1,280 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientsetfake "k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+var testStart = time.Date(2025, 5, 1, 10, 0, 0, 0, time.UTC)
+
+// Returns an ollama LLMEngine and its LLMModel served by two pods, the download is done in the first pod and is
+// retried in the second one
+func newTestObjects() []runtime.Object {
+	llmEngine := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama", Namespace: "llm"},
+		Spec: aitrigramv1.LLMEngineSpec{
+			EngineType: aitrigramv1.LLMEngineTypeOllama, ServicePort: 11434, ServingMode: aitrigramv1.ServingModePerModel,
+		},
+		Status: aitrigramv1.LLMEngineStatus{
+			Conditions: []metav1.Condition{{Type: aitrigramv1.ConditionTypeReady, Status: metav1.ConditionTrue}},
+		},
+	}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "llm"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3.2", EngineRef: "ollama", Replicas: 2},
+		Status: aitrigramv1.LLMModelStatus{
+			Conditions: []metav1.Condition{{Type: aitrigramv1.ConditionTypeAvailable, Status: metav1.ConditionTrue}},
+			Phase:      aitrigramv1.LLMModelPhaseRunning,
+			Replicas:   2,
+			Selector:   "app=aitrigram-llmmodel,instance=ollama-llama3-2",
+			Backend:    "http://ollama-llama3-2.llm.svc:11434",
+		},
+	}
+	idle := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "qwen", Namespace: "llm"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "qwen", EngineRef: "ollama", Replicas: 1},
+		Status:     aitrigramv1.LLMModelStatus{Phase: aitrigramv1.LLMModelPhaseScaledToZero},
+	}
+	podLabels := map[string]string{"app": "aitrigram-llmmodel", "instance": "ollama-llama3-2"}
+	containers := []corev1.Container{{Name: "ollama-llama3-2"}, {Name: "proxy"}}
+	downloaded := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama-llama3-2-a", Namespace: "llm", Labels: podLabels},
+		Spec:       corev1.PodSpec{Containers: containers},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name: "init-ollama-llama3-2",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					StartedAt: metav1.NewTime(testStart), FinishedAt: metav1.NewTime(testStart.Add(5 * time.Minute)),
+				}},
+			}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "ollama-llama3-2", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+				{Name: "proxy", State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			},
+		},
+	}
+	retrying := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama-llama3-2-b", Namespace: "llm", Labels: podLabels},
+		Spec:       corev1.PodSpec{Containers: containers},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:         "init-ollama-llama3-2",
+				RestartCount: 1,
+				State:        corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: metav1.NewTime(testStart.Add(time.Minute))}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					ExitCode: 1, Reason: "Error", Message: "connection reset",
+				}},
+			}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "ollama-llama3-2", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}}},
+				{Name: "proxy", State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}}},
+			},
+		},
+	}
+	return []runtime.Object{llmEngine, llmModel, idle, downloaded, retrying}
+}
+
+func Test_List(t *testing.T) {
+	t.Parallel()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(newTestObjects()...).Build()
+	out := &bytes.Buffer{}
+	require.NoError(t, list(context.Background(), c, "llm", out))
+	require.Equal(t, `NAMESPACE   ENGINE   TYPE     SERVING    READY   MODELS   GATEWAY
+llm         ollama   ollama   PerModel   True    2        <none>
+
+NAMESPACE   MODEL    ENGINE   NAME       AVAILABLE   PHASE          REPLICAS   ENDPOINT
+llm         llama3   ollama   llama3.2   True        Running        2          http://ollama-llama3-2.llm.svc:11434
+llm         qwen     ollama   qwen       Unknown     ScaledToZero   0          <none>
+`, out.String())
+
+	out.Reset()
+	require.NoError(t, list(context.Background(), c, "other", out))
+	require.Equal(t, 3, strings.Count(out.String(), "\n"))
+}
+
+func Test_Logs(t *testing.T) {
+	t.Parallel()
+	objs := newTestObjects()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build()
+	clientset := clientsetfake.NewSimpleClientset(objs[3], objs[4])
+
+	for _, follow := range []bool{false, true} {
+		out := &bytes.Buffer{}
+		require.NoError(t, logs(context.Background(), c, clientset, "llm", "llama3", logsOptions{follow: follow, tail: 10}, out))
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		expected := []string{
+			"[ollama-llama3-2-a/init-ollama-llama3-2] fake logs",
+			"[ollama-llama3-2-a/ollama-llama3-2] fake logs",
+			"[ollama-llama3-2-a/proxy] fake logs",
+			"[ollama-llama3-2-b/init-ollama-llama3-2] fake logs",
+		}
+		if follow {
+			require.ElementsMatch(t, expected, lines)
+		} else {
+			require.Equal(t, expected, lines)
+		}
+	}
+
+	err := logs(context.Background(), c, clientset, "llm", "qwen", logsOptions{tail: -1}, &bytes.Buffer{})
+	require.EqualError(t, err, "the LLMModel qwen has no pods yet")
+}
+
+func Test_PullStatus(t *testing.T) {
+	t.Parallel()
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(newTestObjects()...).Build()
+	out := &bytes.Buffer{}
+	require.NoError(t, pullStatus(context.Background(), c, "llm", "", testStart.Add(3*time.Minute), out))
+	require.Equal(t, `NAMESPACE   MODEL    POD                 STATUS         RESTARTS   DURATION   MESSAGE
+llm         llama3   ollama-llama3-2-a   Downloaded     0          5m
+llm         llama3   ollama-llama3-2-b   Downloading    1          2m         exit code 1, connection reset
+llm         qwen     <none>              ScaledToZero   0
+`, trimLines(out.String()))
+
+	out.Reset()
+	require.NoError(t, pullStatus(context.Background(), c, "llm", "qwen", testStart, out))
+	require.Equal(t, 2, strings.Count(out.String(), "\n"))
+}
+
+func Test_DownloadStatus(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		status   *corev1.ContainerStatus
+		expected []string
+	}{
+		"not-created": {
+			expected: []string{"Pending", "", ""},
+		},
+		"image-pull": {
+			status: &corev1.ContainerStatus{State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+				Reason: "ImagePullBackOff", Message: "Back-off pulling image",
+			}}},
+			expected: []string{"ImagePullBackOff", "", "Back-off pulling image"},
+		},
+		"crash-loop": {
+			status: &corev1.ContainerStatus{
+				RestartCount:         3,
+				State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 2, Reason: "Error"}},
+			},
+			expected: []string{"CrashLoopBackOff", "", "exit code 2, Error"},
+		},
+		"failed": {
+			status: &corev1.ContainerStatus{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				ExitCode: 1, Reason: "Error", StartedAt: metav1.NewTime(testStart), FinishedAt: metav1.NewTime(testStart.Add(90 * time.Second)),
+			}}},
+			expected: []string{"Failed", "90s", "exit code 1, Error"},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			state, elapsed, message := downloadStatus(c.status, testStart)
+			require.Equal(t, c.expected, []string{state, elapsed, message})
+		})
+	}
+}
+
+func Test_Chat(t *testing.T) {
+	t.Parallel()
+	var requests []chatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		request := chatRequest{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		requests = append(requests, request)
+		last := request.Messages[len(request.Messages)-1].Content
+		if last == "fail" {
+			http.Error(w, "model is loading", http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"message": chatMessage{Role: "assistant", Content: "echo " + last}}},
+		})
+	}))
+	defer server.Close()
+
+	in := strings.NewReader("hello\n\nfail\nagain\n/reset\nhi\n/exit\nignored\n")
+	out := &bytes.Buffer{}
+	opts := chatOptions{apiKey: "key", system: "be brief"}
+	require.NoError(t, chat(context.Background(), server.Client(), server.URL, "llama3.2", opts, in, out))
+	require.Equal(t, "> echo hello\n> > error: the model responded 503 Service Unavailable: model is loading\n> echo again\n> > echo hi\n> ", out.String())
+
+	require.Len(t, requests, 4)
+	for _, request := range requests {
+		require.Equal(t, "llama3.2", request.Model)
+		require.Equal(t, chatMessage{Role: "system", Content: "be brief"}, request.Messages[0])
+	}
+	// the failed prompt is not kept, and the conversation starts over after /reset
+	require.Len(t, requests[2].Messages, 4)
+	require.Len(t, requests[3].Messages, 2)
+
+	_, err := complete(context.Background(), server.Client(), server.URL, "llama3.2", "", nil)
+	require.EqualError(t, err, "the model responded 401 Unauthorized: unauthorized")
+}
+
+func Test_PortForward(t *testing.T) {
+	t.Parallel()
+	llmEngine := &aitrigramv1.LLMEngine{Spec: aitrigramv1.LLMEngineSpec{
+		EngineType: aitrigramv1.LLMEngineTypeOllama, ServicePort: 11434, ServingMode: aitrigramv1.ServingModeShared,
+	}}
+	llmEngine.Name = "ollama"
+	llmModel := &aitrigramv1.LLMModel{Spec: aitrigramv1.LLMModelSpec{Name: "llama3.2", NameInEngine: "sql-assistant"}}
+	endpoint := controller.LLMModelEndpointOf(llmEngine, llmModel)
+	require.Equal(t, controller.LLMModelEndpoint{
+		Service: "ollama-shared", Port: 11434, InitContainer: "init-ollama-llama3-2", Model: "sql-assistant",
+	}, endpoint)
+
+	opts := &Options{Kubeconfig: "/tmp/kubeconfig", Context: "kind"}
+	require.Equal(t, []string{"port-forward", "--namespace", "llm", "--address", "127.0.0.1", "service/ollama-shared", ":11434",
+		"--kubeconfig", "/tmp/kubeconfig", "--context", "kind"}, portForwardArgs(opts, "llm", endpoint, "", "127.0.0.1"))
+
+	port, ok := forwardedPort("Forwarding from 127.0.0.1:54321 -> 11434")
+	require.True(t, ok)
+	require.Equal(t, "54321", port)
+	_, ok = forwardedPort("Forwarding from [::1]:54321 -> 11434")
+	require.False(t, ok)
+}
+
+// Removes the padding of the empty cells at the end of the lines of a table
+func trimLines(table string) string {
+	lines := strings.Split(table, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
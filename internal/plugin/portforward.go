@@ -0,0 +1,111 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+// The kubectl running the plugin, the port forwarding is left to it as it already speaks the streaming protocols of
+// the API server, and the requests to the Services keep their Authorization headers unlike by the proxy of the API
+// server.
+const kubectl = "kubectl"
+
+func newPortForwardCommand(opts *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "port-forward <model> [local port]",
+		Short: "Forwards a local port, the port of the Service if not set, to the Service serving the LLMModel",
+		Args:  cobra.RangeArgs(1, 2),
+	}
+	address := "localhost"
+	cmd.Flags().StringVar(&address, "address", address, "The addresses to listen on, separated by commas.")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		clients, err := opts.clients()
+		exitOnError(opts, err)
+		llmModel, llmEngine, err := llmModelOf(cmd.Context(), clients.client, clients.namespace, args[0])
+		exitOnError(opts, err)
+		endpoint := controller.LLMModelEndpointOf(llmEngine, llmModel)
+		localPort := fmt.Sprint(endpoint.Port)
+		if len(args) > 1 {
+			localPort = args[1]
+		}
+		portForward := exec.CommandContext(cmd.Context(), kubectl, portForwardArgs(opts, clients.namespace, endpoint, localPort, address)...)
+		portForward.Stdin, portForward.Stdout, portForward.Stderr = opts.In, opts.Out, opts.ErrOut
+		exitOnError(opts, portForward.Run())
+	}
+	return cmd
+}
+
+// The arguments of kubectl port-forward from the local port to the Service serving the LLMModel, an empty local
+// port is a random one
+func portForwardArgs(opts *Options, namespace string, endpoint controller.LLMModelEndpoint, localPort, address string) []string {
+	args := []string{"port-forward", "--namespace", namespace, "--address", address,
+		"service/" + endpoint.Service, fmt.Sprintf("%s:%d", localPort, endpoint.Port)}
+	if opts.Kubeconfig != "" {
+		args = append(args, "--kubeconfig", opts.Kubeconfig)
+	}
+	if opts.Context != "" {
+		args = append(args, "--context", opts.Context)
+	}
+	return args
+}
+
+// Starts kubectl port-forward in the background until the context is done, it returns the local port once it is
+// forwarded
+func startPortForward(ctx context.Context, args []string, errOut io.Writer) (string, error) {
+	portForward := exec.CommandContext(ctx, kubectl, args...)
+	portForward.Stderr = errOut
+	stdout, err := portForward.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := portForward.Start(); err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if port, ok := forwardedPort(scanner.Text()); ok {
+			go func() {
+				_, _ = io.Copy(io.Discard, stdout)
+				_ = portForward.Wait()
+			}()
+			return port, nil
+		}
+	}
+	return "", errors.Join(errors.New("kubectl port-forward exited before the port is forwarded"), portForward.Wait())
+}
+
+// The local port in the line printed by kubectl port-forward, like: Forwarding from 127.0.0.1:54321 -> 11434
+func forwardedPort(line string) (string, bool) {
+	forwarding, ok := strings.CutPrefix(line, "Forwarding from 127.0.0.1:")
+	if !ok {
+		return "", false
+	}
+	port, _, ok := strings.Cut(forwarding, " ")
+	return port, ok
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+func newPullStatusCommand(opts *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pull-status [model]",
+		Short: "Shows the downloads of the LLMModels, or of the one, by the init containers in their pods",
+		Args:  cobra.MaximumNArgs(1),
+	}
+	allNamespaces := false
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", allNamespaces, "Shows them in all namespaces.")
+
+	cmd.Run = func(cmd *cobra.Command, args []string) {
+		clients, err := opts.clients()
+		exitOnError(opts, err)
+		namespace, name := clients.namespace, ""
+		if len(args) > 0 {
+			name = args[0]
+		} else if allNamespaces {
+			namespace = ""
+		}
+		exitOnError(opts, pullStatus(cmd.Context(), clients.client, namespace, name, time.Now(), opts.Out))
+	}
+	return cmd
+}
+
+// Prints the state of the init container downloading each LLMModel in each of its pods, the LLMModel is the named
+// one, or all of them in the namespace, or in all namespaces when it is empty
+func pullStatus(ctx context.Context, c client.Reader, namespace, name string, now time.Time, w io.Writer) error {
+	var llmModels []aitrigramv1.LLMModel
+	if name != "" {
+		llmModel := &aitrigramv1.LLMModel{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, llmModel); err != nil {
+			return err
+		}
+		llmModels = append(llmModels, *llmModel)
+	} else {
+		llmModelList := &aitrigramv1.LLMModelList{}
+		if err := c.List(ctx, llmModelList, client.InNamespace(namespace)); err != nil {
+			return err
+		}
+		llmModels = llmModelList.Items
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "NAMESPACE\tMODEL\tPOD\tSTATUS\tRESTARTS\tDURATION\tMESSAGE")
+	for i := range llmModels {
+		llmModel := &llmModels[i]
+		llmEngine := &aitrigramv1.LLMEngine{}
+		if err := c.Get(ctx, types.NamespacedName{Namespace: llmModel.Namespace, Name: llmModel.Spec.EngineRef}, llmEngine); err != nil {
+			return fmt.Errorf("failed to get the LLMEngine of the LLMModel %s: %w", llmModel.Name, err)
+		}
+		var pods []corev1.Pod
+		if llmModel.Status.Selector != "" {
+			var err error
+			if pods, err = llmModelPods(ctx, c, llmModel); err != nil {
+				return err
+			}
+		}
+		if len(pods) == 0 {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t<none>\t%s\t0\t\t\n", llmModel.Namespace, llmModel.Name, orNone(string(llmModel.Status.Phase)))
+			continue
+		}
+		initContainer := controller.LLMModelEndpointOf(llmEngine, llmModel).InitContainer
+		for _, pod := range pods {
+			var initStatus *corev1.ContainerStatus
+			for j := range pod.Status.InitContainerStatuses {
+				if pod.Status.InitContainerStatuses[j].Name == initContainer {
+					initStatus = &pod.Status.InitContainerStatuses[j]
+				}
+			}
+			state, elapsed, message := downloadStatus(initStatus, now)
+			restarts := int32(0)
+			if initStatus != nil {
+				restarts = initStatus.RestartCount
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", llmModel.Namespace, llmModel.Name, pod.Name, state, restarts, elapsed, message)
+		}
+	}
+	return tw.Flush()
+}
+
+// The state of the download by the init container, how long it has taken and the message of the last failure
+func downloadStatus(status *corev1.ContainerStatus, now time.Time) (string, string, string) {
+	if status == nil {
+		return "Pending", "", ""
+	}
+	message := ""
+	if status.RestartCount > 0 {
+		message = controller.DownloadFailureMessage(*status)
+	}
+	switch {
+	case status.State.Terminated != nil:
+		terminated := status.State.Terminated
+		elapsed := duration.HumanDuration(terminated.FinishedAt.Sub(terminated.StartedAt.Time))
+		if terminated.ExitCode == 0 {
+			return "Downloaded", elapsed, ""
+		}
+		return "Failed", elapsed, controller.DownloadFailureMessage(*status)
+	case status.State.Running != nil:
+		return "Downloading", duration.HumanDuration(now.Sub(status.State.Running.StartedAt.Time)), message
+	case status.State.Waiting != nil:
+		if message == "" {
+			message = status.State.Waiting.Message
+		}
+		return status.State.Waiting.Reason, "", message
+	}
+	return "Pending", "", message
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// how long the activator waits for the TLS handshake of a client to tell the model
+const clientHelloTimeout = 10 * time.Second
+
+var errClientHelloRead = errors.New("the client hello is read")
+
+// Waker scales up the model which is addressed by the host of the request
+type Waker interface {
+	// Wake asks the model behind the host to scale up, and returns the address of a ready backend
+	// serving the scheme, http or https, once there is one. It blocks until then or the context is done.
+	Wake(ctx context.Context, host string, scheme string) (*url.URL, error)
+}
+
+// Activator receives the requests sent to the models scaled to zero, it holds each request
+// until the model is back and then forwards it.
+type Activator struct {
+	waker   Waker
+	timeout time.Duration
+}
+
+// NewActivator creates an Activator which waits for at most timeout for a model to wake up
+func NewActivator(waker Waker, timeout time.Duration) *Activator {
+	return &Activator{
+		waker:   waker,
+		timeout: timeout,
+	}
+}
+
+func (a *Activator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := logf.Log.WithName("activator")
+	ctx, cancel := context.WithTimeout(r.Context(), a.timeout)
+	defer cancel()
+	target, err := a.waker.Wake(ctx, r.Host, "http")
+	if err != nil {
+		logger.Error(err, "Failed to wake up the model", "host", r.Host)
+		http.Error(w, "the model is not available: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	logger.Info("Forwarding the request to the woken up model", "host", r.Host, "target", target.String())
+	newReverseProxy(target).ServeHTTP(w, r)
+}
+
+// ServeTLS holds the https connections to the models scaled to zero until the context is done. The TLS is not
+// terminated, the model is found by the server name in the handshake of the client, and the connection is forwarded
+// as it is to the https port of a ready backend once the model is back, so the clients verify the certificate of the
+// model as usual.
+func (a *Activator) ServeTLS(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go a.forwardTLS(ctx, conn)
+	}
+}
+
+func (a *Activator) forwardTLS(ctx context.Context, conn net.Conn) {
+	logger := logf.Log.WithName("activator")
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetReadDeadline(time.Now().Add(clientHelloTimeout))
+	serverName, hello, err := readClientHello(conn)
+	if err != nil {
+		logger.Error(err, "Failed to read the server name of the TLS connection", "remote", conn.RemoteAddr().String())
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	target, err := a.waker.Wake(ctx, serverName, "https")
+	if err != nil {
+		// the client sees the connection closed during the handshake
+		logger.Error(err, "Failed to wake up the model", "host", serverName)
+		return
+	}
+	backend, err := (&net.Dialer{}).DialContext(ctx, "tcp", target.Host)
+	if err != nil {
+		logger.Error(err, "Failed to connect to the woken up model", "host", serverName, "target", target.Host)
+		return
+	}
+	defer func() { _ = backend.Close() }()
+	logger.Info("Forwarding the TLS connection to the woken up model", "host", serverName, "target", target.Host)
+	if _, err := backend.Write(hello); err != nil {
+		logger.Error(err, "Failed to forward the TLS handshake", "host", serverName, "target", target.Host)
+		return
+	}
+	done := make(chan struct{}, 2)
+	pipe := func(dst, src net.Conn) {
+		_, _ = io.Copy(dst, src)
+		if conn, ok := dst.(interface{ CloseWrite() error }); ok {
+			_ = conn.CloseWrite()
+		} else {
+			_ = dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go pipe(backend, conn)
+	go pipe(conn, backend)
+	<-done
+	<-done
+}
+
+// helloConn reads the handshake of the client and keeps the bytes read, nothing is sent back to the client
+type helloConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *helloConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *helloConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+// readClientHello returns the server name in the TLS handshake of the client, and the bytes read from the
+// connection so far, which are sent to the backend before the rest of the connection.
+func readClientHello(conn net.Conn) (string, []byte, error) {
+	var read bytes.Buffer
+	var hello *tls.ClientHelloInfo
+	err := tls.Server(&helloConn{Conn: conn, reader: io.TeeReader(conn, &read)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = info
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	if hello == nil {
+		return "", nil, err
+	}
+	if hello.ServerName == "" {
+		return "", nil, errors.New("there is no server name in the TLS handshake, use the DNS name of the Service")
+	}
+	return hello.ServerName, read.Bytes(), nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Admin serves the admin listener of the sidecar, which is apart from the requests of the clients. The GET requests
+// to the paths of the engine which do not touch the models, like its health and metrics endpoints, are forwarded to
+// it for the kubelet and Prometheus, and the other requests are forwarded only with the admin token of the operator,
+// which manages the models of the engine through it when the engine only listens on the loopback address. The paths
+// of the sidecar itself, like the activity and the quota usage, are only served on the admin listener.
+type Admin struct {
+	proxy *httputil.ReverseProxy
+	// the handlers of the paths of the sidecar itself, served without the admin token
+	handlers map[string]http.Handler
+	// the paths of the engine forwarded without the admin token
+	paths []string
+	// the file of the admin token, the requests which need it are rejected when it is empty
+	tokenFile string
+}
+
+// NewAdmin creates an Admin which forwards the requests to the upstream, like: http://127.0.0.1:11434
+func NewAdmin(upstream string, paths []string, tokenFile string) (*Admin, error) {
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", upstream, err)
+	}
+	return &Admin{proxy: newReverseProxy(target), handlers: map[string]http.Handler{}, paths: paths, tokenFile: tokenFile}, nil
+}
+
+// Handle serves the GET requests to the path of the sidecar itself with the handler
+func (a *Admin) Handle(path string, handler http.Handler) {
+	a.handlers[path] = handler
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := a.handlers[r.URL.Path]; ok && r.Method == http.MethodGet {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	if r.Method == http.MethodGet && slices.Contains(a.paths, r.URL.Path) {
+		a.proxy.ServeHTTP(w, r)
+		return
+	}
+	if !a.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeOpenAIError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "invalid admin token")
+		return
+	}
+	a.proxy.ServeHTTP(w, r)
+}
+
+// The admin token is read on each request, so the rotated Secret takes effect without reloading
+func (a *Admin) authorized(r *http.Request) bool {
+	if a.tokenFile == "" {
+		return false
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return false
+	}
+	data, err := os.ReadFile(a.tokenFile)
+	if err != nil {
+		return false
+	}
+	expected := strings.TrimSpace(string(data))
+	return expected != "" && subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(expected)) == 1
+}
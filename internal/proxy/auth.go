@@ -0,0 +1,284 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// APIKeyFile is an API key read from a file, which is mounted from a Secret
+type APIKeyFile struct {
+	// File is the path of the file which holds the API key
+	File string `json:"file"`
+	// Models are the models the API key is allowed to use, all models are allowed if empty
+	Models []string `json:"models,omitempty"`
+}
+
+// AuthConfig is passed by the operator to the sidecar and the gateway to require the API keys
+type AuthConfig struct {
+	APIKeys []APIKeyFile `json:"apiKeys"`
+}
+
+// Authenticator checks the bearer tokens of the requests against the API keys in the files
+type Authenticator struct {
+	config AuthConfig
+	mu     sync.RWMutex
+	// keyed by the hash of the key
+	keys map[[sha256.Size]byte]*authorizedKey
+}
+
+type authorizedKey struct {
+	// the file the key is read from first, it identifies the key in the quotas
+	file string
+	// the models allowed by the key, nil means all models
+	models []string
+}
+
+type apiKeyContextKey struct{}
+
+type allowedModelsContextKey struct{}
+
+// APIKeyFileFromContext returns the file of the API key which authorized the request, it is empty when
+// the request is not authorized by an API key.
+func APIKeyFileFromContext(ctx context.Context) string {
+	file, _ := ctx.Value(apiKeyContextKey{}).(string)
+	return file
+}
+
+// AllowedModelsFromContext returns the models the API key which authorized the request is allowed to use,
+// it is nil when all models are allowed.
+func AllowedModelsFromContext(ctx context.Context) []string {
+	models, _ := ctx.Value(allowedModelsContextKey{}).([]string)
+	return models
+}
+
+// NewAuthenticator creates an Authenticator and reads the API keys
+func NewAuthenticator(config AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{config: config}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Reload reads the API keys from the files again
+func (a *Authenticator) Reload() error {
+	keys := make(map[[sha256.Size]byte]*authorizedKey, len(a.config.APIKeys))
+	for _, apiKey := range a.config.APIKeys {
+		data, err := os.ReadFile(apiKey.File)
+		if err != nil {
+			return err
+		}
+		key := strings.TrimSpace(string(data))
+		if key == "" {
+			return fmt.Errorf("empty API key in %s", apiKey.File)
+		}
+		hash := sha256.Sum256([]byte(key))
+		authorized, seen := keys[hash]
+		switch {
+		case !seen:
+			keys[hash] = &authorizedKey{file: apiKey.File, models: slices.Clone(apiKey.Models)}
+		case authorized.models == nil:
+			// the same key is listed again, but it already allows all models
+		case len(apiKey.Models) == 0:
+			authorized.models = nil
+		default:
+			authorized.models = append(authorized.models, apiKey.Models...)
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = keys
+	return nil
+}
+
+// WatchKeys reloads the API keys periodically until the context is done, so that the rotated Secrets take effect.
+func (a *Authenticator) WatchKeys(ctx context.Context, interval time.Duration) {
+	logger := logf.Log.WithName("auth")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := a.Reload(); err != nil {
+				logger.Error(err, "Failed to reload the API keys")
+			}
+		}
+	}
+}
+
+// Returns the key if it is known
+func (a *Authenticator) lookup(key string) (*authorizedKey, bool) {
+	hash := sha256.Sum256([]byte(key))
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	authorized, ok := a.keys[hash]
+	return authorized, ok
+}
+
+// The paths of the engines which manage the models instead of using them, like pulling and deleting the models
+// of ollama, or loading the LoRA adapters of vllm, the API keys allowed to use only some models cannot call them
+var managementPaths = []string{
+	"/api/pull", "/api/push", "/api/create", "/api/delete", "/api/copy", "/api/blobs/",
+	"/v1/load_lora_adapter", "/v1/unload_lora_adapter",
+}
+
+func isManagementPath(path string) bool {
+	for _, managementPath := range managementPaths {
+		if path == managementPath || (strings.HasSuffix(managementPath, "/") && strings.HasPrefix(path, managementPath)) {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireAPIKey rejects the requests without a valid API key in the "Authorization: Bearer" header with 401,
+// and the ones to a model not allowed by the key with 403. Every model named in the request body is checked,
+// like the adapters and the base models served by the same engine, and the requests naming no model are checked
+// against the given model when the handler serves a single model. Otherwise the requests addressing no model,
+// like listing the models, are allowed by any valid key. The keys allowed to use only some models cannot manage
+// the models of the engine, and the requests of them whose models cannot be told from the body are rejected.
+func RequireAPIKey(auth *Authenticator, model string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || strings.TrimSpace(token) == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeOpenAIError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "missing API key")
+			return
+		}
+		authorized, ok := auth.lookup(strings.TrimSpace(token))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeOpenAIError(w, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "invalid API key")
+			return
+		}
+		if models := authorized.models; models != nil {
+			if isManagementPath(r.URL.Path) {
+				writeOpenAIError(w, http.StatusForbidden, "invalid_request_error", "model_not_allowed",
+					fmt.Sprintf("the API key is not allowed to manage the models by %s", r.URL.Path))
+				return
+			}
+			requested, err := requestModels(r)
+			if err != nil {
+				writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", err.Error())
+				return
+			}
+			if len(requested) == 0 && model != "" {
+				requested = []string{model}
+			}
+			for _, name := range requested {
+				if !slices.Contains(models, name) {
+					writeOpenAIError(w, http.StatusForbidden, "invalid_request_error", "model_not_allowed",
+						fmt.Sprintf("the API key is not allowed to use the model %s", name))
+					return
+				}
+			}
+		}
+		ctx := context.WithValue(r.Context(), apiKeyContextKey{}, authorized.file)
+		if authorized.models != nil {
+			ctx = context.WithValue(ctx, allowedModelsContextKey{}, authorized.models)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// The fields of the request bodies which name the models, the model of the OpenAI-compatible APIs and of ollama,
+// the legacy name and the source and destination of the copies of ollama, and the LoRA adapter of vllm
+var requestModelFields = []string{"model", "name", "from", "source", "destination", "lora_name"}
+
+// Reads the models named in a JSON request body, the body is kept for the next handler. It is empty when the
+// request has no body or names no model. The engines may decode the bodies differently, like encoding/json which
+// matches the fields case-insensitively and takes the last of the duplicated ones, so the bodies which are not
+// a JSON object, or which name a field of the models more than once or in another case, are rejected instead of
+// guessing the model the engine would use.
+func requestModels(r *http.Request) ([]string, error) {
+	body, err := readRequestBody(r)
+	if err != nil || len(bytes.TrimSpace(body)) == 0 {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("the request body is not a JSON object")
+	}
+	var seen []string
+	var models []string
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("the request body is not a JSON object")
+		}
+		key, _ := token.(string)
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("the request body is not a JSON object")
+		}
+		field := slices.IndexFunc(requestModelFields, func(field string) bool { return strings.EqualFold(field, key) })
+		if field < 0 {
+			continue
+		}
+		if key != requestModelFields[field] || slices.Contains(seen, requestModelFields[field]) {
+			return nil, fmt.Errorf("ambiguous field %q in the request body", key)
+		}
+		seen = append(seen, key)
+		var name *string
+		if err := json.Unmarshal(value, &name); err != nil {
+			return nil, fmt.Errorf("the field %q in the request body is not a string", key)
+		}
+		if name != nil && *name != "" && !slices.Contains(models, *name) {
+			models = append(models, *name)
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("the request body is not a JSON object")
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("the request body is not a JSON object")
+	}
+	return models, nil
+}
+
+// Reads the request body and puts it back for the next handler, it is nil when there is no body
+func readRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the request body")
+	}
+	if len(body) > maxRequestBodySize {
+		return nil, fmt.Errorf("the request body is too large")
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	return body, nil
+}
@@ -0,0 +1,413 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// RoutesFileName is the key in the route table ConfigMap and the file name in the gateway container
+	RoutesFileName = "routes.json"
+	// StatsPath is served on the admin listener of the gateway, it reports the requests and the errors of each backend.
+	StatsPath = "/.aitrigram/stats"
+
+	// the max size of the request body the gateway reads to find the model
+	maxRequestBodySize = 32 << 20
+)
+
+// Route tells where the requests to a model go
+type Route struct {
+	// Model is the model name in the requests
+	Model string `json:"model"`
+	// Backend is the base URL of the model Service, like: http://ollama-llama3.default.svc:8080
+	Backend string `json:"backend,omitempty"`
+	// Backends split the requests to the model by their weights, the Backend is ignored when they are defined
+	Backends []WeightedBackend `json:"backends,omitempty"`
+}
+
+// WeightedBackend is one of the backends of a Route which gets a share of the requests
+type WeightedBackend struct {
+	// Backend is the base URL of the model Service
+	Backend string `json:"backend"`
+	// Model replaces the model in the requests sent to the backend, it is kept if not defined
+	Model string `json:"model,omitempty"`
+	// Weight is the relative share of the requests
+	Weight int32 `json:"weight"`
+}
+
+// BackendStats counts the requests sent to a backend since the gateway started
+type BackendStats struct {
+	Requests int64 `json:"requests"`
+	// Errors are the requests failed with a 5xx status code, including the ones the backend could not be reached
+	Errors int64 `json:"errors"`
+}
+
+// Stats are the BackendStats keyed by the base URL of the backends
+type Stats map[string]BackendStats
+
+// RouteTable is the content of the route table file read by the gateway
+type RouteTable struct {
+	Routes []Route `json:"routes"`
+}
+
+// Gateway is an OpenAI-compatible endpoint which routes the requests to the backends by the model field
+type Gateway struct {
+	mu     sync.RWMutex
+	routes map[string][]*gatewayBackend
+	models []string
+	// the transport of the requests to the backends, it is the http.DefaultTransport if nil
+	transport http.RoundTripper
+
+	statsMu sync.Mutex
+	// the counters are kept across the reloads of the route table
+	stats map[string]*backendCounters
+}
+
+type gatewayBackend struct {
+	proxy    *httputil.ReverseProxy
+	backend  string
+	model    string
+	weight   int32
+	counters *backendCounters
+}
+
+type backendCounters struct {
+	requests atomic.Int64
+	errors   atomic.Int64
+}
+
+// NewGateway creates a Gateway without any route
+func NewGateway() *Gateway {
+	return &Gateway{
+		routes: map[string][]*gatewayBackend{},
+		stats:  map[string]*backendCounters{},
+	}
+}
+
+// SetTransport sets the transport of the requests sent to the backends of the routes set afterwards,
+// like the one trusting the CA of the backends served over https
+func (g *Gateway) SetTransport(transport http.RoundTripper) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.transport = transport
+}
+
+// SetRoutes replaces the routes of the gateway with the ones in the table
+func (g *Gateway) SetRoutes(table RouteTable) error {
+	routes := make(map[string][]*gatewayBackend, len(table.Routes))
+	models := make([]string, 0, len(table.Routes))
+	for _, route := range table.Routes {
+		if _, ok := routes[route.Model]; ok {
+			return fmt.Errorf("duplicated route for model %q", route.Model)
+		}
+		weightedBackends := route.Backends
+		if len(weightedBackends) == 0 {
+			if route.Backend == "" {
+				return fmt.Errorf("no backend for model %q", route.Model)
+			}
+			weightedBackends = []WeightedBackend{{Backend: route.Backend, Weight: 1}}
+		}
+		backends := make([]*gatewayBackend, 0, len(weightedBackends))
+		g.mu.RLock()
+		transport := g.transport
+		g.mu.RUnlock()
+		total := int32(0)
+		for _, weighted := range weightedBackends {
+			target, err := url.Parse(weighted.Backend)
+			if err != nil {
+				return fmt.Errorf("invalid backend %q of model %q: %w", weighted.Backend, route.Model, err)
+			}
+			if weighted.Weight < 0 {
+				return fmt.Errorf("negative weight of backend %q of model %q", weighted.Backend, route.Model)
+			}
+			total += weighted.Weight
+			backends = append(backends, &gatewayBackend{
+				proxy:    withTransport(newReverseProxy(target), transport),
+				backend:  weighted.Backend,
+				model:    weighted.Model,
+				weight:   weighted.Weight,
+				counters: g.countersOf(weighted.Backend),
+			})
+		}
+		if total == 0 {
+			return fmt.Errorf("no backend of model %q has a weight", route.Model)
+		}
+		routes[route.Model] = backends
+		models = append(models, route.Model)
+	}
+	sort.Strings(models)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.routes = routes
+	g.models = models
+	return nil
+}
+
+func (g *Gateway) countersOf(backend string) *backendCounters {
+	g.statsMu.Lock()
+	defer g.statsMu.Unlock()
+	counters, ok := g.stats[backend]
+	if !ok {
+		counters = &backendCounters{}
+		g.stats[backend] = counters
+	}
+	return counters
+}
+
+// Stats returns the requests and the errors of each backend since the gateway started
+func (g *Gateway) Stats() Stats {
+	g.statsMu.Lock()
+	defer g.statsMu.Unlock()
+	stats := make(Stats, len(g.stats))
+	for backend, counters := range g.stats {
+		stats[backend] = BackendStats{Requests: counters.requests.Load(), Errors: counters.errors.Load()}
+	}
+	return stats
+}
+
+// StatsHandler serves the Stats of the gateway, it is served on the admin listener at the StatsPath
+func (g *Gateway) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(g.Stats())
+	})
+}
+
+// FetchStats gets the Stats from the admin listener of the gateway on the baseURL, like: http://10.0.0.12:8081
+func FetchStats(ctx context.Context, httpClient *http.Client, baseURL string) (Stats, error) {
+	stats := Stats{}
+	if err := fetchJSON(ctx, httpClient, baseURL+StatsPath, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Picks one of the backends by their weights
+func pickBackend(backends []*gatewayBackend) *gatewayBackend {
+	if len(backends) == 1 {
+		return backends[0]
+	}
+	total := int32(0)
+	for _, backend := range backends {
+		total += backend.weight
+	}
+	n := rand.Int32N(total)
+	for _, backend := range backends {
+		if n < backend.weight {
+			return backend
+		}
+		n -= backend.weight
+	}
+	return backends[len(backends)-1]
+}
+
+// LoadRoutes reads the route table from the file
+func (g *Gateway) LoadRoutes(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return g.loadRoutes(data)
+}
+
+func (g *Gateway) loadRoutes(data []byte) error {
+	table := RouteTable{}
+	if err := json.Unmarshal(data, &table); err != nil {
+		return fmt.Errorf("invalid route table: %w", err)
+	}
+	return g.SetRoutes(table)
+}
+
+// WatchRoutes reloads the route table file when it changes until the context is done.
+// The file is mounted from a ConfigMap, which is updated by the kubelet in place.
+func (g *Gateway) WatchRoutes(ctx context.Context, path string, interval time.Duration) {
+	logger := logf.Log.WithName("gateway")
+	last, _ := os.ReadFile(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logger.Error(err, "Failed to read the route table", "path", path)
+				continue
+			}
+			if bytes.Equal(data, last) {
+				continue
+			}
+			if err := g.loadRoutes(data); err != nil {
+				logger.Error(err, "Failed to reload the route table", "path", path)
+				continue
+			}
+			last = data
+			logger.Info("Reloaded the route table", "path", path)
+		}
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/v1/models":
+		if r.Method != http.MethodGet {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "method not allowed")
+			return
+		}
+		g.serveModels(w, r)
+	case "/v1/chat/completions", "/v1/completions", "/v1/embeddings":
+		if r.Method != http.MethodPost {
+			writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "", "method not allowed")
+			return
+		}
+		g.serveModelRequest(w, r)
+	default:
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "", fmt.Sprintf("unknown path %s", r.URL.Path))
+	}
+}
+
+// Finds the model in the request body and forwards the request to its backend
+func (g *Gateway) serveModelRequest(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize+1))
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "failed to read the request body")
+		return
+	}
+	if len(body) > maxRequestBodySize {
+		writeOpenAIError(w, http.StatusRequestEntityTooLarge, "invalid_request_error", "", "the request body is too large")
+		return
+	}
+	request := struct {
+		Model string `json:"model"`
+	}{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "the request body is not a valid JSON")
+		return
+	}
+	if request.Model == "" {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "the model is required")
+		return
+	}
+	g.mu.RLock()
+	backends, ok := g.routes[request.Model]
+	g.mu.RUnlock()
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "model_not_found",
+			fmt.Sprintf("the model %s does not exist", request.Model))
+		return
+	}
+	backend := pickBackend(backends)
+	if backend.model != "" && backend.model != request.Model {
+		if body, err = replaceModel(body, backend.model); err != nil {
+			writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "", "the request body is not a valid JSON object")
+			return
+		}
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	backend.proxy.ServeHTTP(recorder, r)
+	backend.counters.requests.Add(1)
+	if recorder.status >= http.StatusInternalServerError {
+		backend.counters.errors.Add(1)
+	}
+}
+
+// Replaces the model field of the request body, the other fields are kept as they are
+func replaceModel(body []byte, model string) ([]byte, error) {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(model)
+	if err != nil {
+		return nil, err
+	}
+	fields["model"] = value
+	return json.Marshal(fields)
+}
+
+// statusRecorder keeps the status code written by the backend, the streaming still works through Unwrap
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Lists the models in the OpenAI format, only the ones allowed by the API key of the request if it is limited
+func (g *Gateway) serveModels(w http.ResponseWriter, r *http.Request) {
+	allowed := AllowedModelsFromContext(r.Context())
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		OwnedBy string `json:"owned_by"`
+	}
+	g.mu.RLock()
+	data := make([]model, 0, len(g.models))
+	for _, name := range g.models {
+		if allowed != nil && !slices.Contains(allowed, name) {
+			continue
+		}
+		data = append(data, model{ID: name, Object: "model", OwnedBy: "aitrigram"})
+	}
+	g.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"object": "list",
+		"data":   data,
+	})
+}
+
+// Writes the error in the format of the OpenAI API, so that the OpenAI clients can understand it
+func writeOpenAIError(w http.ResponseWriter, status int, errorType, code, message string) {
+	body := map[string]interface{}{
+		"message": message,
+		"type":    errorType,
+	}
+	if code != "" {
+		body["code"] = code
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
@@ -0,0 +1,604 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// a fake engine which answers each request with the path it receives
+func newFakeEngine(t *testing.T) *httptest.Server {
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "engine:"+r.URL.Path)
+	}))
+	t.Cleanup(engine.Close)
+	return engine
+}
+
+func get(t *testing.T, handler http.Handler, host string, path string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://"+host+path, nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_SidecarTracksActivity(t *testing.T) {
+	t.Parallel()
+	engine := newFakeEngine(t)
+	sidecar, err := NewSidecar(engine.URL)
+	require.NoError(t, err)
+	started := sidecar.Activity().LastRequestTime
+
+	time.Sleep(10 * time.Millisecond)
+	rec := get(t, sidecar, "ollama-llama3.default.svc", "/api/tags")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "engine:/api/tags", rec.Body.String())
+
+	// the activity is only served on the admin listener
+	require.Equal(t, "engine:"+ActivityPath, get(t, sidecar, "10.0.0.12:15080", ActivityPath).Body.String())
+	admin, err := NewAdmin(engine.URL, nil, "")
+	require.NoError(t, err)
+	admin.Handle(ActivityPath, sidecar.ActivityHandler())
+	adminServer := httptest.NewServer(admin)
+	t.Cleanup(adminServer.Close)
+	activity, err := FetchActivity(context.Background(), adminServer.Client(), adminServer.URL)
+	require.NoError(t, err)
+	require.True(t, activity.LastRequestTime.After(started))
+	require.Zero(t, activity.InFlight)
+}
+
+type fakeWaker struct {
+	target *url.URL
+	err    error
+	hosts  []string
+}
+
+func (w *fakeWaker) Wake(_ context.Context, host string, scheme string) (*url.URL, error) {
+	w.hosts = append(w.hosts, scheme+"://"+host)
+	return w.target, w.err
+}
+
+func Test_Activator(t *testing.T) {
+	t.Parallel()
+	engine := newFakeEngine(t)
+	target, err := url.Parse(engine.URL)
+	require.NoError(t, err)
+
+	cases := map[string]struct {
+		waker        *fakeWaker
+		expectedCode int
+		expectedBody string
+	}{
+		"forward-after-wake": {
+			waker:        &fakeWaker{target: target},
+			expectedCode: http.StatusOK,
+			expectedBody: "engine:/v1/models",
+		},
+		"wake-failure": {
+			waker:        &fakeWaker{err: errors.New("timed out")},
+			expectedCode: http.StatusServiceUnavailable,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			activator := NewActivator(c.waker, time.Second)
+			rec := get(t, activator, "ollama-llama3.default.svc:8080", "/v1/models")
+			require.Equal(t, c.expectedCode, rec.Code)
+			if c.expectedBody != "" {
+				require.Equal(t, c.expectedBody, rec.Body.String())
+			}
+			require.Equal(t, []string{"http://ollama-llama3.default.svc:8080"}, c.waker.hosts)
+		})
+	}
+}
+
+func Test_ActivatorTLS(t *testing.T) {
+	t.Parallel()
+	engine := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "engine:"+r.URL.Path)
+	}))
+	t.Cleanup(engine.Close)
+	target, err := url.Parse(engine.URL)
+	require.NoError(t, err)
+	waker := &fakeWaker{target: target}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() { _ = NewActivator(waker, time.Second).ServeTLS(ctx, listener) }()
+
+	// the TLS is passed through to the model, the client verifies the certificate of the model
+	roots := x509.NewCertPool()
+	roots.AddCert(engine.Certificate())
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{RootCAs: roots, ServerName: "example.com"},
+	}}
+	resp, err := client.Get("https://" + listener.Addr().String() + "/v1/models")
+	require.NoError(t, err)
+	defer func() { _ = resp.Body.Close() }()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "engine:/v1/models", string(body))
+	require.Equal(t, []string{"https://example.com"}, waker.hosts)
+
+	// the connections without the server name are closed
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+	if err == nil {
+		_ = conn.Close()
+	}
+	require.Error(t, err)
+}
+
+func post(t *testing.T, handler http.Handler, path string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "http://gateway"+path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_GatewayRoutesByModel(t *testing.T) {
+	t.Parallel()
+	llama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, "llama:"+r.URL.Path+":"+string(body))
+	}))
+	t.Cleanup(llama.Close)
+	qwen := newFakeEngine(t)
+
+	gateway := NewGateway()
+	require.NoError(t, gateway.SetRoutes(RouteTable{Routes: []Route{
+		{Model: "qwen2", Backend: qwen.URL},
+		{Model: "llama3", Backend: llama.URL},
+	}}))
+
+	tests := []struct {
+		name         string
+		path         string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "chat completions to llama3",
+			path:         "/v1/chat/completions",
+			body:         `{"model":"llama3","messages":[]}`,
+			expectedCode: http.StatusOK,
+			expectedBody: `llama:/v1/chat/completions:{"model":"llama3","messages":[]}`,
+		},
+		{
+			name:         "embeddings to qwen2",
+			path:         "/v1/embeddings",
+			body:         `{"model":"qwen2","input":"hello"}`,
+			expectedCode: http.StatusOK,
+			expectedBody: "engine:/v1/embeddings",
+		},
+		{
+			name:         "unknown model",
+			path:         "/v1/completions",
+			body:         `{"model":"mistral","prompt":"hello"}`,
+			expectedCode: http.StatusNotFound,
+			expectedBody: "model_not_found",
+		},
+		{
+			name:         "missing model",
+			path:         "/v1/completions",
+			body:         `{"prompt":"hello"}`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "the model is required",
+		},
+		{
+			name:         "invalid body",
+			path:         "/v1/chat/completions",
+			body:         `not json`,
+			expectedCode: http.StatusBadRequest,
+			expectedBody: "invalid_request_error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(t, gateway, tt.path, tt.body)
+			require.Equal(t, tt.expectedCode, rec.Code)
+			require.Contains(t, rec.Body.String(), tt.expectedBody)
+		})
+	}
+
+	rec := get(t, gateway, "gateway", "/v1/models")
+	require.Equal(t, http.StatusOK, rec.Code)
+	models := struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &models))
+	require.Len(t, models.Data, 2)
+	require.Equal(t, "llama3", models.Data[0].ID)
+	require.Equal(t, "qwen2", models.Data[1].ID)
+
+	require.Error(t, gateway.SetRoutes(RouteTable{Routes: []Route{
+		{Model: "qwen2", Backend: qwen.URL},
+		{Model: "qwen2", Backend: llama.URL},
+	}}))
+}
+
+func Test_GatewayWeightedBackends(t *testing.T) {
+	t.Parallel()
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = io.WriteString(w, "stable:"+string(body))
+	}))
+	t.Cleanup(stable.Close)
+	canary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(canary.Close)
+
+	gateway := NewGateway()
+	require.NoError(t, gateway.SetRoutes(RouteTable{Routes: []Route{{
+		Model: "gemma3",
+		Backends: []WeightedBackend{
+			{Backend: stable.URL, Model: "gemma3:1b", Weight: 1},
+			{Backend: canary.URL, Model: "gemma3:4b", Weight: 0},
+		},
+	}}}))
+	// the model is replaced with the one of the backend
+	rec := post(t, gateway, "/v1/chat/completions", `{"model":"gemma3","messages":[]}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, `stable:{"messages":[],"model":"gemma3:1b"}`, rec.Body.String())
+
+	require.NoError(t, gateway.SetRoutes(RouteTable{Routes: []Route{{
+		Model: "gemma3",
+		Backends: []WeightedBackend{
+			{Backend: stable.URL, Model: "gemma3:1b", Weight: 0},
+			{Backend: canary.URL, Model: "gemma3:4b", Weight: 100},
+		},
+	}}}))
+	rec = post(t, gateway, "/v1/chat/completions", `{"model":"gemma3","messages":[]}`)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+
+	// the stats are kept across the reloads of the routes
+	server := httptest.NewServer(gateway.StatsHandler())
+	t.Cleanup(server.Close)
+	stats, err := FetchStats(context.Background(), http.DefaultClient, server.URL)
+	require.NoError(t, err)
+	require.Equal(t, Stats{
+		stable.URL: {Requests: 1},
+		canary.URL: {Requests: 1, Errors: 1},
+	}, stats)
+
+	require.Error(t, gateway.SetRoutes(RouteTable{Routes: []Route{{
+		Model:    "gemma3",
+		Backends: []WeightedBackend{{Backend: stable.URL, Weight: 0}},
+	}}}))
+	require.Error(t, gateway.SetRoutes(RouteTable{Routes: []Route{{Model: "gemma3"}}}))
+}
+
+func Test_RequireAPIKey(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "0"), []byte("admin-key\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1"), []byte("llama-key"), 0o600))
+	auth, err := NewAuthenticator(AuthConfig{APIKeys: []APIKeyFile{
+		{File: filepath.Join(dir, "0")},
+		{File: filepath.Join(dir, "1"), Models: []string{"llama3"}},
+	}})
+	require.NoError(t, err)
+	engine := newFakeEngine(t)
+	sidecar, err := NewSidecar(engine.URL)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		model        string
+		path         string
+		key          string
+		body         string
+		expectedCode int
+		expectedBody string
+	}{
+		{name: "missing key", body: `{"model":"llama3"}`, expectedCode: http.StatusUnauthorized, expectedBody: "missing API key"},
+		{name: "invalid key", key: "wrong", body: `{"model":"llama3"}`, expectedCode: http.StatusUnauthorized, expectedBody: "invalid API key"},
+		{name: "key of all models", key: "admin-key", body: `{"model":"qwen2"}`, expectedCode: http.StatusOK, expectedBody: "engine:/api/chat"},
+		{name: "allowed model in the body", key: "llama-key", body: `{"model":"llama3"}`, expectedCode: http.StatusOK, expectedBody: "engine:/api/chat"},
+		{name: "not allowed model in the body", key: "llama-key", body: `{"model":"qwen2"}`, expectedCode: http.StatusForbidden, expectedBody: "model_not_allowed"},
+		{name: "allowed model of the sidecar", model: "llama3", key: "llama-key", body: `{}`, expectedCode: http.StatusOK, expectedBody: "engine:/api/chat"},
+		{name: "not allowed model of the sidecar", model: "qwen2", key: "llama-key", body: `{}`, expectedCode: http.StatusForbidden, expectedBody: "model_not_allowed"},
+		{name: "not allowed adapter served by the sidecar", model: "llama3", key: "llama-key", body: `{"model":"sql-lora"}`, expectedCode: http.StatusForbidden, expectedBody: "sql-lora"},
+		{name: "not allowed base model served by the sidecar", model: "llama3", key: "llama-key", body: `{"model":"llama3.2:latest"}`, expectedCode: http.StatusForbidden, expectedBody: "llama3.2:latest"},
+		{name: "not allowed legacy name", model: "llama3", key: "llama-key", body: `{"name":"qwen2"}`, expectedCode: http.StatusForbidden, expectedBody: "qwen2"},
+		{name: "not allowed copy", key: "llama-key", path: "/api/copy", body: `{"source":"llama3","destination":"qwen2"}`, expectedCode: http.StatusForbidden, expectedBody: "model_not_allowed"},
+		{name: "management by a key of some models", key: "llama-key", path: "/v1/load_lora_adapter", body: `{"lora_name":"llama3"}`, expectedCode: http.StatusForbidden, expectedBody: "not allowed to manage"},
+		{name: "management by a key of all models", key: "admin-key", path: "/api/pull", body: `{"model":"qwen2"}`, expectedCode: http.StatusOK, expectedBody: "engine:/api/pull"},
+		{name: "no model addressed", key: "llama-key", expectedCode: http.StatusOK, expectedBody: "engine:/api/chat"},
+		{name: "model in another case", key: "llama-key", body: `{"model":"llama3","Model":"qwen2"}`, expectedCode: http.StatusBadRequest, expectedBody: "ambiguous field"},
+		{name: "duplicated model", key: "llama-key", body: `{"model":"qwen2","model":"llama3"}`, expectedCode: http.StatusBadRequest, expectedBody: "ambiguous field"},
+		{name: "model which is not a string", key: "llama-key", body: `{"model":["qwen2"]}`, expectedCode: http.StatusBadRequest, expectedBody: "not a string"},
+		{name: "body which is not JSON", key: "llama-key", body: `model=qwen2`, expectedCode: http.StatusBadRequest, expectedBody: "not a JSON object"},
+		{name: "body which is not JSON by a key of all models", key: "admin-key", body: `model=qwen2`, expectedCode: http.StatusOK, expectedBody: "engine:/api/chat"},
+		{name: "nested model", key: "llama-key", body: `{"model":"llama3","options":{"model":"qwen2","Model":"qwen2"}}`, expectedCode: http.StatusOK, expectedBody: "engine:/api/chat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.path
+			if path == "" {
+				path = "/api/chat"
+			}
+			req := httptest.NewRequest(http.MethodPost, "http://ollama-llama3.default.svc"+path, strings.NewReader(tt.body))
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+			rec := httptest.NewRecorder()
+			RequireAPIKey(auth, tt.model, sidecar).ServeHTTP(rec, req)
+			require.Equal(t, tt.expectedCode, rec.Code)
+			require.Contains(t, rec.Body.String(), tt.expectedBody)
+		})
+	}
+
+	// the gateway only lists the models allowed by the key
+	gateway := NewGateway()
+	require.NoError(t, gateway.SetRoutes(RouteTable{Routes: []Route{
+		{Model: "llama3", Backends: []WeightedBackend{{Backend: engine.URL, Weight: 100}}},
+		{Model: "qwen2", Backends: []WeightedBackend{{Backend: engine.URL, Weight: 100}}},
+	}}))
+	for key, expected := range map[string][]string{"admin-key": {"llama3", "qwen2"}, "llama-key": {"llama3"}} {
+		req := httptest.NewRequest(http.MethodGet, "http://gateway/v1/models", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		RequireAPIKey(auth, "", gateway).ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+		models := struct {
+			Data []struct {
+				ID string `json:"id"`
+			} `json:"data"`
+		}{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &models))
+		var ids []string
+		for _, model := range models.Data {
+			ids = append(ids, model.ID)
+		}
+		require.ElementsMatch(t, expected, ids)
+	}
+	// the stats of the gateway are only served on its admin listener
+	require.Equal(t, http.StatusUnauthorized, get(t, RequireAPIKey(auth, "", gateway), "gateway:8080", StatsPath).Code)
+
+	// the paths of the admin listener are not served without a key
+	rec := get(t, RequireAPIKey(auth, "llama3", sidecar), "10.0.0.12:15080", ActivityPath)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// the rotated keys take effect once reloaded
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1"), []byte("rotated-key"), 0o600))
+	require.NoError(t, auth.Reload())
+	_, ok := auth.lookup("llama-key")
+	require.False(t, ok)
+	authorized, ok := auth.lookup("rotated-key")
+	require.True(t, ok)
+	require.Equal(t, &authorizedKey{file: filepath.Join(dir, "1"), models: []string{"llama3"}}, authorized)
+}
+
+func Test_Admin(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("admin-token\n"), 0o600))
+	engine := newFakeEngine(t)
+	admin, err := NewAdmin(engine.URL, []string{"/health", "/metrics"}, tokenFile)
+	require.NoError(t, err)
+
+	// the health and the metrics of the engine are served without the token
+	rec := get(t, admin, "10.0.0.12:15090", "/health")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "engine:/health", rec.Body.String())
+	require.Equal(t, http.StatusOK, get(t, admin, "10.0.0.12:15090", "/metrics").Code)
+	// so are the paths of the sidecar itself
+	sidecar, err := NewSidecar(engine.URL)
+	require.NoError(t, err)
+	admin.Handle(ActivityPath, sidecar.ActivityHandler())
+	rec = get(t, admin, "10.0.0.12:15090", ActivityPath)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Contains(t, rec.Body.String(), "lastRequestTime")
+
+	// the other requests need the admin token
+	require.Equal(t, http.StatusUnauthorized, get(t, admin, "10.0.0.12:15090", "/v1/models").Code)
+	require.Equal(t, http.StatusUnauthorized, post(t, admin, "/health", `{}`).Code)
+	request := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://10.0.0.12:15090/v1/load_lora_adapter", strings.NewReader(`{"lora_name":"sql"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+	require.Equal(t, http.StatusUnauthorized, request("wrong").Code)
+	rec = request("admin-token")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "engine:/v1/load_lora_adapter", rec.Body.String())
+
+	// no request needs the token without the token file
+	admin, err = NewAdmin(engine.URL, nil, "")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, request("").Code)
+}
+
+func Test_EnforceQuota(t *testing.T) {
+	t.Parallel()
+	// a fake engine which reports the usage of the tokens like ollama
+	engine := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, `{"done":false}`+"\n"+`{"done":true,"prompt_eval_count":30,"eval_count":70}`+"\n")
+	}))
+	t.Cleanup(engine.Close)
+	sidecar, err := NewSidecar(engine.URL)
+	require.NoError(t, err)
+	limiter := NewLimiter()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	require.NoError(t, limiter.SetConfig(QuotaConfig{Limits: []QuotaLimit{
+		{APIKeyFile: "/etc/aitrigram/auth/0", TokensPerMinute: 150},
+		{RequestsPerSecond: 2},
+	}}))
+	// the client is the API key put into the context by RequireAPIKey
+	withAPIKey := func(file string, next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, file)))
+		})
+	}
+	handler := EnforceQuota(limiter, sidecar)
+
+	// the tokens per minute are exceeded after the second request, until the minute is over
+	batch := withAPIKey("/etc/aitrigram/auth/0", handler)
+	require.Equal(t, http.StatusOK, post(t, batch, "/api/chat", `{"model":"llama3"}`).Code)
+	require.Equal(t, http.StatusOK, post(t, batch, "/api/chat", `{"model":"llama3"}`).Code)
+	rec := post(t, batch, "/api/chat", `{"model":"llama3"}`)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "60", rec.Header().Get("Retry-After"))
+	require.Contains(t, rec.Body.String(), "tokens per minute")
+
+	// the other clients get the default limit of their own
+	other := withAPIKey("/etc/aitrigram/auth/1", handler)
+	require.Equal(t, http.StatusOK, post(t, other, "/api/chat", `{}`).Code)
+	require.Equal(t, http.StatusOK, post(t, other, "/api/chat", `{}`).Code)
+	rec = post(t, other, "/api/chat", `{}`)
+	require.Equal(t, http.StatusTooManyRequests, rec.Code)
+	require.Equal(t, "1", rec.Header().Get("Retry-After"))
+	require.Contains(t, rec.Body.String(), "requests per second")
+
+	rec = get(t, limiter.UsageHandler(), "10.0.0.12:15090", QuotaPath)
+	require.Equal(t, http.StatusOK, rec.Code)
+	usage := []QuotaUsage{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &usage))
+	require.Equal(t, []QuotaUsage{
+		{APIKeyFile: "/etc/aitrigram/auth/0", Requests: 2, Tokens: 200, Throttled: 1},
+		{APIKeyFile: "/etc/aitrigram/auth/1", Requests: 2, Tokens: 200, Throttled: 1},
+	}, usage)
+
+	// a new minute starts over
+	now = now.Add(time.Minute)
+	require.Equal(t, http.StatusOK, post(t, batch, "/api/chat", `{"model":"llama3"}`).Code)
+	require.Equal(t, http.StatusOK, post(t, other, "/api/chat", `{}`).Code)
+
+	require.Equal(t, int64(42), tokensOf([]byte(`data: {"usage":{"prompt_tokens":12,"completion_tokens":30,"total_tokens":42}}`+"\n\ndata: [DONE]\n\n")))
+	require.Error(t, limiter.SetConfig(QuotaConfig{Limits: []QuotaLimit{{RequestsPerSecond: 1}, {TokensPerMinute: 1}}}))
+}
+
+// writes a self-signed certificate of the common name into the cert and key files
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func Test_CertificateReloader(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeCertificate(t, certFile, keyFile, "first")
+	certs, err := NewCertificateReloader(certFile, keyFile)
+	require.NoError(t, err)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	// StartTLS would serve its own certificate
+	server.Listener = tls.NewListener(server.Listener, certs.TLSConfig())
+	server.Start()
+	t.Cleanup(server.Close)
+	servedName := func() string {
+		conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	require.Equal(t, "first", servedName())
+
+	// the rotated certificate is served to the new connections once reloaded
+	writeCertificate(t, certFile, keyFile, "second")
+	require.Equal(t, "first", servedName())
+	require.NoError(t, certs.Reload())
+	require.Equal(t, "second", servedName())
+
+	// a broken certificate keeps the last one
+	require.NoError(t, os.WriteFile(keyFile, []byte("broken"), 0o600))
+	require.Error(t, certs.Reload())
+	require.Equal(t, "second", servedName())
+}
+
+func Test_GatewayBackendOverTLS(t *testing.T) {
+	t.Parallel()
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tls:"+r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}), 0o600))
+	request := func(gateway *Gateway) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "http://gateway/v1/chat/completions", strings.NewReader(`{"model":"llama3"}`))
+		rec := httptest.NewRecorder()
+		gateway.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// the certificate of the backend is not trusted without its CA
+	gateway := NewGateway()
+	require.NoError(t, gateway.SetRoutes(RouteTable{Routes: []Route{{Model: "llama3", Backend: backend.URL}}}))
+	require.Equal(t, http.StatusBadGateway, request(gateway).Code)
+
+	transport, err := NewBackendTransport(caFile)
+	require.NoError(t, err)
+	gateway = NewGateway()
+	gateway.SetTransport(transport)
+	require.NoError(t, gateway.SetRoutes(RouteTable{Routes: []Route{{Model: "llama3", Backend: backend.URL}}}))
+	rec := request(gateway)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, "tls:/v1/chat/completions", rec.Body.String())
+
+	_, err = NewBackendTransport(filepath.Join(t.TempDir(), "missing.crt"))
+	require.Error(t, err)
+}
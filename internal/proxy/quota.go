@@ -0,0 +1,333 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// QuotaPath is served by the admin listener of the sidecar, it reports the usage of each client in the current minute.
+	QuotaPath = "/.aitrigram/quota"
+	// QuotaFileName is the key in the quota ConfigMap and the file name in the sidecar container
+	QuotaFileName = "quota.json"
+
+	// the tokens per minute are counted in the windows of a minute
+	quotaWindow = time.Minute
+	// the tail of the responses kept to find the token usage, which is at the end of the responses
+	usageTailSize = 16 << 10
+)
+
+var (
+	// the usage in the responses of the OpenAI-compatible API
+	totalTokensPattern = regexp.MustCompile(`"total_tokens"\s*:\s*(\d+)`)
+	// the usage in the responses of the ollama API
+	promptEvalCountPattern = regexp.MustCompile(`"prompt_eval_count"\s*:\s*(\d+)`)
+	evalCountPattern       = regexp.MustCompile(`"eval_count"\s*:\s*(\d+)`)
+)
+
+// QuotaLimit limits the requests of a client, a client is an API key, or all requests without an API key
+type QuotaLimit struct {
+	// APIKeyFile is the file of the API key the limit applies to, the limit applies to each of the other clients if empty
+	APIKeyFile string `json:"apiKeyFile,omitempty"`
+	// RequestsPerSecond is not limited if zero
+	RequestsPerSecond float64 `json:"requestsPerSecond,omitempty"`
+	// TokensPerMinute is not limited if zero
+	TokensPerMinute int64 `json:"tokensPerMinute,omitempty"`
+}
+
+// QuotaConfig is the content of the quota file read by the sidecar
+type QuotaConfig struct {
+	Limits []QuotaLimit `json:"limits"`
+}
+
+// QuotaUsage is the usage of a client in the current minute
+type QuotaUsage struct {
+	// APIKeyFile is the file of the API key of the client, it is empty for the requests without an API key
+	APIKeyFile string `json:"apiKeyFile,omitempty"`
+	Requests   int64  `json:"requests"`
+	Tokens     int64  `json:"tokens"`
+	// Throttled are the requests rejected with 429
+	Throttled int64 `json:"throttled"`
+}
+
+// Limiter keeps the usage of each client, and decides if its next request is allowed by its QuotaLimit
+type Limiter struct {
+	mu      sync.Mutex
+	limits  map[string]QuotaLimit
+	clients map[string]*clientQuota
+	now     func() time.Time
+}
+
+type clientQuota struct {
+	limit QuotaLimit
+	// nil when the requests per second are not limited
+	requests    *rate.Limiter
+	windowStart time.Time
+	usage       QuotaUsage
+}
+
+// NewLimiter creates a Limiter without any limit
+func NewLimiter() *Limiter {
+	return &Limiter{
+		limits:  map[string]QuotaLimit{},
+		clients: map[string]*clientQuota{},
+		now:     time.Now,
+	}
+}
+
+// SetConfig replaces the limits, the usage of the clients in the current minute is kept
+func (l *Limiter) SetConfig(config QuotaConfig) error {
+	limits := make(map[string]QuotaLimit, len(config.Limits))
+	for _, limit := range config.Limits {
+		if _, ok := limits[limit.APIKeyFile]; ok {
+			return fmt.Errorf("duplicated limit for API key %q", limit.APIKeyFile)
+		}
+		if limit.RequestsPerSecond < 0 || limit.TokensPerMinute < 0 {
+			return fmt.Errorf("negative limit for API key %q", limit.APIKeyFile)
+		}
+		limits[limit.APIKeyFile] = limit
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limits = limits
+	for file, client := range l.clients {
+		client.setLimit(l.limitOf(file))
+	}
+	return nil
+}
+
+// The limit of the API key, or the default one when it has no limit of its own
+func (l *Limiter) limitOf(file string) QuotaLimit {
+	if limit, ok := l.limits[file]; ok {
+		return limit
+	}
+	return l.limits[""]
+}
+
+func (c *clientQuota) setLimit(limit QuotaLimit) {
+	c.limit = limit
+	if limit.RequestsPerSecond == 0 {
+		c.requests = nil
+		return
+	}
+	burst := int(math.Max(1, math.Ceil(limit.RequestsPerSecond)))
+	if c.requests == nil {
+		c.requests = rate.NewLimiter(rate.Limit(limit.RequestsPerSecond), burst)
+		return
+	}
+	c.requests.SetLimit(rate.Limit(limit.RequestsPerSecond))
+	c.requests.SetBurst(burst)
+}
+
+// Returns the client with its window of the current minute, it must be called with the lock held
+func (l *Limiter) clientOf(file string, now time.Time) *clientQuota {
+	client, ok := l.clients[file]
+	if !ok {
+		client = &clientQuota{windowStart: now, usage: QuotaUsage{APIKeyFile: file}}
+		client.setLimit(l.limitOf(file))
+		l.clients[file] = client
+	}
+	if now.Sub(client.windowStart) >= quotaWindow {
+		client.windowStart = now
+		client.usage = QuotaUsage{APIKeyFile: file}
+	}
+	return client
+}
+
+// Counts the request of the client if it is allowed, otherwise returns the limit it exceeds and how long to wait
+func (l *Limiter) allow(file string) (string, time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	client := l.clientOf(file, now)
+	if client.limit.TokensPerMinute > 0 && client.usage.Tokens >= client.limit.TokensPerMinute {
+		client.usage.Throttled++
+		return "tokens", client.windowStart.Add(quotaWindow).Sub(now), false
+	}
+	if client.requests != nil {
+		reservation := client.requests.ReserveN(now, 1)
+		if delay := reservation.DelayFrom(now); delay > 0 {
+			reservation.CancelAt(now)
+			client.usage.Throttled++
+			return "requests", delay, false
+		}
+	}
+	client.usage.Requests++
+	return "", 0, true
+}
+
+// Counts the tokens used by a request of the client
+func (l *Limiter) record(file string, tokens int64) {
+	if tokens == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clientOf(file, l.now()).usage.Tokens += tokens
+}
+
+// Usage returns the usage of the clients which sent requests in the current minute
+func (l *Limiter) Usage() []QuotaUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	usage := []QuotaUsage{}
+	for _, client := range l.clients {
+		if now.Sub(client.windowStart) < quotaWindow {
+			usage = append(usage, client.usage)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		return usage[i].APIKeyFile < usage[j].APIKeyFile
+	})
+	return usage
+}
+
+// LoadConfig reads the limits from the file
+func (l *Limiter) LoadConfig(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return l.loadConfig(data)
+}
+
+func (l *Limiter) loadConfig(data []byte) error {
+	config := QuotaConfig{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("invalid quota config: %w", err)
+	}
+	return l.SetConfig(config)
+}
+
+// WatchConfig reloads the quota file when it changes until the context is done.
+// The file is mounted from a ConfigMap, which is updated by the kubelet in place.
+func (l *Limiter) WatchConfig(ctx context.Context, path string, interval time.Duration) {
+	logger := logf.Log.WithName("quota")
+	last, _ := os.ReadFile(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logger.Error(err, "Failed to read the quota config", "path", path)
+				continue
+			}
+			if bytes.Equal(data, last) {
+				continue
+			}
+			if err := l.loadConfig(data); err != nil {
+				logger.Error(err, "Failed to reload the quota config", "path", path)
+				continue
+			}
+			last = data
+			logger.Info("Reloaded the quota config", "path", path)
+		}
+	}
+}
+
+// EnforceQuota rejects the requests of the clients over their limits with 429 and a Retry-After header.
+// The client is the API key put into the request context by RequireAPIKey. The tokens are counted from
+// the usage reported at the end of the responses, so the tokens per minute take effect from the next request.
+func EnforceQuota(l *Limiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file := APIKeyFileFromContext(r.Context())
+		if exceeded, retryAfter, ok := l.allow(file); !ok {
+			message := "the requests per second of the client are over the quota"
+			if exceeded == "tokens" {
+				message = "the tokens per minute of the client are over the quota"
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(retryAfter.Seconds())))))
+			writeOpenAIError(w, http.StatusTooManyRequests, exceeded, "rate_limit_exceeded", message)
+			return
+		}
+		recorder := &usageRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+		l.record(file, tokensOf(recorder.tail))
+	})
+}
+
+// usageRecorder keeps the tail of the response to find the token usage, the streaming still works through Unwrap
+type usageRecorder struct {
+	http.ResponseWriter
+	tail []byte
+}
+
+func (r *usageRecorder) Write(data []byte) (int, error) {
+	r.tail = append(r.tail, data...)
+	if len(r.tail) > usageTailSize {
+		r.tail = r.tail[len(r.tail)-usageTailSize:]
+	}
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *usageRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Finds the tokens used in the tail of a response, including the last chunk of a streamed one
+func tokensOf(tail []byte) int64 {
+	if tokens := lastNumber(totalTokensPattern, tail); tokens > 0 {
+		return tokens
+	}
+	return lastNumber(promptEvalCountPattern, tail) + lastNumber(evalCountPattern, tail)
+}
+
+func lastNumber(pattern *regexp.Regexp, data []byte) int64 {
+	matches := pattern.FindAllSubmatch(data, -1)
+	if len(matches) == 0 {
+		return 0
+	}
+	n, _ := strconv.ParseInt(string(matches[len(matches)-1][1]), 10, 64)
+	return n
+}
+
+// UsageHandler serves the usage of the clients, it is served on the admin listener of the sidecar at the QuotaPath
+func (l *Limiter) UsageHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(l.Usage())
+	})
+}
+
+// FetchQuotaUsage gets the usage of the clients from the admin listener of the sidecar on the baseURL, like: http://10.0.0.12:15090
+func FetchQuotaUsage(ctx context.Context, httpClient *http.Client, baseURL string) ([]QuotaUsage, error) {
+	usage := []QuotaUsage{}
+	if err := fetchJSON(ctx, httpClient, baseURL+QuotaPath, &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
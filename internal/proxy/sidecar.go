@@ -0,0 +1,131 @@
+/*
+Copyright 2025 Lin Gao.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package proxy contains the HTTP proxies run by the operator binary: the sidecar which sits in front of
+// the engine container in the model pods, the activator which wakes up the models scaled to zero, and
+// the OpenAI-compatible gateway which routes the requests to the models of an engine.
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ActivityPath is served by the admin listener of the sidecar, it reports when the last request came in.
+	ActivityPath = "/.aitrigram/activity"
+)
+
+// Activity reports the requests handled by a sidecar
+type Activity struct {
+	// LastRequestTime is when the last request came in, it is the start time of the sidecar if no request yet
+	LastRequestTime time.Time `json:"lastRequestTime"`
+	// InFlight is the number of the requests which are being handled
+	InFlight int64 `json:"inFlight"`
+}
+
+// Sidecar forwards the requests to the engine container in the same pod and keeps track of the activity
+type Sidecar struct {
+	proxy       *httputil.ReverseProxy
+	lastRequest atomic.Int64
+	inFlight    atomic.Int64
+}
+
+// NewSidecar creates a Sidecar which forwards the requests to the upstream, like: http://127.0.0.1:11434
+func NewSidecar(upstream string) (*Sidecar, error) {
+	target, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", upstream, err)
+	}
+	s := &Sidecar{
+		proxy: newReverseProxy(target),
+	}
+	s.lastRequest.Store(time.Now().UnixNano())
+	return s, nil
+}
+
+func (s *Sidecar) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.inFlight.Add(1)
+	defer func() {
+		s.lastRequest.Store(time.Now().UnixNano())
+		s.inFlight.Add(-1)
+	}()
+	s.lastRequest.Store(time.Now().UnixNano())
+	s.proxy.ServeHTTP(w, r)
+}
+
+// Activity returns the current activity of the sidecar
+func (s *Sidecar) Activity() Activity {
+	return Activity{
+		LastRequestTime: time.Unix(0, s.lastRequest.Load()),
+		InFlight:        s.inFlight.Load(),
+	}
+}
+
+// ActivityHandler serves the Activity of the sidecar, it is served on the admin listener at the ActivityPath
+func (s *Sidecar) ActivityHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Activity())
+	})
+}
+
+// FetchActivity gets the Activity from the admin listener of the sidecar on the baseURL, like: http://10.0.0.12:15090
+func FetchActivity(ctx context.Context, httpClient *http.Client, baseURL string) (*Activity, error) {
+	activity := &Activity{}
+	if err := fetchJSON(ctx, httpClient, baseURL+ActivityPath, activity); err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
+// Gets the JSON from the endpoint served by the proxies themselves
+func fetchJSON(ctx context.Context, httpClient *http.Client, url string, into interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(into)
+}
+
+// The responses are flushed immediately so that the streamed tokens reach the clients without buffering
+func newReverseProxy(target *url.URL) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.FlushInterval = -1
+	return proxy
+}
+
+// withTransport sends the requests of the proxy with the transport, it is the http.DefaultTransport if nil
+func withTransport(proxy *httputil.ReverseProxy, transport http.RoundTripper) *httputil.ReverseProxy {
+	proxy.Transport = transport
+	return proxy
+}
@@ -20,8 +20,12 @@ import (
 	"context"
 	"fmt"
 
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
@@ -35,10 +39,10 @@ import (
 var llmenginelog = logf.Log.WithName("llmengine-resource")
 
 // SetupLLMEngineWebhookWithManager registers the webhook for LLMEngine in the manager.
-func SetupLLMEngineWebhookWithManager(mgr ctrl.Manager) error {
+func SetupLLMEngineWebhookWithManager(mgr ctrl.Manager, bindableRoles []string) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(&aitrigramv1.LLMEngine{}).
-		// WithValidator(&LLMEngineCustomValidator{}).
+		WithValidator(&LLMEngineCustomValidator{Client: mgr.GetClient(), BindableRoles: bindableRoles}).
 		// WithDefaulter(&LLMEngineCustomDefaulter{}).
 		Complete()
 }
@@ -85,7 +89,10 @@ func (d *LLMEngineCustomDefaulter) Default(ctx context.Context, obj runtime.Obje
 // NOTE: The +kubebuilder:object:generate=false marker prevents controller-gen from generating DeepCopy methods,
 // as this struct is used only for temporary operations and does not need to be deeply copied.
 type LLMEngineCustomValidator struct {
-	// TODO(user): Add more fields as needed for validation
+	// Client creates the SubjectAccessReviews of the referenced roles
+	Client client.Client
+	// BindableRoles are the roles the operator is allowed to bind, like ClusterRole/view
+	BindableRoles []string
 }
 
 var _ webhook.CustomValidator = &LLMEngineCustomValidator{}
@@ -98,9 +105,7 @@ func (v *LLMEngineCustomValidator) ValidateCreate(ctx context.Context, obj runti
 	}
 	llmenginelog.Info("Validation for LLMEngine upon creation", "name", llmengine.GetName())
 
-	// TODO(user): fill in your validation logic upon object creation.
-
-	return nil, nil
+	return v.validateLLMEngine(ctx, nil, llmengine)
 }
 
 // ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type LLMEngine.
@@ -109,11 +114,17 @@ func (v *LLMEngineCustomValidator) ValidateUpdate(ctx context.Context, oldObj, n
 	if !ok {
 		return nil, fmt.Errorf("expected a LLMEngine object for the newObj but got %T", newObj)
 	}
+	oldLLMEngine, ok := oldObj.(*aitrigramv1.LLMEngine)
+	if !ok {
+		return nil, fmt.Errorf("expected a LLMEngine object for the oldObj but got %T", oldObj)
+	}
+	// the finalizers of a LLMEngine being deleted are removed, and its metadata is updated, without the spec validated again
+	if !llmengine.GetDeletionTimestamp().IsZero() || equality.Semantic.DeepEqual(oldLLMEngine.Spec, llmengine.Spec) {
+		return nil, nil
+	}
 	llmenginelog.Info("Validation for LLMEngine upon update", "name", llmengine.GetName())
 
-	// TODO(user): fill in your validation logic upon object update.
-
-	return nil, nil
+	return v.validateLLMEngine(ctx, oldLLMEngine, llmengine)
 }
 
 // ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type LLMEngine.
@@ -128,3 +139,19 @@ func (v *LLMEngineCustomValidator) ValidateDelete(ctx context.Context, obj runti
 
 	return nil, nil
 }
+
+// The roles the user can not bind are returned as an Invalid error with the field paths, the weakened security
+// contexts are only warned about.
+func (v *LLMEngineCustomValidator) validateLLMEngine(ctx context.Context, oldLLMEngine, llmengine *aitrigramv1.LLMEngine) (admission.Warnings, error) {
+	warnings := securityContextWarnings(field.NewPath("spec", "modelDeploymentTemplate"), llmengine.Spec.ModelDeploymentTemplate)
+	var oldServiceAccount *aitrigramv1.ServiceAccountSpec
+	if oldLLMEngine != nil {
+		oldServiceAccount = oldLLMEngine.Spec.ServiceAccount
+	}
+	allErrs := roleRefErrors(ctx, v.Client, v.BindableRoles, field.NewPath("spec", "serviceAccount"), llmengine.Namespace,
+		oldServiceAccount, llmengine.Spec.ServiceAccount)
+	if len(allErrs) > 0 {
+		return warnings, apierrors.NewInvalid(aitrigramv1.GroupVersion.WithKind("LLMEngine").GroupKind(), llmengine.Name, allErrs)
+	}
+	return warnings, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+// log is for logging in this package.
+var llmmodellog = logf.Log.WithName("llmmodel-resource")
+
+// SetupLLMModelWebhookWithManager registers the webhook for LLMModel in the manager.
+func SetupLLMModelWebhookWithManager(mgr ctrl.Manager, bindableRoles []string) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&aitrigramv1.LLMModel{}).
+		WithValidator(&LLMModelCustomValidator{Client: mgr.GetClient(), BindableRoles: bindableRoles}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-aitrigram-ihomeland-cn-v1-llmmodel,mutating=false,failurePolicy=fail,sideEffects=None,groups=aitrigram.ihomeland.cn,resources=llmmodels,verbs=create;update,versions=v1,name=vllmmodel-v1.kb.io,admissionReviewVersions=v1
+
+// LLMModelCustomValidator struct is responsible for validating the LLMModel resource
+// when it is created or updated.
+type LLMModelCustomValidator struct {
+	// Client creates the SubjectAccessReviews of the referenced roles
+	Client client.Client
+	// BindableRoles are the roles the operator is allowed to bind, like ClusterRole/view
+	BindableRoles []string
+}
+
+var _ webhook.CustomValidator = &LLMModelCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type LLMModel.
+func (v *LLMModelCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	llmmodel, ok := obj.(*aitrigramv1.LLMModel)
+	if !ok {
+		return nil, fmt.Errorf("expected a LLMModel object but got %T", obj)
+	}
+	llmmodellog.Info("Validation for LLMModel upon creation", "name", llmmodel.GetName())
+
+	return v.validateLLMModel(ctx, nil, llmmodel)
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type LLMModel.
+func (v *LLMModelCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	llmmodel, ok := newObj.(*aitrigramv1.LLMModel)
+	if !ok {
+		return nil, fmt.Errorf("expected a LLMModel object for the newObj but got %T", newObj)
+	}
+	oldLLMModel, ok := oldObj.(*aitrigramv1.LLMModel)
+	if !ok {
+		return nil, fmt.Errorf("expected a LLMModel object for the oldObj but got %T", oldObj)
+	}
+	// the finalizers of a LLMModel being deleted are removed, and its metadata is updated, without the spec validated again
+	if !llmmodel.GetDeletionTimestamp().IsZero() || equality.Semantic.DeepEqual(oldLLMModel.Spec, llmmodel.Spec) {
+		return nil, nil
+	}
+	llmmodellog.Info("Validation for LLMModel upon update", "name", llmmodel.GetName())
+
+	return v.validateLLMModel(ctx, oldLLMModel, llmmodel)
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type LLMModel.
+func (v *LLMModelCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// The roles the user can not bind are returned as an Invalid error with the field paths, the weakened security
+// contexts are only warned about.
+func (v *LLMModelCustomValidator) validateLLMModel(ctx context.Context, oldLLMModel, llmmodel *aitrigramv1.LLMModel) (admission.Warnings, error) {
+	warnings := securityContextWarnings(field.NewPath("spec", "modelDeployment"), llmmodel.Spec.ModelDeployment)
+	var oldServiceAccount *aitrigramv1.ServiceAccountSpec
+	if oldLLMModel != nil {
+		oldServiceAccount = oldLLMModel.Spec.ServiceAccount
+	}
+	allErrs := roleRefErrors(ctx, v.Client, v.BindableRoles, field.NewPath("spec", "serviceAccount"), llmmodel.Namespace,
+		oldServiceAccount, llmmodel.Spec.ServiceAccount)
+	if llmmodel.Spec.NetworkPolicy != nil || llmmodel.Spec.ServiceAccount != nil {
+		// the engine created later is checked by the reconciler
+		llmEngine := &aitrigramv1.LLMEngine{}
+		err := v.Client.Get(ctx, client.ObjectKey{Namespace: llmmodel.Namespace, Name: llmmodel.Spec.EngineRef}, llmEngine)
+		if err != nil && !apierrors.IsNotFound(err) {
+			return warnings, err
+		}
+		if err == nil {
+			allErrs = append(allErrs, controller.ValidateLLMModelOfEngine(llmEngine, llmmodel)...)
+		}
+	}
+	if len(allErrs) > 0 {
+		return warnings, apierrors.NewInvalid(aitrigramv1.GroupVersion.WithKind("LLMModel").GroupKind(), llmmodel.Name, allErrs)
+	}
+	return warnings, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// Returns the error when the role referred by the ServiceAccountSpec is not one of the bindable roles of the operator,
+// or when the user of the admission request can not bind it. The operator binds it to the ServiceAccount of the pods
+// with its own bind permission, so the users could grant any bindable role to the pods otherwise. The role is only
+// checked when it is referred to for the first time, and is not checked without the client, like by the validate
+// command out of the cluster.
+func roleRefErrors(ctx context.Context, c client.Client, bindableRoles []string, path *field.Path, namespace string,
+	oldServiceAccount, serviceAccount *aitrigramv1.ServiceAccountSpec) field.ErrorList {
+	if c == nil || serviceAccount == nil || serviceAccount.RoleRef == nil {
+		return nil
+	}
+	roleRef := *serviceAccount.RoleRef
+	if oldServiceAccount != nil && oldServiceAccount.RoleRef != nil && *oldServiceAccount.RoleRef == roleRef {
+		return nil
+	}
+	path = path.Child("roleRef")
+	if key := controller.RoleRefKey(&roleRef); !slices.Contains(bindableRoles, key) {
+		return field.ErrorList{field.Forbidden(path, fmt.Sprintf("the %s is not one of the --bindable-roles of the operator", key))}
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return field.ErrorList{field.InternalError(path, err)}
+	}
+	kind, resource := "Role", "roles"
+	if roleRef.Kind == "ClusterRole" {
+		kind, resource = "ClusterRole", "clusterroles"
+	}
+	extra := make(map[string]authorizationv1.ExtraValue, len(req.UserInfo.Extra))
+	for key, value := range req.UserInfo.Extra {
+		extra[key] = authorizationv1.ExtraValue(value)
+	}
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "bind",
+				Group:     rbacv1.GroupName,
+				Resource:  resource,
+				Name:      roleRef.Name,
+			},
+			User:   req.UserInfo.Username,
+			Groups: req.UserInfo.Groups,
+			UID:    req.UserInfo.UID,
+			Extra:  extra,
+		},
+	}
+	if err := c.Create(ctx, review); err != nil {
+		return field.ErrorList{field.InternalError(path, err)}
+	}
+	if !review.Status.Allowed {
+		return field.ErrorList{field.Forbidden(path, fmt.Sprintf("%s can not bind the %s %s in the namespace %s",
+			req.UserInfo.Username, kind, roleRef.Name, namespace))}
+	}
+	return nil
+}
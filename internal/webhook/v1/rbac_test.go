@@ -0,0 +1,129 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+func Test_LLMModelValidatorRoleRef(t *testing.T) {
+	t.Parallel()
+	// only the admin can bind the ClusterRole view in the namespace team-a
+	reviews := []authorizationv1.SubjectAccessReviewSpec{}
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, aitrigramv1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			review := obj.(*authorizationv1.SubjectAccessReview)
+			reviews = append(reviews, review.Spec)
+			attributes := review.Spec.ResourceAttributes
+			review.Status.Allowed = review.Spec.User == "admin" && attributes.Namespace == "team-a" &&
+				attributes.Verb == "bind" && attributes.Resource == "clusterroles" && attributes.Name == "view"
+			return nil
+		},
+	}).Build()
+	validator := &LLMModelCustomValidator{Client: c, BindableRoles: []string{"ClusterRole/view", "Role/view"}}
+	contextOf := func(username string) context.Context {
+		return admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			UserInfo: authenticationv1.UserInfo{Username: username, Groups: []string{"system:authenticated"}},
+		}})
+	}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "team-a"},
+		Spec: aitrigramv1.LLMModelSpec{
+			Name:           "llama3",
+			EngineRef:      "ollama",
+			ServiceAccount: &aitrigramv1.ServiceAccountSpec{RoleRef: &aitrigramv1.RoleReference{Kind: "ClusterRole", Name: "view"}},
+		},
+	}
+
+	_, err := validator.ValidateCreate(contextOf("admin"), llmModel)
+	require.NoError(t, err)
+	require.Equal(t, []string{"system:authenticated"}, reviews[0].Groups)
+
+	_, err = validator.ValidateCreate(contextOf("developer"), llmModel)
+	require.True(t, apierrors.IsInvalid(err))
+	require.ErrorContains(t, err, "spec.serviceAccount.roleRef: Forbidden: developer can not bind the ClusterRole view in the namespace team-a")
+
+	// the role bound already is not checked again, so the others can still update the model
+	reviewed := len(reviews)
+	updated := llmModel.DeepCopy()
+	updated.Spec.Replicas = 2
+	_, err = validator.ValidateUpdate(contextOf("developer"), llmModel, updated)
+	require.NoError(t, err)
+	require.Len(t, reviews, reviewed)
+
+	// a Role of the same name is another role
+	updated.Spec.ServiceAccount.RoleRef = &aitrigramv1.RoleReference{Name: "view"}
+	_, err = validator.ValidateUpdate(contextOf("admin"), llmModel, updated)
+	require.ErrorContains(t, err, "admin can not bind the Role view in the namespace team-a")
+	require.Equal(t, "roles", reviews[len(reviews)-1].ResourceAttributes.Resource)
+
+	// a role which is not bindable by the operator is rejected without a review
+	reviewed = len(reviews)
+	updated.Spec.ServiceAccount.RoleRef = &aitrigramv1.RoleReference{Kind: "ClusterRole", Name: "cluster-admin"}
+	_, err = validator.ValidateUpdate(contextOf("admin"), llmModel, updated)
+	require.ErrorContains(t, err, "the ClusterRole/cluster-admin is not one of the --bindable-roles of the operator")
+	require.Len(t, reviews, reviewed)
+}
+
+func Test_LLMModelValidatorSharedServing(t *testing.T) {
+	t.Parallel()
+	scheme := runtime.NewScheme()
+	require.NoError(t, aitrigramv1.AddToScheme(scheme))
+	llmEngine := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "ollama", Namespace: "default"},
+		Spec:       aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeOllama, ServingMode: aitrigramv1.ServingModeShared},
+	}
+	validator := &LLMModelCustomValidator{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(llmEngine).Build()}
+	llmModel := &aitrigramv1.LLMModel{
+		ObjectMeta: metav1.ObjectMeta{Name: "llama3", Namespace: "default"},
+		Spec:       aitrigramv1.LLMModelSpec{Name: "llama3", EngineRef: "ollama"},
+	}
+	_, err := validator.ValidateCreate(context.Background(), llmModel)
+	require.NoError(t, err)
+
+	// the shared Deployment of the engine has no NetworkPolicy or ServiceAccount of each model
+	llmModel.Spec.NetworkPolicy = &aitrigramv1.NetworkPolicySpec{Enabled: true}
+	llmModel.Spec.ServiceAccount = &aitrigramv1.ServiceAccountSpec{Name: "models"}
+	_, err = validator.ValidateCreate(context.Background(), llmModel)
+	require.True(t, apierrors.IsInvalid(err))
+	require.ErrorContains(t, err, "spec.networkPolicy: Forbidden: not supported in the Shared servingMode")
+	require.ErrorContains(t, err, "spec.serviceAccount: Forbidden: not supported in the Shared servingMode")
+
+	// the engine created later is not known yet
+	llmModel.Spec.EngineRef = "vllm"
+	_, err = validator.ValidateCreate(context.Background(), llmModel)
+	require.NoError(t, err)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+)
+
+// Returns the warnings of the securityContexts in the template which weaken the restricted defaults of the engine
+// profiles. The pods are still created, but they are rejected in the namespaces enforcing the restricted Pod Security
+// Standard, except for the writable root filesystem, which is only a hardening of the profiles.
+func securityContextWarnings(path *field.Path, template *aitrigramv1.ModelDeploymentTemplate) admission.Warnings {
+	if template == nil {
+		return nil
+	}
+	var warnings admission.Warnings
+	warn := func(p *field.Path, format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf("%s: %s", p, fmt.Sprintf(format, args...)))
+	}
+
+	podSecurityContext := template.PodSecurityContext
+	securityContext := template.SecurityContext
+	if podSecurityContext != nil {
+		podPath := path.Child("podSecurityContext")
+		if podSecurityContext.RunAsNonRoot != nil && !*podSecurityContext.RunAsNonRoot {
+			warn(podPath.Child("runAsNonRoot"), "the pods may run as root")
+		}
+		if ptr.Deref(podSecurityContext.RunAsUser, 1) == 0 {
+			warn(podPath.Child("runAsUser"), "the pods run as root")
+		}
+		if unconfined(podSecurityContext.SeccompProfile) {
+			warn(podPath.Child("seccompProfile", "type"), "the pods run without a seccomp profile")
+		}
+		// the fields missing in both contexts are weakened, the other context may come from the engine otherwise
+		if securityContext != nil {
+			if podSecurityContext.RunAsNonRoot == nil && securityContext.RunAsNonRoot == nil {
+				warn(podPath.Child("runAsNonRoot"), "should be true as the containers do not set it")
+			}
+			if podSecurityContext.SeccompProfile == nil && securityContext.SeccompProfile == nil {
+				warn(podPath.Child("seccompProfile"), "should be RuntimeDefault as the containers do not set it")
+			}
+		}
+	}
+
+	if securityContext != nil {
+		containerPath := path.Child("securityContext")
+		if ptr.Deref(securityContext.Privileged, false) {
+			warn(containerPath.Child("privileged"), "the containers are privileged")
+		}
+		if ptr.Deref(securityContext.AllowPrivilegeEscalation, true) {
+			warn(containerPath.Child("allowPrivilegeEscalation"), "should be false")
+		}
+		if securityContext.Capabilities == nil || !slices.Contains(securityContext.Capabilities.Drop, "ALL") {
+			warn(containerPath.Child("capabilities", "drop"), "should contain ALL")
+		}
+		if securityContext.Capabilities != nil {
+			for _, capability := range securityContext.Capabilities.Add {
+				if capability != "NET_BIND_SERVICE" {
+					warn(containerPath.Child("capabilities", "add"), "the capability %s is not allowed", capability)
+				}
+			}
+		}
+		if securityContext.RunAsNonRoot != nil && !*securityContext.RunAsNonRoot {
+			warn(containerPath.Child("runAsNonRoot"), "the containers may run as root")
+		}
+		if ptr.Deref(securityContext.RunAsUser, 1) == 0 {
+			warn(containerPath.Child("runAsUser"), "the containers run as root")
+		}
+		if unconfined(securityContext.SeccompProfile) {
+			warn(containerPath.Child("seccompProfile", "type"), "the containers run without a seccomp profile")
+		}
+		if !ptr.Deref(securityContext.ReadOnlyRootFilesystem, false) {
+			warn(containerPath.Child("readOnlyRootFilesystem"), "the root filesystem of the containers is writable")
+		}
+	}
+	return warnings
+}
+
+func unconfined(profile *corev1.SeccompProfile) bool {
+	return profile != nil && profile.Type == corev1.SeccompProfileTypeUnconfined
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2025.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	aitrigramv1 "github.com/gaol/AITrigram/api/v1"
+	"github.com/gaol/AITrigram/internal/controller"
+)
+
+func Test_SecurityContextWarnings(t *testing.T) {
+	t.Parallel()
+	profile := controller.DefaultsOfLLMEngine(&aitrigramv1.LLMEngine{
+		Spec: aitrigramv1.LLMEngineSpec{EngineType: aitrigramv1.LLMEngineTypeOllama},
+	}).ModelDeploymentTemplate
+
+	cases := map[string]struct {
+		template *aitrigramv1.ModelDeploymentTemplate
+		expected admission.Warnings
+	}{
+		"no-template": {},
+		"no-override": {
+			template: &aitrigramv1.ModelDeploymentTemplate{Args: []string{"/bin/ollama", "serve"}},
+		},
+		"engine-profile": {
+			template: profile,
+		},
+		"root-pods": {
+			template: &aitrigramv1.ModelDeploymentTemplate{
+				PodSecurityContext: &corev1.PodSecurityContext{
+					RunAsNonRoot:   ptr.To(false),
+					RunAsUser:      ptr.To(int64(0)),
+					SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+				},
+			},
+			expected: admission.Warnings{
+				"spec.modelDeployment.podSecurityContext.runAsNonRoot: the pods may run as root",
+				"spec.modelDeployment.podSecurityContext.runAsUser: the pods run as root",
+				"spec.modelDeployment.podSecurityContext.seccompProfile.type: the pods run without a seccomp profile",
+			},
+		},
+		"weakened-containers": {
+			template: &aitrigramv1.ModelDeploymentTemplate{
+				SecurityContext: &corev1.SecurityContext{
+					Privileged:   ptr.To(true),
+					Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE", "SYS_ADMIN"}},
+				},
+			},
+			expected: admission.Warnings{
+				"spec.modelDeployment.securityContext.privileged: the containers are privileged",
+				"spec.modelDeployment.securityContext.allowPrivilegeEscalation: should be false",
+				"spec.modelDeployment.securityContext.capabilities.drop: should contain ALL",
+				"spec.modelDeployment.securityContext.capabilities.add: the capability SYS_ADMIN is not allowed",
+				"spec.modelDeployment.securityContext.readOnlyRootFilesystem: the root filesystem of the containers is writable",
+			},
+		},
+		"missing-in-both-contexts": {
+			template: &aitrigramv1.ModelDeploymentTemplate{
+				PodSecurityContext: &corev1.PodSecurityContext{FSGroup: ptr.To(int64(1000))},
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: ptr.To(false),
+					ReadOnlyRootFilesystem:   ptr.To(true),
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+			},
+			expected: admission.Warnings{
+				"spec.modelDeployment.podSecurityContext.runAsNonRoot: should be true as the containers do not set it",
+				"spec.modelDeployment.podSecurityContext.seccompProfile: should be RuntimeDefault as the containers do not set it",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			warnings := securityContextWarnings(field.NewPath("spec", "modelDeployment"), c.template)
+			require.Equal(t, c.expected, warnings)
+		})
+	}
+}
+
+func Test_LLMModelValidatorWarnings(t *testing.T) {
+	t.Parallel()
+	llmModel := &aitrigramv1.LLMModel{
+		Spec: aitrigramv1.LLMModelSpec{
+			Name: "llama3",
+			ModelDeployment: &aitrigramv1.ModelDeploymentTemplate{
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: ptr.To(false),
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+			},
+		},
+	}
+	validator := &LLMModelCustomValidator{}
+	expected := admission.Warnings{
+		"spec.modelDeployment.securityContext.readOnlyRootFilesystem: the root filesystem of the containers is writable",
+	}
+	warnings, err := validator.ValidateCreate(context.Background(), llmModel)
+	require.NoError(t, err)
+	require.Equal(t, expected, warnings)
+	updated := llmModel.DeepCopy()
+	updated.Spec.Replicas = 2
+	warnings, err = validator.ValidateUpdate(context.Background(), llmModel, updated)
+	require.NoError(t, err)
+	require.Equal(t, expected, warnings)
+}
+
+func Test_LLMEngineValidatorUpdate(t *testing.T) {
+	t.Parallel()
+	llmEngine := &aitrigramv1.LLMEngine{
+		ObjectMeta: metav1.ObjectMeta{Name: "vllm"},
+		Spec: aitrigramv1.LLMEngineSpec{
+			EngineType: aitrigramv1.LLMEngineTypeVLLM,
+			ModelDeploymentTemplate: &aitrigramv1.ModelDeploymentTemplate{
+				SecurityContext: &corev1.SecurityContext{Privileged: ptr.To(true)},
+			},
+		},
+	}
+	validator := &LLMEngineCustomValidator{}
+	// the checks of the reconcilers, like the missing port of vllm, are left to the validate command
+	warnings, err := validator.ValidateCreate(context.Background(), llmEngine)
+	require.NoError(t, err)
+	require.Contains(t, warnings, "spec.modelDeploymentTemplate.securityContext.privileged: the containers are privileged")
+
+	// the spec is only validated again when it changes
+	updated := llmEngine.DeepCopy()
+	updated.Labels = map[string]string{"team": "a"}
+	warnings, err = validator.ValidateUpdate(context.Background(), llmEngine, updated)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+	updated.Spec.Port = 8000
+	warnings, err = validator.ValidateUpdate(context.Background(), llmEngine, updated)
+	require.NoError(t, err)
+	require.NotEmpty(t, warnings)
+
+	// nor when the LLMEngine is being deleted, so that its finalizers can be removed
+	updated.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	updated.Finalizers = nil
+	warnings, err = validator.ValidateUpdate(context.Background(), llmEngine, updated)
+	require.NoError(t, err)
+	require.Empty(t, warnings)
+}